@@ -49,6 +49,8 @@ type DashboardCLIConfig struct {
 	// key-visual file mode for debug
 	KVFileStartTime int64
 	KVFileEndTime   int64
+	// key-visual snapshot mode, for viewing an exported heatmap snapshot
+	KVSnapshotFile string
 }
 
 // NewCLIConfig generates the configuration of the dashboard in standalone mode.
@@ -83,6 +85,8 @@ func NewCLIConfig() *DashboardCLIConfig {
 	_ = flag.CommandLine.MarkHidden("keyviz-file-start")
 	_ = flag.CommandLine.MarkHidden("keyviz-file-end")
 
+	flag.StringVar(&cfg.KVSnapshotFile, "keyviz-snapshot", "", "path of a Key Visualizer snapshot to view instead of collecting statistics from the cluster")
+
 	flag.Parse()
 	if *showVersion {
 		version.PrintStandaloneModeInfo()
@@ -115,6 +119,9 @@ func NewCLIConfig() *DashboardCLIConfig {
 		if startTime == 0 || endTime == 0 || startTime >= endTime {
 			log.Fatal("keyviz-file-start must be smaller than keyviz-file-end, and none of them are 0")
 		}
+		if cfg.KVSnapshotFile != "" {
+			log.Fatal("keyviz-snapshot cannot be used together with keyviz-file-start and keyviz-file-end")
+		}
 	}
 
 	return cfg
@@ -199,6 +206,10 @@ func main() {
 			FileStartTime: cliConfig.KVFileStartTime,
 			FileEndTime:   cliConfig.KVFileEndTime,
 		}
+	} else if cliConfig.KVSnapshotFile != "" {
+		customKeyVisualProvider = &keyvisualregion.DataProvider{
+			SnapshotFile: cliConfig.KVSnapshotFile,
+		}
 	}
 	assets := uiserver.Assets(cliConfig.CoreConfig)
 	s := apiserver.NewService(
@@ -14,6 +14,7 @@ import (
 	cors "github.com/rs/cors/wrapper/gin"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/clusterinfo"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/configuration"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/conprof"
@@ -28,6 +29,7 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/topsql"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/code"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/code/codeauth"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/rbac"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sqlauth"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso/ssoauth"
@@ -126,6 +128,8 @@ var Modules = fx.Options(
 		// NOTE: Don't remove above comment line, it is a placeholder for code generator
 	),
 	user.Module,
+	rbac.Module,
+	audit.Module,
 	codeauth.Module,
 	sqlauth.Module,
 	ssoauth.Module,
@@ -248,11 +252,13 @@ func (s *Service) provideLocals() (*config.Config, http.FileSystem, *keyvisualre
 	return s.config, s.uiAssetFS, s.customKeyVisualProvider
 }
 
-func newAPIHandlerEngine() (apiHandlerEngine *gin.Engine, endpoint *gin.RouterGroup) {
+func newAPIHandlerEngine(auditService *audit.Service) (apiHandlerEngine *gin.Engine, endpoint *gin.RouterGroup) {
 	apiHandlerEngine = gin.New()
 	apiHandlerEngine.Use(gin.Recovery())
 	apiHandlerEngine.Use(cors.AllowAll())
-	apiHandlerEngine.Use(gzip.Gzip(gzip.DefaultCompression))
+	apiHandlerEngine.Use(mwGzip())
+	// Audit must be placed before the error handler in order to see the final response status.
+	apiHandlerEngine.Use(auditService.MWRecord())
 	apiHandlerEngine.Use(rest.ErrorHandlerFn())
 
 	endpoint = apiHandlerEngine.Group("/dashboard/api")
@@ -260,6 +266,22 @@ func newAPIHandlerEngine() (apiHandlerEngine *gin.Engine, endpoint *gin.RouterGr
 	return
 }
 
+// gzipExcludedRoutes are Server-Sent Events streams. The gzip writer cannot be flushed, so events would stall until
+// its buffer is full.
+var gzipExcludedRoutes = map[string]struct{}{
+	"/dashboard/api/logs/tail": {},
+}
+
+func mwGzip() gin.HandlerFunc {
+	compress := gzip.Gzip(gzip.DefaultCompression)
+	return func(c *gin.Context) {
+		if _, ok := gzipExcludedRoutes[c.FullPath()]; ok {
+			return
+		}
+		compress(c)
+	}
+}
+
 var StoppedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 	_, _ = io.WriteString(w, "Dashboard is not started.\n")
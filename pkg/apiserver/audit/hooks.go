@@ -0,0 +1,40 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package audit
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	contextKeyTargets = "audit_targets"
+	contextKeyBefore  = "audit_before"
+	contextKeyAfter   = "audit_after"
+	contextKeyFailure = "audit_failure"
+	contextKeySkip    = "audit_skip"
+)
+
+// SetTargets records the instances or objects affected by the current request in the audit event.
+func SetTargets(c *gin.Context, targets ...string) {
+	c.Set(contextKeyTargets, targets)
+}
+
+// SetBefore records the value before the modification in the audit event. The value will be JSON encoded.
+func SetBefore(c *gin.Context, v interface{}) {
+	c.Set(contextKeyBefore, v)
+}
+
+// SetAfter records the value after the modification in the audit event. The value will be JSON encoded.
+func SetAfter(c *gin.Context, v interface{}) {
+	c.Set(contextKeyAfter, v)
+}
+
+// SetFailure marks the audit event as failed, for handlers that respond errors in a successful HTTP response.
+func SetFailure(c *gin.Context, err error) {
+	c.Set(contextKeyFailure, err)
+}
+
+// Skip prevents the current request from being recorded, e.g. for requests that do not mutate anything.
+func Skip(c *gin.Context) {
+	c.Set(contextKeySkip, true)
+}
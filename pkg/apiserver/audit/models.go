@@ -0,0 +1,53 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package audit
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type EventResult string
+
+const (
+	EventResultSuccess EventResult = "success"
+	EventResultFailed  EventResult = "failed"
+)
+
+type TargetList []string
+
+func (l *TargetList) Scan(src interface{}) error {
+	return json.Unmarshal([]byte(src.(string)), l)
+}
+
+func (l TargetList) Value() (driver.Value, error) {
+	val, err := json.Marshal(l)
+	return string(val), err
+}
+
+type EventModel struct {
+	ID       uint        `json:"id" gorm:"primary_key"`
+	Time     int64       `json:"time" gorm:"index"` // Unix timestamp in milliseconds
+	Actor    string      `json:"actor" gorm:"size:256;index"`
+	AuthFrom int         `json:"auth_from"`
+	SQLUser  string      `json:"sql_user" gorm:"size:128"`
+	Method   string      `json:"method" gorm:"size:16"`
+	Route    string      `json:"route" gorm:"size:256;index"`
+	Path     string      `json:"path" gorm:"type:text"`
+	Targets  TargetList  `json:"targets" gorm:"type:text"`
+	Before   *string     `json:"before" gorm:"type:text"` // JSON encoded
+	After    *string     `json:"after" gorm:"type:text"`  // JSON encoded
+	Result   EventResult `json:"result" gorm:"size:16;index"`
+	Status   int         `json:"status"`
+	Error    *string     `json:"error" gorm:"type:text"`
+}
+
+func (EventModel) TableName() string {
+	return "audit_events"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&EventModel{})
+}
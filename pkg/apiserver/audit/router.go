@@ -0,0 +1,90 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package audit
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/audit")
+	endpoint.Use(auth.MWAuthRequired())
+	endpoint.GET("/events", auth.MWRequirePermission(user.PermAuditView), s.listEventsHandler)
+	endpoint.GET("/config", auth.MWRequirePermission(user.PermAuditView), s.getConfigHandler)
+	endpoint.PUT("/config", auth.MWRequirePermission(user.PermAuditConfig), s.setConfigHandler)
+}
+
+// @ID auditListEvents
+// @Summary List audit events of mutating API calls, latest first
+// @Param q query ListEventsRequest true "Query"
+// @Success 200 {object} ListEventsResponse
+// @Router /audit/events [get]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) listEventsHandler(c *gin.Context) {
+	var req ListEventsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	resp, err := s.listEvents(&req)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @ID auditGetConfig
+// @Summary Get audit config
+// @Success 200 {object} config.AuditConfig
+// @Router /audit/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) getConfigHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.Audit)
+}
+
+// @ID auditSetConfig
+// @Summary Set audit config
+// @Param request body config.AuditConfig true "Request body"
+// @Success 200 {object} config.AuditConfig
+// @Router /audit/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) setConfigHandler(c *gin.Context) {
+	var req config.AuditConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		SetBefore(c, dc.Audit)
+		dc.Audit = req
+		SetAfter(c, dc.Audit)
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
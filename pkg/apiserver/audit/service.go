@@ -0,0 +1,248 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+const (
+	gcInterval = time.Hour
+)
+
+var ErrNS = errorx.NewNamespace("error.api.audit")
+
+type ServiceParams struct {
+	fx.In
+	LocalStore    *dbstore.DB
+	ConfigManager *config.DynamicConfigManager
+}
+
+type Service struct {
+	params ServiceParams
+	wg     sync.WaitGroup
+}
+
+func NewService(lc fx.Lifecycle, p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	s := &Service{params: p}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.gcLoop(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			s.wg.Wait()
+			return nil
+		},
+	})
+	return s, nil
+}
+
+var Module = fx.Options(
+	fx.Provide(NewService),
+	fx.Invoke(registerRouter),
+)
+
+func isMutatingMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func encodeValue(c *gin.Context, key string) *string {
+	v, ok := c.Get(key)
+	if !ok {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
+// MWRecord creates a middleware that records an audit event for every mutating request issued by a signed in user.
+// Handlers can attach more details to the event via SetTargets, SetBefore, SetAfter and SetFailure.
+// The middleware should be placed before the error handler so that the final response status is recorded.
+func (s *Service) MWRecord() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutatingMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		startTime := time.Now()
+		c.Next()
+
+		if c.GetBool(contextKeySkip) {
+			return
+		}
+		// Requests without a session are either rejected or not related to any user (e.g. log in).
+		u := utils.GetSession(c)
+		if u == nil {
+			return
+		}
+
+		event := &EventModel{
+			Time:     startTime.UnixNano() / int64(time.Millisecond),
+			Actor:    u.DisplayName,
+			AuthFrom: int(u.AuthFrom),
+			SQLUser:  u.TiDBUsername,
+			Method:   c.Request.Method,
+			Route:    c.FullPath(),
+			Path:     c.Request.URL.Path,
+			Before:   encodeValue(c, contextKeyBefore),
+			After:    encodeValue(c, contextKeyAfter),
+			Result:   EventResultSuccess,
+			Status:   c.Writer.Status(),
+		}
+		if targets, ok := c.Get(contextKeyTargets); ok {
+			event.Targets = targets.([]string)
+		}
+
+		var failure error
+		if v, ok := c.Get(contextKeyFailure); ok {
+			failure = v.(error)
+		} else if lastErr := c.Errors.Last(); lastErr != nil {
+			failure = lastErr.Err
+		}
+		if failure != nil || event.Status >= http.StatusBadRequest {
+			event.Result = EventResultFailed
+		}
+		if failure != nil {
+			msg := failure.Error()
+			event.Error = &msg
+		}
+
+		if err := s.params.LocalStore.Create(event).Error; err != nil {
+			log.Warn("Failed to save audit event",
+				zap.String("actor", event.Actor),
+				zap.String("route", event.Route),
+				zap.Error(err))
+		}
+	}
+}
+
+func (s *Service) gcLoop(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.gc()
+		}
+	}
+}
+
+func (s *Service) gc() {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		// Dynamic config is not ready yet, try in the next round.
+		return
+	}
+	expireBefore := time.Now().Add(-time.Duration(dc.Audit.RetentionDays) * 24 * time.Hour)
+	result := s.params.LocalStore.
+		Where("time < ?", expireBefore.UnixNano()/int64(time.Millisecond)).
+		Delete(&EventModel{})
+	if result.Error != nil {
+		log.Warn("Failed to clean up expired audit events", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Info("Expired audit events are cleaned up", zap.Int64("count", result.RowsAffected))
+	}
+}
+
+type ListEventsRequest struct {
+	Page      int    `json:"page" form:"page"`           // Starts from 1
+	PageSize  int    `json:"page_size" form:"page_size"` // Defaults to 50, at most 500
+	Actor     string `json:"actor" form:"actor"`
+	Route     string `json:"route" form:"route"`
+	Result    string `json:"result" form:"result"`
+	BeginTime int64  `json:"begin_time" form:"begin_time"` // Unix timestamp in milliseconds, inclusive
+	EndTime   int64  `json:"end_time" form:"end_time"`     // Unix timestamp in milliseconds, exclusive
+}
+
+type ListEventsResponse struct {
+	Total  int64        `json:"total"`
+	Events []EventModel `json:"events"`
+}
+
+func (req *ListEventsRequest) filter(db *gorm.DB) *gorm.DB {
+	if req.Actor != "" {
+		db = db.Where("actor = ?", req.Actor)
+	}
+	if req.Route != "" {
+		db = db.Where("route = ?", req.Route)
+	}
+	if req.Result != "" {
+		db = db.Where("result = ?", req.Result)
+	}
+	if req.BeginTime > 0 {
+		db = db.Where("time >= ?", req.BeginTime)
+	}
+	if req.EndTime > 0 {
+		db = db.Where("time < ?", req.EndTime)
+	}
+	return db
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func (s *Service) listEvents(req *ListEventsRequest) (*ListEventsResponse, error) {
+	if req.Page <= 0 {
+		req.Page = 1
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultPageSize
+	}
+	if req.PageSize > maxPageSize {
+		req.PageSize = maxPageSize
+	}
+
+	resp := &ListEventsResponse{}
+	err := req.filter(s.params.LocalStore.Model(&EventModel{})).Count(&resp.Total).Error
+	if err != nil {
+		return nil, err
+	}
+	err = req.filter(s.params.LocalStore.DB).
+		Order("time DESC, id DESC").
+		Offset((req.Page - 1) * req.PageSize).
+		Limit(req.PageSize).
+		Find(&resp.Events).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
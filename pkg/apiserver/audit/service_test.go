@@ -0,0 +1,157 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package audit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testServiceSuite{})
+
+type testServiceSuite struct {
+	service *Service
+	engine  *gin.Engine
+}
+
+func (t *testServiceSuite) SetUpTest(c *C) {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	db := &dbstore.DB{DB: gormDB}
+	c.Assert(autoMigrate(db), IsNil)
+	t.service = &Service{params: ServiceParams{LocalStore: db}}
+
+	gin.SetMode(gin.TestMode)
+	t.engine = gin.New()
+	t.engine.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Test-User") != "" {
+			c.Set(utils.SessionUserKey, &utils.SessionUser{
+				DisplayName:  c.GetHeader("X-Test-User"),
+				TiDBUsername: "root",
+			})
+		}
+	})
+	t.engine.Use(t.service.MWRecord())
+	t.engine.Use(rest.ErrorHandlerFn())
+	t.engine.PUT("/config", func(c *gin.Context) {
+		SetTargets(c, "tidb-1")
+		SetBefore(c, map[string]int{"size": 1})
+		SetAfter(c, map[string]int{"size": 2})
+		c.Status(http.StatusOK)
+	})
+	t.engine.GET("/config", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	t.engine.POST("/bad", func(c *gin.Context) {
+		rest.Error(c, rest.ErrBadRequest.New("bad input"))
+	})
+	t.engine.POST("/run", func(c *gin.Context) {
+		SetFailure(c, errors.New("statement failed"))
+		c.Status(http.StatusOK)
+	})
+	t.engine.POST("/skip", func(c *gin.Context) {
+		Skip(c)
+		c.Status(http.StatusOK)
+	})
+}
+
+func (t *testServiceSuite) request(method, path, user string) {
+	req := httptest.NewRequest(method, path, nil)
+	if user != "" {
+		req.Header.Set("X-Test-User", user)
+	}
+	t.engine.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func (t *testServiceSuite) events(c *C) []EventModel {
+	resp, err := t.service.listEvents(&ListEventsRequest{})
+	c.Assert(err, IsNil)
+	return resp.Events
+}
+
+func (t *testServiceSuite) Test_RecordBeforeAfter(c *C) {
+	t.request(http.MethodPut, "/config", "foo")
+
+	events := t.events(c)
+	c.Assert(events, HasLen, 1)
+	e := events[0]
+	c.Assert(e.Actor, Equals, "foo")
+	c.Assert(e.SQLUser, Equals, "root")
+	c.Assert(e.Method, Equals, http.MethodPut)
+	c.Assert(e.Route, Equals, "/config")
+	c.Assert([]string(e.Targets), DeepEquals, []string{"tidb-1"})
+	c.Assert(*e.Before, Equals, `{"size":1}`)
+	c.Assert(*e.After, Equals, `{"size":2}`)
+	c.Assert(e.Result, Equals, EventResultSuccess)
+	c.Assert(e.Status, Equals, http.StatusOK)
+	c.Assert(e.Error, IsNil)
+}
+
+func (t *testServiceSuite) Test_SkipUnrecorded(c *C) {
+	// Reading requests, requests without a session and skipped requests are not recorded.
+	t.request(http.MethodGet, "/config", "foo")
+	t.request(http.MethodPut, "/config", "")
+	t.request(http.MethodPost, "/skip", "foo")
+	c.Assert(t.events(c), HasLen, 0)
+}
+
+func (t *testServiceSuite) Test_RecordFailure(c *C) {
+	t.request(http.MethodPost, "/bad", "foo")
+	t.request(http.MethodPost, "/run", "bar")
+
+	events := t.events(c)
+	c.Assert(events, HasLen, 2)
+	byActor := map[string]EventModel{}
+	for _, e := range events {
+		byActor[e.Actor] = e
+	}
+
+	bad := byActor["foo"]
+	c.Assert(bad.Result, Equals, EventResultFailed)
+	c.Assert(bad.Status, Equals, http.StatusBadRequest)
+	c.Assert(bad.Error, NotNil)
+	c.Assert(bad.Before, IsNil)
+
+	// Failures responded in a successful HTTP response.
+	run := byActor["bar"]
+	c.Assert(run.Result, Equals, EventResultFailed)
+	c.Assert(run.Status, Equals, http.StatusOK)
+	c.Assert(*run.Error, Equals, "statement failed")
+}
+
+func (t *testServiceSuite) Test_ListEvents(c *C) {
+	db := t.service.params.LocalStore
+	for i, actor := range []string{"foo", "bar", "foo"} {
+		c.Assert(db.Create(&EventModel{Time: int64(100 * (i + 1)), Actor: actor, Result: EventResultSuccess}).Error, IsNil)
+	}
+
+	resp, err := t.service.listEvents(&ListEventsRequest{Actor: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Total, Equals, int64(2))
+	c.Assert(resp.Events, HasLen, 2)
+	// Latest first.
+	c.Assert(resp.Events[0].Time, Equals, int64(300))
+
+	resp, err = t.service.listEvents(&ListEventsRequest{Page: 2, PageSize: 2, BeginTime: 100, EndTime: 400})
+	c.Assert(err, IsNil)
+	c.Assert(resp.Total, Equals, int64(3))
+	c.Assert(resp.Events, HasLen, 1)
+	c.Assert(resp.Events[0].Time, Equals, int64(100))
+}
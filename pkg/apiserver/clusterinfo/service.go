@@ -16,6 +16,7 @@ import (
 	"go.etcd.io/etcd/clientv3"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/clusterinfo/hostinfo"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
@@ -54,7 +55,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/topology")
 	endpoint.Use(auth.MWAuthRequired())
 	endpoint.GET("/tidb", s.getTiDBTopology)
-	endpoint.DELETE("/tidb/:address", s.deleteTiDBTopology)
+	endpoint.DELETE("/tidb/:address", auth.MWRequirePermission(user.PermTopologyEdit), s.deleteTiDBTopology)
 	endpoint.GET("/store", s.getStoreTopology)
 	endpoint.GET("/pd", s.getPDTopology)
 	endpoint.GET("/alertmanager", s.getAlertManagerTopology)
@@ -74,10 +75,12 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 // @Param address path string true "ip:port"
 // @Success 200 "delete ok"
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Security JwtAuth
 // @Router /topology/tidb/{address} [delete]
 func (s *Service) deleteTiDBTopology(c *gin.Context) {
 	address := c.Param("address")
+	audit.SetTargets(c, address)
 	errorChannel := make(chan error, 2)
 	ttlKey := fmt.Sprintf("/topology/tidb/%v/ttl", address)
 	nonTTLKey := fmt.Sprintf("/topology/tidb/%v/info", address)
@@ -3,10 +3,12 @@
 package configuration
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/util/rest"
@@ -18,7 +20,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 	endpoint.Use(utils.MWForbidByExperimentalFlag(s.params.Config.EnableExperimental))
 	endpoint.GET("/all", s.getHandler)
-	endpoint.POST("/edit", auth.MWRequireWritePriv(), s.editHandler)
+	endpoint.POST("/edit", auth.MWRequirePermission(user.PermConfigurationEdit), s.editHandler)
 }
 
 // @ID configurationGetAll
@@ -67,7 +69,16 @@ func (s *Service) editHandler(c *gin.Context) {
 	}
 
 	db := utils.GetTiDBConnection(c)
-	warnings, err := s.editConfig(db, req.Kind, req.ID, req.NewValue)
+	if req.Kind == ItemKindTiDBVariable && isConfigItemEditable(req.Kind, req.ID) {
+		var oldValue string
+		// We have checked the correctness of id, so no need to worry about injections
+		if err := db.Raw(fmt.Sprintf("SELECT @@GLOBAL.%s", req.ID)).Row().Scan(&oldValue); err == nil {
+			audit.SetBefore(c, map[string]interface{}{req.ID: oldValue})
+		}
+	}
+	warnings, targets, err := s.editConfig(db, req.Kind, req.ID, req.NewValue)
+	audit.SetTargets(c, targets...)
+	audit.SetAfter(c, map[string]interface{}{req.ID: req.NewValue})
 	if err != nil {
 		rest.Error(c, err)
 		return
@@ -308,31 +308,36 @@ func (s *Service) getAllConfigItems(db *gorm.DB) (*AllConfigItems, error) {
 	}, nil
 }
 
-func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue interface{}) ([]rest.ErrorResponse, error) {
+// editConfig returns the warnings and the instances that the edit is issued to.
+func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue interface{}) ([]rest.ErrorResponse, []string, error) {
 	if !isConfigItemEditable(kind, id) {
-		return nil, ErrNotEditable.New("Configuration `%s` is not editable", id)
+		return nil, nil, ErrNotEditable.New("Configuration `%s` is not editable", id)
 	}
 	body := make(map[string]interface{})
 	body[id] = newValue
 	bodyJSON, err := json.Marshal(&body)
 	if err != nil {
-		return nil, ErrEditFailed.WrapWithNoMessage(err)
+		return nil, nil, ErrEditFailed.WrapWithNoMessage(err)
 	}
 
 	switch kind {
 	case ItemKindPDConfig:
+		targets := []string{s.params.Config.PDEndPoint}
 		_, err := s.params.PDClient.SendPostRequest("/config", bytes.NewBuffer(bodyJSON))
 		if err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(err)
+			return nil, targets, ErrEditFailed.WrapWithNoMessage(err)
 		}
+		return nil, targets, nil
 	case ItemKindTiKVConfig:
 		tikvInfo, _, err := topology.FetchStoreTopology(s.params.PDClient)
 		if err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(ErrListTopologyFailed.WrapWithNoMessage(err))
+			return nil, nil, ErrEditFailed.WrapWithNoMessage(ErrListTopologyFailed.WrapWithNoMessage(err))
 		}
 		failures := make([]error, 0)
+		targets := make([]string, 0, len(tikvInfo))
 		for _, kvStore := range tikvInfo {
 			// TODO: What about tombstone stores?
+			targets = append(targets, fmt.Sprintf("%s:%d", kvStore.IP, kvStore.Port))
 			_, err := s.params.TiKVClient.SendPostRequest(kvStore.IP, int(kvStore.StatusPort), "/config", bytes.NewBuffer(bodyJSON))
 			if err != nil {
 				failures = append(failures, ErrEditFailed.Wrap(err, "Failed to edit config for TiKV instance `%s:%d`", kvStore.IP, kvStore.Port))
@@ -340,23 +345,24 @@ func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue int
 		}
 		if len(failures) == len(tikvInfo) {
 			if len(failures) > 0 {
-				return nil, failures[0]
+				return nil, targets, failures[0]
 			}
-			return nil, nil
+			return nil, targets, nil
 		}
 		warnings := make([]rest.ErrorResponse, 0)
 		for _, err := range failures {
 			warnings = append(warnings, rest.NewErrorResponse(err))
 		}
-		return warnings, nil
+		return warnings, targets, nil
 	case ItemKindTiDBVariable:
+		// Global variables take effect in the whole TiDB cluster.
+		targets := []string{string(ItemKindTiDBVariable)}
 		// We have checked the correctness of id, so no need to worry about injections
 		if err := db.Exec(fmt.Sprintf("SET GLOBAL %s = ?", id), newValue).Error; err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(err)
+			return nil, targets, ErrEditFailed.WrapWithNoMessage(err)
 		}
+		return nil, targets, nil
 	default:
-		return nil, ErrEditFailed.New("Edit failed, not implemented")
+		return nil, nil, ErrEditFailed.New("Edit failed, not implemented")
 	}
-
-	return nil, nil
 }
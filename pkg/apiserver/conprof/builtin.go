@@ -0,0 +1,332 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package conprof
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/profiling"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/topology"
+)
+
+// The built-in continuous profiler collects profiles when NgMonitoring is not deployed.
+
+const (
+	builtinCheckInterval = 10 * time.Second
+
+	ProfileStateRunning = "running"
+	ProfileStateSuccess = "success"
+	ProfileStateFailed  = "failed"
+
+	GroupStatePartialFailed = "partial failed"
+)
+
+// builtinProfileTypes maps profile types in the API of NgMonitoring to profiling types.
+var builtinProfileTypes = []struct {
+	name          string
+	profilingType profiling.TaskProfilingType
+}{
+	{"profile", profiling.ProfilingTypeCPU},
+	{"heap", profiling.ProfilingTypeHeap},
+	{"goroutine", profiling.ProfilingTypeGoroutine},
+}
+
+type ProfileModel struct {
+	ID          uint  `gorm:"primary_key"`
+	Ts          int64 `gorm:"index"`
+	ProfileSecs int
+	Component   string                    `gorm:"size:16"`
+	Address     string                    `gorm:"size:64"`
+	ProfileType string                    `gorm:"size:16"`
+	State       string                    `gorm:"size:16"`
+	Error       string                    `gorm:"type:text"`
+	RawDataType profiling.TaskRawDataType `gorm:"size:16"`
+	Data        []byte
+}
+
+func (ProfileModel) TableName() string {
+	return "conprof_builtin_profiles"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&ProfileModel{})
+}
+
+// fetchComponents returns components that are up in the cluster.
+func (s *Service) fetchComponents() ([]Component, error) {
+	var components []Component
+	pds, err := topology.FetchPDTopology(s.params.PDClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, pd := range pds {
+		if pd.Status == topology.ComponentStatusUp {
+			components = append(components, Component{Name: string(model.NodeKindPD), IP: pd.IP, Port: pd.Port, StatusPort: pd.Port})
+		}
+	}
+	tidbs, err := topology.FetchTiDBTopology(s.lifecycleCtx, s.params.EtcdClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, tidb := range tidbs {
+		if tidb.Status == topology.ComponentStatusUp {
+			components = append(components, Component{Name: string(model.NodeKindTiDB), IP: tidb.IP, Port: tidb.Port, StatusPort: tidb.StatusPort})
+		}
+	}
+	tikvs, tiflashes, err := topology.FetchStoreTopology(s.params.PDClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, tikv := range tikvs {
+		if tikv.Status == topology.ComponentStatusUp {
+			components = append(components, Component{Name: string(model.NodeKindTiKV), IP: tikv.IP, Port: tikv.Port, StatusPort: tikv.StatusPort})
+		}
+	}
+	for _, tiflash := range tiflashes {
+		if tiflash.Status == topology.ComponentStatusUp {
+			components = append(components, Component{Name: string(model.NodeKindTiFlash), IP: tiflash.IP, Port: tiflash.Port, StatusPort: tiflash.StatusPort})
+		}
+	}
+	return components, nil
+}
+
+// target returns the profiling target of the component. Profiles are fetched from the status port except PD.
+func (c *Component) target() model.RequestTargetNode {
+	address := fmt.Sprintf("%s:%d", c.IP, c.StatusPort)
+	return model.RequestTargetNode{
+		Kind:        model.NodeKind(c.Name),
+		DisplayName: address,
+		IP:          c.IP,
+		Port:        int(c.StatusPort),
+	}
+}
+
+func (s *Service) builtinLoop(ctx context.Context) {
+	// Profiles being collected when the dashboard stopped are never finished.
+	s.params.LocalStore.
+		Model(&ProfileModel{}).
+		Where("state = ?", ProfileStateRunning).
+		Updates(map[string]interface{}{"state": ProfileStateFailed, "error": "interrupted"})
+
+	for {
+		wait := builtinCheckInterval
+		if dc, err := s.params.ConfigManager.Get(); err == nil {
+			s.builtinGC(&dc.Conprof)
+			if dc.Conprof.Enable && !s.params.NgmProxy.Available() {
+				startAt := time.Now()
+				s.collectProfiles(ctx, dc.Conprof.ProfileSeconds, dc.Conprof.TimeoutSeconds)
+				wait = time.Until(startAt.Add(time.Duration(dc.Conprof.IntervalSeconds) * time.Second))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// collectProfiles collects a group of profiles of all components. Profiles not finished in timeoutSecs are marked
+// as failed. Profiles wait for manual profiling of the same instance and type to finish.
+func (s *Service) collectProfiles(ctx context.Context, profileSecs, timeoutSecs int) {
+	components, err := s.fetchComponents()
+	if err != nil {
+		log.Warn("Failed to fetch components for continuous profiling", zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSecs)*time.Second)
+	defer cancel()
+
+	ts := time.Now().Unix()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	pending := make(map[uint]struct{})
+	for i := range components {
+		target := components[i].target()
+		for _, pt := range builtinProfileTypes {
+			if !profiling.IsProfilingTypeSupported(target.Kind, pt.profilingType) {
+				continue
+			}
+			profile := &ProfileModel{
+				Ts:          ts,
+				ProfileSecs: profileSecs,
+				Component:   components[i].Name,
+				Address:     target.DisplayName,
+				ProfileType: pt.name,
+				State:       ProfileStateRunning,
+			}
+			if err := s.params.LocalStore.Create(profile).Error; err != nil {
+				log.Warn("Failed to save continuous profile", zap.Error(err))
+				continue
+			}
+			mu.Lock()
+			pending[profile.ID] = struct{}{}
+			mu.Unlock()
+
+			wg.Add(1)
+			s.wg.Add(1)
+			go func(profilingType profiling.TaskProfilingType) {
+				defer s.wg.Done()
+				defer wg.Done()
+				data, rawDataType, err := s.params.Profiling.FetchProfile(ctx, &target, profilingType, uint(profileSecs))
+				mu.Lock()
+				defer mu.Unlock()
+				if _, ok := pending[profile.ID]; !ok {
+					// Already timed out.
+					return
+				}
+				delete(pending, profile.ID)
+				if err != nil {
+					profile.State = ProfileStateFailed
+					profile.Error = err.Error()
+				} else {
+					profile.State = ProfileStateSuccess
+					profile.RawDataType = rawDataType
+					profile.Data = data
+				}
+				s.params.LocalStore.Save(profile)
+			}(pt.profilingType)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for id := range pending {
+		s.params.LocalStore.
+			Model(&ProfileModel{}).
+			Where("id = ?", id).
+			Updates(map[string]interface{}{"state": ProfileStateFailed, "error": "timeout"})
+		delete(pending, id)
+	}
+}
+
+// builtinGC deletes expired profiles.
+func (s *Service) builtinGC(cfg *config.ContinuousProfilingConfig) {
+	deleted, err := deleteExpiredProfiles(s.params.LocalStore, cfg, time.Now())
+	if err != nil {
+		log.Warn("Failed to delete expired continuous profiles", zap.Error(err))
+	}
+	if deleted > 0 {
+		log.Info("Expired continuous profiles are cleaned up", zap.Int64("count", deleted))
+	}
+}
+
+// deleteExpiredProfiles deletes profiles older than DataRetentionSeconds, and then the oldest groups of profiles
+// until the total size of profiles is within DataRetentionBytes. The newest group is always kept. It returns the
+// number of deleted profiles.
+func deleteExpiredProfiles(db *dbstore.DB, cfg *config.ContinuousProfilingConfig, now time.Time) (int64, error) {
+	expireBefore := now.Add(-time.Duration(cfg.DataRetentionSeconds) * time.Second).Unix()
+	result := db.Where("ts < ?", expireBefore).Delete(&ProfileModel{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	deleted := result.RowsAffected
+
+	var groups []struct {
+		Ts   int64
+		Size int64
+	}
+	err := db.
+		Model(&ProfileModel{}).
+		Select("ts, COALESCE(SUM(LENGTH(data)), 0) AS size").
+		Group("ts").
+		Order("ts DESC").
+		Scan(&groups).Error
+	if err != nil {
+		return deleted, err
+	}
+	var totalBytes uint64
+	for i, group := range groups {
+		totalBytes += uint64(group.Size)
+		if i > 0 && totalBytes > cfg.DataRetentionBytes {
+			result := db.Where("ts <= ?", group.Ts).Delete(&ProfileModel{})
+			return deleted + result.RowsAffected, result.Error
+		}
+	}
+	return deleted, nil
+}
+
+// groupState returns the state of a group from states of its profiles.
+func groupState(profiles []ProfileModel) string {
+	succeeded, failed := 0, 0
+	for _, p := range profiles {
+		switch p.State {
+		case ProfileStateRunning:
+			return ProfileStateRunning
+		case ProfileStateSuccess:
+			succeeded++
+		default:
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return ProfileStateSuccess
+	case succeeded == 0:
+		return ProfileStateFailed
+	default:
+		return GroupStatePartialFailed
+	}
+}
+
+// buildGroupProfiles groups profiles by the timestamp, the newest group first.
+func buildGroupProfiles(profiles []ProfileModel) []GroupProfiles {
+	groups := make(map[int64][]ProfileModel)
+	for _, p := range profiles {
+		groups[p.Ts] = append(groups[p.Ts], p)
+	}
+	result := make([]GroupProfiles, 0, len(groups))
+	for ts, group := range groups {
+		addresses := make(map[string]struct{})
+		compNum := ComponentNum{}
+		for _, p := range group {
+			key := p.Component + "/" + p.Address
+			if _, ok := addresses[key]; ok {
+				continue
+			}
+			addresses[key] = struct{}{}
+			switch model.NodeKind(p.Component) {
+			case model.NodeKindTiDB:
+				compNum.TiDB++
+			case model.NodeKindPD:
+				compNum.PD++
+			case model.NodeKindTiKV:
+				compNum.TiKV++
+			case model.NodeKindTiFlash:
+				compNum.TiFlash++
+			}
+		}
+		result = append(result, GroupProfiles{
+			Ts:          ts,
+			ProfileSecs: group[0].ProfileSecs,
+			State:       groupState(group),
+			CompNum:     compNum,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Ts > result[j].Ts
+	})
+	return result
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package conprof
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	. "github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testBuiltinSuite{})
+
+type testBuiltinSuite struct{}
+
+func (t *testBuiltinSuite) Test_buildGroupProfiles(c *C) {
+	profiles := []ProfileModel{
+		{Ts: 100, ProfileSecs: 10, Component: "tidb", Address: "127.0.0.1:10080", ProfileType: "profile", State: ProfileStateSuccess},
+		{Ts: 100, ProfileSecs: 10, Component: "tidb", Address: "127.0.0.1:10080", ProfileType: "heap", State: ProfileStateFailed},
+		{Ts: 100, ProfileSecs: 10, Component: "tikv", Address: "127.0.0.1:20180", ProfileType: "profile", State: ProfileStateSuccess},
+		{Ts: 200, ProfileSecs: 10, Component: "pd", Address: "127.0.0.1:2379", ProfileType: "profile", State: ProfileStateSuccess},
+		{Ts: 200, ProfileSecs: 10, Component: "pd", Address: "127.0.0.1:2379", ProfileType: "heap", State: ProfileStateRunning},
+		{Ts: 300, ProfileSecs: 5, Component: "tiflash", Address: "127.0.0.1:20292", ProfileType: "profile", State: ProfileStateFailed},
+		{Ts: 400, ProfileSecs: 5, Component: "tidb", Address: "127.0.0.1:10080", ProfileType: "goroutine", State: ProfileStateSuccess},
+	}
+	c.Assert(buildGroupProfiles(profiles), DeepEquals, []GroupProfiles{
+		{Ts: 400, ProfileSecs: 5, State: ProfileStateSuccess, CompNum: ComponentNum{TiDB: 1}},
+		{Ts: 300, ProfileSecs: 5, State: ProfileStateFailed, CompNum: ComponentNum{TiFlash: 1}},
+		{Ts: 200, ProfileSecs: 10, State: ProfileStateRunning, CompNum: ComponentNum{PD: 1}},
+		{Ts: 100, ProfileSecs: 10, State: GroupStatePartialFailed, CompNum: ComponentNum{TiDB: 1, TiKV: 1}},
+	})
+	c.Assert(buildGroupProfiles(nil), HasLen, 0)
+}
+
+func (t *testBuiltinSuite) Test_deleteExpiredProfiles(c *C) {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	db := &dbstore.DB{DB: gormDB}
+	c.Assert(autoMigrate(db), IsNil)
+
+	now := time.Unix(10000, 0)
+	profiles := []ProfileModel{
+		{Ts: 1000, State: ProfileStateSuccess, Data: make([]byte, 10)},
+		{Ts: 9000, State: ProfileStateSuccess, Data: make([]byte, 10)},
+		{Ts: 9000, State: ProfileStateFailed},
+		{Ts: 9500, State: ProfileStateSuccess, Data: make([]byte, 10)},
+		{Ts: 9900, State: ProfileStateSuccess, Data: make([]byte, 30)},
+	}
+	c.Assert(db.Create(&profiles).Error, IsNil)
+	remainingTs := func() []int64 {
+		var ts []int64
+		c.Assert(db.Model(&ProfileModel{}).Order("id").Pluck("ts", &ts).Error, IsNil)
+		return ts
+	}
+
+	cfg := config.ContinuousProfilingConfig{DataRetentionSeconds: 5000, DataRetentionBytes: 1000}
+	deleted, err := deleteExpiredProfiles(db, &cfg, now)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, int64(1))
+	c.Assert(remainingTs(), DeepEquals, []int64{9000, 9000, 9500, 9900})
+
+	cfg.DataRetentionBytes = 45
+	deleted, err = deleteExpiredProfiles(db, &cfg, now)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, int64(2))
+	c.Assert(remainingTs(), DeepEquals, []int64{9500, 9900})
+
+	// The newest group is kept even if it alone exceeds the limit.
+	cfg.DataRetentionBytes = 20
+	deleted, err = deleteExpiredProfiles(db, &cfg, now)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, int64(1))
+	c.Assert(remainingTs(), DeepEquals, []int64{9900})
+}
@@ -4,16 +4,27 @@
 package conprof
 
 import (
+	"archive/zip"
 	"context"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.etcd.io/etcd/clientv3"
 	"go.uber.org/fx"
+	"gorm.io/gorm"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/profiling"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/pd"
 	"github.com/pingcap/tidb-dashboard/util/featureflag"
 	"github.com/pingcap/tidb-dashboard/util/rest"
 )
@@ -21,10 +32,14 @@ import (
 type ServiceParams struct {
 	fx.In
 
-	EtcdClient   *clientv3.Client
-	Config       *config.Config
-	NgmProxy     *utils.NgmProxy
-	FeatureFlags *featureflag.Registry
+	EtcdClient    *clientv3.Client
+	PDClient      *pd.Client
+	Config        *config.Config
+	ConfigManager *config.DynamicConfigManager
+	LocalStore    *dbstore.DB
+	NgmProxy      *utils.NgmProxy
+	FeatureFlags  *featureflag.Registry
+	Profiling     *profiling.Service
 }
 
 type Service struct {
@@ -32,9 +47,13 @@ type Service struct {
 
 	params       ServiceParams
 	lifecycleCtx context.Context
+	wg           sync.WaitGroup
 }
 
-func newService(lc fx.Lifecycle, p ServiceParams) *Service {
+func newService(lc fx.Lifecycle, p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
 	s := &Service{
 		FeatureFlagConprof: p.FeatureFlags.Register("conprof", ">= 5.3.0"),
 		params:             p,
@@ -43,11 +62,20 @@ func newService(lc fx.Lifecycle, p ServiceParams) *Service {
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			s.lifecycleCtx = ctx
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.builtinLoop(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			s.wg.Wait()
 			return nil
 		},
 	})
 
-	return s
+	return s, nil
 }
 
 // Register register the handlers to the service.
@@ -56,27 +84,34 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 
 	endpoint.Use(s.FeatureFlagConprof.VersionGuard())
 	{
-		endpoint.GET("/config", auth.MWAuthRequired(), s.params.NgmProxy.Route("/config"))
-		endpoint.POST("/config", auth.MWAuthRequired(), auth.MWRequireWritePriv(), s.params.NgmProxy.Route("/config"))
-		endpoint.GET("/components", auth.MWAuthRequired(), s.params.NgmProxy.Route("/continuous_profiling/components"))
-		endpoint.GET("/estimate_size", auth.MWAuthRequired(), s.params.NgmProxy.Route("/continuous_profiling/estimate_size"))
-		endpoint.GET("/group_profiles", auth.MWAuthRequired(), s.params.NgmProxy.Route("/continuous_profiling/group_profiles"))
-		endpoint.GET("/group_profile/detail", auth.MWAuthRequired(), s.params.NgmProxy.Route("/continuous_profiling/group_profile/detail"))
+		endpoint.GET("/config", auth.MWAuthRequired(), s.ngmOrBuiltin("/config", s.ConprofConfig))
+		endpoint.POST("/config", auth.MWAuthRequired(), auth.MWRequirePermission(user.PermConprofConfig), s.ngmOrBuiltin("/config", s.UpdateConprofConfig))
+		endpoint.GET("/components", auth.MWAuthRequired(), s.ngmOrBuiltin("/continuous_profiling/components", s.ConprofComponents))
+		endpoint.GET("/estimate_size", auth.MWAuthRequired(), s.ngmOrBuiltin("/continuous_profiling/estimate_size", s.EstimateSize))
+		endpoint.GET("/group_profiles", auth.MWAuthRequired(), s.ngmOrBuiltin("/continuous_profiling/group_profiles", s.ConprofGroupProfiles))
+		endpoint.GET("/group_profile/detail", auth.MWAuthRequired(), s.ngmOrBuiltin("/continuous_profiling/group_profile/detail", s.ConprofGroupProfileDetail))
 
 		endpoint.GET("/action_token", auth.MWAuthRequired(), s.GenConprofActionToken)
-		endpoint.GET("/download", s.parseJWTToken, s.params.NgmProxy.Route("/continuous_profiling/download"))
-		endpoint.GET("/single_profile/view", s.parseJWTToken, s.params.NgmProxy.Route("/continuous_profiling/single_profile/view"))
+		endpoint.GET("/download", s.parseJWTToken, s.ngmOrBuiltin("/continuous_profiling/download", s.ConprofDownload))
+		endpoint.GET("/single_profile/view", s.parseJWTToken, s.ngmOrBuiltin("/continuous_profiling/single_profile/view", s.ConprofViewProfile))
 	}
 }
 
-type ContinuousProfilingConfig struct {
-	Enable               bool `json:"enable"`
-	ProfileSeconds       int  `json:"profile_seconds"`
-	IntervalSeconds      int  `json:"interval_seconds"`
-	TimeoutSeconds       int  `json:"timeout_seconds"`
-	DataRetentionSeconds int  `json:"data_retention_seconds"`
+// ngmOrBuiltin proxies requests to NgMonitoring if it is deployed, otherwise serves requests by the built-in continuous
+// profiler.
+func (s *Service) ngmOrBuiltin(targetPath string, builtin gin.HandlerFunc) gin.HandlerFunc {
+	proxy := s.params.NgmProxy.Route(targetPath)
+	return func(c *gin.Context) {
+		if s.params.NgmProxy.Available() {
+			proxy(c)
+			return
+		}
+		builtin(c)
+	}
 }
 
+type ContinuousProfilingConfig = config.ContinuousProfilingConfig
+
 type NgMonitoringConfig struct {
 	ContinuousProfiling ContinuousProfilingConfig `json:"continuous_profiling"`
 }
@@ -88,7 +123,12 @@ type NgMonitoringConfig struct {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofConfig(c *gin.Context) {
-	// dummy, for generate openapi
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, NgMonitoringConfig{ContinuousProfiling: dc.Conprof})
 }
 
 // @Summary Update Continuous Profiling Config
@@ -96,10 +136,31 @@ func (s *Service) ConprofConfig(c *gin.Context) {
 // @Param request body NgMonitoringConfig true "Request body"
 // @Security JwtAuth
 // @Success 200 {string} string "ok"
+// @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) UpdateConprofConfig(c *gin.Context) {
-	// dummy, for generate openapi
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	// Fields missing in the request body are kept unchanged.
+	req := NgMonitoringConfig{ContinuousProfiling: dc.Conprof}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.Conprof)
+		dc.Conprof = req.ContinuousProfiling
+		audit.SetAfter(c, dc.Conprof)
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.String(http.StatusOK, "ok")
 }
 
 type Component struct {
@@ -116,9 +177,17 @@ type Component struct {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofComponents(c *gin.Context) {
-	// dummy, for generate openapi
+	components, err := s.fetchComponents()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, components)
 }
 
+// defaultEstimateProfileSize is the estimated size of a profile when there is no collected profile yet.
+const defaultEstimateProfileSize = 256 * 1024
+
 type EstimateSizeRes struct {
 	InstanceCount int `json:"instance_count"`
 	ProfileSize   int `json:"profile_size"`
@@ -131,12 +200,60 @@ type EstimateSizeRes struct {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) EstimateSize(c *gin.Context) {
-	// dummy, for generate openapi
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	components, err := s.fetchComponents()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	// Estimate with the average size of collected profiles of each component.
+	var stats []struct {
+		Component string
+		Count     int
+		Size      int
+	}
+	err = s.params.LocalStore.
+		Model(&ProfileModel{}).
+		Select("component, COUNT(*) AS count, SUM(LENGTH(data)) AS size").
+		Where("state = ?", ProfileStateSuccess).
+		Group("component").
+		Scan(&stats).Error
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	avgSizes := make(map[string]int, len(stats))
+	for _, stat := range stats {
+		if stat.Count > 0 {
+			avgSizes[stat.Component] = stat.Size / stat.Count
+		}
+	}
+	rounds := dc.Conprof.DataRetentionSeconds / dc.Conprof.IntervalSeconds
+	size := 0
+	for _, component := range components {
+		avgSize, ok := avgSizes[component.Name]
+		if !ok {
+			avgSize = defaultEstimateProfileSize
+		}
+		for _, pt := range builtinProfileTypes {
+			if profiling.IsProfilingTypeSupported(component.target().Kind, pt.profilingType) {
+				size += avgSize * rounds
+			}
+		}
+	}
+	c.JSON(http.StatusOK, EstimateSizeRes{
+		InstanceCount: len(components),
+		ProfileSize:   size,
+	})
 }
 
 type GetGroupProfileReq struct {
-	BeginTime int `json:"begin_time"`
-	EndTime   int `json:"end_time"`
+	BeginTime int `json:"begin_time" form:"begin_time"`
+	EndTime   int `json:"end_time" form:"end_time"`
 }
 
 type ComponentNum struct {
@@ -180,7 +297,21 @@ type Target struct {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofGroupProfiles(c *gin.Context) {
-	// dummy, for generate openapi
+	var req GetGroupProfileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	var profiles []ProfileModel
+	err := s.params.LocalStore.
+		Omit("data").
+		Where("ts >= ? AND ts <= ?", req.BeginTime, req.EndTime).
+		Find(&profiles).Error
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, buildGroupProfiles(profiles))
 }
 
 // @Summary Get Group Profile Detail
@@ -191,7 +322,35 @@ func (s *Service) ConprofGroupProfiles(c *gin.Context) {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofGroupProfileDetail(c *gin.Context) {
-	// dummy, for generate openapi
+	ts, err := strconv.ParseInt(c.Query("ts"), 10, 64)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	var profiles []ProfileModel
+	if err := s.params.LocalStore.Omit("data").Where("ts = ?", ts).Order("id").Find(&profiles).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	if len(profiles) == 0 {
+		rest.Error(c, rest.ErrNotFound.New("profile group %d is not found", ts))
+		return
+	}
+	detail := GroupProfileDetail{
+		Ts:             profiles[0].Ts,
+		ProfileSecs:    profiles[0].ProfileSecs,
+		State:          groupState(profiles),
+		TargetProfiles: make([]ProfileDetail, 0, len(profiles)),
+	}
+	for _, p := range profiles {
+		detail.TargetProfiles = append(detail.TargetProfiles, ProfileDetail{
+			State:  p.State,
+			Error:  p.Error,
+			Type:   p.ProfileType,
+			Target: Target{Component: p.Component, Address: p.Address},
+		})
+	}
+	c.JSON(http.StatusOK, detail)
 }
 
 // @Summary Get action token for download or view profile
@@ -219,7 +378,59 @@ func (s *Service) GenConprofActionToken(c *gin.Context) {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofDownload(c *gin.Context) {
-	// dummy, for generate openapi
+	var req ViewSingleProfileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	var profiles []ProfileModel
+	if err := s.queryProfiles(&req).Find(&profiles).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+
+	fileName := fmt.Sprintf("profile_%s.zip", time.Unix(int64(req.Ts), 0).Format("2006-01-02_15-04-05"))
+	c.Writer.Header().Set("Content-type", "application/octet-stream")
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	zw := zip.NewWriter(c.Writer)
+	defer func() {
+		_ = zw.Close()
+	}()
+	for i := range profiles {
+		w, err := zw.Create(profileFileName(&profiles[i]))
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		if _, err := w.Write(profiles[i].Data); err != nil {
+			rest.Error(c, err)
+			return
+		}
+	}
+}
+
+// queryProfiles returns the query of succeeded profiles matching the request. Empty fields except Ts match any profile.
+func (s *Service) queryProfiles(req *ViewSingleProfileReq) *gorm.DB {
+	db := s.params.LocalStore.Where("ts = ? AND state = ?", req.Ts, ProfileStateSuccess)
+	if req.ProfileType != "" {
+		db = db.Where("profile_type = ?", req.ProfileType)
+	}
+	if req.Component != "" {
+		db = db.Where("component = ?", req.Component)
+	}
+	if req.Address != "" {
+		db = db.Where("address = ?", req.Address)
+	}
+	return db
+}
+
+func profileFileName(p *ProfileModel) string {
+	ext := "proto"
+	if p.RawDataType == profiling.RawDataTypeText {
+		ext = "txt"
+	}
+	address := strings.NewReplacer(":", "_").Replace(p.Address)
+	return fmt.Sprintf("%s_%s_%s_%d.%s", p.ProfileType, p.Component, address, p.Ts, ext)
 }
 
 func (s *Service) parseJWTToken(c *gin.Context) {
@@ -233,10 +444,11 @@ func (s *Service) parseJWTToken(c *gin.Context) {
 }
 
 type ViewSingleProfileReq struct {
-	Ts          int    `json:"ts"`
-	ProfileType string `json:"profile_type"`
-	Component   string `json:"component"`
-	Address     string `json:"address"`
+	Ts          int    `json:"ts" form:"ts"`
+	ProfileType string `json:"profile_type" form:"profile_type"`
+	Component   string `json:"component" form:"component"`
+	Address     string `json:"address" form:"address"`
+	DataFormat  string `json:"data_format" form:"data_format"` // "protobuf" to get the raw profile, otherwise rendered as SVG
 }
 
 // @Summary View Single Profile files
@@ -247,5 +459,33 @@ type ViewSingleProfileReq struct {
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 func (s *Service) ConprofViewProfile(c *gin.Context) {
-	// dummy, for generate openapi
+	var req ViewSingleProfileReq
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	var profile ProfileModel
+	if err := s.queryProfiles(&req).First(&profile).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			rest.Error(c, rest.ErrNotFound.NewWithNoMessage())
+			return
+		}
+		rest.Error(c, err)
+		return
+	}
+
+	switch {
+	case profile.RawDataType == profiling.RawDataTypeText:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", profile.Data)
+	case req.DataFormat == "protobuf":
+		c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", profileFileName(&profile)))
+		c.Data(http.StatusOK, "application/octet-stream", profile.Data)
+	default:
+		svg, err := profiling.ConvertProtobufToSVG(profile.Data)
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", svg)
+	}
 }
@@ -27,7 +27,7 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	{
 		ep.Use(auth.MWAuthRequired())
 		ep.GET("/endpoints", s.GetEndpoints)
-		ep.POST("/endpoint", s.RequestEndpoint)
+		ep.POST("/endpoint", auth.MWRequirePermission(user.PermDebugAPIRequest), s.RequestEndpoint)
 	}
 }
 
@@ -86,6 +86,7 @@ func getExtFromContentTypeHeader(contentType string) string {
 // @Success 200 {object} string
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 // @Router /debug_api/endpoint [post]
 func (s *Service) RequestEndpoint(c *gin.Context) {
@@ -18,6 +18,7 @@ import (
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
@@ -129,6 +130,7 @@ type GenerateMetricsRelationRequest struct {
 // @Security JwtAuth
 // @Failure 401 {object} rest.ErrorResponse
 func (s *Service) metricsRelationHandler(c *gin.Context) {
+	audit.Skip(c)
 	var req GenerateMetricsRelationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
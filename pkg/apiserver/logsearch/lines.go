@@ -0,0 +1,144 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+const (
+	defaultLinesPageSize = 100
+	maxLinesPageSize     = 1000
+
+	// linesScanBatchSize and linesMaxScanRows limit the work of a single request when most lines are dropped by
+	// the pattern filter. The returned cursor points to the last scanned line so that the client can continue.
+	linesScanBatchSize = 1000
+	linesMaxScanRows   = 50000
+)
+
+type GetLinesRequest struct {
+	// Cursor is the NextCursor of the previous page. Leave empty to start from the earliest line.
+	Cursor   string   `json:"cursor" form:"cursor"`
+	Limit    int      `json:"limit" form:"limit"`
+	MinLevel LogLevel `json:"min_level" form:"min_level"`
+	// TaskIDs selects the instances to browse. Leave empty to browse all instances in the task group.
+	TaskIDs []uint `json:"task_ids" form:"task_ids"`
+	// Pattern is a case-insensitive regular expression that lines must match.
+	Pattern string `json:"pattern" form:"pattern"`
+}
+
+type GetLinesResponse struct {
+	Lines []LineModel `json:"lines"`
+	// NextCursor is empty when there are no more lines.
+	NextCursor string `json:"next_cursor"`
+}
+
+// lineCursor is the position of a line in the time-ordered result set. Lines having the same time are ordered
+// by ID, thus (Time, ID) is unique.
+type lineCursor struct {
+	Time int64
+	ID   uint
+}
+
+func (c lineCursor) String() string {
+	return fmt.Sprintf("%d_%d", c.Time, c.ID)
+}
+
+func parseLineCursor(s string) (*lineCursor, error) {
+	parts := strings.SplitN(s, "_", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	t, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	return &lineCursor{Time: t, ID: uint(id)}, nil
+}
+
+// queryLines returns lines of the task group ordered by time, which merges lines from all instances. Pagination
+// is only stable after the task group is finished, since running tasks may still add earlier lines.
+func queryLines(db *dbstore.DB, taskGroupID uint, req *GetLinesRequest) (*GetLinesResponse, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultLinesPageSize
+	}
+	if limit > maxLinesPageSize {
+		limit = maxLinesPageSize
+	}
+
+	var cursor *lineCursor
+	if req.Cursor != "" {
+		var err error
+		cursor, err = parseLineCursor(req.Cursor)
+		if err != nil {
+			return nil, rest.ErrBadRequest.WrapWithNoMessage(err)
+		}
+	}
+
+	var re *regexp.Regexp
+	if req.Pattern != "" {
+		var err error
+		re, err = regexp.Compile("(?i)" + req.Pattern)
+		if err != nil {
+			return nil, rest.ErrBadRequest.Wrap(err, "invalid pattern")
+		}
+	}
+
+	resp := &GetLinesResponse{Lines: make([]LineModel, 0, limit)}
+	scanned := 0
+	for {
+		batchSize := linesScanBatchSize
+		if re == nil {
+			// Without the pattern filter, every scanned line is returned. One more line is fetched to know
+			// whether there is a next page.
+			batchSize = limit - len(resp.Lines) + 1
+		}
+
+		query := db.Where("task_group_id = ?", taskGroupID)
+		if req.MinLevel > 0 {
+			query = query.Where("level >= ?", req.MinLevel)
+		}
+		if len(req.TaskIDs) > 0 {
+			query = query.Where("task_id IN ?", req.TaskIDs)
+		}
+		if cursor != nil {
+			query = query.Where("(time > ? OR (time = ? AND id > ?))", cursor.Time, cursor.Time, cursor.ID)
+		}
+		var batch []LineModel
+		if err := query.Order("time, id").Limit(batchSize).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+
+		for i := range batch {
+			if len(resp.Lines) == limit {
+				resp.NextCursor = cursor.String()
+				return resp, nil
+			}
+			cursor = &lineCursor{Time: batch[i].Time, ID: batch[i].ID}
+			if re == nil || re.MatchString(batch[i].Message) {
+				resp.Lines = append(resp.Lines, batch[i])
+			}
+		}
+		scanned += len(batch)
+
+		if len(batch) < batchSize {
+			// Reached the end of the result set.
+			return resp, nil
+		}
+		if len(resp.Lines) == limit || scanned >= linesMaxScanRows {
+			resp.NextCursor = cursor.String()
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"path"
+	"testing"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/diagnosticspb"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testLinesSuite{})
+
+type testLinesSuite struct {
+	db *dbstore.DB
+}
+
+func newTestDB(c *C) *dbstore.DB {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	db := &dbstore.DB{DB: gormDB}
+	c.Assert(autoMigrate(db), IsNil)
+	return db
+}
+
+func (t *testLinesSuite) SetUpTest(c *C) {
+	t.db = newTestDB(c)
+
+	// Two instances in task group 1, whose lines are interleaved in time.
+	lines := []*LineModel{
+		{TaskID: 1, TaskGroupID: 1, Time: 100, Level: diagnosticspb.LogLevel(LogLevelInfo), Message: "tidb start"},
+		{TaskID: 2, TaskGroupID: 1, Time: 50, Level: diagnosticspb.LogLevel(LogLevelInfo), Message: "tikv start"},
+		{TaskID: 1, TaskGroupID: 1, Time: 200, Level: diagnosticspb.LogLevel(LogLevelError), Message: "tidb panic"},
+		{TaskID: 2, TaskGroupID: 1, Time: 200, Level: diagnosticspb.LogLevel(LogLevelWarn), Message: "tikv slow"},
+		{TaskID: 2, TaskGroupID: 1, Time: 300, Level: diagnosticspb.LogLevel(LogLevelError), Message: "tikv PANIC"},
+		{TaskID: 3, TaskGroupID: 2, Time: 10, Level: diagnosticspb.LogLevel(LogLevelInfo), Message: "other group"},
+	}
+	c.Assert(t.db.Create(lines).Error, IsNil)
+}
+
+func messagesOf(resp *GetLinesResponse) []string {
+	r := make([]string, 0, len(resp.Lines))
+	for _, l := range resp.Lines {
+		r = append(r, l.Message)
+	}
+	return r
+}
+
+func (t *testLinesSuite) Test_Pagination(c *C) {
+	resp, err := queryLines(t.db, 1, &GetLinesRequest{Limit: 2})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tikv start", "tidb start"})
+	c.Assert(resp.NextCursor, Not(Equals), "")
+
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{Limit: 2, Cursor: resp.NextCursor})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tidb panic", "tikv slow"})
+	c.Assert(resp.NextCursor, Not(Equals), "")
+
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{Limit: 2, Cursor: resp.NextCursor})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tikv PANIC"})
+	c.Assert(resp.NextCursor, Equals, "")
+
+	_, err = queryLines(t.db, 1, &GetLinesRequest{Cursor: "foo"})
+	c.Assert(err, NotNil)
+}
+
+func (t *testLinesSuite) Test_Filters(c *C) {
+	resp, err := queryLines(t.db, 1, &GetLinesRequest{MinLevel: LogLevelWarn})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tidb panic", "tikv slow", "tikv PANIC"})
+
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{TaskIDs: []uint{2}})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tikv start", "tikv slow", "tikv PANIC"})
+
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{Pattern: "panic"})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tidb panic", "tikv PANIC"})
+
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{Pattern: "panic", Limit: 1})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tidb panic"})
+	resp, err = queryLines(t.db, 1, &GetLinesRequest{Pattern: "panic", Limit: 1, Cursor: resp.NextCursor})
+	c.Assert(err, IsNil)
+	c.Assert(messagesOf(resp), DeepEquals, []string{"tikv PANIC"})
+
+	_, err = queryLines(t.db, 1, &GetLinesRequest{Pattern: "("})
+	c.Assert(err, NotNil)
+}
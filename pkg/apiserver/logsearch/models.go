@@ -6,6 +6,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"os"
+	"time"
 
 	"github.com/pingcap/kvproto/pkg/diagnosticspb"
 
@@ -88,7 +89,10 @@ type TaskModel struct {
 	LogStorePath     *string                  `json:"log_store_path" gorm:"type:text"`
 	SlowLogStorePath *string                  `json:"slow_log_store_path" gorm:"type:text"`
 	Size             int64                    `json:"size" gorm:"index"`
-	Error            *string                  `json:"error" gorm:"type:text"`
+	IndexedLines     int64                    `json:"indexed_lines"`
+	// MatchedLines is the number of lines found by the task. Unlike IndexedLines, it is not limited.
+	MatchedLines int64   `json:"matched_lines"`
+	Error        *string `json:"error" gorm:"type:text"`
 }
 
 func (TaskModel) TableName() string {
@@ -102,6 +106,9 @@ func (task *TaskModel) RemoveDataAndPreview(db *dbstore.DB) {
 		task.LogStorePath = nil
 	}
 	db.Where("task_id = ?", task.ID).Delete(&PreviewModel{})
+	db.Where("task_id = ?", task.ID).Delete(&LineModel{})
+	task.IndexedLines = 0
+	task.MatchedLines = 0
 }
 
 type TaskGroupModel struct {
@@ -110,6 +117,11 @@ type TaskGroupModel struct {
 	State         TaskGroupState                `json:"state" gorm:"index"`
 	TargetStats   model.RequestTargetStatistics `json:"target_stats" gorm:"embedded;embedded_prefix:target_stats_"`
 	LogStoreDir   *string                       `json:"log_store_dir" gorm:"type:text"`
+	SavedSearchID *uint                         `json:"saved_search_id" gorm:"index"`
+	// UnclusteredLines is the number of lines not counted in any pattern, since there are too many patterns.
+	UnclusteredLines int64 `json:"unclustered_lines"`
+	// CreatedAt is zero for task groups created by old versions.
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func (TaskGroupModel) TableName() string {
@@ -121,6 +133,8 @@ func (tg *TaskGroupModel) Delete(db *dbstore.DB) {
 		_ = os.RemoveAll(*tg.LogStoreDir)
 	}
 	db.Where("task_group_id = ?", tg.ID).Delete(&PreviewModel{})
+	db.Where("task_group_id = ?", tg.ID).Delete(&LineModel{})
+	db.Where("task_group_id = ?", tg.ID).Delete(&PatternModel{})
 	db.Where("task_group_id = ?", tg.ID).Delete(&TaskModel{})
 	db.Where("id = ?", tg.ID).Delete(&TaskGroupModel{})
 }
@@ -138,14 +152,123 @@ func (PreviewModel) TableName() string {
 	return "log_previews"
 }
 
+// LineModel is a log line fetched by a task. Unlike PreviewModel, all lines (up to TaskMaxIndexedLines) are kept,
+// so that the whole result set can be browsed without downloading the log files.
+type LineModel struct {
+	ID          uint                   `json:"id" gorm:"primary_key"`
+	TaskID      uint                   `json:"task_id" gorm:"index:idx_log_lines_task"`
+	TaskGroupID uint                   `json:"task_group_id" gorm:"index:idx_log_lines_task_group_time,priority:1"`
+	Time        int64                  `json:"time" gorm:"index:idx_log_lines_task_group_time,priority:2"`
+	Level       diagnosticspb.LogLevel `json:"level" gorm:"type:integer" swaggertype:"integer"`
+	Message     string                 `json:"message" gorm:"type:text"`
+}
+
+func (LineModel) TableName() string {
+	return "log_lines"
+}
+
+// PatternModel is a template of similar log lines in a task group, like `[region_id=<*>] split region`.
+type PatternModel struct {
+	ID          uint                   `json:"id" gorm:"primary_key"`
+	TaskGroupID uint                   `json:"task_group_id" gorm:"index"`
+	Template    string                 `json:"template" gorm:"type:text"`
+	Count       int64                  `json:"count"`
+	FirstSeen   int64                  `json:"first_seen"`
+	LastSeen    int64                  `json:"last_seen"`
+	MaxLevel    diagnosticspb.LogLevel `json:"max_level" gorm:"type:integer" swaggertype:"integer"`
+	Instances   StringList             `json:"instances" gorm:"type:text"`
+	Samples     StringList             `json:"samples" gorm:"type:text"`
+}
+
+func (PatternModel) TableName() string {
+	return "log_patterns"
+}
+
+type StringList []string
+
+func (l *StringList) Scan(src interface{}) error {
+	return json.Unmarshal([]byte(src.(string)), l)
+}
+
+func (l StringList) Value() (driver.Value, error) {
+	val, err := json.Marshal(l)
+	return string(val), err
+}
+
+// TargetSelector selects log search targets from the current cluster topology when a saved search runs, so that
+// newly added instances are also searched.
+type TargetSelector struct {
+	// Kinds selects all instances of these kinds, e.g. all TiKV instances.
+	Kinds []model.NodeKind `json:"kinds"`
+	// Instances selects instances by display names, i.e. `ip:port`. Instances that no longer exist are ignored.
+	Instances []string `json:"instances"`
+}
+
+func (s *TargetSelector) Scan(src interface{}) error {
+	return json.Unmarshal([]byte(src.(string)), s)
+}
+
+func (s *TargetSelector) Value() (driver.Value, error) {
+	val, err := json.Marshal(s)
+	return string(val), err
+}
+
+type SavedSearchModel struct {
+	ID       uint            `json:"id" gorm:"primary_key"`
+	Name     string          `json:"name" gorm:"type:text;unique"`
+	Patterns StringList      `json:"patterns" gorm:"type:text"`
+	MinLevel LogLevel        `json:"min_level"`
+	Targets  *TargetSelector `json:"targets" gorm:"type:text"`
+	// WindowSecs is the time window to search, ending at the time the search runs.
+	WindowSecs int64 `json:"window_secs"`
+	// ScheduleIntervalSecs is the interval to run the search automatically. 0 means the search is never
+	// scheduled and can only be run manually.
+	ScheduleIntervalSecs int64 `json:"schedule_interval_secs"`
+	// KeepTaskGroups is the number of latest task groups to keep. Match counts of older runs are still kept.
+	KeepTaskGroups int        `json:"keep_task_groups"`
+	LastRunAt      *time.Time `json:"last_run_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (SavedSearchModel) TableName() string {
+	return "log_search_saved_searches"
+}
+
+// SavedSearchRunModel records the result of running a saved search.
+type SavedSearchRunModel struct {
+	ID            uint       `json:"id" gorm:"primary_key"`
+	SavedSearchID uint       `json:"saved_search_id" gorm:"index"`
+	TaskGroupID   uint       `json:"task_group_id"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at"`
+	MatchCount    int64      `json:"match_count"`
+	FailedTasks   int        `json:"failed_tasks"`
+}
+
+func (SavedSearchRunModel) TableName() string {
+	return "log_search_saved_search_runs"
+}
+
 func autoMigrate(db *dbstore.DB) error {
-	return db.AutoMigrate(&TaskModel{}, &TaskGroupModel{}, &PreviewModel{})
+	return db.AutoMigrate(
+		&TaskModel{},
+		&TaskGroupModel{},
+		&PreviewModel{},
+		&LineModel{},
+		&PatternModel{},
+		&SavedSearchModel{},
+		&SavedSearchRunModel{},
+	)
 }
 
+// cleanupAllTasks removes task groups left by the previous run. Finished task groups of saved searches are kept,
+// since they are the history of the saved search.
 func cleanupAllTasks(db *dbstore.DB) {
 	var taskGroups []*TaskGroupModel
-	db.Find(&taskGroups)
+	db.Where("saved_search_id IS NULL OR state != ?", TaskGroupStateFinished).Find(&taskGroups)
 	for _, tg := range taskGroups {
 		tg.Delete(db)
 	}
+	// Runs that were interrupted have no result.
+	db.Where("finished_at IS NULL").Delete(&SavedSearchRunModel{})
 }
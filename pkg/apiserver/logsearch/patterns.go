@@ -0,0 +1,179 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pingcap/kvproto/pkg/diagnosticspb"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/util/logpattern"
+)
+
+const patternMaxSamples = 3
+
+type patternStat struct {
+	count     int64
+	firstSeen int64
+	lastSeen  int64
+	maxLevel  diagnosticspb.LogLevel
+	instances map[string]struct{}
+	samples   []string
+}
+
+func (s *patternStat) merge(other *patternStat) {
+	if s.count == 0 || other.firstSeen < s.firstSeen {
+		s.firstSeen = other.firstSeen
+	}
+	if other.lastSeen > s.lastSeen {
+		s.lastSeen = other.lastSeen
+	}
+	if other.maxLevel > s.maxLevel {
+		s.maxLevel = other.maxLevel
+	}
+	s.count += other.count
+	for i := range other.instances {
+		s.instances[i] = struct{}{}
+	}
+	for _, sample := range other.samples {
+		if len(s.samples) >= patternMaxSamples {
+			break
+		}
+		s.samples = append(s.samples, sample)
+	}
+}
+
+// patternCollector clusters log messages into templates and keeps statistics of each template. Each task
+// collects patterns on its own, and the result is merged into the task group only when the task succeeds, so
+// that lines of failed tasks are not counted twice after retrying.
+type patternCollector struct {
+	mu          sync.Mutex
+	miner       *logpattern.Miner
+	stats       map[int]*patternStat
+	unclustered int64
+}
+
+func newPatternCollector() *patternCollector {
+	return &patternCollector{
+		miner: logpattern.NewMiner(logpattern.DefaultOptions),
+		stats: map[int]*patternStat{},
+	}
+}
+
+func (pc *patternCollector) statOf(c *logpattern.Cluster) *patternStat {
+	s, ok := pc.stats[c.ID]
+	if !ok {
+		s = &patternStat{instances: map[string]struct{}{}}
+		pc.stats[c.ID] = s
+	}
+	return s
+}
+
+func (pc *patternCollector) add(msg *diagnosticspb.LogMessage, instance string) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	c := pc.miner.Add(msg.Message)
+	if c == nil {
+		pc.unclustered++
+		return
+	}
+	stat := pc.statOf(c)
+	if stat.count == 0 || msg.Time < stat.firstSeen {
+		stat.firstSeen = msg.Time
+	}
+	if msg.Time > stat.lastSeen {
+		stat.lastSeen = msg.Time
+	}
+	if msg.Level > stat.maxLevel {
+		stat.maxLevel = msg.Level
+	}
+	stat.count++
+	stat.instances[instance] = struct{}{}
+	if len(stat.samples) < patternMaxSamples {
+		stat.samples = append(stat.samples, msg.Message)
+	}
+}
+
+func (pc *patternCollector) addTemplate(template string, stat *patternStat) {
+	c := pc.miner.AddTemplate(template, stat.count)
+	if c == nil {
+		pc.unclustered += stat.count
+		return
+	}
+	pc.statOf(c).merge(stat)
+}
+
+func (pc *patternCollector) merge(other *patternCollector) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, c := range other.miner.Clusters() {
+		pc.addTemplate(c.Template(), other.stats[c.ID])
+	}
+	pc.unclustered += other.unclustered
+}
+
+// load seeds the collector with patterns saved previously, e.g. when retrying failed tasks of a task group.
+func (pc *patternCollector) load(db *dbstore.DB, tg *TaskGroupModel) error {
+	var patterns []*PatternModel
+	if err := db.Where("task_group_id = ?", tg.ID).Find(&patterns).Error; err != nil {
+		return err
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, p := range patterns {
+		stat := &patternStat{
+			count:     p.Count,
+			firstSeen: p.FirstSeen,
+			lastSeen:  p.LastSeen,
+			maxLevel:  p.MaxLevel,
+			instances: map[string]struct{}{},
+			samples:   p.Samples,
+		}
+		for _, i := range p.Instances {
+			stat.instances[i] = struct{}{}
+		}
+		pc.addTemplate(p.Template, stat)
+	}
+	pc.unclustered = tg.UnclusteredLines
+	return nil
+}
+
+// save replaces saved patterns of the task group.
+func (pc *patternCollector) save(db *dbstore.DB, tg *TaskGroupModel) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	patterns := make([]*PatternModel, 0, len(pc.stats))
+	for _, c := range pc.miner.Clusters() {
+		stat := pc.stats[c.ID]
+		instances := make(StringList, 0, len(stat.instances))
+		for i := range stat.instances {
+			instances = append(instances, i)
+		}
+		sort.Strings(instances)
+		patterns = append(patterns, &PatternModel{
+			TaskGroupID: tg.ID,
+			Template:    c.Template(),
+			Count:       stat.count,
+			FirstSeen:   stat.firstSeen,
+			LastSeen:    stat.lastSeen,
+			MaxLevel:    stat.maxLevel,
+			Instances:   instances,
+			Samples:     StringList(stat.samples),
+		})
+	}
+
+	if err := db.Where("task_group_id = ?", tg.ID).Delete(&PatternModel{}).Error; err != nil {
+		return err
+	}
+	if len(patterns) > 0 {
+		if err := db.CreateInBatches(patterns, lineInsertBatchSize).Error; err != nil {
+			return err
+		}
+	}
+	tg.UnclusteredLines = pc.unclustered
+	return nil
+}
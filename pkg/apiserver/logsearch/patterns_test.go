@@ -0,0 +1,59 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/diagnosticspb"
+)
+
+var _ = Suite(&testPatternsSuite{})
+
+type testPatternsSuite struct{}
+
+func (t *testPatternsSuite) Test_CollectAndSave(c *C) {
+	db := newTestDB(c)
+	tg := &TaskGroupModel{State: TaskGroupStateRunning}
+	c.Assert(db.Create(tg).Error, IsNil)
+
+	info := diagnosticspb.LogLevel(LogLevelInfo)
+	warn := diagnosticspb.LogLevel(LogLevelWarn)
+
+	task1 := newPatternCollector()
+	task1.add(&diagnosticspb.LogMessage{Time: 100, Level: info, Message: "split region region_id=1"}, "tikv-1")
+	task1.add(&diagnosticspb.LogMessage{Time: 300, Level: warn, Message: "split region region_id=2"}, "tikv-1")
+	task2 := newPatternCollector()
+	task2.add(&diagnosticspb.LogMessage{Time: 50, Level: info, Message: "split region region_id=3"}, "tikv-2")
+	task2.add(&diagnosticspb.LogMessage{Time: 60, Level: info, Message: "leader changed"}, "tikv-2")
+
+	group := newPatternCollector()
+	group.merge(task1)
+	group.merge(task2)
+	c.Assert(group.save(db, tg), IsNil)
+
+	var patterns []PatternModel
+	c.Assert(db.Order("count DESC").Find(&patterns).Error, IsNil)
+	c.Assert(patterns, HasLen, 2)
+	c.Assert(patterns[0].Template, Equals, "split region region_id=<*>")
+	c.Assert(patterns[0].Count, Equals, int64(3))
+	c.Assert(patterns[0].FirstSeen, Equals, int64(50))
+	c.Assert(patterns[0].LastSeen, Equals, int64(300))
+	c.Assert(patterns[0].MaxLevel, Equals, warn)
+	c.Assert([]string(patterns[0].Instances), DeepEquals, []string{"tikv-1", "tikv-2"})
+	c.Assert(patterns[0].Samples, HasLen, 3)
+
+	// Loading saved patterns and merging another task does not lose counts.
+	retry := newPatternCollector()
+	c.Assert(retry.load(db, tg), IsNil)
+	task3 := newPatternCollector()
+	task3.add(&diagnosticspb.LogMessage{Time: 400, Level: info, Message: "split region region_id=4"}, "tikv-3")
+	retry.merge(task3)
+	c.Assert(retry.save(db, tg), IsNil)
+
+	patterns = nil
+	c.Assert(db.Order("count DESC").Find(&patterns).Error, IsNil)
+	c.Assert(patterns, HasLen, 2)
+	c.Assert(patterns[0].Count, Equals, int64(4))
+	c.Assert(patterns[0].LastSeen, Equals, int64(400))
+	c.Assert(patterns[0].Samples, HasLen, 3)
+}
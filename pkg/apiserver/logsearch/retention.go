@@ -0,0 +1,177 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+const gcInterval = 10 * time.Minute
+
+// taskGroupItems returns task groups matching the query with the total size of their log files and indexed lines.
+func taskGroupItems(db *gorm.DB) ([]utils.RetentionItem, error) {
+	var taskGroups []TaskGroupModel
+	if err := db.Find(&taskGroups).Error; err != nil {
+		return nil, err
+	}
+	if len(taskGroups) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, 0, len(taskGroups))
+	for _, tg := range taskGroups {
+		ids = append(ids, tg.ID)
+	}
+	var sizes []struct {
+		TaskGroupID uint
+		Size        int64
+	}
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Model(&TaskModel{}).
+		Select("task_group_id, SUM(size) AS size").
+		Where("task_group_id IN ?", ids).
+		Group("task_group_id").
+		Scan(&sizes).Error
+	if err != nil {
+		return nil, err
+	}
+	sizeMap := make(map[uint]int64, len(sizes))
+	for _, s := range sizes {
+		sizeMap[s.TaskGroupID] = s.Size
+	}
+	sizes = nil
+	err = db.Session(&gorm.Session{NewDB: true}).
+		Model(&LineModel{}).
+		Select("task_group_id, SUM(LENGTH(message)) AS size").
+		Where("task_group_id IN ?", ids).
+		Group("task_group_id").
+		Scan(&sizes).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sizes {
+		sizeMap[s.TaskGroupID] += s.Size
+	}
+
+	items := make([]utils.RetentionItem, 0, len(taskGroups))
+	for _, tg := range taskGroups {
+		items = append(items, utils.RetentionItem{
+			ID:        tg.ID,
+			CreatedAt: tg.CreatedAt,
+			Bytes:     sizeMap[tg.ID],
+		})
+	}
+	return items, nil
+}
+
+// expirableTaskGroups queries finished task groups which are not owned by saved searches.
+func expirableTaskGroups(db *dbstore.DB) *gorm.DB {
+	return db.Where("state != ? AND saved_search_id IS NULL", TaskGroupStateRunning)
+}
+
+func (s *Service) gcLoop(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.gc()
+		}
+	}
+}
+
+// gc deletes finished task groups exceeding the retention limits. Task groups of saved searches are not counted, since
+// each saved search keeps its own number of task groups.
+func (s *Service) gc() {
+	dc, err := s.configManager.Get()
+	if err != nil {
+		// Dynamic config is not ready yet, try in the next round.
+		return
+	}
+	items, err := taskGroupItems(expirableTaskGroups(s.db))
+	if err != nil {
+		log.Warn("Failed to list log search task groups", zap.Error(err))
+		return
+	}
+	expired := utils.SelectExpiredItems(items, &dc.LogSearch.Retention, time.Now())
+	for _, id := range expired {
+		tg := TaskGroupModel{}
+		if err := s.db.Where("id = ?", id).First(&tg).Error; err != nil {
+			continue
+		}
+		tg.Delete(s.db)
+	}
+	if len(expired) > 0 {
+		log.Info("Expired log search task groups are cleaned up", zap.Int("count", len(expired)))
+	}
+}
+
+// @Summary Get storage usage of log search results
+// @Security JwtAuth
+// @Success 200 {object} utils.StorageUsage
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/storage [get]
+func (s *Service) GetStorageUsage(c *gin.Context) {
+	items, err := taskGroupItems(s.db.DB)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, utils.NewStorageUsage(items))
+}
+
+// @Summary Get log search dynamic config
+// @Security JwtAuth
+// @Success 200 {object} config.LogSearchConfig
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/config [get]
+func (s *Service) GetDynamicConfig(c *gin.Context) {
+	dc, err := s.configManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.LogSearch)
+}
+
+// @Summary Set log search dynamic config
+// @Param request body config.LogSearchConfig true "Request body"
+// @Security JwtAuth
+// @Success 200 {object} config.LogSearchConfig
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/config [put]
+func (s *Service) SetDynamicConfig(c *gin.Context) {
+	var req config.LogSearchConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.LogSearch)
+		dc.LogSearch = req
+		audit.SetAfter(c, dc.LogSearch)
+	}
+	if err := s.configManager.Modify(opt); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
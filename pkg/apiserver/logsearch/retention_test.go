@@ -0,0 +1,45 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testRetentionSuite{})
+
+type testRetentionSuite struct{}
+
+func (t *testRetentionSuite) Test_taskGroupItems(c *C) {
+	db := newTestDB(c)
+	savedSearchID := uint(1)
+	c.Assert(db.Create([]*TaskGroupModel{
+		{ID: 1, State: TaskGroupStateFinished},
+		{ID: 2, State: TaskGroupStateFinished, SavedSearchID: &savedSearchID},
+		{ID: 3, State: TaskGroupStateRunning},
+	}).Error, IsNil)
+	c.Assert(db.Create([]*TaskModel{
+		{TaskGroupID: 1, Size: 100},
+		{TaskGroupID: 1, Size: 20},
+		{TaskGroupID: 2, Size: 50},
+	}).Error, IsNil)
+	c.Assert(db.Create([]*LineModel{
+		{TaskGroupID: 1, Message: "12345"},
+		{TaskGroupID: 1, Message: "123"},
+		{TaskGroupID: 2, Message: "1"},
+	}).Error, IsNil)
+
+	items, err := taskGroupItems(db.DB)
+	c.Assert(err, IsNil)
+	sizes := make(map[uint]int64)
+	for _, item := range items {
+		sizes[item.ID] = item.Bytes
+	}
+	c.Assert(sizes, DeepEquals, map[uint]int64{1: 128, 2: 51, 3: 0})
+
+	items, err = taskGroupItems(expirableTaskGroups(db))
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 1)
+	c.Assert(items[0].ID, Equals, uint(1))
+	c.Assert(items[0].Bytes, Equals, int64(128))
+}
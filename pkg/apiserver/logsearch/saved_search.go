@@ -0,0 +1,443 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/topology"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+const (
+	DefaultKeepTaskGroups = 7
+	MaxKeepTaskGroups     = 100
+	MinScheduleInterval   = 10 * time.Minute
+
+	// maxRunsPerSavedSearch limits the match count history of each saved search.
+	maxRunsPerSavedSearch = 1000
+	scheduleCheckInterval = time.Minute
+)
+
+func (m *SavedSearchModel) validate() error {
+	if m.Name == "" {
+		return rest.ErrBadRequest.New("Name is required")
+	}
+	if m.Targets == nil || (len(m.Targets.Kinds) == 0 && len(m.Targets.Instances) == 0) {
+		return rest.ErrBadRequest.New("Expect at least 1 target")
+	}
+	if m.WindowSecs <= 0 {
+		return rest.ErrBadRequest.New("Time window must be positive")
+	}
+	if m.ScheduleIntervalSecs != 0 && time.Duration(m.ScheduleIntervalSecs)*time.Second < MinScheduleInterval {
+		return rest.ErrBadRequest.New("Schedule interval must be at least %s", MinScheduleInterval)
+	}
+	if int(m.MinLevel) >= len(PBLogLevelSlice) || m.MinLevel < 0 {
+		return rest.ErrBadRequest.New("Invalid min level")
+	}
+	if m.KeepTaskGroups == 0 {
+		m.KeepTaskGroups = DefaultKeepTaskGroups
+	}
+	if m.KeepTaskGroups < 0 || m.KeepTaskGroups > MaxKeepTaskGroups {
+		return rest.ErrBadRequest.New("Expect to keep 1 to %d task groups", MaxKeepTaskGroups)
+	}
+	return nil
+}
+
+// resolveTargets returns log search targets matching the selector in the current topology. The target port
+// follows the one used by the UI, i.e. the status port for TiDB and the service port for others.
+func (s *Service) resolveTargets(selector *TargetSelector) ([]model.RequestTargetNode, error) {
+	kinds := make(map[model.NodeKind]struct{})
+	for _, k := range selector.Kinds {
+		kinds[k] = struct{}{}
+	}
+	instances := make(map[string]struct{})
+	for _, i := range selector.Instances {
+		instances[i] = struct{}{}
+	}
+
+	all := make([]model.RequestTargetNode, 0)
+	add := func(kind model.NodeKind, ip string, port uint, targetPort uint, status topology.ComponentStatus) {
+		if status == topology.ComponentStatusTombstone {
+			return
+		}
+		displayName := fmt.Sprintf("%s:%d", ip, port)
+		_, kindSelected := kinds[kind]
+		_, instanceSelected := instances[displayName]
+		if !kindSelected && !instanceSelected {
+			return
+		}
+		all = append(all, model.RequestTargetNode{
+			Kind:        kind,
+			DisplayName: displayName,
+			IP:          ip,
+			Port:        int(targetPort),
+		})
+	}
+
+	tidbInfo, err := topology.FetchTiDBTopology(s.lifecycleCtx, s.etcdClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range tidbInfo {
+		add(model.NodeKindTiDB, i.IP, i.Port, i.StatusPort, i.Status)
+	}
+	tikvInfo, tiflashInfo, err := topology.FetchStoreTopology(s.pdClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range tikvInfo {
+		add(model.NodeKindTiKV, i.IP, i.Port, i.Port, i.Status)
+	}
+	for _, i := range tiflashInfo {
+		add(model.NodeKindTiFlash, i.IP, i.Port, i.Port, i.Status)
+	}
+	pdInfo, err := topology.FetchPDTopology(s.pdClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range pdInfo {
+		add(model.NodeKindPD, i.IP, i.Port, i.Port, i.Status)
+	}
+	return all, nil
+}
+
+func (s *Service) runSavedSearch(ss *SavedSearchModel) (*TaskGroupResponse, error) {
+	targets, err := s.resolveTargets(ss.Targets)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, rest.ErrBadRequest.New("No instance matches the targets")
+	}
+
+	now := time.Now()
+	req := &SearchLogRequest{
+		StartTime: now.Add(-time.Duration(ss.WindowSecs)*time.Second).UnixNano() / int64(time.Millisecond),
+		EndTime:   now.UnixNano() / int64(time.Millisecond),
+		MinLevel:  ss.MinLevel,
+		Patterns:  ss.Patterns,
+	}
+	if err := s.db.Model(ss).Update("last_run_at", now).Error; err != nil {
+		return nil, err
+	}
+	resp, err := s.startTaskGroup(req, targets, &ss.ID)
+	if err != nil {
+		return nil, err
+	}
+	s.recordSavedSearchRun(ss, resp.TaskGroup.ID, now)
+	return resp, nil
+}
+
+func (s *Service) recordSavedSearchRun(ss *SavedSearchModel, taskGroupID uint, startedAt time.Time) {
+	err := s.db.Create(&SavedSearchRunModel{
+		SavedSearchID: ss.ID,
+		TaskGroupID:   taskGroupID,
+		StartedAt:     startedAt,
+	}).Error
+	if err != nil {
+		log.Warn("Failed to record saved log search run", zap.Uint("saved_search_id", ss.ID), zap.Error(err))
+	}
+}
+
+// onTaskGroupRetried marks the run of a retried task group as unfinished, until the task group finishes again.
+func (s *Service) onTaskGroupRetried(tg *TaskGroupModel) {
+	if tg.SavedSearchID == nil {
+		return
+	}
+	s.db.Model(&SavedSearchRunModel{}).
+		Where("task_group_id = ?", tg.ID).
+		Update("finished_at", nil)
+}
+
+// onTaskGroupFinished records the result of a saved search run, including runs of retried task groups, and removes
+// task groups beyond the retention.
+func (s *Service) onTaskGroupFinished(tg *TaskGroupModel) {
+	if tg.SavedSearchID == nil {
+		return
+	}
+
+	var tasks []*TaskModel
+	s.db.Where("task_group_id = ?", tg.ID).Find(&tasks)
+	var matchCount int64
+	failedTasks := 0
+	for _, t := range tasks {
+		matchCount += t.MatchedLines
+		if t.State == TaskStateError {
+			failedTasks++
+		}
+	}
+	s.db.Model(&SavedSearchRunModel{}).
+		Where("task_group_id = ?", tg.ID).
+		Updates(map[string]interface{}{
+			"finished_at":  time.Now(),
+			"match_count":  matchCount,
+			"failed_tasks": failedTasks,
+		})
+
+	var ss SavedSearchModel
+	if err := s.db.First(&ss, *tg.SavedSearchID).Error; err != nil {
+		return
+	}
+	var taskGroups []*TaskGroupModel
+	s.db.
+		Where("saved_search_id = ? AND state = ?", ss.ID, TaskGroupStateFinished).
+		Order("id DESC").
+		Find(&taskGroups)
+	if len(taskGroups) > ss.KeepTaskGroups {
+		for _, o := range taskGroups[ss.KeepTaskGroups:] {
+			o.Delete(s.db)
+		}
+	}
+
+	var runIDs []uint
+	s.db.Model(&SavedSearchRunModel{}).
+		Where("saved_search_id = ?", ss.ID).
+		Order("id DESC").
+		Pluck("id", &runIDs)
+	if len(runIDs) > maxRunsPerSavedSearch {
+		s.db.Where("id IN ?", runIDs[maxRunsPerSavedSearch:]).Delete(&SavedSearchRunModel{})
+	}
+}
+
+func (s *Service) isSavedSearchRunning(id uint) bool {
+	var count int64
+	s.db.Model(&TaskGroupModel{}).
+		Where("saved_search_id = ? AND state = ?", id, TaskGroupStateRunning).
+		Count(&count)
+	return count > 0
+}
+
+// dueSavedSearches returns scheduled searches whose interval has elapsed since the last run, and are not running.
+func (s *Service) dueSavedSearches(now time.Time) ([]*SavedSearchModel, error) {
+	var searches []*SavedSearchModel
+	if err := s.db.Where("schedule_interval_secs > 0").Find(&searches).Error; err != nil {
+		return nil, err
+	}
+	due := make([]*SavedSearchModel, 0, len(searches))
+	for _, ss := range searches {
+		interval := time.Duration(ss.ScheduleIntervalSecs) * time.Second
+		if ss.LastRunAt != nil && now.Sub(*ss.LastRunAt) < interval {
+			continue
+		}
+		if s.isSavedSearchRunning(ss.ID) {
+			continue
+		}
+		due = append(due, ss)
+	}
+	return due, nil
+}
+
+func (s *Service) runScheduledSearches() {
+	searches, err := s.dueSavedSearches(time.Now())
+	if err != nil {
+		log.Warn("Failed to list saved log searches", zap.Error(err))
+		return
+	}
+	for _, ss := range searches {
+		if _, err := s.runSavedSearch(ss); err != nil {
+			log.Warn("Failed to run scheduled log search", zap.Uint("saved_search_id", ss.ID), zap.Error(err))
+		}
+	}
+}
+
+func (s *Service) scheduleLoop(ctx context.Context) {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.runScheduledSearches()
+		}
+	}
+}
+
+// savedSearchWriteError converts the error of saving a saved search, so that a duplicated name is reported as a bad
+// request.
+func savedSearchWriteError(ss *SavedSearchModel, err error) error {
+	if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return rest.ErrBadRequest.New("Saved search %s already exists", ss.Name)
+	}
+	return err
+}
+
+func (s *Service) getSavedSearch(c *gin.Context) (*SavedSearchModel, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return nil, false
+	}
+	var ss SavedSearchModel
+	if err := s.db.First(&ss, id).Error; err != nil {
+		rest.Error(c, err)
+		return nil, false
+	}
+	return &ss, true
+}
+
+// @Summary List saved log searches
+// @Security JwtAuth
+// @Success 200 {array} SavedSearchModel
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches [get]
+func (s *Service) GetSavedSearches(c *gin.Context) {
+	var searches []*SavedSearchModel
+	if err := s.db.Order("id").Find(&searches).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, searches)
+}
+
+// @Summary Create a saved log search
+// @Param request body SavedSearchModel true "Request body"
+// @Security JwtAuth
+// @Success 200 {object} SavedSearchModel
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches [post]
+func (s *Service) CreateSavedSearch(c *gin.Context) {
+	var ss SavedSearchModel
+	if err := c.ShouldBindJSON(&ss); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if err := ss.validate(); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	ss.ID = 0
+	ss.LastRunAt = nil
+	if err := s.db.Create(&ss).Error; err != nil {
+		rest.Error(c, savedSearchWriteError(&ss, err))
+		return
+	}
+	c.JSON(http.StatusOK, ss)
+}
+
+// @Summary Update a saved log search
+// @Param id path string true "saved search id"
+// @Param request body SavedSearchModel true "Request body"
+// @Security JwtAuth
+// @Success 200 {object} SavedSearchModel
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches/{id} [put]
+func (s *Service) UpdateSavedSearch(c *gin.Context) {
+	ss, ok := s.getSavedSearch(c)
+	if !ok {
+		return
+	}
+	var req SavedSearchModel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if err := req.validate(); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	req.ID = ss.ID
+	req.LastRunAt = ss.LastRunAt
+	req.CreatedAt = ss.CreatedAt
+	if err := s.db.Save(&req).Error; err != nil {
+		rest.Error(c, savedSearchWriteError(&req, err))
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// @Summary Delete a saved log search and all of its task groups
+// @Param id path string true "saved search id"
+// @Security JwtAuth
+// @Success 200 {object} rest.EmptyResponse
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches/{id} [delete]
+func (s *Service) DeleteSavedSearch(c *gin.Context) {
+	ss, ok := s.getSavedSearch(c)
+	if !ok {
+		return
+	}
+	if s.isSavedSearchRunning(ss.ID) {
+		rest.Error(c, rest.ErrBadRequest.New("Saved search is running"))
+		return
+	}
+	var taskGroups []*TaskGroupModel
+	s.db.Where("saved_search_id = ?", ss.ID).Find(&taskGroups)
+	for _, tg := range taskGroups {
+		tg.Delete(s.db)
+	}
+	s.db.Where("saved_search_id = ?", ss.ID).Delete(&SavedSearchRunModel{})
+	if err := s.db.Delete(ss).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rest.EmptyResponse{})
+}
+
+// @Summary Run a saved log search now
+// @Param id path string true "saved search id"
+// @Security JwtAuth
+// @Success 200 {object} TaskGroupResponse
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches/{id}/run [post]
+func (s *Service) RunSavedSearch(c *gin.Context) {
+	ss, ok := s.getSavedSearch(c)
+	if !ok {
+		return
+	}
+	if s.isSavedSearchRunning(ss.ID) {
+		rest.Error(c, rest.ErrBadRequest.New("Saved search is already running"))
+		return
+	}
+	resp, err := s.runSavedSearch(ss)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @Summary List runs of a saved log search, including match counts
+// @Param id path string true "saved search id"
+// @Security JwtAuth
+// @Success 200 {array} SavedSearchRunModel
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/saved_searches/{id}/runs [get]
+func (s *Service) GetSavedSearchRuns(c *gin.Context) {
+	ss, ok := s.getSavedSearch(c)
+	if !ok {
+		return
+	}
+	var runs []*SavedSearchRunModel
+	if err := s.db.Where("saved_search_id = ?", ss.ID).Order("id").Find(&runs).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
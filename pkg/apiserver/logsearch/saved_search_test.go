@@ -0,0 +1,159 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+var _ = Suite(&testSavedSearchSuite{})
+
+type testSavedSearchSuite struct {
+	service *Service
+}
+
+func (t *testSavedSearchSuite) SetUpTest(c *C) {
+	t.service = &Service{db: newTestDB(c)}
+}
+
+func (t *testSavedSearchSuite) newSavedSearch(c *C, name string, scheduleIntervalSecs int64, lastRunAt *time.Time) *SavedSearchModel {
+	ss := &SavedSearchModel{
+		Name:                 name,
+		Targets:              &TargetSelector{Kinds: []model.NodeKind{model.NodeKindTiKV}},
+		WindowSecs:           3600,
+		ScheduleIntervalSecs: scheduleIntervalSecs,
+		KeepTaskGroups:       1,
+		LastRunAt:            lastRunAt,
+	}
+	c.Assert(t.service.db.Create(ss).Error, IsNil)
+	return ss
+}
+
+func (t *testSavedSearchSuite) Test_Validate(c *C) {
+	ss := &SavedSearchModel{
+		Name:       "panics",
+		Patterns:   StringList{"panic|fatal"},
+		Targets:    &TargetSelector{Kinds: []model.NodeKind{model.NodeKindTiKV}},
+		WindowSecs: 86400,
+	}
+	c.Assert(ss.validate(), IsNil)
+	c.Assert(ss.KeepTaskGroups, Equals, DefaultKeepTaskGroups)
+
+	ss.ScheduleIntervalSecs = 60
+	c.Assert(ss.validate(), NotNil)
+	ss.ScheduleIntervalSecs = 86400
+	c.Assert(ss.validate(), IsNil)
+
+	ss.Targets = &TargetSelector{}
+	c.Assert(ss.validate(), NotNil)
+	ss.Targets = &TargetSelector{Instances: []string{"127.0.0.1:20160"}}
+	c.Assert(ss.validate(), IsNil)
+
+	ss.KeepTaskGroups = MaxKeepTaskGroups + 1
+	c.Assert(ss.validate(), NotNil)
+}
+
+func (t *testSavedSearchSuite) Test_DueSavedSearches(c *C) {
+	now := time.Now()
+	recent := now.Add(-time.Hour)
+	old := now.Add(-2 * 24 * time.Hour)
+	never := t.newSavedSearch(c, "never run", 86400, nil)
+	t.newSavedSearch(c, "recently run", 86400, &recent)
+	due := t.newSavedSearch(c, "due", 86400, &old)
+	running := t.newSavedSearch(c, "running", 86400, &old)
+	t.newSavedSearch(c, "manual", 0, nil)
+	c.Assert(t.service.db.Create(&TaskGroupModel{State: TaskGroupStateRunning, SavedSearchID: &running.ID}).Error, IsNil)
+
+	searches, err := t.service.dueSavedSearches(now)
+	c.Assert(err, IsNil)
+	ids := make([]uint, 0, len(searches))
+	for _, ss := range searches {
+		ids = append(ids, ss.ID)
+	}
+	c.Assert(ids, DeepEquals, []uint{never.ID, due.ID})
+}
+
+func (t *testSavedSearchSuite) Test_RecordRuns(c *C) {
+	db := t.service.db
+	ss := t.newSavedSearch(c, "panics", 0, nil)
+
+	// The first run finishes with a failed task.
+	tg1 := &TaskGroupModel{State: TaskGroupStateFinished, SavedSearchID: &ss.ID}
+	c.Assert(db.Create(tg1).Error, IsNil)
+	t.service.recordSavedSearchRun(ss, tg1.ID, time.Now())
+	c.Assert(db.Create([]*TaskModel{
+		// Match counts are not limited by indexed lines.
+		{TaskGroupID: tg1.ID, State: TaskStateFinished, IndexedLines: TaskMaxIndexedLines, MatchedLines: TaskMaxIndexedLines + 5},
+		{TaskGroupID: tg1.ID, State: TaskStateError},
+	}).Error, IsNil)
+	t.service.onTaskGroupFinished(tg1)
+
+	var run SavedSearchRunModel
+	c.Assert(db.Where("task_group_id = ?", tg1.ID).First(&run).Error, IsNil)
+	c.Assert(run.FinishedAt, NotNil)
+	c.Assert(run.MatchCount, Equals, int64(TaskMaxIndexedLines+5))
+	c.Assert(run.FailedTasks, Equals, 1)
+
+	// Retrying the failed task updates the run.
+	t.service.onTaskGroupRetried(tg1)
+	c.Assert(db.Where("task_group_id = ?", tg1.ID).First(&run).Error, IsNil)
+	c.Assert(run.FinishedAt, IsNil)
+	c.Assert(db.Model(&TaskModel{}).
+		Where("task_group_id = ? AND state = ?", tg1.ID, TaskStateError).
+		Updates(map[string]interface{}{"state": TaskStateFinished, "matched_lines": 3}).Error, IsNil)
+	t.service.onTaskGroupFinished(tg1)
+	c.Assert(db.Where("task_group_id = ?", tg1.ID).First(&run).Error, IsNil)
+	c.Assert(run.FinishedAt, NotNil)
+	c.Assert(run.MatchCount, Equals, int64(TaskMaxIndexedLines+8))
+	c.Assert(run.FailedTasks, Equals, 0)
+
+	// Task groups beyond the retention are removed, while runs are kept.
+	tg2 := &TaskGroupModel{State: TaskGroupStateFinished, SavedSearchID: &ss.ID}
+	c.Assert(db.Create(tg2).Error, IsNil)
+	t.service.recordSavedSearchRun(ss, tg2.ID, time.Now())
+	t.service.onTaskGroupFinished(tg2)
+	var taskGroupIDs []uint
+	c.Assert(db.Model(&TaskGroupModel{}).Pluck("id", &taskGroupIDs).Error, IsNil)
+	c.Assert(taskGroupIDs, DeepEquals, []uint{tg2.ID})
+	var runs int64
+	c.Assert(db.Model(&SavedSearchRunModel{}).Where("saved_search_id = ?", ss.ID).Count(&runs).Error, IsNil)
+	c.Assert(runs, Equals, int64(2))
+}
+
+func (t *testSavedSearchSuite) Test_DuplicatedName(c *C) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(rest.ErrorHandlerFn())
+	engine.POST("/saved_searches", t.service.CreateSavedSearch)
+	engine.PUT("/saved_searches/:id", t.service.UpdateSavedSearch)
+
+	request := func(method, path string, ss *SavedSearchModel) int {
+		body, err := json.Marshal(ss)
+		c.Assert(err, IsNil)
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return w.Code
+	}
+	ss := &SavedSearchModel{
+		Name:       "panics",
+		Targets:    &TargetSelector{Kinds: []model.NodeKind{model.NodeKindTiKV}},
+		WindowSecs: 3600,
+	}
+	c.Assert(request(http.MethodPost, "/saved_searches", ss), Equals, http.StatusOK)
+	c.Assert(request(http.MethodPost, "/saved_searches", ss), Equals, http.StatusBadRequest)
+
+	ss.Name = "errors"
+	c.Assert(request(http.MethodPost, "/saved_searches", ss), Equals, http.StatusOK)
+	ss.Name = "panics"
+	c.Assert(request(http.MethodPut, "/saved_searches/2", ss), Equals, http.StatusBadRequest)
+}
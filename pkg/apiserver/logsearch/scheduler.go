@@ -12,6 +12,9 @@ import (
 const (
 	TaskMaxPreviewLines      = 500
 	TaskGroupMaxPreviewLines = 5000
+	TaskMaxIndexedLines      = 1000000
+
+	lineInsertBatchSize = 500
 )
 
 type Scheduler struct {
@@ -40,6 +43,7 @@ func (s *Scheduler) AsyncStart(taskGroupModel *TaskGroupModel, tasksModel []*Tas
 		tasks:                  nil, // Tasks are created only after successfully adding to the sync map.
 		tasksMu:                sync.Mutex{},
 		maxPreviewLinesPerTask: previewsLinesPerTask,
+		patterns:               newPatternCollector(),
 	}
 	_, alreadyRunning := s.runningTaskGroups.LoadOrStore(taskGroup.model.ID, taskGroup)
 	if alreadyRunning {
@@ -52,6 +56,7 @@ func (s *Scheduler) AsyncStart(taskGroupModel *TaskGroupModel, tasksModel []*Tas
 	go func() {
 		taskGroup.SyncRun()
 		s.runningTaskGroups.Delete(taskGroup.model.ID)
+		s.service.onTaskGroupFinished(taskGroup.model)
 
 		log.Debug("Scheduler task group finished", zap.Uint("task_group_id", taskGroupModel.ID))
 	}()
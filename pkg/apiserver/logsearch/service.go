@@ -4,13 +4,17 @@ package logsearch
 
 import (
 	"context"
+	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
@@ -19,6 +23,7 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/pd"
 	"github.com/pingcap/tidb-dashboard/util/rest"
 )
 
@@ -27,12 +32,16 @@ type Service struct {
 	lifecycleCtx context.Context
 
 	config            *config.Config
+	configManager     *config.DynamicConfigManager
 	logStoreDirectory string
 	db                *dbstore.DB
+	pdClient          *pd.Client
+	etcdClient        *clientv3.Client
 	scheduler         *Scheduler
+	tailLimiter       *tailLimiter
 }
 
-func NewService(lc fx.Lifecycle, config *config.Config, db *dbstore.DB) *Service {
+func NewService(lc fx.Lifecycle, config *config.Config, configManager *config.DynamicConfigManager, db *dbstore.DB, pdClient *pd.Client, etcdClient *clientv3.Client) *Service {
 	dir := config.TempDir
 	if dir == "" {
 		var err error
@@ -49,9 +58,13 @@ func NewService(lc fx.Lifecycle, config *config.Config, db *dbstore.DB) *Service
 
 	service := &Service{
 		config:            config,
+		configManager:     configManager,
 		logStoreDirectory: dir,
 		db:                db,
+		pdClient:          pdClient,
+		etcdClient:        etcdClient,
 		scheduler:         nil, // will be filled after scheduler is created
+		tailLimiter:       newTailLimiter(),
 	}
 	scheduler := NewScheduler(service)
 	service.scheduler = scheduler
@@ -59,6 +72,8 @@ func NewService(lc fx.Lifecycle, config *config.Config, db *dbstore.DB) *Service
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			service.lifecycleCtx = ctx
+			go service.scheduleLoop(ctx)
+			go service.gcLoop(ctx)
 			return nil
 		},
 	})
@@ -70,16 +85,29 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/logs")
 	{
 		endpoint.GET("/download", s.DownloadLogs)
+		endpoint.GET("/tail", s.TailLogs)
 		endpoint.Use(auth.MWAuthRequired())
 		{
 			endpoint.GET("/download/acquire_token", s.GetDownloadToken)
-			endpoint.PUT("/taskgroup", s.CreateTaskGroup)
+			endpoint.POST("/tail/acquire_token", auth.MWRequirePermission(user.PermLogSearchRun), s.GetTailToken)
+			endpoint.PUT("/taskgroup", auth.MWRequirePermission(user.PermLogSearchRun), s.CreateTaskGroup)
 			endpoint.GET("/taskgroups", s.GetAllTaskGroups)
 			endpoint.GET("/taskgroups/:id", s.GetTaskGroup)
 			endpoint.GET("/taskgroups/:id/preview", s.GetTaskGroupPreview)
-			endpoint.POST("/taskgroups/:id/retry", s.RetryTask)
-			endpoint.POST("/taskgroups/:id/cancel", s.CancelTask)
-			endpoint.DELETE("/taskgroups/:id", s.DeleteTaskGroup)
+			endpoint.GET("/taskgroups/:id/lines", s.GetTaskGroupLines)
+			endpoint.GET("/taskgroups/:id/patterns", s.GetTaskGroupPatterns)
+			endpoint.POST("/taskgroups/:id/retry", auth.MWRequirePermission(user.PermLogSearchRun), s.RetryTask)
+			endpoint.POST("/taskgroups/:id/cancel", auth.MWRequirePermission(user.PermLogSearchRun), s.CancelTask)
+			endpoint.DELETE("/taskgroups/:id", auth.MWRequirePermission(user.PermLogSearchRun), s.DeleteTaskGroup)
+			endpoint.GET("/saved_searches", s.GetSavedSearches)
+			endpoint.POST("/saved_searches", auth.MWRequirePermission(user.PermLogSearchRun), s.CreateSavedSearch)
+			endpoint.PUT("/saved_searches/:id", auth.MWRequirePermission(user.PermLogSearchRun), s.UpdateSavedSearch)
+			endpoint.DELETE("/saved_searches/:id", auth.MWRequirePermission(user.PermLogSearchRun), s.DeleteSavedSearch)
+			endpoint.POST("/saved_searches/:id/run", auth.MWRequirePermission(user.PermLogSearchRun), s.RunSavedSearch)
+			endpoint.GET("/saved_searches/:id/runs", s.GetSavedSearchRuns)
+			endpoint.GET("/storage", s.GetStorageUsage)
+			endpoint.GET("/config", s.GetDynamicConfig)
+			endpoint.PUT("/config", auth.MWRequirePermission(user.PermLogSearchConfig), s.SetDynamicConfig)
 		}
 	}
 }
@@ -100,6 +128,7 @@ type TaskGroupResponse struct {
 // @Success 200 {object} TaskGroupResponse
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 // @Router /logs/taskgroup [put]
 func (s *Service) CreateTaskGroup(c *gin.Context) {
@@ -112,18 +141,27 @@ func (s *Service) CreateTaskGroup(c *gin.Context) {
 		rest.Error(c, rest.ErrBadRequest.New("Expect at least 1 target"))
 		return
 	}
-	stats := model.NewRequestTargetStatisticsFromArray(&req.Targets)
+	resp, err := s.startTaskGroup(&req.Request, req.Targets, nil)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Service) startTaskGroup(req *SearchLogRequest, targets []model.RequestTargetNode, savedSearchID *uint) (*TaskGroupResponse, error) {
+	stats := model.NewRequestTargetStatisticsFromArray(&targets)
 	taskGroup := TaskGroupModel{
-		SearchRequest: &req.Request,
+		SearchRequest: req,
 		State:         TaskGroupStateRunning,
 		TargetStats:   stats,
+		SavedSearchID: savedSearchID,
 	}
 	if err := s.db.Create(&taskGroup).Error; err != nil {
-		rest.Error(c, err)
-		return
+		return nil, err
 	}
-	tasks := make([]*TaskModel, 0, len(req.Targets))
-	for _, t := range req.Targets {
+	tasks := make([]*TaskModel, 0, len(targets))
+	for _, t := range targets {
 		target := t
 		task := &TaskModel{
 			TaskGroupID: taskGroup.ID,
@@ -137,11 +175,10 @@ func (s *Service) CreateTaskGroup(c *gin.Context) {
 	if !s.scheduler.AsyncStart(&taskGroup, tasks) {
 		log.Error("Failed to start task group", zap.Uint("task_group_id", taskGroup.ID))
 	}
-	resp := TaskGroupResponse{
+	return &TaskGroupResponse{
 		TaskGroup: taskGroup,
 		Tasks:     tasks,
-	}
-	c.JSON(http.StatusOK, resp)
+	}, nil
 }
 
 // @Summary List all log search task groups
@@ -211,12 +248,100 @@ func (s *Service) GetTaskGroupPreview(c *gin.Context) {
 	c.JSON(http.StatusOK, lines)
 }
 
+// @Summary Browse all lines of a log search task group
+// @Description Lines from all instances are merged in time order. Use `next_cursor` in the response to fetch the next page.
+// @Param id path string true "task group id"
+// @Param q query GetLinesRequest true "Query"
+// @Security JwtAuth
+// @Success 200 {object} GetLinesResponse
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/taskgroups/{id}/lines [get]
+func (s *Service) GetTaskGroupLines(c *gin.Context) {
+	taskGroupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var req GetLinesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	resp, err := queryLines(s.db, uint(taskGroupID), &req)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type GetPatternsRequest struct {
+	Limit int `json:"limit" form:"limit"`
+}
+
+type GetPatternsResponse struct {
+	Patterns         []PatternModel `json:"patterns"`
+	TotalPatterns    int64          `json:"total_patterns"`
+	UnclusteredLines int64          `json:"unclustered_lines"`
+}
+
+// @Summary Get patterns of log lines in a log search task group, ordered by number of lines
+// @Description Patterns are available after the task group is finished.
+// @Param id path string true "task group id"
+// @Param q query GetPatternsRequest true "Query"
+// @Security JwtAuth
+// @Success 200 {object} GetPatternsResponse
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /logs/taskgroups/{id}/patterns [get]
+func (s *Service) GetTaskGroupPatterns(c *gin.Context) {
+	taskGroupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var req GetPatternsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	if req.Limit <= 0 || req.Limit > maxLinesPageSize {
+		req.Limit = defaultLinesPageSize
+	}
+
+	var taskGroup TaskGroupModel
+	if err := s.db.First(&taskGroup, taskGroupID).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	resp := GetPatternsResponse{UnclusteredLines: taskGroup.UnclusteredLines}
+	query := s.db.Model(&PatternModel{}).Where("task_group_id = ?", taskGroupID)
+	if err := query.Count(&resp.TotalPatterns).Error; err != nil {
+		rest.Error(c, err)
+		return
+	}
+	err = s.db.
+		Where("task_group_id = ?", taskGroupID).
+		Order("count DESC, id").
+		Limit(req.Limit).
+		Find(&resp.Patterns).Error
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 // @Summary Retry failed tasks in a log search task group
 // @Param id path string true "task group id"
 // @Security JwtAuth
 // @Success 200 {object} rest.EmptyResponse
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 // @Router /logs/taskgroups/{id}/retry [post]
 func (s *Service) RetryTask(c *gin.Context) {
@@ -254,6 +379,7 @@ func (s *Service) RetryTask(c *gin.Context) {
 		s.db.Save(task)
 	}
 
+	s.onTaskGroupRetried(&taskGroup)
 	if !s.scheduler.AsyncStart(&taskGroup, tasks) {
 		log.Error("Failed to retry task group", zap.Uint("task_group_id", taskGroup.ID))
 	}
@@ -265,6 +391,7 @@ func (s *Service) RetryTask(c *gin.Context) {
 // @Security JwtAuth
 // @Success 200 {object} rest.EmptyResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Failure 400 {object} rest.ErrorResponse
 // @Router /logs/taskgroups/{id}/cancel [post]
 func (s *Service) CancelTask(c *gin.Context) {
@@ -292,6 +419,7 @@ func (s *Service) CancelTask(c *gin.Context) {
 // @Security JwtAuth
 // @Success 200 {object} rest.EmptyResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 // @Router /logs/taskgroups/{id} [delete]
 func (s *Service) DeleteTaskGroup(c *gin.Context) {
@@ -361,3 +489,101 @@ func (s *Service) DownloadLogs(c *gin.Context) {
 		serveMultipleTaskForDownload(tasks, c)
 	}
 }
+
+// @Summary Generate a token for tailing logs
+// @Description The token can be used in `/logs/tail` within 1 minute.
+// @Produce plain
+// @Param request body TailLogsRequest true "Request body"
+// @Security JwtAuth
+// @Success 200 {string} string "xxx"
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Router /logs/tail/acquire_token [post]
+func (s *Service) GetTailToken(c *gin.Context) {
+	var req TailLogsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if len(req.Targets) == 0 || len(req.Targets) > TailMaxTargets {
+		rest.Error(c, rest.ErrBadRequest.New("Expect 1 to %d targets", TailMaxTargets))
+		return
+	}
+	if int(req.MinLevel) >= len(PBLogLevelSlice) || req.MinLevel < 0 {
+		rest.Error(c, rest.ErrBadRequest.New("Invalid min level"))
+		return
+	}
+	b, err := json.Marshal(tailTokenData{
+		Owner:   utils.GetSession(c).DisplayName,
+		Request: req,
+	})
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	token, err := utils.NewJWTStringWithExpire("logs/tail", string(b), time.Minute)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.String(http.StatusOK, token)
+}
+
+// @Summary Tail logs
+// @Description New log lines of the selected targets are pushed as Server-Sent Events. Each `lines` event
+// @Description contains a TailEvent. The stream is closed after 1 hour.
+// @Produce text/event-stream
+// @Param token query string true "tail token"
+// @Success 200 {object} TailEvent
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 429 {object} rest.ErrorResponse
+// @Router /logs/tail [get]
+func (s *Service) TailLogs(c *gin.Context) {
+	str, err := utils.ParseJWTString("logs/tail", c.Query("token"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var data tailTokenData
+	if err := json.Unmarshal([]byte(str), &data); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if !s.tailLimiter.acquire(data.Owner) {
+		rest.Error(c, rest.ErrBadRequest.New("Too many log tail streams, at most %d are allowed", TailMaxStreamsPerUser))
+		c.Status(http.StatusTooManyRequests)
+		return
+	}
+	defer s.tailLimiter.release(data.Owner)
+
+	t := newTailer(s.config, &data.Request)
+	defer t.close()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), TailMaxDuration)
+	defer cancel()
+	ch := make(chan *TailEvent, tailBufferEvents)
+	go t.run(ctx, ch)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(tailHeartbeatInterval)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent("lines", ev)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", "")
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	})
+}
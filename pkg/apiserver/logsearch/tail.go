@@ -0,0 +1,265 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/kvproto/pkg/diagnosticspb"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+const (
+	TailMaxTargets        = 20
+	TailMaxStreamsPerUser = 3
+	TailMaxDuration       = time.Hour
+
+	tailPollInterval      = 2 * time.Second
+	tailPollTimeout       = 10 * time.Second
+	tailHeartbeatInterval = 15 * time.Second
+	// tailMaxLinesPerPoll limits lines fetched from a single target in one poll, so that a burst of logs does not
+	// block the stream for too long. Remaining lines are fetched in the next poll.
+	tailMaxLinesPerPoll = 2000
+	// tailBufferEvents is the number of events buffered for a slow client. Further events are dropped and the
+	// number of dropped lines is reported in the next delivered event.
+	tailBufferEvents = 100
+)
+
+type TailLogsRequest struct {
+	Targets  []model.RequestTargetNode `json:"targets" binding:"required"`
+	MinLevel LogLevel                  `json:"min_level"`
+	Patterns []string                  `json:"patterns"`
+}
+
+type TailLine struct {
+	Instance string                 `json:"instance"`
+	Time     int64                  `json:"time"`
+	Level    diagnosticspb.LogLevel `json:"level" swaggertype:"integer"`
+	Message  string                 `json:"message"`
+}
+
+type TailError struct {
+	Instance string `json:"instance"`
+	Error    string `json:"error"`
+}
+
+// TailEvent is pushed to the client as the data of a `lines` event.
+type TailEvent struct {
+	Lines   []TailLine  `json:"lines"`
+	Errors  []TailError `json:"errors,omitempty"`
+	Dropped int         `json:"dropped,omitempty"`
+}
+
+type tailTokenData struct {
+	Owner   string          `json:"owner"`
+	Request TailLogsRequest `json:"request"`
+}
+
+// tailLimiter limits the number of concurrent tail streams of each user.
+type tailLimiter struct {
+	mu      sync.Mutex
+	streams map[string]int
+}
+
+func newTailLimiter() *tailLimiter {
+	return &tailLimiter{streams: map[string]int{}}
+}
+
+func (l *tailLimiter) acquire(owner string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.streams[owner] >= TailMaxStreamsPerUser {
+		return false
+	}
+	l.streams[owner]++
+	return true
+}
+
+func (l *tailLimiter) release(owner string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.streams[owner]--
+	if l.streams[owner] <= 0 {
+		delete(l.streams, owner)
+	}
+}
+
+type tailTarget struct {
+	node   model.RequestTargetNode
+	conn   *grpc.ClientConn
+	client diagnosticspb.DiagnosticsClient
+	err    error
+	// since is the time of the latest received line. Lines at this time are polled again in the same order, thus the
+	// first sinceLines of them, which are already delivered, are skipped to avoid duplicates.
+	since      int64
+	sinceLines int
+}
+
+// accept returns the lines not delivered yet. offset is the number of lines at t.since in the current poll before
+// msgs.
+func (t *tailTarget) accept(msgs []*diagnosticspb.LogMessage, offset *int) []TailLine {
+	lines := make([]TailLine, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Time < t.since {
+			continue
+		}
+		if msg.Time > t.since {
+			t.since = msg.Time
+			t.sinceLines = 0
+			*offset = 0
+		}
+		*offset++
+		if *offset <= t.sinceLines {
+			continue
+		}
+		t.sinceLines = *offset
+		lines = append(lines, TailLine{
+			Instance: t.node.DisplayName,
+			Time:     msg.Time,
+			Level:    msg.Level,
+			Message:  msg.Message,
+		})
+	}
+	return lines
+}
+
+func (t *tailTarget) poll(ctx context.Context, req *TailLogsRequest) ([]TailLine, error) {
+	ctx, cancel := context.WithTimeout(ctx, tailPollTimeout)
+	defer cancel()
+
+	sr := &SearchLogRequest{
+		StartTime: t.since,
+		EndTime:   math.MaxInt64,
+		MinLevel:  req.MinLevel,
+		Patterns:  caseInsensitivePatterns(req.Patterns),
+	}
+	stream, err := t.client.SearchLog(ctx, sr.ConvertToPB(diagnosticspb.SearchLogRequest_Normal))
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]TailLine, 0)
+	offset := 0
+	for len(lines) < tailMaxLinesPerPoll {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, t.accept(res.Messages, &offset)...)
+	}
+	return lines, nil
+}
+
+// tailer polls new log lines from all targets and merges them in time order.
+type tailer struct {
+	req     *TailLogsRequest
+	targets []*tailTarget
+}
+
+func newTailer(config *config.Config, req *TailLogsRequest) *tailer {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	t := &tailer{req: req}
+	for i := range req.Targets {
+		target := &tailTarget{
+			node:  req.Targets[i],
+			since: now,
+		}
+		target.conn, target.err = dialDiagnostics(config, &target.node)
+		if target.err == nil {
+			target.client = diagnosticspb.NewDiagnosticsClient(target.conn)
+		}
+		t.targets = append(t.targets, target)
+	}
+	return t
+}
+
+func (t *tailer) close() {
+	for _, target := range t.targets {
+		if target.conn != nil {
+			_ = target.conn.Close()
+		}
+	}
+}
+
+func (t *tailer) pollOnce(ctx context.Context) *TailEvent {
+	ev := &TailEvent{Lines: make([]TailLine, 0)}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, target := range t.targets {
+		if target.client == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(target *tailTarget) {
+			defer wg.Done()
+			lines, err := target.poll(ctx, t.req)
+			mu.Lock()
+			defer mu.Unlock()
+			ev.Lines = append(ev.Lines, lines...)
+			if err != nil && ctx.Err() == nil {
+				ev.Errors = append(ev.Errors, TailError{Instance: target.node.DisplayName, Error: err.Error()})
+			}
+		}(target)
+	}
+	wg.Wait()
+	sort.SliceStable(ev.Lines, func(i, j int) bool {
+		return ev.Lines[i].Time < ev.Lines[j].Time
+	})
+	return ev
+}
+
+// run polls targets until ctx is done, then closes ch. Events are dropped instead of blocking when the client
+// does not consume them in time.
+func (t *tailer) run(ctx context.Context, ch chan<- *TailEvent) {
+	defer close(ch)
+
+	initial := &TailEvent{Lines: make([]TailLine, 0)}
+	for _, target := range t.targets {
+		if target.err != nil {
+			initial.Errors = append(initial.Errors, TailError{Instance: target.node.DisplayName, Error: target.err.Error()})
+		}
+	}
+	ch <- initial
+
+	dropped := 0
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ev := t.pollOnce(ctx)
+		if len(ev.Lines) == 0 && len(ev.Errors) == 0 {
+			continue
+		}
+		dropped = sendTailEvent(ch, ev, dropped)
+	}
+}
+
+// sendTailEvent sends the event with the number of lines dropped before it, or drops it if the client is too slow.
+// It returns the number of dropped lines to be reported in the next event.
+func sendTailEvent(ch chan<- *TailEvent, ev *TailEvent, dropped int) int {
+	ev.Dropped = dropped
+	select {
+	case ch <- ev:
+		return 0
+	default:
+		log.Debug("Log tail client is too slow, lines are dropped", zap.Int("lines", len(ev.Lines)))
+		return dropped + len(ev.Lines)
+	}
+}
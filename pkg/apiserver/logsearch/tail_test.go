@@ -0,0 +1,140 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logsearch
+
+import (
+	"context"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/kvproto/pkg/diagnosticspb"
+	"google.golang.org/grpc"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+)
+
+var _ = Suite(&testTailSuite{})
+
+type testTailSuite struct{}
+
+func (t *testTailSuite) Test_TailLimiter(c *C) {
+	l := newTailLimiter()
+	for i := 0; i < TailMaxStreamsPerUser; i++ {
+		c.Assert(l.acquire("foo"), Equals, true)
+	}
+	c.Assert(l.acquire("foo"), Equals, false)
+	c.Assert(l.acquire("bar"), Equals, true)
+
+	l.release("foo")
+	c.Assert(l.acquire("foo"), Equals, true)
+
+	l.release("bar")
+	_, ok := l.streams["bar"]
+	c.Assert(ok, Equals, false)
+}
+
+// fakeDiagnosticsClient returns log messages of the target not earlier than the start time of the request.
+type fakeDiagnosticsClient struct {
+	diagnosticspb.DiagnosticsClient
+	messages []*diagnosticspb.LogMessage
+}
+
+type fakeSearchLogClient struct {
+	grpc.ClientStream
+	batches [][]*diagnosticspb.LogMessage
+}
+
+func (f *fakeDiagnosticsClient) SearchLog(ctx context.Context, in *diagnosticspb.SearchLogRequest, opts ...grpc.CallOption) (diagnosticspb.Diagnostics_SearchLogClient, error) {
+	stream := &fakeSearchLogClient{}
+	for _, msg := range f.messages {
+		if msg.Time >= in.StartTime {
+			// One message per batch, so that the offset is kept across batches.
+			stream.batches = append(stream.batches, []*diagnosticspb.LogMessage{msg})
+		}
+	}
+	return stream, nil
+}
+
+func (f *fakeSearchLogClient) Recv() (*diagnosticspb.SearchLogResponse, error) {
+	if len(f.batches) == 0 {
+		return nil, io.EOF
+	}
+	res := &diagnosticspb.SearchLogResponse{Messages: f.batches[0]}
+	f.batches = f.batches[1:]
+	return res, nil
+}
+
+func logMessage(time int64, message string) *diagnosticspb.LogMessage {
+	return &diagnosticspb.LogMessage{Time: time, Level: diagnosticspb.LogLevel_Info, Message: message}
+}
+
+func tailMessagesOf(lines []TailLine) []string {
+	r := make([]string, 0, len(lines))
+	for _, l := range lines {
+		r = append(r, l.Instance+":"+l.Message)
+	}
+	return r
+}
+
+func (t *testTailSuite) Test_TailDedup(c *C) {
+	client := &fakeDiagnosticsClient{messages: []*diagnosticspb.LogMessage{
+		logMessage(90, "too old"),
+		logMessage(100, "same"),
+		logMessage(100, "same"),
+	}}
+	target := &tailTarget{node: model.RequestTargetNode{DisplayName: "a"}, client: client, since: 100}
+	req := &TailLogsRequest{}
+
+	lines, err := target.poll(context.Background(), req)
+	c.Assert(err, IsNil)
+	// Identical lines at the same time are all delivered.
+	c.Assert(tailMessagesOf(lines), DeepEquals, []string{"a:same", "a:same"})
+
+	// Lines at the latest time are polled again, and only new ones are delivered.
+	client.messages = append(client.messages, logMessage(100, "same"), logMessage(101, "next"))
+	lines, err = target.poll(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(tailMessagesOf(lines), DeepEquals, []string{"a:same", "a:next"})
+
+	lines, err = target.poll(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(lines, HasLen, 0)
+}
+
+func (t *testTailSuite) Test_TailMergeTargets(c *C) {
+	tl := &tailer{req: &TailLogsRequest{}}
+	tl.targets = []*tailTarget{
+		{node: model.RequestTargetNode{DisplayName: "a"}, client: &fakeDiagnosticsClient{messages: []*diagnosticspb.LogMessage{
+			logMessage(1, "a1"), logMessage(3, "a3"),
+		}}},
+		{node: model.RequestTargetNode{DisplayName: "b"}, client: &fakeDiagnosticsClient{messages: []*diagnosticspb.LogMessage{
+			logMessage(2, "b2"), logMessage(4, "b4"),
+		}}},
+		// Targets failed to connect are skipped.
+		{node: model.RequestTargetNode{DisplayName: "c"}},
+	}
+	ev := tl.pollOnce(context.Background())
+	c.Assert(tailMessagesOf(ev.Lines), DeepEquals, []string{"a:a1", "b:b2", "a:a3", "b:b4"})
+	c.Assert(ev.Errors, HasLen, 0)
+}
+
+func (t *testTailSuite) Test_TailDropped(c *C) {
+	ch := make(chan *TailEvent, 1)
+	ev := func(n int) *TailEvent {
+		return &TailEvent{Lines: make([]TailLine, n)}
+	}
+
+	dropped := sendTailEvent(ch, ev(2), 0)
+	c.Assert(dropped, Equals, 0)
+	// The client does not consume events, so following events are dropped.
+	dropped = sendTailEvent(ch, ev(3), dropped)
+	dropped = sendTailEvent(ch, ev(4), dropped)
+	c.Assert(dropped, Equals, 7)
+
+	c.Assert((<-ch).Dropped, Equals, 0)
+	dropped = sendTailEvent(ch, ev(1), dropped)
+	c.Assert(dropped, Equals, 0)
+	delivered := <-ch
+	c.Assert(delivered.Dropped, Equals, 7)
+	c.Assert(delivered.Lines, HasLen, 1)
+}
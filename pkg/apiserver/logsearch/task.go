@@ -24,6 +24,7 @@ import (
 	"google.golang.org/grpc/credentials"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
 )
 
 // MaxRecvMsgSize set max gRPC receive message size received from server. If any message size is larger than
@@ -36,6 +37,7 @@ type TaskGroup struct {
 	tasks                  []*Task
 	tasksMu                sync.Mutex
 	maxPreviewLinesPerTask int
+	patterns               *patternCollector
 }
 
 func (tg *TaskGroup) InitTasks(ctx context.Context, taskModels []*TaskModel) {
@@ -54,6 +56,7 @@ func (tg *TaskGroup) InitTasks(ctx context.Context, taskModels []*TaskModel) {
 			model:     taskModel,
 			ctx:       ctx,
 			cancel:    cancel,
+			patterns:  newPatternCollector(),
 		})
 	}
 }
@@ -69,6 +72,11 @@ func (tg *TaskGroup) SyncRun() {
 		tg.service.db.Save(tg.model)
 	}
 
+	// Patterns of tasks that are not retried are kept.
+	if err := tg.patterns.load(tg.service.db, tg.model); err != nil {
+		log.Warn("Failed to load log patterns", zap.Uint("task_group_id", tg.model.ID), zap.Error(err))
+	}
+
 	wg := sync.WaitGroup{}
 	for _, task := range tg.tasks {
 		wg.Add(1)
@@ -79,6 +87,10 @@ func (tg *TaskGroup) SyncRun() {
 	}
 	wg.Wait()
 
+	if err := tg.patterns.save(tg.service.db, tg.model); err != nil {
+		log.Warn("Failed to save log patterns", zap.Uint("task_group_id", tg.model.ID), zap.Error(err))
+	}
+
 	log.Debug("LogSearchTaskGroup finished", zap.Uint("task_group_id", tg.model.ID))
 	tg.model.State = TaskGroupStateFinished
 	tg.service.db.Save(tg.model)
@@ -101,6 +113,7 @@ type Task struct {
 	model     *TaskModel
 	ctx       context.Context
 	cancel    context.CancelFunc
+	patterns  *patternCollector
 }
 
 func (t *Task) String() string {
@@ -149,6 +162,7 @@ func (t *Task) SyncRun() {
 			return
 		}
 		t.model.State = TaskStateFinished
+		t.taskGroup.patterns.merge(t.patterns)
 		t.accumulateLogSize(t.model.LogStorePath)
 		t.accumulateLogSize(t.model.SlowLogStorePath)
 		log.Debug("LogSearchTask finished", zap.Any("task", t))
@@ -162,16 +176,7 @@ func (t *Task) SyncRun() {
 		return
 	}
 
-	secureOpt := grpc.WithInsecure()
-	if t.taskGroup.service.config.ClusterTLSConfig != nil {
-		creds := credentials.NewTLS(t.taskGroup.service.config.ClusterTLSConfig)
-		secureOpt = grpc.WithTransportCredentials(creds)
-	}
-
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", t.model.Target.IP, t.model.Target.Port),
-		secureOpt,
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxRecvMsgSize)),
-	)
+	conn, err := dialDiagnostics(t.taskGroup.service.config, t.model.Target)
 	if err != nil {
 		t.setError(err)
 		return
@@ -191,11 +196,7 @@ func (t *Task) searchLog(client diagnosticspb.DiagnosticsClient, targetType diag
 		return
 	}
 	req := t.taskGroup.model.SearchRequest.ConvertToPB(targetType)
-	patterns := make([]string, len(req.Patterns))
-	for i, p := range req.Patterns {
-		patterns[i] = "(?i)" + p
-	}
-	req.Patterns = patterns
+	req.Patterns = caseInsensitivePatterns(req.Patterns)
 	stream, err := client.SearchLog(t.ctx, req)
 	if err != nil {
 		t.setError(err)
@@ -248,6 +249,7 @@ func (t *Task) searchLog(client diagnosticspb.DiagnosticsClient, targetType diag
 			}
 			return
 		}
+		lines := make([]*LineModel, 0, len(res.Messages))
 		for _, msg := range res.Messages {
 			line := logMessageToString(msg)
 			_, err := bufWriter.Write(*(*[]byte)(unsafe.Pointer(&line))) // #nosec
@@ -255,6 +257,17 @@ func (t *Task) searchLog(client diagnosticspb.DiagnosticsClient, targetType diag
 				t.setError(err)
 				return
 			}
+			t.patterns.add(msg, t.model.Target.DisplayName)
+			t.model.MatchedLines++
+			if t.model.IndexedLines+int64(len(lines)) < TaskMaxIndexedLines {
+				lines = append(lines, &LineModel{
+					TaskID:      t.model.ID,
+					TaskGroupID: t.taskGroup.model.ID,
+					Time:        msg.Time,
+					Level:       msg.Level,
+					Message:     msg.Message,
+				})
+			}
 			if previewLogLinesCount < t.taskGroup.maxPreviewLinesPerTask {
 				t.taskGroup.service.db.Create(&PreviewModel{
 					TaskID:      t.model.ID,
@@ -266,7 +279,41 @@ func (t *Task) searchLog(client diagnosticspb.DiagnosticsClient, targetType diag
 				previewLogLinesCount++
 			}
 		}
+		t.indexLines(lines)
+	}
+}
+
+// indexLines saves lines so that they can be browsed by GetTaskGroupLines. Failing to index lines does not fail
+// the task, since lines are still available in the log file.
+func (t *Task) indexLines(lines []*LineModel) {
+	if len(lines) == 0 {
+		return
+	}
+	if err := t.taskGroup.service.db.CreateInBatches(lines, lineInsertBatchSize).Error; err != nil {
+		log.Warn("Failed to index log lines", zap.Any("task", t), zap.Error(err))
+		return
+	}
+	t.model.IndexedLines += int64(len(lines))
+}
+
+func dialDiagnostics(config *config.Config, target *model.RequestTargetNode) (*grpc.ClientConn, error) {
+	secureOpt := grpc.WithInsecure()
+	if config.ClusterTLSConfig != nil {
+		creds := credentials.NewTLS(config.ClusterTLSConfig)
+		secureOpt = grpc.WithTransportCredentials(creds)
+	}
+	return grpc.Dial(fmt.Sprintf("%s:%d", target.IP, target.Port),
+		secureOpt,
+		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(MaxRecvMsgSize)),
+	)
+}
+
+func caseInsensitivePatterns(patterns []string) []string {
+	r := make([]string, len(patterns))
+	for i, p := range patterns {
+		r[i] = "(?i)" + p
 	}
+	return r
 }
 
 func logMessageToString(msg *diagnosticspb.LogMessage) string {
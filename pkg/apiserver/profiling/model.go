@@ -25,6 +25,7 @@ const (
 	TaskStateFinish
 	TaskStatePartialFinish // Only valid for task group
 	TaskStateSkipped
+	TaskStateQueued // Only valid for task, waiting for conflicting tasks to finish
 )
 
 type TaskRawDataType string
@@ -32,6 +33,7 @@ type TaskRawDataType string
 const (
 	RawDataTypeProtobuf TaskRawDataType = "protobuf"
 	RawDataTypeText     TaskRawDataType = "text"
+	RawDataTypeTrace    TaskRawDataType = "trace"
 )
 
 type (
@@ -53,6 +55,9 @@ const (
 	ProfilingTypeHeap      TaskProfilingType = "heap"
 	ProfilingTypeGoroutine TaskProfilingType = "goroutine"
 	ProfilingTypeMutex     TaskProfilingType = "mutex"
+	ProfilingTypeBlock     TaskProfilingType = "block"
+	ProfilingTypeAllocs    TaskProfilingType = "allocs"
+	ProfilingTypeTrace     TaskProfilingType = "trace"
 )
 
 var profilingTypeMap = map[TaskProfilingType]struct{}{
@@ -60,6 +65,9 @@ var profilingTypeMap = map[TaskProfilingType]struct{}{
 	ProfilingTypeHeap:      {},
 	ProfilingTypeGoroutine: {},
 	ProfilingTypeMutex:     {},
+	ProfilingTypeBlock:     {},
+	ProfilingTypeAllocs:    {},
+	ProfilingTypeTrace:     {},
 }
 
 type TaskModel struct {
@@ -102,6 +110,7 @@ type Task struct {
 	cancel    context.CancelFunc
 	taskGroup *TaskGroup
 	fetchers  *fetchers
+	done      chan struct{}
 }
 
 // NewTask creates a new profiling task.
@@ -119,6 +128,7 @@ func NewTask(ctx context.Context, taskGroup *TaskGroup, target model.RequestTarg
 		cancel:    cancel,
 		taskGroup: taskGroup,
 		fetchers:  fts,
+		done:      make(chan struct{}),
 	}
 }
 
@@ -148,7 +158,8 @@ func (t *Task) stop() {
 // TaskGroup is the collection of tasks.
 type TaskGroup struct {
 	*TaskGroupModel
-	db *dbstore.DB
+	db   *dbstore.DB
+	done chan struct{}
 }
 
 // NewTaskGroup create a new profiling task group.
@@ -161,6 +172,7 @@ func NewTaskGroup(db *dbstore.DB, profileDurationSecs uint, stats model.RequestT
 			StartedAt:              time.Now().Unix(),
 			RequstedProfilingTypes: requestedProfilingTypes,
 		},
-		db: db,
+		db:   db,
+		done: make(chan struct{}),
 	}
 }
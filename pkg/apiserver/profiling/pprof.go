@@ -35,6 +35,30 @@ type fetcher struct {
 }
 
 func (f *fetcher) FetchAndWriteToFile(duration uint, fileNameWithoutExt string, profilingType TaskProfilingType) (string, TaskRawDataType, error) {
+	resp, profilingRawDataType, fileExtenstion, err := f.Fetch(duration, profilingType)
+	if err != nil {
+		return "", "", err
+	}
+
+	tmpfile, err := ioutil.TempFile("", fileNameWithoutExt+"_"+fileExtenstion)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create tmpfile to write profile: %v", err)
+	}
+
+	defer func() {
+		_ = tmpfile.Close()
+	}()
+
+	_, err = tmpfile.Write(resp)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to write profile: %v", err)
+	}
+
+	return tmpfile.Name(), profilingRawDataType, nil
+}
+
+// Fetch returns the profile content with its raw data type and file extension pattern.
+func (f *fetcher) Fetch(duration uint, profilingType TaskProfilingType) ([]byte, TaskRawDataType, string, error) {
 	var profilingRawDataType TaskRawDataType
 	var fileExtenstion string
 	secs := strconv.Itoa(int(duration))
@@ -46,6 +70,11 @@ func (f *fetcher) FetchAndWriteToFile(duration uint, fileNameWithoutExt string,
 		fileExtenstion = "*.proto"
 	case ProfilingTypeHeap:
 		url = "/debug/pprof/heap"
+		if f.target.Kind == model.NodeKindTiKV || f.target.Kind == model.NodeKindTiFlash {
+			// The jemalloc heap profile is converted to protobuf by TiKV and TiFlash. Old versions activate heap
+			// profiling for the given seconds before dumping, while new versions ignore it.
+			url += "?seconds=" + secs
+		}
 		profilingRawDataType = RawDataTypeProtobuf
 		fileExtenstion = "*.proto"
 	case ProfilingTypeGoroutine:
@@ -56,26 +85,25 @@ func (f *fetcher) FetchAndWriteToFile(duration uint, fileNameWithoutExt string,
 		url = "/debug/pprof/mutex?debug=1"
 		profilingRawDataType = RawDataTypeText
 		fileExtenstion = "*.txt"
+	case ProfilingTypeBlock:
+		url = "/debug/pprof/block"
+		profilingRawDataType = RawDataTypeProtobuf
+		fileExtenstion = "*.proto"
+	case ProfilingTypeAllocs:
+		url = "/debug/pprof/allocs"
+		profilingRawDataType = RawDataTypeProtobuf
+		fileExtenstion = "*.proto"
+	case ProfilingTypeTrace:
+		url = "/debug/pprof/trace?seconds=" + secs
+		profilingRawDataType = RawDataTypeTrace
+		fileExtenstion = "*.trace"
+	default:
+		return nil, "", "", ErrUnsupportedProfilingType.New(string(profilingType))
 	}
 
-	tmpfile, err := ioutil.TempFile("", fileNameWithoutExt+"_"+fileExtenstion)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to create tmpfile to write profile: %v", err)
-	}
-
-	defer func() {
-		_ = tmpfile.Close()
-	}()
-
 	resp, err := (*f.profileFetcher).fetch(&fetchOptions{ip: f.target.IP, port: f.target.Port, path: url})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to fetch profile with %v format: %v", fileExtenstion, err)
-	}
-
-	_, err = tmpfile.Write(resp)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to write profile: %v", err)
+		return nil, "", "", fmt.Errorf("failed to fetch profile with %v format: %v", fileExtenstion, err)
 	}
-
-	return tmpfile.Name(), profilingRawDataType, nil
+	return resp, profilingRawDataType, fileExtenstion, nil
 }
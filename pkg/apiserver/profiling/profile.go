@@ -8,25 +8,70 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
 )
 
-func profileAndWritePprof(ctx context.Context, fts *fetchers, target *model.RequestTargetNode, fileNameWithoutExt string, profileDurationSecs uint, profilingType TaskProfilingType) (string, TaskRawDataType, error) {
+// tikvProfilingTypes are profiling types supported by TiKV and TiFlash. Heap profiles are dumped by jemalloc in the
+// protobuf format, which requires heap profiling to be enabled in the instance.
+var tikvProfilingTypes = map[TaskProfilingType]struct{}{
+	ProfilingTypeCPU:  {},
+	ProfilingTypeHeap: {},
+}
+
+// IsProfilingTypeSupported returns whether targets of the kind support the profiling type.
+func IsProfilingTypeSupported(kind model.NodeKind, profilingType TaskProfilingType) bool {
+	if kind == model.NodeKindTiKV || kind == model.NodeKindTiFlash {
+		_, ok := tikvProfilingTypes[profilingType]
+		return ok
+	}
+	_, ok := profilingTypeMap[profilingType]
+	return ok
+}
+
+// targetProfileFetcher returns the fetcher of the target, or ErrUnsupportedProfilingType if the target does not support
+// the profiling type.
+func targetProfileFetcher(fts *fetchers, target *model.RequestTargetNode, profilingType TaskProfilingType) (*profileFetcher, error) {
+	var profileFetcher *profileFetcher
 	switch target.Kind {
 	case model.NodeKindTiKV:
-		// TiKV only supports CPU Profiling
-		if profilingType != ProfilingTypeCPU {
-			return "", "", ErrUnsupportedProfilingType.NewWithNoMessage()
-		}
-		return fetchPprof(&pprofOptions{duration: profileDurationSecs, fileNameWithoutExt: fileNameWithoutExt, target: target, fetcher: &fts.tikv, profilingType: profilingType})
+		profileFetcher = &fts.tikv
 	case model.NodeKindTiFlash:
-		// TiFlash only supports CPU Profiling
-		if profilingType != ProfilingTypeCPU {
-			return "", "", ErrUnsupportedProfilingType.NewWithNoMessage()
-		}
-		return fetchPprof(&pprofOptions{duration: profileDurationSecs, fileNameWithoutExt: fileNameWithoutExt, target: target, fetcher: &fts.tiflash, profilingType: profilingType})
+		profileFetcher = &fts.tiflash
 	case model.NodeKindTiDB:
-		return fetchPprof(&pprofOptions{duration: profileDurationSecs, fileNameWithoutExt: fileNameWithoutExt, target: target, fetcher: &fts.tidb, profilingType: profilingType})
+		profileFetcher = &fts.tidb
 	case model.NodeKindPD:
-		return fetchPprof(&pprofOptions{duration: profileDurationSecs, fileNameWithoutExt: fileNameWithoutExt, target: target, fetcher: &fts.pd, profilingType: profilingType})
+		profileFetcher = &fts.pd
 	default:
-		return "", "", ErrUnsupportedProfilingTarget.New(target.String())
+		return nil, ErrUnsupportedProfilingTarget.New(target.String())
+	}
+	if !IsProfilingTypeSupported(target.Kind, profilingType) {
+		return nil, ErrUnsupportedProfilingType.NewWithNoMessage()
+	}
+	return profileFetcher, nil
+}
+
+func profileAndWritePprof(ctx context.Context, fts *fetchers, target *model.RequestTargetNode, fileNameWithoutExt string, profileDurationSecs uint, profilingType TaskProfilingType) (string, TaskRawDataType, error) {
+	profileFetcher, err := targetProfileFetcher(fts, target, profilingType)
+	if err != nil {
+		return "", "", err
+	}
+	return fetchPprof(&pprofOptions{duration: profileDurationSecs, fileNameWithoutExt: fileNameWithoutExt, target: target, fetcher: profileFetcher, profilingType: profilingType})
+}
+
+// FetchProfile fetches a profile of the target without creating a task, e.g. for continuous profiling. Like tasks,
+// it waits until the target is not being profiled by the same profiling type, until ctx is done.
+// ErrUnsupportedProfilingType is returned if the target does not support the profiling type.
+func (s *Service) FetchProfile(ctx context.Context, target *model.RequestTargetNode, profilingType TaskProfilingType, durationSecs uint) ([]byte, TaskRawDataType, error) {
+	profileFetcher, err := targetProfileFetcher(s.fetchers, target, profilingType)
+	if err != nil {
+		return nil, "", err
+	}
+	unlock, err := s.scheduler.lock(ctx, lockKey(target, profilingType))
+	if err != nil {
+		return nil, "", err
+	}
+	defer unlock()
+	f := &fetcher{profileFetcher: profileFetcher, target: target}
+	content, rawDataType, _, err := f.Fetch(durationSecs, profilingType)
+	if err != nil {
+		return nil, "", err
 	}
+	return content, rawDataType, nil
 }
@@ -0,0 +1,102 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"math"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// checkComparable checks whether the profile of the task can be compared with the profile of the base task.
+func checkComparable(baseTask, task *TaskModel) error {
+	if baseTask.ProfilingType != task.ProfilingType {
+		return ErrIncompatibleProfiles.New("cannot compare %s profile with %s profile", task.ProfilingType, baseTask.ProfilingType)
+	}
+	if baseTask.Target.Kind != task.Target.Kind {
+		return ErrIncompatibleProfiles.New("cannot compare profile of %s with profile of %s", task.Target.Kind, baseTask.Target.Kind)
+	}
+	if baseTask.RawDataType != RawDataTypeProtobuf || task.RawDataType != RawDataTypeProtobuf {
+		return ErrIncompatibleProfiles.New("only protobuf profiles can be compared")
+	}
+	return nil
+}
+
+// FunctionShareChange is the change of a function's share of the total value. Percentages of each profile are
+// relative to the total of the profile itself, so that profiles of different durations can be compared.
+type FunctionShareChange struct {
+	Function         string  `json:"function"`
+	BaseFlatPercent  float64 `json:"base_flat_percent"`
+	FlatPercent      float64 `json:"flat_percent"`
+	FlatPercentDelta float64 `json:"flat_percent_delta"`
+	BaseCumPercent   float64 `json:"base_cum_percent"`
+	CumPercent       float64 `json:"cum_percent"`
+	CumPercentDelta  float64 `json:"cum_percent_delta"`
+}
+
+type DiffSummary struct {
+	SampleType string                `json:"sample_type"`
+	Unit       string                `json:"unit"`
+	BaseTotal  int64                 `json:"base_total"`
+	Total      int64                 `json:"total"`
+	Functions  []FunctionShareChange `json:"functions"`
+}
+
+// summarizeDiff returns functions whose flat share changed most between the two profiles.
+func summarizeDiff(base, p *profile.Profile, sampleType string, limit int) (*DiffSummary, error) {
+	baseTable, err := renderTopTable(base, sampleType, 0)
+	if err != nil {
+		return nil, err
+	}
+	table, err := renderTopTable(p, baseTable.SampleType, 0)
+	if err != nil {
+		return nil, err
+	}
+	summary := &DiffSummary{
+		SampleType: table.SampleType,
+		Unit:       table.Unit,
+		BaseTotal:  baseTable.Total,
+		Total:      table.Total,
+		Functions:  make([]FunctionShareChange, 0),
+	}
+
+	changes := make(map[string]*FunctionShareChange)
+	change := func(name string) *FunctionShareChange {
+		c, ok := changes[name]
+		if !ok {
+			c = &FunctionShareChange{Function: name}
+			changes[name] = c
+		}
+		return c
+	}
+	for _, r := range baseTable.Rows {
+		c := change(r.Function)
+		c.BaseFlatPercent = r.FlatPercent
+		c.BaseCumPercent = r.CumPercent
+	}
+	for _, r := range table.Rows {
+		c := change(r.Function)
+		c.FlatPercent = r.FlatPercent
+		c.CumPercent = r.CumPercent
+	}
+	for _, c := range changes {
+		c.FlatPercentDelta = c.FlatPercent - c.BaseFlatPercent
+		c.CumPercentDelta = c.CumPercent - c.BaseCumPercent
+		summary.Functions = append(summary.Functions, *c)
+	}
+	sort.Slice(summary.Functions, func(i, j int) bool {
+		a, b := summary.Functions[i], summary.Functions[j]
+		if math.Abs(a.FlatPercentDelta) != math.Abs(b.FlatPercentDelta) {
+			return math.Abs(a.FlatPercentDelta) > math.Abs(b.FlatPercentDelta)
+		}
+		if math.Abs(a.CumPercentDelta) != math.Abs(b.CumPercentDelta) {
+			return math.Abs(a.CumPercentDelta) > math.Abs(b.CumPercentDelta)
+		}
+		return a.Function < b.Function
+	})
+	if limit > 0 && len(summary.Functions) > limit {
+		summary.Functions = summary.Functions[:limit]
+	}
+	return summary, nil
+}
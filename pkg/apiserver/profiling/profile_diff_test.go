@@ -0,0 +1,50 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+)
+
+var _ = Suite(&testDiffSuite{})
+
+type testDiffSuite struct{}
+
+func (t *testDiffSuite) TestCheckComparable(c *C) {
+	newTask := func(kind model.NodeKind, profilingType TaskProfilingType, rawDataType TaskRawDataType) *TaskModel {
+		return &TaskModel{
+			Target:        model.RequestTargetNode{Kind: kind},
+			ProfilingType: profilingType,
+			RawDataType:   rawDataType,
+		}
+	}
+	base := newTask(model.NodeKindTiDB, ProfilingTypeCPU, RawDataTypeProtobuf)
+	c.Assert(checkComparable(base, newTask(model.NodeKindTiDB, ProfilingTypeCPU, RawDataTypeProtobuf)), IsNil)
+	c.Assert(checkComparable(base, newTask(model.NodeKindPD, ProfilingTypeCPU, RawDataTypeProtobuf)), NotNil)
+	c.Assert(checkComparable(base, newTask(model.NodeKindTiDB, ProfilingTypeHeap, RawDataTypeProtobuf)), NotNil)
+
+	goroutine := newTask(model.NodeKindTiDB, ProfilingTypeGoroutine, RawDataTypeText)
+	c.Assert(checkComparable(goroutine, goroutine), NotNil)
+}
+
+func (t *testDiffSuite) TestSummarizeDiff(c *C) {
+	// Shares of b: 20% -> 60%, c: 80% -> 20%, d: 0% -> 20%
+	base := newTestProfile([][]string{{"b", "a"}, {"c", "a"}}, []int64{10, 40})
+	p := newTestProfile([][]string{{"b", "a"}, {"c", "a"}, {"d", "a"}}, []int64{60, 20, 20})
+	summary, err := summarizeDiff(base, p, "", 2)
+	c.Assert(err, IsNil)
+	c.Assert(summary.BaseTotal, Equals, int64(50))
+	c.Assert(summary.Total, Equals, int64(100))
+	c.Assert(summary.Functions, HasLen, 2)
+	c.Assert(summary.Functions[0].Function, Equals, "c")
+	c.Assert(summary.Functions[0].FlatPercentDelta, Equals, -60.0)
+	c.Assert(summary.Functions[1].Function, Equals, "b")
+	c.Assert(summary.Functions[1].BaseFlatPercent, Equals, 20.0)
+	c.Assert(summary.Functions[1].FlatPercent, Equals, 60.0)
+	c.Assert(summary.Functions[1].CumPercentDelta, Equals, 40.0)
+
+	_, err = summarizeDiff(base, p, "alloc_space", 0)
+	c.Assert(err, NotNil)
+}
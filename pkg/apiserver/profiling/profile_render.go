@@ -0,0 +1,294 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/pprof/profile"
+)
+
+// diffBaseLabel marks samples coming from the base profile in a diff profile, the same as `pprof -diff_base`.
+const diffBaseLabel = "pprof::base"
+
+func loadProfile(task *TaskModel) (*profile.Profile, error) {
+	if task.RawDataType != RawDataTypeProtobuf {
+		return nil, ErrUnsupportedProfilingType.New("%s profile is not in protobuf", task.ProfilingType)
+	}
+	f, err := os.Open(filepath.Clean(task.FilePath))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return profile.Parse(f)
+}
+
+func encodeProfile(p *profile.Profile) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// diffProfiles subtracts the base profile from the profile. Samples of the base profile are negated and labeled by
+// diffBaseLabel, so that renderers can tell them apart.
+func diffProfiles(base, p *profile.Profile) (*profile.Profile, error) {
+	base = base.Copy()
+	base.Scale(-1)
+	for _, s := range base.Sample {
+		if s.Label == nil {
+			s.Label = make(map[string][]string)
+		}
+		s.Label[diffBaseLabel] = []string{"true"}
+	}
+	return profile.Merge([]*profile.Profile{p, base})
+}
+
+func loadDiffProfile(baseTask, task *TaskModel) (*profile.Profile, error) {
+	if err := checkComparable(baseTask, task); err != nil {
+		return nil, err
+	}
+	base, err := loadProfile(baseTask)
+	if err != nil {
+		return nil, err
+	}
+	p, err := loadProfile(task)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := diffProfiles(base, p)
+	if err != nil {
+		return nil, ErrIncompatibleProfiles.WrapWithNoMessage(err)
+	}
+	return diff, nil
+}
+
+func isBaseSample(s *profile.Sample) bool {
+	return len(s.Label[diffBaseLabel]) > 0
+}
+
+// sampleIndex returns the index of the sample type. The default sample type of the profile, or the last one if
+// there is no default, is used if sampleType is empty.
+func sampleIndex(p *profile.Profile, sampleType string) (int, error) {
+	if len(p.SampleType) == 0 {
+		return 0, fmt.Errorf("profile has no sample type")
+	}
+	if sampleType == "" {
+		sampleType = p.DefaultSampleType
+	}
+	if sampleType == "" {
+		return len(p.SampleType) - 1, nil
+	}
+	for i, t := range p.SampleType {
+		if t.Type == sampleType {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("sample type %s not found", sampleType)
+}
+
+// stackOf returns function names of the sample from the root to the leaf, with inlined functions expanded.
+func stackOf(s *profile.Sample) []string {
+	stack := make([]string, 0, len(s.Location))
+	for i := len(s.Location) - 1; i >= 0; i-- {
+		loc := s.Location[i]
+		if len(loc.Line) == 0 {
+			stack = append(stack, fmt.Sprintf("0x%x", loc.Address))
+			continue
+		}
+		// Line[0] is the innermost inlined function.
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			name := "<unknown>"
+			if loc.Line[j].Function != nil {
+				name = loc.Line[j].Function.Name
+			}
+			stack = append(stack, name)
+		}
+	}
+	return stack
+}
+
+// FlameGraphNode is a frame of the flame graph in the d3-flamegraph format. For diff profiles, Value is the value
+// of the new profile and Delta is the value change against the base profile.
+type FlameGraphNode struct {
+	Name     string            `json:"name"`
+	Value    int64             `json:"value"`
+	Delta    int64             `json:"delta,omitempty"`
+	Children []*FlameGraphNode `json:"children"`
+
+	childMap map[string]*FlameGraphNode
+}
+
+func (n *FlameGraphNode) child(name string) *FlameGraphNode {
+	if n.childMap == nil {
+		n.childMap = make(map[string]*FlameGraphNode)
+	}
+	c, ok := n.childMap[name]
+	if !ok {
+		c = &FlameGraphNode{Name: name}
+		n.childMap[name] = c
+	}
+	return c
+}
+
+// seal converts child maps into slices ordered by name.
+func (n *FlameGraphNode) seal() {
+	n.Children = make([]*FlameGraphNode, 0, len(n.childMap))
+	for _, c := range n.childMap {
+		c.seal()
+		n.Children = append(n.Children, c)
+	}
+	n.childMap = nil
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+}
+
+type FlameGraph struct {
+	SampleType string          `json:"sample_type"`
+	Unit       string          `json:"unit"`
+	Diff       bool            `json:"diff"`
+	Root       *FlameGraphNode `json:"root"`
+}
+
+func renderFlameGraph(p *profile.Profile, sampleType string) (*FlameGraph, error) {
+	idx, err := sampleIndex(p, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	fg := &FlameGraph{
+		SampleType: p.SampleType[idx].Type,
+		Unit:       p.SampleType[idx].Unit,
+		Root:       &FlameGraphNode{Name: "root"},
+	}
+	for _, s := range p.Sample {
+		v := s.Value[idx]
+		base := isBaseSample(s)
+		fg.Diff = fg.Diff || base
+		nodes := []*FlameGraphNode{fg.Root}
+		node := fg.Root
+		for _, name := range stackOf(s) {
+			node = node.child(name)
+			nodes = append(nodes, node)
+		}
+		for _, node := range nodes {
+			if !base {
+				node.Value += v
+			}
+			node.Delta += v
+		}
+	}
+	if !fg.Diff {
+		clearDelta(fg.Root)
+	}
+	fg.Root.seal()
+	return fg, nil
+}
+
+func clearDelta(n *FlameGraphNode) {
+	n.Delta = 0
+	for _, c := range n.childMap {
+		clearDelta(c)
+	}
+}
+
+type TopRow struct {
+	Function    string  `json:"function"`
+	Flat        int64   `json:"flat"`
+	FlatPercent float64 `json:"flat_percent"`
+	Cum         int64   `json:"cum"`
+	CumPercent  float64 `json:"cum_percent"`
+}
+
+type TopTable struct {
+	SampleType string   `json:"sample_type"`
+	Unit       string   `json:"unit"`
+	Total      int64    `json:"total"`
+	Rows       []TopRow `json:"rows"`
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// renderTopTable returns functions ordered by the flat value, like `pprof -top`. For diff profiles, percentages are
+// relative to the total of the base profile.
+func renderTopTable(p *profile.Profile, sampleType string, limit int) (*TopTable, error) {
+	idx, err := sampleIndex(p, sampleType)
+	if err != nil {
+		return nil, err
+	}
+	table := &TopTable{
+		SampleType: p.SampleType[idx].Type,
+		Unit:       p.SampleType[idx].Unit,
+		Rows:       make([]TopRow, 0),
+	}
+	rows := make(map[string]*TopRow)
+	row := func(name string) *TopRow {
+		r, ok := rows[name]
+		if !ok {
+			r = &TopRow{Function: name}
+			rows[name] = r
+		}
+		return r
+	}
+	var total, baseTotal int64
+	for _, s := range p.Sample {
+		v := s.Value[idx]
+		if isBaseSample(s) {
+			baseTotal += abs(v)
+		} else {
+			total += v
+		}
+		stack := stackOf(s)
+		if len(stack) == 0 {
+			continue
+		}
+		row(stack[len(stack)-1]).Flat += v
+		// Recursive functions are counted once in a sample.
+		seen := make(map[string]struct{}, len(stack))
+		for _, name := range stack {
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			row(name).Cum += v
+		}
+	}
+	table.Total = total
+	if baseTotal > 0 {
+		table.Total = baseTotal
+	}
+
+	for _, r := range rows {
+		if table.Total != 0 {
+			r.FlatPercent = float64(r.Flat) * 100 / float64(table.Total)
+			r.CumPercent = float64(r.Cum) * 100 / float64(table.Total)
+		}
+		table.Rows = append(table.Rows, *r)
+	}
+	sort.Slice(table.Rows, func(i, j int) bool {
+		a, b := table.Rows[i], table.Rows[j]
+		if abs(a.Flat) != abs(b.Flat) {
+			return abs(a.Flat) > abs(b.Flat)
+		}
+		if abs(a.Cum) != abs(b.Cum) {
+			return abs(a.Cum) > abs(b.Cum)
+		}
+		return a.Function < b.Function
+	})
+	if limit > 0 && len(table.Rows) > limit {
+		table.Rows = table.Rows[:limit]
+	}
+	return table, nil
+}
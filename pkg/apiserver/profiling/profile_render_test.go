@@ -0,0 +1,107 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"testing"
+
+	"github.com/google/pprof/profile"
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testRenderSuite{})
+
+type testRenderSuite struct{}
+
+// newTestProfile creates a CPU profile. Each stack is a list of function names from the leaf to the root.
+func newTestProfile(stacks [][]string, values []int64) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     1,
+	}
+	functions := make(map[string]*profile.Location)
+	for i, stack := range stacks {
+		s := &profile.Sample{Value: []int64{1, values[i]}}
+		for _, name := range stack {
+			loc, ok := functions[name]
+			if !ok {
+				fn := &profile.Function{ID: uint64(len(functions) + 1), Name: name}
+				loc = &profile.Location{ID: fn.ID, Line: []profile.Line{{Function: fn}}}
+				functions[name] = loc
+				p.Function = append(p.Function, fn)
+				p.Location = append(p.Location, loc)
+			}
+			s.Location = append(s.Location, loc)
+		}
+		p.Sample = append(p.Sample, s)
+	}
+	return p
+}
+
+func (t *testRenderSuite) TestFlameGraph(c *C) {
+	p := newTestProfile([][]string{{"b", "a"}, {"c", "a"}, {"a"}}, []int64{10, 20, 5})
+	fg, err := renderFlameGraph(p, "")
+	c.Assert(err, IsNil)
+	c.Assert(fg.SampleType, Equals, "cpu")
+	c.Assert(fg.Diff, Equals, false)
+	c.Assert(fg.Root.Value, Equals, int64(35))
+	c.Assert(fg.Root.Children, HasLen, 1)
+	a := fg.Root.Children[0]
+	c.Assert(a.Name, Equals, "a")
+	c.Assert(a.Value, Equals, int64(35))
+	c.Assert(a.Children, HasLen, 2)
+	c.Assert(a.Children[0].Name, Equals, "b")
+	c.Assert(a.Children[0].Value, Equals, int64(10))
+	c.Assert(a.Children[1].Name, Equals, "c")
+	c.Assert(a.Children[1].Delta, Equals, int64(0))
+
+	fg, err = renderFlameGraph(p, "samples")
+	c.Assert(err, IsNil)
+	c.Assert(fg.Root.Value, Equals, int64(3))
+	_, err = renderFlameGraph(p, "alloc_space")
+	c.Assert(err, NotNil)
+}
+
+func (t *testRenderSuite) TestTopTable(c *C) {
+	p := newTestProfile([][]string{{"b", "a"}, {"a", "b", "a"}, {"c"}}, []int64{10, 20, 70})
+	table, err := renderTopTable(p, "", 2)
+	c.Assert(err, IsNil)
+	c.Assert(table.Total, Equals, int64(100))
+	c.Assert(table.Rows, DeepEquals, []TopRow{
+		{Function: "c", Flat: 70, FlatPercent: 70, Cum: 70, CumPercent: 70},
+		{Function: "a", Flat: 20, FlatPercent: 20, Cum: 30, CumPercent: 30},
+	})
+}
+
+func (t *testRenderSuite) TestDiff(c *C) {
+	base := newTestProfile([][]string{{"b", "a"}, {"c", "a"}}, []int64{10, 30})
+	p := newTestProfile([][]string{{"b", "a"}, {"c", "a"}}, []int64{40, 20})
+	diff, err := diffProfiles(base, p)
+	c.Assert(err, IsNil)
+
+	fg, err := renderFlameGraph(diff, "")
+	c.Assert(err, IsNil)
+	c.Assert(fg.Diff, Equals, true)
+	a := fg.Root.Children[0]
+	c.Assert(a.Value, Equals, int64(60))
+	c.Assert(a.Delta, Equals, int64(20))
+	c.Assert(a.Children[0].Delta, Equals, int64(30))
+	c.Assert(a.Children[1].Delta, Equals, int64(-10))
+
+	table, err := renderTopTable(diff, "", 0)
+	c.Assert(err, IsNil)
+	c.Assert(table.Total, Equals, int64(40))
+	c.Assert(table.Rows[0].Function, Equals, "b")
+	c.Assert(table.Rows[0].Flat, Equals, int64(30))
+
+	incompatible := newTestProfile(nil, nil)
+	incompatible.SampleType = incompatible.SampleType[:1]
+	_, err = diffProfiles(incompatible, p)
+	c.Assert(err, NotNil)
+}
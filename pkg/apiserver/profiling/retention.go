@@ -0,0 +1,109 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+const gcInterval = 10 * time.Minute
+
+// taskGroupItems returns task groups matching the query with the total size of their files.
+func taskGroupItems(db *gorm.DB) ([]utils.RetentionItem, error) {
+	var taskGroups []TaskGroupModel
+	if err := db.Find(&taskGroups).Error; err != nil {
+		return nil, err
+	}
+	if len(taskGroups) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint, 0, len(taskGroups))
+	for _, tg := range taskGroups {
+		ids = append(ids, tg.ID)
+	}
+	var tasks []TaskModel
+	if err := db.Session(&gorm.Session{NewDB: true}).Where("task_group_id IN ? AND file_path != ''", ids).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	sizes := make(map[uint]int64, len(taskGroups))
+	for _, task := range tasks {
+		if fi, err := os.Stat(task.FilePath); err == nil {
+			sizes[task.TaskGroupID] += fi.Size()
+		}
+	}
+
+	items := make([]utils.RetentionItem, 0, len(taskGroups))
+	for _, tg := range taskGroups {
+		items = append(items, utils.RetentionItem{
+			ID:        tg.ID,
+			CreatedAt: time.Unix(tg.StartedAt, 0),
+			Bytes:     sizes[tg.ID],
+		})
+	}
+	return items, nil
+}
+
+// deleteTaskGroup deletes files and records of the task group.
+func deleteTaskGroup(db *dbstore.DB, taskGroupID uint) error {
+	var tasks []TaskModel
+	if err := db.Where("task_group_id = ?", taskGroupID).Find(&tasks).Error; err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		if task.FilePath != "" {
+			if err := os.Remove(task.FilePath); err != nil && !os.IsNotExist(err) {
+				log.Warn("Failed to remove profiling file", zap.String("path", task.FilePath), zap.Error(err))
+			}
+		}
+	}
+	if err := db.Where("task_group_id = ?", taskGroupID).Delete(&TaskModel{}).Error; err != nil {
+		return err
+	}
+	return db.Where("id = ?", taskGroupID).Delete(&TaskGroupModel{}).Error
+}
+
+func (s *Service) gcLoop(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.gc()
+		}
+	}
+}
+
+// gc deletes finished task groups exceeding the retention limits.
+func (s *Service) gc() {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		// Dynamic config is not ready yet, try in the next round.
+		return
+	}
+	items, err := taskGroupItems(s.params.LocalStore.Where("state != ?", TaskStateRunning))
+	if err != nil {
+		log.Warn("Failed to list profiling task groups", zap.Error(err))
+		return
+	}
+	expired := utils.SelectExpiredItems(items, &dc.Profiling.Retention, time.Now())
+	for _, id := range expired {
+		if err := deleteTaskGroup(s.params.LocalStore, id); err != nil {
+			log.Warn("Failed to delete expired profiling task group", zap.Uint("id", id), zap.Error(err))
+			return
+		}
+	}
+	if len(expired) > 0 {
+		log.Info("Expired profiling task groups are cleaned up", zap.Int("count", len(expired)))
+	}
+}
@@ -11,10 +11,13 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/pprof/profile"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
@@ -25,18 +28,22 @@ import (
 func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/profiling")
 	endpoint.GET("/group/list", auth.MWAuthRequired(), s.getGroupList)
-	endpoint.POST("/group/start", auth.MWAuthRequired(), s.handleStartGroup)
+	endpoint.POST("/group/start", auth.MWAuthRequired(), auth.MWRequirePermission(user.PermProfilingStart), s.handleStartGroup)
 	endpoint.GET("/group/detail/:groupId", auth.MWAuthRequired(), s.getGroupDetail)
-	endpoint.POST("/group/cancel/:groupId", auth.MWAuthRequired(), s.handleCancelGroup)
-	endpoint.DELETE("/group/delete/:groupId", auth.MWAuthRequired(), s.deleteGroup)
+	endpoint.POST("/group/cancel/:groupId", auth.MWAuthRequired(), auth.MWRequirePermission(user.PermProfilingStart), s.handleCancelGroup)
+	endpoint.DELETE("/group/delete/:groupId", auth.MWAuthRequired(), auth.MWRequirePermission(user.PermProfilingStart), s.deleteGroup)
+	endpoint.GET("/queue", auth.MWAuthRequired(), s.getQueueStatus)
+	endpoint.GET("/storage", auth.MWAuthRequired(), s.getStorageUsage)
 
 	endpoint.GET("/action_token", auth.MWAuthRequired(), s.getActionToken)
 	endpoint.GET("/group/download", s.downloadGroup)
 	endpoint.GET("/single/download", s.downloadSingle)
 	endpoint.GET("/single/view", s.viewSingle)
+	endpoint.GET("/diff/summary", auth.MWAuthRequired(), s.getDiffSummary)
+	endpoint.GET("/diff/view", s.viewDiff)
 
 	endpoint.GET("/config", auth.MWAuthRequired(), s.getDynamicConfig)
-	endpoint.PUT("/config", auth.MWAuthRequired(), auth.MWRequireWritePriv(), s.setDynamicConfig)
+	endpoint.PUT("/config", auth.MWAuthRequired(), auth.MWRequirePermission(user.PermProfilingConfig), s.setDynamicConfig)
 }
 
 // @ID startProfiling
@@ -59,6 +66,11 @@ func (s *Service) handleStartGroup(c *gin.Context) {
 		rest.Error(c, rest.ErrBadRequest.New("Expect at least 1 target"))
 		return
 	}
+	targets := make([]string, 0, len(req.Targets))
+	for i := range req.Targets {
+		targets = append(targets, req.Targets[i].String())
+	}
+	audit.SetTargets(c, targets...)
 
 	if req.DurationSecs == 0 {
 		req.DurationSecs = config.DefaultProfilingAutoCollectionDurationSecs
@@ -143,6 +155,52 @@ func (s *Service) getGroupDetail(c *gin.Context) {
 	})
 }
 
+type QueueStatusResponse struct {
+	Running []TaskModel `json:"running"`
+	// Queued tasks are ordered by the time they will be run.
+	Queued []TaskModel `json:"queued"`
+}
+
+// @ID getProfilingQueueStatus
+// @Summary List running and queued tasks
+// @Description List running tasks and tasks queued for conflicting tasks profiling the same instance
+// @Security JwtAuth
+// @Success 200 {object} QueueStatusResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /profiling/queue [get]
+func (s *Service) getQueueStatus(c *gin.Context) {
+	resp := QueueStatusResponse{
+		Running: make([]TaskModel, 0),
+		Queued:  make([]TaskModel, 0),
+	}
+	err := s.params.LocalStore.Where("state = ?", TaskStateRunning).Order("id").Find(&resp.Running).Error
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+
+	ids := s.scheduler.queuedTaskIDs()
+	if len(ids) > 0 {
+		var tasks []TaskModel
+		err = s.params.LocalStore.Where("id IN ?", ids).Find(&tasks).Error
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		taskMap := make(map[uint]TaskModel, len(tasks))
+		for _, t := range tasks {
+			taskMap[t.ID] = t
+		}
+		for _, id := range ids {
+			if t, ok := taskMap[id]; ok {
+				resp.Queued = append(resp.Queued, t)
+			}
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
 // @ID cancelProfilingGroup
 // @Summary Cancel all tasks with a given group ID
 // @Description Cancel all profling tasks with a given group ID
@@ -179,7 +237,7 @@ func (s *Service) handleCancelGroup(c *gin.Context) {
 // @Router /profiling/action_token [get]
 func (s *Service) getActionToken(c *gin.Context) {
 	id := c.Query("id")
-	action := c.Query("action") // group_download, single_download, single_view
+	action := c.Query("action") // group_download, single_download, single_view, diff_view (id is "base_task_id,task_id")
 	token, err := utils.NewJWTString("profiling/"+action, id)
 	if err != nil {
 		rest.Error(c, err)
@@ -344,6 +402,10 @@ func zipREADME(zw *zip.Writer) error {
 To review the CPU profiling or heap profiling result interactively:
 
 $ go tool pprof --http=0.0.0.0:1234 cpu_xxx.proto
+
+To review the execution trace:
+
+$ go tool trace trace_xxx.trace
 `
 	zipFile, err := zw.CreateHeader(&zip.FileHeader{
 		Name:     "README.md",
@@ -364,36 +426,54 @@ $ go tool pprof --http=0.0.0.0:1234 cpu_xxx.proto
 type ViewOutputType string
 
 const (
-	ViewOutputTypeProtobuf ViewOutputType = "protobuf"
-	ViewOutputTypeGraph    ViewOutputType = "graph"
-	ViewOutputTypeText     ViewOutputType = "text"
+	ViewOutputTypeProtobuf   ViewOutputType = "protobuf"
+	ViewOutputTypeGraph      ViewOutputType = "graph"
+	ViewOutputTypeFlameGraph ViewOutputType = "flamegraph"
+	ViewOutputTypeTop        ViewOutputType = "top"
+	ViewOutputTypeText       ViewOutputType = "text"
+	ViewOutputTypeTrace      ViewOutputType = "trace"
 )
 
+const defaultTopLimit = 100
+
+func (s *Service) findFinishedTask(taskID uint) (*TaskModel, error) {
+	task := &TaskModel{}
+	err := s.params.LocalStore.Where("id = ? AND state = ?", taskID, TaskStateFinish).First(task).Error
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+func (s *Service) findViewTask(token string) (*TaskModel, error) {
+	str, err := utils.ParseJWTString("profiling/single_view", token)
+	if err != nil {
+		return nil, rest.ErrBadRequest.NewWithNoMessage()
+	}
+	taskID, err := strconv.Atoi(str)
+	if err != nil {
+		return nil, rest.ErrBadRequest.NewWithNoMessage()
+	}
+	return s.findFinishedTask(uint(taskID))
+}
+
 // @ID viewProfilingSingle
 // @Summary View the result of a task
-// @Description View the finished profiling result of a task
+// @Description View the finished profiling result of a task. Protobuf profiles can be rendered as a call graph, a flame graph or a top table, optionally compared with a base task like `pprof -diff_base`.
 // @Produce html
 // @Param token query string true "download token"
+// @Param output_type query string false "output type" Enums(protobuf, graph, flamegraph, top, text, trace)
+// @Param base_token query string false "view token of the base task to compare with"
+// @Param sample_type query string false "sample type of flame graphs and top tables"
+// @Param limit query int false "max rows of top tables"
 // @Security JwtAuth
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 // @Failure 500 {object} rest.ErrorResponse
 // @Router /profiling/single/view [get]
 func (s *Service) viewSingle(c *gin.Context) {
-	token := c.Query("token")
 	outputType := c.Query("output_type")
-	str, err := utils.ParseJWTString("profiling/single_view", token)
-	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
-		return
-	}
-	taskID, err := strconv.Atoi(str)
-	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
-		return
-	}
-	task := TaskModel{}
-	err = s.params.LocalStore.Where("id = ? AND state = ?", taskID, TaskStateFinish).First(&task).Error
+	task, err := s.findViewTask(c.Query("token"))
 	if err != nil {
 		rest.Error(c, err)
 		return
@@ -409,22 +489,28 @@ func (s *Service) viewSingle(c *gin.Context) {
 	contentType := "image/svg+xml"
 
 	if task.RawDataType == RawDataTypeProtobuf {
-		switch outputType {
-		case string(ViewOutputTypeGraph):
-			svgContent, err := convertProtobufToSVG(content, task)
+		var p *profile.Profile
+		if baseToken := c.Query("base_token"); baseToken != "" {
+			baseTask, err := s.findViewTask(baseToken)
 			if err != nil {
 				rest.Error(c, err)
 				return
 			}
-			content = svgContent
-			contentType = "image/svg+xml"
-		case string(ViewOutputTypeProtobuf):
-			contentType = "application/protobuf"
-		default:
-			// Will not handle converting protobuf to other formats except flamegraph and graph
-			rest.Error(c, rest.ErrBadRequest.New("Cannot output protobuf as %s", outputType))
-			return
+			if err := checkComparable(baseTask, task); err != nil {
+				rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+				return
+			}
+			if p, err = loadDiffProfile(baseTask, task); err != nil {
+				rest.Error(c, err)
+				return
+			}
+			if content, err = encodeProfile(p); err != nil {
+				rest.Error(c, err)
+				return
+			}
 		}
+		writeProfileView(c, task, content, p, outputType)
+		return
 	} else if task.RawDataType == RawDataTypeText {
 		switch outputType {
 		case string(ViewOutputTypeText):
@@ -434,10 +520,181 @@ func (s *Service) viewSingle(c *gin.Context) {
 			rest.Error(c, rest.ErrBadRequest.New("Cannot output text as %s", outputType))
 			return
 		}
+	} else if task.RawDataType == RawDataTypeTrace {
+		switch outputType {
+		case string(ViewOutputTypeTrace):
+			// Execution traces can only be viewed by `go tool trace`, so they are always downloaded.
+			contentType = "application/octet-stream"
+			c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(task.FilePath)))
+		default:
+			rest.Error(c, rest.ErrBadRequest.New("Cannot output trace as %s", outputType))
+			return
+		}
 	}
 	c.Data(http.StatusOK, contentType, content)
 }
 
+// writeProfileView writes the protobuf profile content in the output type. p is the parsed content, which is
+// parsed on demand if it is nil.
+func writeProfileView(c *gin.Context, task *TaskModel, content []byte, p *profile.Profile, outputType string) {
+	switch outputType {
+	case string(ViewOutputTypeGraph):
+		svgContent, err := convertProtobufToSVG(content, *task)
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		c.Data(http.StatusOK, "image/svg+xml", svgContent)
+	case string(ViewOutputTypeProtobuf):
+		c.Data(http.StatusOK, "application/protobuf", content)
+	case string(ViewOutputTypeFlameGraph), string(ViewOutputTypeTop):
+		var err error
+		if p == nil {
+			if p, err = profile.ParseData(content); err != nil {
+				rest.Error(c, err)
+				return
+			}
+		}
+		var result interface{}
+		if outputType == string(ViewOutputTypeFlameGraph) {
+			result, err = renderFlameGraph(p, c.Query("sample_type"))
+		} else {
+			result, err = renderTopTable(p, c.Query("sample_type"), queryLimit(c, defaultTopLimit))
+		}
+		if err != nil {
+			rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	default:
+		// Will not handle converting protobuf to other formats except flamegraph, top and graph
+		rest.Error(c, rest.ErrBadRequest.New("Cannot output protobuf as %s", outputType))
+	}
+}
+
+func queryLimit(c *gin.Context, defaultLimit int) int {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		return defaultLimit
+	}
+	return limit
+}
+
+func (s *Service) findDiffTasks(baseTaskID, taskID uint) (*TaskModel, *TaskModel, error) {
+	baseTask, err := s.findFinishedTask(baseTaskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	task, err := s.findFinishedTask(taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkComparable(baseTask, task); err != nil {
+		return nil, nil, rest.ErrBadRequest.WrapWithNoMessage(err)
+	}
+	return baseTask, task, nil
+}
+
+type DiffSummaryRequest struct {
+	BaseTaskID uint   `json:"base_task_id" form:"base_task_id"`
+	TaskID     uint   `json:"task_id" form:"task_id"`
+	SampleType string `json:"sample_type" form:"sample_type"`
+	Limit      int    `json:"limit" form:"limit"`
+}
+
+// @ID getProfilingDiffSummary
+// @Summary Summarize the difference between two tasks
+// @Description List functions whose share changed most between the profiles of two finished tasks. The tasks must have the same profiling type and target kind.
+// @Param q query DiffSummaryRequest true "Query"
+// @Security JwtAuth
+// @Success 200 {object} DiffSummary
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /profiling/diff/summary [get]
+func (s *Service) getDiffSummary(c *gin.Context) {
+	var req DiffSummaryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultTopLimit
+	}
+	baseTask, task, err := s.findDiffTasks(req.BaseTaskID, req.TaskID)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	base, err := loadProfile(baseTask)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	p, err := loadProfile(task)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	summary, err := summarizeDiff(base, p, req.SampleType, req.Limit)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+}
+
+// @ID viewProfilingDiff
+// @Summary View the difference between two tasks
+// @Description View the profile of a finished task with the profile of a base task subtracted, like `pprof -diff_base`
+// @Produce html
+// @Param token query string true "diff_view token"
+// @Param output_type query string true "output type" Enums(protobuf, graph, flamegraph, top)
+// @Param sample_type query string false "sample type of flame graphs and top tables"
+// @Param limit query int false "max rows of top tables"
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /profiling/diff/view [get]
+func (s *Service) viewDiff(c *gin.Context) {
+	str, err := utils.ParseJWTString("profiling/diff_view", c.Query("token"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	ids := strings.Split(str, ",")
+	if len(ids) != 2 {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	baseTaskID, err := strconv.Atoi(ids[0])
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	taskID, err := strconv.Atoi(ids[1])
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	baseTask, task, err := s.findDiffTasks(uint(baseTaskID), uint(taskID))
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	p, err := loadDiffProfile(baseTask, task)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	content, err := encodeProfile(p)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	writeProfileView(c, task, content, p, c.Query("output_type"))
+}
+
 // @ID deleteProfilingGroup
 // @Summary Delete all tasks with a given group ID
 // @Description Delete all finished profiling tasks with a given group ID
@@ -459,15 +716,27 @@ func (s *Service) deleteGroup(c *gin.Context) {
 		return
 	}
 
-	if err = s.params.LocalStore.Where("task_group_id = ?", taskGroupID).Delete(&TaskModel{}).Error; err != nil {
+	if err = deleteTaskGroup(s.params.LocalStore, uint(taskGroupID)); err != nil {
 		rest.Error(c, err)
 		return
 	}
-	if err = s.params.LocalStore.Where("id = ?", taskGroupID).Delete(&TaskGroupModel{}).Error; err != nil {
+	c.JSON(http.StatusOK, rest.EmptyResponse{})
+}
+
+// @ID getProfilingStorageUsage
+// @Summary Get storage usage of profiling results
+// @Security JwtAuth
+// @Success 200 {object} utils.StorageUsage
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /profiling/storage [get]
+func (s *Service) getStorageUsage(c *gin.Context) {
+	items, err := taskGroupItems(s.params.LocalStore.DB)
+	if err != nil {
 		rest.Error(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, rest.EmptyResponse{})
+	c.JSON(http.StatusOK, utils.NewStorageUsage(items))
 }
 
 // @Summary Get Profiling Dynamic Config
@@ -500,7 +769,13 @@ func (s *Service) setDynamicConfig(c *gin.Context) {
 		return
 	}
 	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.Profiling)
+		if req.Retention == (config.RetentionConfig{}) {
+			// Keep the retention if it is not specified, e.g. by clients only aware of auto collection.
+			req.Retention = dc.Profiling.Retention
+		}
 		dc.Profiling = req
+		audit.SetAfter(c, dc.Profiling)
 	}
 	if err := s.params.ConfigManager.Modify(opt); err != nil {
 		rest.Error(c, err)
@@ -0,0 +1,197 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+// scheduler runs tasks of different task groups concurrently. An instance can only be profiled by one task of each
+// profiling type at a time, e.g. it cannot be CPU profiled twice at the same time, so conflicting tasks are queued
+// until the running one finishes.
+type scheduler struct {
+	ctx context.Context
+	wg  *sync.WaitGroup
+	db  *dbstore.DB
+
+	mu sync.Mutex
+	// running holds lock keys of running tasks. A nil task means the lock is held outside of task groups.
+	running map[string]*Task
+	queue   []*Task
+	// released is closed and replaced whenever a lock key is released.
+	released chan struct{}
+}
+
+// newScheduler creates a scheduler, which aborts queued tasks when ctx is done.
+func newScheduler(ctx context.Context, wg *sync.WaitGroup, db *dbstore.DB) *scheduler {
+	s := &scheduler{
+		ctx:      ctx,
+		wg:       wg,
+		db:       db,
+		running:  make(map[string]*Task),
+		released: make(chan struct{}),
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.abortQueueLocked("canceled by shutdown")
+	}()
+	return s
+}
+
+// cleanupStaleTasks marks tasks and task groups left running or queued by the last run as errors, since they are
+// never finished after a restart.
+func cleanupStaleTasks(db *dbstore.DB) error {
+	err := db.Model(&TaskModel{}).
+		Where("state IN ?", []TaskState{TaskStateRunning, TaskStateQueued}).
+		Updates(map[string]interface{}{"state": TaskStateError, "error": "interrupted by restart"}).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&TaskGroupModel{}).
+		Where("state = ?", TaskStateRunning).
+		Update("state", TaskStateError).Error
+}
+
+func lockKey(target *model.RequestTargetNode, profilingType TaskProfilingType) string {
+	return fmt.Sprintf("%s:%d/%s", target.IP, target.Port, profilingType)
+}
+
+func taskLockKey(t *Task) string {
+	return lockKey(&t.Target, t.ProfilingType)
+}
+
+// lock waits until the lock key is not held by running tasks, and then holds it until the returned function is
+// called, so that conflicting tasks are queued meanwhile. It is used to profile outside of task groups.
+func (s *scheduler) lock(ctx context.Context, key string) (func(), error) {
+	for {
+		s.mu.Lock()
+		if _, ok := s.running[key]; !ok {
+			s.running[key] = nil
+			s.mu.Unlock()
+			return func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				s.releaseLocked(key)
+			}, nil
+		}
+		released := s.released
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.ctx.Done():
+			return nil, s.ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (s *scheduler) releaseLocked(key string) {
+	delete(s.running, key)
+	close(s.released)
+	s.released = make(chan struct{})
+	s.dispatchLocked()
+}
+
+// submit runs the tasks, or queues them if they conflict with running tasks.
+func (s *scheduler) submit(tasks []*Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, tasks...)
+	s.dispatchLocked()
+}
+
+// dispatchLocked starts queued tasks which do not conflict with running tasks, in the order they are submitted.
+func (s *scheduler) dispatchLocked() {
+	if s.ctx.Err() != nil {
+		s.abortQueueLocked("canceled by shutdown")
+		return
+	}
+	queue := make([]*Task, 0, len(s.queue))
+	for _, t := range s.queue {
+		key := taskLockKey(t)
+		if _, ok := s.running[key]; ok {
+			if t.State != TaskStateQueued {
+				t.State = TaskStateQueued
+				s.db.Save(t.TaskModel)
+			}
+			queue = append(queue, t)
+			continue
+		}
+		s.running[key] = t
+		s.startLocked(t)
+	}
+	s.queue = queue
+}
+
+func (s *scheduler) startLocked(t *Task) {
+	if t.State == TaskStateQueued {
+		t.State = TaskStateRunning
+		t.StartedAt = time.Now().Unix()
+		s.db.Save(t.TaskModel)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		t.run()
+		s.finish(t)
+	}()
+}
+
+func (s *scheduler) finish(t *Task) {
+	s.mu.Lock()
+	s.releaseLocked(taskLockKey(t))
+	s.mu.Unlock()
+	close(t.done)
+}
+
+// cancelGroup removes queued tasks of the task group from the queue. Running tasks are not affected.
+func (s *scheduler) cancelGroup(taskGroupID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queue := make([]*Task, 0, len(s.queue))
+	for _, t := range s.queue {
+		if t.TaskGroupID != taskGroupID {
+			queue = append(queue, t)
+			continue
+		}
+		s.abortLocked(t, "canceled")
+	}
+	s.queue = queue
+}
+
+// abortQueueLocked removes all queued tasks from the queue as errors.
+func (s *scheduler) abortQueueLocked(reason string) {
+	for _, t := range s.queue {
+		s.abortLocked(t, reason)
+	}
+	s.queue = nil
+}
+
+func (s *scheduler) abortLocked(t *Task, reason string) {
+	t.State = TaskStateError
+	t.Error = reason
+	s.db.Save(t.TaskModel)
+	close(t.done)
+}
+
+// queuedTaskIDs returns IDs of queued tasks in the order they will be run.
+func (s *scheduler) queuedTaskIDs() []uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]uint, 0, len(s.queue))
+	for _, t := range s.queue {
+		ids = append(ids, t.ID)
+	}
+	return ids
+}
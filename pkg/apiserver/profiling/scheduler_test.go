@@ -0,0 +1,190 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package profiling
+
+import (
+	"context"
+	"os"
+	"path"
+	"sync"
+
+	. "github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var _ = Suite(&testSchedulerSuite{})
+
+type testSchedulerSuite struct {
+	db *dbstore.DB
+}
+
+func (t *testSchedulerSuite) SetUpTest(c *C) {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	t.db = &dbstore.DB{DB: gormDB}
+	c.Assert(autoMigrate(t.db), IsNil)
+}
+
+// blockingFetcher returns profiles after it is released.
+type blockingFetcher struct {
+	release chan struct{}
+}
+
+func (f *blockingFetcher) fetch(op *fetchOptions) ([]byte, error) {
+	<-f.release
+	return []byte("profile"), nil
+}
+
+func (t *testSchedulerSuite) newTasks(c *C, fts *fetchers, types ...TaskProfilingType) []*Task {
+	target := model.RequestTargetNode{Kind: model.NodeKindTiDB, IP: "127.0.0.1", Port: 10080}
+	taskGroup := NewTaskGroup(t.db, 1, model.RequestTargetStatistics{}, types)
+	c.Assert(t.db.Create(taskGroup.TaskGroupModel).Error, IsNil)
+	tasks := make([]*Task, 0, len(types))
+	for _, profilingType := range types {
+		task := NewTask(context.Background(), taskGroup, target, fts, profilingType)
+		c.Assert(t.db.Create(task.TaskModel).Error, IsNil)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func (t *testSchedulerSuite) TestQueueConflictingTasks(c *C) {
+	fetcher := &blockingFetcher{release: make(chan struct{})}
+	fts := &fetchers{tidb: fetcher}
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(ctx, &wg, t.db)
+
+	tasks := t.newTasks(c, fts, ProfilingTypeCPU, ProfilingTypeHeap)
+	s.submit(tasks)
+	queued := t.newTasks(c, fts, ProfilingTypeCPU, ProfilingTypeCPU)
+	s.submit(queued)
+	c.Assert(s.queuedTaskIDs(), DeepEquals, []uint{queued[0].ID, queued[1].ID})
+	c.Assert(queued[0].State, Equals, TaskStateQueued)
+
+	// Canceling the first task group does not affect the queue.
+	s.cancelGroup(tasks[0].TaskGroupID)
+	c.Assert(s.queuedTaskIDs(), HasLen, 2)
+
+	close(fetcher.release)
+	for _, task := range append(tasks, queued...) {
+		<-task.done
+		c.Assert(task.State, Equals, TaskStateFinish)
+		_ = os.Remove(task.FilePath)
+	}
+	cancel()
+	wg.Wait()
+	c.Assert(s.queuedTaskIDs(), HasLen, 0)
+}
+
+func (t *testSchedulerSuite) TestCancelQueuedTasks(c *C) {
+	fetcher := &blockingFetcher{release: make(chan struct{})}
+	fts := &fetchers{tidb: fetcher}
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(ctx, &wg, t.db)
+
+	running := t.newTasks(c, fts, ProfilingTypeCPU)
+	s.submit(running)
+	queued := t.newTasks(c, fts, ProfilingTypeCPU)
+	s.submit(queued)
+	s.cancelGroup(queued[0].TaskGroupID)
+	<-queued[0].done
+	c.Assert(queued[0].State, Equals, TaskStateError)
+	c.Assert(s.queuedTaskIDs(), HasLen, 0)
+
+	var task TaskModel
+	c.Assert(t.db.Where("id = ?", queued[0].ID).First(&task).Error, IsNil)
+	c.Assert(task.State, Equals, TaskStateError)
+
+	close(fetcher.release)
+	<-running[0].done
+	cancel()
+	wg.Wait()
+	_ = os.Remove(running[0].FilePath)
+}
+
+func (t *testSchedulerSuite) TestAbortQueuedTasksOnShutdown(c *C) {
+	fetcher := &blockingFetcher{release: make(chan struct{})}
+	fts := &fetchers{tidb: fetcher}
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(ctx, &wg, t.db)
+
+	running := t.newTasks(c, fts, ProfilingTypeCPU)
+	s.submit(running)
+	queued := t.newTasks(c, fts, ProfilingTypeCPU)
+	s.submit(queued)
+
+	cancel()
+	<-queued[0].done
+	c.Assert(queued[0].State, Equals, TaskStateError)
+	c.Assert(s.queuedTaskIDs(), HasLen, 0)
+
+	// Tasks submitted after shutdown are aborted at once.
+	late := t.newTasks(c, fts, ProfilingTypeHeap)
+	s.submit(late)
+	<-late[0].done
+	c.Assert(late[0].State, Equals, TaskStateError)
+
+	close(fetcher.release)
+	<-running[0].done
+	wg.Wait()
+	_ = os.Remove(running[0].FilePath)
+}
+
+func (t *testSchedulerSuite) TestLock(c *C) {
+	fetcher := &blockingFetcher{release: make(chan struct{})}
+	fts := &fetchers{tidb: fetcher}
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	s := newScheduler(ctx, &wg, t.db)
+
+	// Tasks are queued while the lock is held outside of task groups.
+	unlock, err := s.lock(context.Background(), lockKey(&model.RequestTargetNode{IP: "127.0.0.1", Port: 10080}, ProfilingTypeCPU))
+	c.Assert(err, IsNil)
+	tasks := t.newTasks(c, fts, ProfilingTypeCPU)
+	s.submit(tasks)
+	c.Assert(s.queuedTaskIDs(), DeepEquals, []uint{tasks[0].ID})
+	unlock()
+	c.Assert(s.queuedTaskIDs(), HasLen, 0)
+
+	// Locking waits for the running task, until the context is done.
+	lockCtx, lockCancel := context.WithCancel(context.Background())
+	lockCancel()
+	_, err = s.lock(lockCtx, taskLockKey(tasks[0]))
+	c.Assert(err, NotNil)
+
+	close(fetcher.release)
+	unlock, err = s.lock(context.Background(), taskLockKey(tasks[0]))
+	c.Assert(err, IsNil)
+	c.Assert(tasks[0].State, Equals, TaskStateFinish)
+	unlock()
+
+	cancel()
+	wg.Wait()
+	_ = os.Remove(tasks[0].FilePath)
+}
+
+func (t *testSchedulerSuite) TestCleanupStaleTasks(c *C) {
+	taskGroup := &TaskGroupModel{State: TaskStateRunning}
+	c.Assert(t.db.Create(taskGroup).Error, IsNil)
+	tasks := []*TaskModel{
+		{TaskGroupID: taskGroup.ID, State: TaskStateRunning},
+		{TaskGroupID: taskGroup.ID, State: TaskStateQueued},
+		{TaskGroupID: taskGroup.ID, State: TaskStateFinish},
+	}
+	c.Assert(t.db.Create(tasks).Error, IsNil)
+
+	c.Assert(cleanupStaleTasks(t.db), IsNil)
+
+	var states []TaskState
+	c.Assert(t.db.Model(&TaskModel{}).Order("id").Pluck("state", &states).Error, IsNil)
+	c.Assert(states, DeepEquals, []TaskState{TaskStateError, TaskStateError, TaskStateFinish})
+	c.Assert(t.db.First(taskGroup, taskGroup.ID).Error, IsNil)
+	c.Assert(taskGroup.State, Equals, TaskStateError)
+}
@@ -30,6 +30,7 @@ var (
 	ErrTimeout                    = ErrNS.NewType("timeout")
 	ErrUnsupportedProfilingType   = ErrNS.NewType("unsupported_profiling_type")
 	ErrUnsupportedProfilingTarget = ErrNS.NewType("unsupported_profiling_target")
+	ErrIncompatibleProfiles       = ErrNS.NewType("incompatible_profiles")
 )
 
 type StartRequest struct {
@@ -59,26 +60,35 @@ type Service struct {
 	params       ServiceParams
 	lifecycleCtx context.Context
 
-	wg            sync.WaitGroup
-	sessionCh     chan *StartRequestSession
-	lastTaskGroup *TaskGroup
-	tasks         sync.Map
-	fetchers      *fetchers
+	wg        sync.WaitGroup
+	sessionCh chan *StartRequestSession
+	scheduler *scheduler
+	tasks     sync.Map
+	fetchers  *fetchers
 }
 
 var newService = fx.Provide(func(lc fx.Lifecycle, p ServiceParams, fts *fetchers) (*Service, error) {
 	if err := autoMigrate(p.LocalStore); err != nil {
 		return nil, err
 	}
+	if err := cleanupStaleTasks(p.LocalStore); err != nil {
+		return nil, err
+	}
 	s := &Service{params: p, fetchers: fts}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			s.lifecycleCtx = ctx
+			s.scheduler = newScheduler(ctx, &s.wg, p.LocalStore)
 			s.wg.Add(1)
 			go func() {
 				defer s.wg.Done()
 				s.serviceLoop(ctx)
 			}()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.gcLoop(ctx)
+			}()
 			return nil
 		},
 		OnStop: func(context.Context) error {
@@ -97,6 +107,7 @@ func (s *Service) serviceLoop(ctx context.Context) {
 
 	var dc *config.DynamicConfig
 	var timeCh <-chan time.Time = make(chan time.Time, 1)
+	var autoTaskGroup *TaskGroup
 
 	newAutoRequest := func() *StartRequest {
 		if dc == nil || dc.Profiling.AutoCollectionDurationSecs == 0 {
@@ -109,6 +120,20 @@ func (s *Service) serviceLoop(ctx context.Context) {
 			DurationSecs: dc.Profiling.AutoCollectionDurationSecs,
 		}
 	}
+	startAutoGroup := func(req *StartRequest) {
+		if autoTaskGroup != nil {
+			select {
+			case <-autoTaskGroup.done:
+			default:
+				// Tasks may be queued behind manual tasks. Skip this round instead of piling up the queue.
+				log.Warn("last automatic task group is not finished, skip", zap.Uint("id", autoTaskGroup.ID))
+				return
+			}
+		}
+		if taskGroup, err := s.startGroup(ctx, req); err == nil {
+			autoTaskGroup = taskGroup
+		}
+	}
 
 	for {
 		select {
@@ -120,36 +145,23 @@ func (s *Service) serviceLoop(ctx context.Context) {
 			}
 			dc = newDc
 			if req := newAutoRequest(); req != nil {
-				_, _ = s.exclusiveExecute(ctx, req)
+				startAutoGroup(req)
 			}
 		case <-timeCh:
 			if req := newAutoRequest(); req != nil {
-				_, _ = s.exclusiveExecute(ctx, req)
+				startAutoGroup(req)
 			}
 		case session := <-s.sessionCh:
-			s.handleRequest(ctx, session, dc)
+			s.handleRequest(ctx, session)
 		}
 	}
 }
 
-func (s *Service) handleRequest(ctx context.Context, session *StartRequestSession, dc *config.DynamicConfig) {
+// handleRequest starts a manual task group. It runs alongside automatic collection and other task groups, while
+// tasks conflicting with running tasks are queued by the scheduler.
+func (s *Service) handleRequest(ctx context.Context, session *StartRequestSession) {
 	defer close(session.ch)
-	if dc.Profiling.AutoCollectionDurationSecs > 0 {
-		session.err = ErrIgnoredRequest.New("automatic collection is enabled")
-		log.Warn("request is ignored", zap.Error(session.err))
-		return
-	}
-	session.taskGroup, session.err = s.exclusiveExecute(ctx, &session.req)
-}
-
-func (s *Service) exclusiveExecute(ctx context.Context, req *StartRequest) (*TaskGroup, error) {
-	if s.lastTaskGroup != nil {
-		if err := s.cancelGroup(s.lastTaskGroup.ID); err != nil {
-			return nil, ErrIgnoredRequest.New("failed to cancel last task group: id = %d", s.lastTaskGroup.ID)
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
-	return s.startGroup(ctx, req)
+	session.taskGroup, session.err = s.startGroup(ctx, &session.req)
 }
 
 func (s *Service) startGroup(ctx context.Context, req *StartRequest) (*TaskGroup, error) {
@@ -175,20 +187,19 @@ func (s *Service) startGroup(ctx context.Context, req *StartRequest) (*TaskGroup
 			tasks = append(tasks, t)
 		}
 	}
+	s.scheduler.submit(tasks)
 
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		var wg sync.WaitGroup
-		for i := 0; i < len(tasks); i++ {
-			wg.Add(1)
-			go func(idx int) {
-				defer wg.Done()
-				tasks[idx].run()
-				s.tasks.Delete(tasks[idx].ID)
-			}(i)
+		for _, task := range tasks {
+			select {
+			case <-task.done:
+				s.tasks.Delete(task.ID)
+			case <-ctx.Done():
+				return
+			}
 		}
-		wg.Wait()
 		errorTasks := 0
 		finishedTasks := 0
 		for _, task := range tasks {
@@ -207,12 +218,15 @@ func (s *Service) startGroup(ctx context.Context, req *StartRequest) (*TaskGroup
 			taskGroup.State = TaskStateFinish
 		}
 		s.params.LocalStore.Save(taskGroup.TaskGroupModel)
+		close(taskGroup.done)
 	}()
 
 	return taskGroup, nil
 }
 
 func (s *Service) cancelGroup(taskGroupID uint) error {
+	s.scheduler.cancelGroup(taskGroupID)
+
 	var tasks []TaskModel
 	if err := s.params.LocalStore.Where("task_group_id = ? AND state = ?", taskGroupID, TaskStateRunning).Find(&tasks).Error; err != nil {
 		log.Warn("failed to cancel task group", zap.Error(err))
@@ -4,7 +4,9 @@ package queryeditor
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"net/http"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
@@ -48,7 +51,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(auth.MWAuthRequired())
 	endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 	endpoint.Use(utils.MWForbidByExperimentalFlag(s.params.Config.EnableExperimental))
-	endpoint.POST("/run", auth.MWRequireWritePriv(), s.runHandler)
+	endpoint.POST("/run", auth.MWRequirePermission(user.PermQueryEditorRun), s.runHandler)
 }
 
 type RunRequest struct {
@@ -111,6 +114,21 @@ func executeStatements(context context.Context, db *sql.DB, statements string) (
 	return colNames, retRows, nil
 }
 
+// statementsAuditRecord is recorded in the audit event instead of the statements, which may contain secrets, e.g.
+// `IDENTIFIED BY 'password'`. The digest can be used to check whether the given statements were executed.
+type statementsAuditRecord struct {
+	SHA256 string `json:"sha256"`
+	Length int    `json:"length"`
+}
+
+func newStatementsAuditRecord(statements string) statementsAuditRecord {
+	sum := sha256.Sum256([]byte(statements))
+	return statementsAuditRecord{
+		SHA256: hex.EncodeToString(sum[:]),
+		Length: len(statements),
+	}
+}
+
 // @ID queryEditorRun
 // @Summary Run statements
 // @Param request body RunRequest true "Request body"
@@ -135,11 +153,13 @@ func (s *Service) runHandler(c *gin.Context) {
 	if err != nil {
 		panic(err)
 	}
+	audit.SetAfter(c, newStatementsAuditRecord(req.Statements))
 	colNames, rows, err := executeStatements(ctx, sqlDB, req.Statements)
 	elapsedTime := time.Since(startTime)
 
 	if err != nil {
 		log.Warn("Failed to execute user input statements", zap.String("statements", req.Statements), zap.Error(err))
+		audit.SetFailure(c, err)
 		c.JSON(http.StatusOK, RunResponse{
 			ErrorMsg:    err.Error(),
 			ColumnNames: nil,
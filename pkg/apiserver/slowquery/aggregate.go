@@ -0,0 +1,403 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+// Slow queries are aggregated in TiDB by the group key and an exponential latency bucket, so that only a few rows
+// are transferred for each group. Percentiles are estimated from latency buckets.
+
+const (
+	GroupByDigest   = "digest"
+	GroupByUser     = "user"
+	GroupByInstance = "instance"
+	GroupByDB       = "db"
+	GroupByTime     = "time"
+
+	// latencyBucketsPerOctave is the number of latency buckets between x and 2x. The upper bound of the bucket b is
+	// 2^(b/latencyBucketsPerOctave) milliseconds, so that an estimated percentile is at most ~19% higher than it is.
+	latencyBucketsPerOctave = 4
+	// maxTimeBuckets is the number of time buckets to produce when the interval is not specified.
+	maxTimeBuckets = 60
+	// maxSpecifiedTimeBuckets is the max number of time buckets when the interval is specified.
+	maxSpecifiedTimeBuckets = 1440
+	minIntervalSecs         = 60
+	defaultGroupsLimit      = 100
+)
+
+var latencyBucketExpr = fmt.Sprintf(
+	"IFNULL(GREATEST(CEIL(LOG2(Query_time * 1000) * %d), 0), 0)", latencyBucketsPerOctave)
+
+var groupOrders = map[string]func(g *SlowQueryGroup) float64{
+	"count":            func(g *SlowQueryGroup) float64 { return float64(g.Count) },
+	"sum_query_time":   func(g *SlowQueryGroup) float64 { return g.SumQueryTime },
+	"p99_query_time":   func(g *SlowQueryGroup) float64 { return g.P99QueryTime },
+	"max_query_time":   func(g *SlowQueryGroup) float64 { return g.MaxQueryTime },
+	"sum_process_time": func(g *SlowQueryGroup) float64 { return g.SumProcessTime },
+	"max_memory":       func(g *SlowQueryGroup) float64 { return float64(g.MaxMemory) },
+}
+
+type AggregateFilter struct {
+	BeginTime int      `json:"begin_time" form:"begin_time" binding:"required"`
+	EndTime   int      `json:"end_time" form:"end_time" binding:"required"`
+	DB        []string `json:"db" form:"db"`
+	Text      string   `json:"text" form:"text"`
+	Plans     []string `json:"plans" form:"plans"`
+	Digest    string   `json:"digest" form:"digest"`
+}
+
+func (f *AggregateFilter) listRequest() *GetListRequest {
+	return &GetListRequest{
+		BeginTime: f.BeginTime,
+		EndTime:   f.EndTime,
+		DB:        f.DB,
+		Text:      f.Text,
+		Plans:     f.Plans,
+		Digest:    f.Digest,
+	}
+}
+
+// intervalSecs returns the given interval, which is at least minIntervalSecs, or an interval producing at most
+// maxTimeBuckets buckets in the time range. An error is returned if the given interval produces more than
+// maxSpecifiedTimeBuckets buckets.
+func (f *AggregateFilter) intervalSecs(interval int) (int, error) {
+	if interval > 0 {
+		if interval < minIntervalSecs {
+			interval = minIntervalSecs
+		}
+		if buckets := (f.EndTime - f.BeginTime + interval - 1) / interval; buckets > maxSpecifiedTimeBuckets {
+			return 0, rest.ErrBadRequest.New("interval_secs %d produces %d time buckets, more than %d", interval, buckets, maxSpecifiedTimeBuckets)
+		}
+		return interval, nil
+	}
+	interval = (f.EndTime - f.BeginTime + maxTimeBuckets - 1) / maxTimeBuckets
+	interval = (interval + minIntervalSecs - 1) / minIntervalSecs * minIntervalSecs
+	if interval < minIntervalSecs {
+		interval = minIntervalSecs
+	}
+	return interval, nil
+}
+
+type GetGroupRequest struct {
+	AggregateFilter
+	// GroupBy is one of digest, user, instance, db and time.
+	GroupBy string `json:"group_by" form:"group_by" binding:"required"`
+	// IntervalSecs is the size of time buckets when grouping by time, at least 60. 0 chooses one by the time range.
+	IntervalSecs int `json:"interval_secs" form:"interval_secs"`
+	// OrderBy is one of count, sum_query_time, p99_query_time, max_query_time, sum_process_time and max_memory.
+	OrderBy string `json:"order_by" form:"order_by"`
+	Limit   int    `json:"limit" form:"limit"`
+}
+
+type SlowQueryGroup struct {
+	// GroupKey is the value of the grouped column, or the beginning unix timestamp of the time bucket.
+	GroupKey string `json:"group_key"`
+	// SampleQuery is one of queries in the group, only available when grouping by digest.
+	SampleQuery    string  `json:"sample_query,omitempty"`
+	Count          int64   `json:"count"`
+	SumQueryTime   float64 `json:"sum_query_time"`
+	P50QueryTime   float64 `json:"p50_query_time"`
+	P95QueryTime   float64 `json:"p95_query_time"`
+	P99QueryTime   float64 `json:"p99_query_time"`
+	MaxQueryTime   float64 `json:"max_query_time"`
+	SumProcessTime float64 `json:"sum_process_time"`
+	SumWaitTime    float64 `json:"sum_wait_time"`
+	SumBackoffTime float64 `json:"sum_backoff_time"`
+	MaxMemory      int64   `json:"max_memory"`
+}
+
+type GetHistogramRequest struct {
+	AggregateFilter
+	// IntervalSecs is the size of time buckets, at least 60. 0 chooses one by the time range.
+	IntervalSecs int `json:"interval_secs" form:"interval_secs"`
+}
+
+type HistogramPoint struct {
+	Timestamp int64 `json:"timestamp"`
+	// Counts are numbers of slow queries in each latency bucket of the response.
+	Counts []int64 `json:"counts"`
+}
+
+type HistogramResponse struct {
+	IntervalSecs int `json:"interval_secs"`
+	// LatencyBounds are upper bounds of latency buckets in seconds.
+	LatencyBounds []float64        `json:"latency_bounds"`
+	Points        []HistogramPoint `json:"points"`
+}
+
+type latencyBucketRow struct {
+	GroupKey       string  `gorm:"column:group_key"`
+	LatencyBucket  int     `gorm:"column:latency_bucket"`
+	Count          int64   `gorm:"column:count"`
+	SumQueryTime   float64 `gorm:"column:sum_query_time"`
+	MaxQueryTime   float64 `gorm:"column:max_query_time"`
+	SumProcessTime float64 `gorm:"column:sum_process_time"`
+	SumWaitTime    float64 `gorm:"column:sum_wait_time"`
+	SumBackoffTime float64 `gorm:"column:sum_backoff_time"`
+	MaxMemory      int64   `gorm:"column:max_memory"`
+	SampleQuery    string  `gorm:"column:sample_query"`
+}
+
+// latencyBucketBound returns the upper bound of the latency bucket in seconds.
+func latencyBucketBound(bucket int) float64 {
+	return math.Pow(2, float64(bucket)/latencyBucketsPerOctave) / 1000
+}
+
+// estimatePercentile estimates the percentile from counts of latency buckets, by interpolating in the bucket where
+// the percentile falls. Bounds are capped by the max latency.
+func estimatePercentile(buckets map[int]int64, count int64, maxLatency, p float64) float64 {
+	if count == 0 {
+		return 0
+	}
+	keys := make([]int, 0, len(buckets))
+	for b := range buckets {
+		keys = append(keys, b)
+	}
+	sort.Ints(keys)
+
+	rank := p * float64(count)
+	var cum int64
+	for _, b := range keys {
+		n := buckets[b]
+		cum += n
+		if float64(cum) < rank {
+			continue
+		}
+		upper := math.Min(latencyBucketBound(b), maxLatency)
+		lower := 0.0
+		if b > 0 {
+			lower = math.Min(latencyBucketBound(b-1), upper)
+		}
+		return lower + (upper-lower)*(rank-float64(cum-n))/float64(n)
+	}
+	return maxLatency
+}
+
+// aggregateGroups merges latency buckets of each group into groups, sorted by the order in descending order.
+func aggregateGroups(rows []latencyBucketRow, orderBy string) []SlowQueryGroup {
+	groups := make(map[string]*SlowQueryGroup)
+	buckets := make(map[string]map[int]int64)
+	keys := make([]string, 0)
+	for _, r := range rows {
+		g, ok := groups[r.GroupKey]
+		if !ok {
+			g = &SlowQueryGroup{GroupKey: r.GroupKey}
+			groups[r.GroupKey] = g
+			buckets[r.GroupKey] = make(map[int]int64)
+			keys = append(keys, r.GroupKey)
+		}
+		buckets[r.GroupKey][r.LatencyBucket] += r.Count
+		g.Count += r.Count
+		g.SumQueryTime += r.SumQueryTime
+		g.SumProcessTime += r.SumProcessTime
+		g.SumWaitTime += r.SumWaitTime
+		g.SumBackoffTime += r.SumBackoffTime
+		g.MaxQueryTime = math.Max(g.MaxQueryTime, r.MaxQueryTime)
+		if r.MaxMemory > g.MaxMemory {
+			g.MaxMemory = r.MaxMemory
+		}
+		if g.SampleQuery == "" {
+			g.SampleQuery = r.SampleQuery
+		}
+	}
+
+	result := make([]SlowQueryGroup, 0, len(keys))
+	for _, key := range keys {
+		g := groups[key]
+		g.P50QueryTime = estimatePercentile(buckets[key], g.Count, g.MaxQueryTime, 0.5)
+		g.P95QueryTime = estimatePercentile(buckets[key], g.Count, g.MaxQueryTime, 0.95)
+		g.P99QueryTime = estimatePercentile(buckets[key], g.Count, g.MaxQueryTime, 0.99)
+		result = append(result, *g)
+	}
+	order, ok := groupOrders[orderBy]
+	if !ok {
+		order = groupOrders["sum_query_time"]
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return order(&result[i]) > order(&result[j])
+	})
+	return result
+}
+
+// buildHistogram converts latency buckets of time groups into a histogram covering all observed latency buckets.
+func buildHistogram(rows []latencyBucketRow, intervalSecs int) (*HistogramResponse, error) {
+	resp := &HistogramResponse{
+		IntervalSecs:  intervalSecs,
+		LatencyBounds: []float64{},
+		Points:        []HistogramPoint{},
+	}
+	if len(rows) == 0 {
+		return resp, nil
+	}
+
+	minBucket, maxBucket := rows[0].LatencyBucket, rows[0].LatencyBucket
+	for _, r := range rows {
+		if r.LatencyBucket < minBucket {
+			minBucket = r.LatencyBucket
+		}
+		if r.LatencyBucket > maxBucket {
+			maxBucket = r.LatencyBucket
+		}
+	}
+	for b := minBucket; b <= maxBucket; b++ {
+		resp.LatencyBounds = append(resp.LatencyBounds, latencyBucketBound(b))
+	}
+
+	points := make(map[int64]*HistogramPoint)
+	for _, r := range rows {
+		ts, err := strconv.ParseInt(r.GroupKey, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		p, ok := points[ts]
+		if !ok {
+			p = &HistogramPoint{Timestamp: ts, Counts: make([]int64, len(resp.LatencyBounds))}
+			points[ts] = p
+		}
+		p.Counts[r.LatencyBucket-minBucket] += r.Count
+	}
+	for _, p := range points {
+		resp.Points = append(resp.Points, *p)
+	}
+	sort.Slice(resp.Points, func(i, j int) bool {
+		return resp.Points[i].Timestamp < resp.Points[j].Timestamp
+	})
+	return resp, nil
+}
+
+func groupByExpr(groupBy string, intervalSecs int) (string, bool) {
+	switch groupBy {
+	case GroupByDigest:
+		return "IFNULL(Digest, '')", true
+	case GroupByUser:
+		return "IFNULL(User, '')", true
+	case GroupByInstance:
+		return "IFNULL(INSTANCE, '')", true
+	case GroupByDB:
+		return "IFNULL(DB, '')", true
+	case GroupByTime:
+		return fmt.Sprintf("CAST(FLOOR(UNIX_TIMESTAMP(Time) / %d) * %d AS CHAR)", intervalSecs, intervalSecs), true
+	default:
+		return "", false
+	}
+}
+
+func queryLatencyBuckets(db *gorm.DB, filter *AggregateFilter, groupExpr string, withSample bool) ([]latencyBucketRow, error) {
+	sampleExpr := "''"
+	if withSample {
+		sampleExpr = "ANY_VALUE(Query)"
+	}
+	tx := db.Table(SlowQueryTable).Select(fmt.Sprintf(`%s AS group_key,
+		%s AS latency_bucket,
+		COUNT(*) AS count,
+		SUM(Query_time) AS sum_query_time,
+		MAX(Query_time) AS max_query_time,
+		SUM(Process_time) AS sum_process_time,
+		SUM(Wait_time) AS sum_wait_time,
+		SUM(Backoff_time) AS sum_backoff_time,
+		MAX(Mem_max) AS max_memory,
+		%s AS sample_query`, groupExpr, latencyBucketExpr, sampleExpr))
+	tx = applyFilters(tx, filter.listRequest())
+
+	var rows []latencyBucketRow
+	if err := tx.Group("group_key, latency_bucket").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// @Summary Aggregate slow queries by a dimension
+// @Description Aggregate slow queries by digest, user, instance, db or time bucket. Percentiles are estimated.
+// @Param q query GetGroupRequest true "Query"
+// @Success 200 {array} SlowQueryGroup
+// @Router /slow_query/group [get]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+func (s *Service) getGroups(c *gin.Context) {
+	var req GetGroupRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	if req.OrderBy != "" {
+		if _, ok := groupOrders[req.OrderBy]; !ok {
+			rest.Error(c, rest.ErrBadRequest.New("unsupported order_by %s", req.OrderBy))
+			return
+		}
+	}
+	intervalSecs := minIntervalSecs
+	if req.GroupBy == GroupByTime {
+		var err error
+		if intervalSecs, err = req.intervalSecs(req.IntervalSecs); err != nil {
+			rest.Error(c, err)
+			return
+		}
+	}
+	groupExpr, ok := groupByExpr(req.GroupBy, intervalSecs)
+	if !ok {
+		rest.Error(c, rest.ErrBadRequest.New("unsupported group_by %s", req.GroupBy))
+		return
+	}
+	if req.Limit <= 0 {
+		req.Limit = defaultGroupsLimit
+	}
+
+	db := utils.GetTiDBConnection(c)
+	rows, err := queryLatencyBuckets(db, &req.AggregateFilter, groupExpr, req.GroupBy == GroupByDigest)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	groups := aggregateGroups(rows, req.OrderBy)
+	if len(groups) > req.Limit {
+		groups = groups[:req.Limit]
+	}
+	c.JSON(http.StatusOK, groups)
+}
+
+// @Summary Get the latency histogram of slow queries over time
+// @Param q query GetHistogramRequest true "Query"
+// @Success 200 {object} HistogramResponse
+// @Router /slow_query/histogram [get]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+func (s *Service) getHistogram(c *gin.Context) {
+	var req GetHistogramRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	intervalSecs, err := req.intervalSecs(req.IntervalSecs)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	groupExpr, _ := groupByExpr(GroupByTime, intervalSecs)
+
+	db := utils.GetTiDBConnection(c)
+	rows, err := queryLatencyBuckets(db, &req.AggregateFilter, groupExpr, false)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	resp, err := buildHistogram(rows, intervalSecs)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
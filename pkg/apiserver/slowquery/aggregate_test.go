@@ -0,0 +1,101 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testAggregateSuite{})
+
+type testAggregateSuite struct{}
+
+func (t *testAggregateSuite) Test_estimatePercentile(c *C) {
+	c.Assert(estimatePercentile(map[int]int64{}, 0, 0, 0.5), Equals, 0.0)
+
+	// 100 queries in (~0.43s, ~0.51s], so that the median is interpolated to the middle of the bucket.
+	b := 36
+	buckets := map[int]int64{b: 100}
+	lower, upper := latencyBucketBound(b-1), latencyBucketBound(b)
+	c.Assert(math.Abs(estimatePercentile(buckets, 100, 10, 0.5)-(lower+upper)/2) < 1e-9, IsTrue)
+	// The upper bound is capped by the max latency.
+	c.Assert(estimatePercentile(buckets, 100, lower, 0.99), Equals, lower)
+
+	buckets = map[int]int64{0: 90, 40: 10}
+	c.Assert(estimatePercentile(buckets, 100, 2, 0.5) <= latencyBucketBound(0), IsTrue)
+	c.Assert(estimatePercentile(buckets, 100, 2, 0.95) > latencyBucketBound(39), IsTrue)
+}
+
+func (t *testAggregateSuite) Test_aggregateGroups(c *C) {
+	rows := []latencyBucketRow{
+		{GroupKey: "a", LatencyBucket: 10, Count: 10, SumQueryTime: 1, MaxQueryTime: 0.1, SumWaitTime: 1, MaxMemory: 10},
+		{GroupKey: "b", LatencyBucket: 40, Count: 1, SumQueryTime: 5, MaxQueryTime: 5, MaxMemory: 5, SampleQuery: "select 1"},
+		{GroupKey: "a", LatencyBucket: 20, Count: 5, SumQueryTime: 1, MaxQueryTime: 0.3, SumWaitTime: 2, MaxMemory: 20},
+	}
+	groups := aggregateGroups(rows, "")
+	c.Assert(groups, HasLen, 2)
+	c.Assert(groups[0].GroupKey, Equals, "b")
+	c.Assert(groups[0].SampleQuery, Equals, "select 1")
+	c.Assert(groups[1].Count, Equals, int64(15))
+	c.Assert(groups[1].SumQueryTime, Equals, 2.0)
+	c.Assert(groups[1].SumWaitTime, Equals, 3.0)
+	c.Assert(groups[1].MaxQueryTime, Equals, 0.3)
+	c.Assert(groups[1].MaxMemory, Equals, int64(20))
+	c.Assert(groups[1].P50QueryTime <= latencyBucketBound(10), IsTrue)
+
+	groups = aggregateGroups(rows, "count")
+	c.Assert(groups[0].GroupKey, Equals, "a")
+}
+
+func (t *testAggregateSuite) Test_buildHistogram(c *C) {
+	rows := []latencyBucketRow{
+		{GroupKey: "120", LatencyBucket: 3, Count: 2},
+		{GroupKey: "60", LatencyBucket: 1, Count: 1},
+		{GroupKey: "60", LatencyBucket: 3, Count: 4},
+	}
+	resp, err := buildHistogram(rows, 60)
+	c.Assert(err, IsNil)
+	c.Assert(resp.LatencyBounds, HasLen, 3)
+	c.Assert(resp.Points, DeepEquals, []HistogramPoint{
+		{Timestamp: 60, Counts: []int64{1, 0, 4}},
+		{Timestamp: 120, Counts: []int64{0, 0, 2}},
+	})
+
+	resp, err = buildHistogram(nil, 60)
+	c.Assert(err, IsNil)
+	c.Assert(resp.Points, HasLen, 0)
+}
+
+func (t *testAggregateSuite) Test_intervalSecs(c *C) {
+	f := AggregateFilter{BeginTime: 0, EndTime: 3600}
+	interval, err := f.intervalSecs(0)
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, 60)
+	// Intervals are at least minIntervalSecs.
+	interval, err = f.intervalSecs(10)
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, minIntervalSecs)
+	interval, err = f.intervalSecs(600)
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, 600)
+
+	f.EndTime = 86400
+	interval, err = f.intervalSecs(0)
+	c.Assert(err, IsNil)
+	c.Assert(interval, Equals, 1440)
+	_, err = f.intervalSecs(60)
+	c.Assert(err, IsNil)
+
+	// Too many buckets.
+	f.EndTime = 30 * 86400
+	_, err = f.intervalSecs(60)
+	c.Assert(err, NotNil)
+}
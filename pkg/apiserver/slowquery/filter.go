@@ -0,0 +1,63 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
+)
+
+var modelFieldKinds = func() map[string]reflect.Kind {
+	t := reflect.TypeOf(Model{})
+	kinds := make(map[string]reflect.Kind, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		kinds[t.Field(i).Tag.Get("json")] = t.Field(i).Type.Kind()
+	}
+	return kinds
+}()
+
+// filterKindOf infers the kind of a slow query field in filter expressions. Float fields except the timestamp are
+// durations in seconds.
+func filterKindOf(jsonName string) filterexpr.Kind {
+	switch modelFieldKinds[jsonName] {
+	case reflect.String:
+		return filterexpr.KindString
+	case reflect.Float64:
+		if jsonName == "timestamp" {
+			return filterexpr.KindNumber
+		}
+		return filterexpr.KindDuration
+	default:
+		if strings.HasSuffix(jsonName, "_max") || strings.HasSuffix(jsonName, "_size") || strings.HasSuffix(jsonName, "_byte") {
+			return filterexpr.KindBytes
+		}
+		return filterexpr.KindNumber
+	}
+}
+
+// filterFields returns fields available in filter expressions, i.e. fields returned by GetAvailableFields.
+func filterFields(tableColumns []string) []filterexpr.Field {
+	fields := filterFieldsByColumns(getFieldsAndTags(), tableColumns)
+	result := make([]filterexpr.Field, 0, len(fields))
+	for _, f := range fields {
+		ff := filterexpr.Field{
+			Name: f.JSONName,
+			SQL:  f.ColumnName,
+			Kind: filterKindOf(f.JSONName),
+			Unit: time.Second,
+		}
+		switch {
+		case f.Projection != "":
+			ff.SQL = f.Projection
+		case ff.Kind == filterexpr.KindString:
+			// Same as the text filter, string columns are converted so that they can be lowered for CONTAINS.
+			ff.SQL = fmt.Sprintf("CONVERT(%s USING utf8)", f.ColumnName)
+		}
+		result = append(result, ff)
+	}
+	return result
+}
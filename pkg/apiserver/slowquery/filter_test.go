@@ -0,0 +1,38 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
+)
+
+var _ = Suite(&testFilterSuite{})
+
+type testFilterSuite struct{}
+
+func (t *testFilterSuite) Test_filterFields(c *C) {
+	fields := filterFields([]string{"Query", "Query_time", "Mem_max", "Process_keys", "Time"})
+	kinds := make(map[string]filterexpr.Field)
+	for _, f := range fields {
+		kinds[f.Name] = f
+	}
+	c.Assert(kinds, HasLen, 5)
+	c.Assert(kinds["query"].Kind, Equals, filterexpr.KindString)
+	c.Assert(kinds["query"].SQL, Equals, "CONVERT(Query USING utf8)")
+	c.Assert(kinds["query_time"].Kind, Equals, filterexpr.KindDuration)
+	c.Assert(kinds["memory_max"].Kind, Equals, filterexpr.KindBytes)
+	c.Assert(kinds["process_keys"].Kind, Equals, filterexpr.KindNumber)
+	c.Assert(kinds["timestamp"].Kind, Equals, filterexpr.KindNumber)
+	c.Assert(kinds["timestamp"].SQL, Equals, "(UNIX_TIMESTAMP(Time) + 0E0)")
+
+	filter, err := filterexpr.Compile("query_time > 500ms AND memory_max >= 1GB", fields)
+	c.Assert(err, IsNil)
+	sql, args := filter.SQL()
+	c.Assert(sql, Equals, "((Query_time) > ? AND (Mem_max) >= ?)")
+	c.Assert(args, DeepEquals, []interface{}{0.5, float64(1 << 30)})
+
+	_, err = filterexpr.Compile("disk_max > 1GB", fields)
+	c.Assert(err, NotNil)
+}
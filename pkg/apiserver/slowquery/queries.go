@@ -8,6 +8,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/pingcap/tidb-dashboard/pkg/utils"
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
 )
 
 const (
@@ -28,6 +29,9 @@ type GetListRequest struct {
 	Digest string   `json:"digest" form:"digest"`
 
 	Fields string `json:"fields" form:"fields"` // example: "Query,Digest"
+
+	// Filter is an expression of available fields, e.g. "query_time > 1s AND db = 'test'", see filterexpr.
+	Filter string `json:"filter" form:"filter"`
 }
 
 type GetDetailRequest struct {
@@ -38,6 +42,21 @@ type GetDetailRequest struct {
 }
 
 func QuerySlowLogList(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.DB) ([]Model, error) {
+	tx, err := buildSlowLogListQuery(req, sysSchema, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []Model
+	err = tx.Find(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// buildSlowLogListQuery builds the query of QuerySlowLogList, which can also be iterated by rows.
+func buildSlowLogListQuery(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.DB) (*gorm.DB, error) {
 	slowQueryColumns, err := sysSchema.GetTableColumnNames(db, SlowQueryTable)
 	if err != nil {
 		return nil, err
@@ -52,8 +71,15 @@ func QuerySlowLogList(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.
 	tx := db.
 		Select(selectStmt)
 
-	if req.BeginTime != 0 && req.EndTime != 0 {
-		tx = tx.Where("Time BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)", req.BeginTime, req.EndTime)
+	tx = applyFilters(tx, req)
+
+	filter, err := filterexpr.Compile(req.Filter, filterFields(slowQueryColumns))
+	if err != nil {
+		return nil, err
+	}
+	if filter != nil {
+		sql, args := filter.SQL()
+		tx = tx.Where(sql, args...)
 	}
 
 	if req.Limit <= 0 {
@@ -61,6 +87,25 @@ func QuerySlowLogList(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.
 	}
 	tx = tx.Limit(req.Limit)
 
+	// more robust
+	if req.OrderBy == "" {
+		req.OrderBy = "timestamp"
+	}
+	orderStmt, err := genOrderStmt(slowQueryColumns, req.OrderBy, req.IsDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	tx = tx.Order(orderStmt)
+	return tx, nil
+}
+
+// applyFilters applies filters of the request except Limit, OrderBy and Fields.
+func applyFilters(tx *gorm.DB, req *GetListRequest) *gorm.DB {
+	if req.BeginTime != 0 && req.EndTime != 0 {
+		tx = tx.Where("Time BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)", req.BeginTime, req.EndTime)
+	}
+
 	if req.Text != "" {
 		lowerStr := strings.ToLower(req.Text)
 		arr := strings.Fields(lowerStr)
@@ -79,17 +124,6 @@ func QuerySlowLogList(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.
 		tx = tx.Where("DB IN (?)", req.DB)
 	}
 
-	// more robust
-	if req.OrderBy == "" {
-		req.OrderBy = "timestamp"
-	}
-	orderStmt, err := genOrderStmt(slowQueryColumns, req.OrderBy, req.IsDesc)
-	if err != nil {
-		return nil, err
-	}
-
-	tx = tx.Order(orderStmt)
-
 	if len(req.Plans) > 0 {
 		tx = tx.Where("Plan_digest IN (?)", req.Plans)
 	}
@@ -98,12 +132,7 @@ func QuerySlowLogList(req *GetListRequest, sysSchema *utils.SysSchema, db *gorm.
 		tx = tx.Where("Digest = ?", req.Digest)
 	}
 
-	var results []Model
-	err = tx.Find(&results).Error
-	if err != nil {
-		return nil, err
-	}
-	return results, nil
+	return tx
 }
 
 func QuerySlowLogDetail(req *GetDetailRequest, db *gorm.DB) (*Model, error) {
@@ -12,11 +12,14 @@ import (
 	"github.com/joomcode/errorx"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/statement"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 	commonUtils "github.com/pingcap/tidb-dashboard/pkg/utils"
 	"github.com/pingcap/tidb-dashboard/util/rest"
+	"github.com/pingcap/tidb-dashboard/util/rest/fileswap"
 )
 
 var (
@@ -26,16 +29,24 @@ var (
 
 type ServiceParams struct {
 	fx.In
-	TiDBClient *tidb.Client
-	SysSchema  *commonUtils.SysSchema
+	TiDBClient       *tidb.Client
+	SysSchema        *commonUtils.SysSchema
+	StatementService *statement.Service
+	NgmProxy         *utils.NgmProxy
 }
 
 type Service struct {
 	params ServiceParams
+	fSwap  *fileswap.Handler
 }
 
 func newService(p ServiceParams) *Service {
-	return &Service{params: p}
+	return &Service{params: p, fSwap: fileswap.New()}
+}
+
+type DownloadRequest struct {
+	GetListRequest
+	utils.ExportOptions
 }
 
 func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
@@ -48,6 +59,9 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		{
 			endpoint.GET("/list", s.getList)
 			endpoint.GET("/detail", s.getDetails)
+			endpoint.GET("/group", s.getGroups)
+			endpoint.GET("/histogram", s.getHistogram)
+			endpoint.GET("/story", s.getStory)
 
 			endpoint.POST("/download/token", s.downloadTokenHandler)
 
@@ -73,7 +87,7 @@ func (s *Service) getList(c *gin.Context) {
 	db := utils.GetTiDBConnection(c)
 	results, err := QuerySlowLogList(&req, s.params.SysSchema, db.Table(SlowQueryTable))
 	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
 		return
 	}
 
@@ -112,65 +126,77 @@ func (s *Service) getDetails(c *gin.Context) {
 
 // @Router /slow_query/download/token [post]
 // @Summary Generate a download token for exported slow query statements
+// @Description Slow queries are exported in CSV, JSON Lines or Parquet, optionally compressed by gzip
 // @Produce plain
-// @Param request body GetListRequest true "Request body"
+// @Param request body DownloadRequest true "Request body"
 // @Success 200 {string} string "xxx"
 // @Security JwtAuth
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 func (s *Service) downloadTokenHandler(c *gin.Context) {
-	var req GetListRequest
+	audit.Skip(c)
+	var req DownloadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
 		return
 	}
+	if err := req.Validate(); err != nil {
+		rest.Error(c, err)
+		return
+	}
 	fields := []string{}
 	if strings.TrimSpace(req.Fields) != "" {
 		fields = strings.Split(req.Fields, ",")
 	}
 	db := utils.GetTiDBConnection(c)
-	list, err := QuerySlowLogList(&req, s.params.SysSchema, db.Table(SlowQueryTable))
+	tx, err := buildSlowLogListQuery(&req.GetListRequest, s.params.SysSchema, db.Table(SlowQueryTable))
 	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
 		return
 	}
-	if len(list) == 0 {
-		rest.Error(c, ErrNoData.NewWithNoMessage())
-		return
-	}
-
-	// interface{} tricky
-	rawData := make([]interface{}, len(list))
-	for i, v := range list {
-		rawData[i] = v
-	}
 
-	// convert data
-	csvData := utils.GenerateCSVFromRaw(rawData, fields, []string{})
-
-	// generate temp file that persist encrypted data
 	timeLayout := "0102150405"
 	beginTime := time.Unix(int64(req.BeginTime), 0).Format(timeLayout)
 	endTime := time.Unix(int64(req.EndTime), 0).Format(timeLayout)
-	token, err := utils.ExportCSV(csvData,
-		fmt.Sprintf("slowquery_%s_%s_*.csv", beginTime, endTime),
-		"slowquery/download")
+	token, err := utils.ExportRows(s.fSwap, req.ExportOptions,
+		fmt.Sprintf("slowquery_%s_%s", beginTime, endTime),
+		Model{}, fields, []string{},
+		func(write func(row interface{}) error) error {
+			rows, err := tx.Rows()
+			if err != nil {
+				return err
+			}
+			defer rows.Close() // #nosec
+			for rows.Next() {
+				var m Model
+				if err := tx.ScanRows(rows, &m); err != nil {
+					return err
+				}
+				if err := write(&m); err != nil {
+					return err
+				}
+			}
+			return rows.Err()
+		})
 	if err != nil {
 		rest.Error(c, err)
 		return
 	}
+	if token == "" {
+		rest.Error(c, ErrNoData.NewWithNoMessage())
+		return
+	}
 	c.String(http.StatusOK, token)
 }
 
 // @Router /slow_query/download [get]
 // @Summary Download slow query statements
-// @Produce text/csv
+// @Produce application/octet-stream
 // @Param token query string true "download token"
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 func (s *Service) downloadHandler(c *gin.Context) {
-	token := c.Query("token")
-	utils.DownloadByToken(token, "slowquery/download", c)
+	s.fSwap.HandleDownloadRequest(c)
 }
 
 // @Summary Get available field names
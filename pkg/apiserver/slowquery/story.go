@@ -0,0 +1,260 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/statement"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/topsql"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+// A story collects everything related to a statement digest in a time range: statements summary, plans, slow query
+// samples, Top SQL CPU time and the binary plan with diagnosis, which are otherwise requested by the UI one by one.
+
+const (
+	defaultStoryRangeSecs    = 3600
+	defaultStorySamplesLimit = 10
+	storyTopSQLTimeout       = 10 * time.Second
+	// Top SQL is queried by the top N digests of each instance, since NgMonitoring cannot filter by digest. N grows
+	// from storyTopSQLMinTop to storyTopSQLMaxTop until the digest is found.
+	storyTopSQLMinTop        = 100
+	storyTopSQLMaxTop        = 10000
+	storyTopSQLMaxPoints     = 100
+	storyTopSQLMinWindowSecs = 60
+	storySampleFields        = "query,digest,instance,db,connection_id,timestamp,query_time,parse_time,compile_time," +
+		"process_time,wait_time,backoff_time,memory_max,user,success,txn_start_ts,binary_plan"
+)
+
+type GetStoryRequest struct {
+	Digest     string `json:"digest" form:"digest" binding:"required"`
+	SchemaName string `json:"schema_name" form:"schema_name"`
+	// PlanDigest narrows the story to the plan.
+	PlanDigest string `json:"plan_digest" form:"plan_digest"`
+	// BeginTime and EndTime default to the last hour.
+	BeginTime int `json:"begin_time" form:"begin_time"`
+	EndTime   int `json:"end_time" form:"end_time"`
+	// SamplesLimit is the max number of slow query samples, the slowest ones are returned.
+	SamplesLimit int `json:"samples_limit" form:"samples_limit"`
+}
+
+func (r *GetStoryRequest) adjust(now time.Time) {
+	if r.EndTime <= 0 {
+		r.EndTime = int(now.Unix())
+	}
+	if r.BeginTime <= 0 || r.BeginTime >= r.EndTime {
+		r.BeginTime = r.EndTime - defaultStoryRangeSecs
+	}
+	if r.SamplesLimit <= 0 {
+		r.SamplesLimit = defaultStorySamplesLimit
+	}
+}
+
+func (r *GetStoryRequest) plans() []string {
+	if r.PlanDigest == "" {
+		return nil
+	}
+	return []string{r.PlanDigest}
+}
+
+type TopSQLInstanceSeries struct {
+	Instance     string                   `json:"instance"`
+	InstanceType string                   `json:"instance_type"`
+	Plans        []topsql.SummaryPlanItem `json:"plans"`
+}
+
+type Story struct {
+	BeginTime int `json:"begin_time"`
+	EndTime   int `json:"end_time"`
+	// Statements are the statement in each summary window.
+	Statements []statement.Model `json:"statements"`
+	Plans      []statement.Model `json:"plans"`
+	// SlowQueries are the slowest samples, without binary plans.
+	SlowQueries []Model `json:"slow_queries"`
+	// TopSQL is the CPU time of the statement on each instance, only available when NgMonitoring is deployed.
+	TopSQL []TopSQLInstanceSeries `json:"top_sql"`
+	// BinaryPlan is the visual plan with diagnosis of operators, see utils.GenerateBinaryPlanJSON.
+	BinaryPlan json.RawMessage `json:"binary_plan" swaggertype:"object"`
+	// Warnings are parts of the story failed to be collected.
+	Warnings []string `json:"warnings"`
+}
+
+func (s *Service) queryStorySamples(db *gorm.DB, req *GetStoryRequest) ([]Model, error) {
+	listReq := &GetListRequest{
+		BeginTime: req.BeginTime,
+		EndTime:   req.EndTime,
+		Digest:    req.Digest,
+		Plans:     req.plans(),
+		Limit:     req.SamplesLimit,
+		OrderBy:   "query_time",
+		IsDesc:    true,
+		Fields:    storySampleFields,
+	}
+	if req.SchemaName != "" {
+		listReq.DB = []string{req.SchemaName}
+	}
+	return QuerySlowLogList(listReq, s.params.SysSchema, db.Table(SlowQueryTable))
+}
+
+// ngmJSONGetter requests NgMonitoring, see utils.NgmProxy.GetJSON.
+type ngmJSONGetter func(ctx context.Context, targetPath string, query url.Values, result interface{}) error
+
+// queryStoryTopSQL collects CPU time series of the digest from the Top SQL summary of each instance.
+func queryStoryTopSQL(ctx context.Context, getJSON ngmJSONGetter, req *GetStoryRequest) ([]TopSQLInstanceSeries, error) {
+	ctx, cancel := context.WithTimeout(ctx, storyTopSQLTimeout)
+	defer cancel()
+
+	start := strconv.Itoa(req.BeginTime)
+	end := strconv.Itoa(req.EndTime)
+	var instances topsql.InstanceResponse
+	if err := getJSON(ctx, "/topsql/v1/instances", url.Values{
+		"start": {start},
+		"end":   {end},
+	}, &instances); err != nil {
+		return nil, err
+	}
+
+	windowSecs := (req.EndTime - req.BeginTime) / storyTopSQLMaxPoints
+	if windowSecs < storyTopSQLMinWindowSecs {
+		windowSecs = storyTopSQLMinWindowSecs
+	}
+	result := make([]TopSQLInstanceSeries, 0)
+	for _, inst := range instances.Data {
+		for top := storyTopSQLMinTop; top <= storyTopSQLMaxTop; top *= 10 {
+			var summary topsql.SummaryResponse
+			if err := getJSON(ctx, "/topsql/v1/summary", url.Values{
+				"instance":      {inst.Instance},
+				"instance_type": {inst.InstanceType},
+				"start":         {start},
+				"end":           {end},
+				"top":           {strconv.Itoa(top)},
+				"window":        {fmt.Sprintf("%ds", windowSecs)},
+			}, &summary); err != nil {
+				return nil, err
+			}
+			item, complete := findTopSQLDigest(&summary, req.Digest, top)
+			if item != nil {
+				series := TopSQLInstanceSeries{Instance: inst.Instance, InstanceType: inst.InstanceType}
+				for _, p := range item.Plans {
+					if req.PlanDigest == "" || p.PlanDigest == req.PlanDigest {
+						series.Plans = append(series.Plans, p)
+					}
+				}
+				if len(series.Plans) > 0 {
+					result = append(result, series)
+				}
+			}
+			if item != nil || complete {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// findTopSQLDigest returns the item of the digest in the summary of the top digests. complete is whether the summary
+// contains all digests, i.e. fewer than top digests are reported.
+func findTopSQLDigest(summary *topsql.SummaryResponse, digest string, top int) (item *topsql.SummaryItem, complete bool) {
+	digests := 0
+	for i := range summary.Data {
+		if summary.Data[i].IsOther {
+			continue
+		}
+		digests++
+		if summary.Data[i].SQLDigest == digest {
+			item = &summary.Data[i]
+		}
+	}
+	return item, digests < top
+}
+
+// setBinaryPlan sets the visual plan of the story from the binary plan in the statements summary, or the slowest
+// sample if it is not recorded there. Binary plans of samples are removed.
+func (story *Story) setBinaryPlan(summaryBinaryPlan string) {
+	binaryPlan := summaryBinaryPlan
+	if binaryPlan == "" && len(story.SlowQueries) > 0 {
+		binaryPlan = story.SlowQueries[0].BinaryPlan
+	}
+	for i := range story.SlowQueries {
+		story.SlowQueries[i].BinaryPlan = ""
+	}
+	if binaryPlan == "" {
+		return
+	}
+	vp, err := utils.GenerateBinaryPlanJSON(binaryPlan)
+	if err != nil {
+		story.Warnings = append(story.Warnings, fmt.Sprintf("failed to decode binary plan: %v", err))
+	} else if vp != "" {
+		story.BinaryPlan = json.RawMessage(vp)
+	}
+}
+
+// @Summary Get everything related to a statement digest
+// @Description Get statements summary, plans, slow query samples, Top SQL CPU time and the visual plan of a digest in one call
+// @Param q query GetStoryRequest true "Query"
+// @Success 200 {object} Story
+// @Router /slow_query/story [get]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+func (s *Service) getStory(c *gin.Context) {
+	var req GetStoryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	req.adjust(time.Now())
+
+	db := utils.GetTiDBConnection(c)
+	stmtService := s.params.StatementService
+	story := Story{BeginTime: req.BeginTime, EndTime: req.EndTime, Warnings: []string{}}
+
+	var err error
+	story.Statements, err = stmtService.QueryStatementHistory(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest, req.plans())
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	story.Plans, err = stmtService.QueryPlans(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	story.SlowQueries, err = s.queryStorySamples(db, &req)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+
+	if s.params.NgmProxy.Available() {
+		story.TopSQL, err = queryStoryTopSQL(c.Request.Context(), s.params.NgmProxy.GetJSON, &req)
+		if err != nil {
+			story.Warnings = append(story.Warnings, fmt.Sprintf("failed to query Top SQL: %v", err))
+		}
+	} else {
+		story.Warnings = append(story.Warnings, "Top SQL is not available as NgMonitoring is not started")
+	}
+
+	summaryBinaryPlan := ""
+	if len(story.Plans) > 0 {
+		detail, err := stmtService.QueryPlanDetail(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest, req.plans())
+		if err != nil {
+			story.Warnings = append(story.Warnings, fmt.Sprintf("failed to query plan detail: %v", err))
+		}
+		summaryBinaryPlan = detail.AggBinaryPlan
+	}
+	story.setBinaryPlan(summaryBinaryPlan)
+
+	c.JSON(http.StatusOK, story)
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package slowquery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/topsql"
+)
+
+var _ = Suite(&testStorySuite{})
+
+type testStorySuite struct{}
+
+func (t *testStorySuite) Test_adjust(c *C) {
+	now := time.Unix(10000, 0)
+
+	req := GetStoryRequest{Digest: "d"}
+	req.adjust(now)
+	c.Assert(req.EndTime, Equals, 10000)
+	c.Assert(req.BeginTime, Equals, 10000-defaultStoryRangeSecs)
+	c.Assert(req.SamplesLimit, Equals, defaultStorySamplesLimit)
+	c.Assert(req.plans(), IsNil)
+
+	req = GetStoryRequest{Digest: "d", PlanDigest: "p", BeginTime: 100, EndTime: 200, SamplesLimit: 3}
+	req.adjust(now)
+	c.Assert(req.BeginTime, Equals, 100)
+	c.Assert(req.EndTime, Equals, 200)
+	c.Assert(req.SamplesLimit, Equals, 3)
+	c.Assert(req.plans(), DeepEquals, []string{"p"})
+
+	req = GetStoryRequest{Digest: "d", BeginTime: 300, EndTime: 200}
+	req.adjust(now)
+	c.Assert(req.BeginTime, Equals, 200-defaultStoryRangeSecs)
+}
+
+// fakeTopSQL serves the Top SQL summary of each instance, where the digests are ordered by CPU time.
+type fakeTopSQL struct {
+	digests map[string][]string
+	tops    []string
+}
+
+func (f *fakeTopSQL) getJSON(ctx context.Context, targetPath string, query url.Values, result interface{}) error {
+	var resp interface{}
+	switch targetPath {
+	case "/topsql/v1/instances":
+		instances := topsql.InstanceResponse{Data: []topsql.InstanceItem{}}
+		for instance := range f.digests {
+			instances.Data = append(instances.Data, topsql.InstanceItem{Instance: instance, InstanceType: "tidb"})
+		}
+		resp = instances
+	case "/topsql/v1/summary":
+		top, err := strconv.Atoi(query.Get("top"))
+		if err != nil {
+			return err
+		}
+		f.tops = append(f.tops, query.Get("top"))
+		summary := topsql.SummaryResponse{Data: []topsql.SummaryItem{}}
+		for i, digest := range f.digests[query.Get("instance")] {
+			if i == top {
+				summary.Data = append(summary.Data, topsql.SummaryItem{IsOther: true})
+				break
+			}
+			summary.Data = append(summary.Data, topsql.SummaryItem{
+				SQLDigest: digest,
+				Plans: []topsql.SummaryPlanItem{
+					{PlanDigest: "p1", CPUTimeMs: []uint64{1}},
+					{PlanDigest: "p2", CPUTimeMs: []uint64{2}},
+				},
+			})
+		}
+		resp = summary
+	default:
+		return fmt.Errorf("unexpected path %s", targetPath)
+	}
+	b, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, result)
+}
+
+func digests(prefix string, n int) []string {
+	r := make([]string, n)
+	for i := range r {
+		r[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return r
+}
+
+func (t *testStorySuite) Test_queryStoryTopSQL(c *C) {
+	req := &GetStoryRequest{Digest: "d", BeginTime: 0, EndTime: 3600}
+
+	// The digest is not in the top 100 digests.
+	f := &fakeTopSQL{digests: map[string][]string{"tidb-1": append(digests("x", 150), "d")}}
+	series, err := queryStoryTopSQL(context.Background(), f.getJSON, req)
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Instance, Equals, "tidb-1")
+	c.Assert(series[0].Plans, HasLen, 2)
+	c.Assert(f.tops, DeepEquals, []string{"100", "1000"})
+
+	// All digests are reported and the digest is not among them.
+	f = &fakeTopSQL{digests: map[string][]string{"tidb-1": digests("x", 10)}}
+	series, err = queryStoryTopSQL(context.Background(), f.getJSON, req)
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 0)
+	c.Assert(f.tops, DeepEquals, []string{"100"})
+
+	// Plans are filtered by the plan digest.
+	req.PlanDigest = "p2"
+	f = &fakeTopSQL{digests: map[string][]string{"tidb-1": {"d"}}}
+	series, err = queryStoryTopSQL(context.Background(), f.getJSON, req)
+	c.Assert(err, IsNil)
+	c.Assert(series, HasLen, 1)
+	c.Assert(series[0].Plans, HasLen, 1)
+	c.Assert(series[0].Plans[0].PlanDigest, Equals, "p2")
+}
+
+func (t *testStorySuite) Test_setBinaryPlan(c *C) {
+	story := Story{
+		SlowQueries: []Model{{BinaryPlan: "sample"}, {BinaryPlan: "other"}},
+		Warnings:    []string{},
+	}
+	// The sample is used when the summary has no binary plan, and binary plans of samples are not responded.
+	story.setBinaryPlan("")
+	c.Assert(story.SlowQueries[0].BinaryPlan, Equals, "")
+	c.Assert(story.SlowQueries[1].BinaryPlan, Equals, "")
+	c.Assert(story.Warnings, HasLen, 1)
+	c.Assert(story.BinaryPlan, IsNil)
+
+	story = Story{Warnings: []string{}}
+	story.setBinaryPlan("")
+	c.Assert(story.Warnings, HasLen, 0)
+	c.Assert(story.BinaryPlan, IsNil)
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Statements aggregated by TiDB can be aggregated again in the dashboard, following the `agg` tag of each Model
+// field. This is used to merge archived statements with statements still held by TiDB.
+
+type mergeKind int
+
+const (
+	mergeNone mergeKind = iota
+	mergeSum
+	mergeMax
+	mergeMin
+	mergeAvgByExecCount
+	mergeAvgByCopTaskNum
+	mergeAnyValue
+	mergePlanCount
+)
+
+type mergeField struct {
+	index int
+	kind  mergeKind
+}
+
+func mergeKindOf(agg string) mergeKind {
+	switch {
+	case agg == "":
+		return mergeNone
+	case strings.HasPrefix(agg, "CAST(") && strings.Contains(agg, "/ SUM(sum_cop_task_num)"):
+		return mergeAvgByCopTaskNum
+	case strings.HasPrefix(agg, "CAST("):
+		return mergeAvgByExecCount
+	case strings.HasPrefix(agg, "SUM("):
+		return mergeSum
+	case strings.HasPrefix(agg, "COUNT(DISTINCT plan_digest)"):
+		return mergePlanCount
+	case strings.HasPrefix(agg, "ANY_VALUE("):
+		return mergeAnyValue
+	case strings.Contains(agg, "MAX("):
+		return mergeMax
+	case strings.Contains(agg, "MIN("):
+		return mergeMin
+	default:
+		return mergeNone
+	}
+}
+
+var mergeFields = func() []mergeField {
+	t := reflect.TypeOf(Model{})
+	fields := make([]mergeField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if kind := mergeKindOf(t.Field(i).Tag.Get("agg")); kind != mergeNone {
+			fields = append(fields, mergeField{index: i, kind: kind})
+		}
+	}
+	return fields
+}()
+
+func numberOf(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint64:
+		return float64(v.Uint())
+	default:
+		return 0
+	}
+}
+
+func setNumber(v reflect.Value, n float64) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		v.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint64:
+		v.SetUint(uint64(n))
+	}
+}
+
+func weightedAvg(v1, w1, v2, w2 float64) float64 {
+	if w1+w2 == 0 {
+		return 0
+	}
+	return (v1*w1 + v2*w2) / (w1 + w2)
+}
+
+// statementAccumulator aggregates statements of the same group.
+type statementAccumulator struct {
+	model Model
+	plans map[string]struct{}
+	empty bool
+}
+
+func newStatementAccumulator() *statementAccumulator {
+	return &statementAccumulator{plans: make(map[string]struct{}), empty: true}
+}
+
+// add aggregates the statement executed with the given plans into the accumulator.
+func (a *statementAccumulator) add(m *Model, plans []string) {
+	for _, p := range plans {
+		a.plans[p] = struct{}{}
+	}
+	if a.empty {
+		a.model = *m
+		a.empty = false
+		a.updatePlanCount()
+		return
+	}
+
+	dst := reflect.ValueOf(&a.model).Elem()
+	src := reflect.ValueOf(m).Elem()
+	// Weights are read before exec_count and sum_cop_task_num are summed up.
+	execCount1, execCount2 := float64(a.model.AggExecCount), float64(m.AggExecCount)
+	copTaskNum1, copTaskNum2 := float64(a.model.AggSumCopTaskNum), float64(m.AggSumCopTaskNum)
+	for _, f := range mergeFields {
+		d, s := dst.Field(f.index), src.Field(f.index)
+		switch f.kind {
+		case mergeSum:
+			setNumber(d, numberOf(d)+numberOf(s))
+		case mergeMax:
+			if numberOf(s) > numberOf(d) {
+				setNumber(d, numberOf(s))
+			}
+		case mergeMin:
+			// Zero means the value is unknown, e.g. the column does not exist in the TiDB version.
+			if numberOf(d) == 0 || (numberOf(s) != 0 && numberOf(s) < numberOf(d)) {
+				setNumber(d, numberOf(s))
+			}
+		case mergeAvgByExecCount:
+			setNumber(d, weightedAvg(numberOf(d), execCount1, numberOf(s), execCount2))
+		case mergeAvgByCopTaskNum:
+			setNumber(d, weightedAvg(numberOf(d), copTaskNum1, numberOf(s), copTaskNum2))
+		case mergeAnyValue:
+			if d.String() == "" {
+				d.SetString(s.String())
+			}
+		}
+	}
+	a.updatePlanCount()
+}
+
+// updatePlanCount updates plan_count by distinct plans. It is kept unchanged if plans are unknown.
+func (a *statementAccumulator) updatePlanCount() {
+	if len(a.plans) > 0 {
+		a.model.AggPlanCount = len(a.plans)
+	}
+}
+
+func (a *statementAccumulator) result() Model {
+	m := a.model
+	m.RelatedSchemas = extractSchemasFromTableNames(m.AggTableNames)
+	return m
+}
+
+// sortBySumLatency sorts statements in the same order as queryStatements.
+func sortBySumLatency(models []Model) {
+	sort.SliceStable(models, func(i, j int) bool {
+		return models[i].AggSumLatency > models[j].AggSumLatency
+	})
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testAggregateSuite{})
+
+type testAggregateSuite struct{}
+
+func (t *testAggregateSuite) Test_mergeKindOf(c *C) {
+	c.Assert(mergeKindOf("SUM(exec_count)"), Equals, mergeSum)
+	c.Assert(mergeKindOf("MAX(max_latency)"), Equals, mergeMax)
+	c.Assert(mergeKindOf("MIN(min_latency)"), Equals, mergeMin)
+	c.Assert(mergeKindOf("FLOOR(UNIX_TIMESTAMP(MIN(summary_begin_time)))"), Equals, mergeMin)
+	c.Assert(mergeKindOf("UNIX_TIMESTAMP(MAX(last_seen))"), Equals, mergeMax)
+	c.Assert(mergeKindOf("CAST(SUM(exec_count * avg_latency) / SUM(exec_count) AS SIGNED)"), Equals, mergeAvgByExecCount)
+	c.Assert(mergeKindOf("CAST(SUM(exec_count * avg_wait_time) / SUM(sum_cop_task_num) AS SIGNED)"), Equals, mergeAvgByCopTaskNum)
+	c.Assert(mergeKindOf("ANY_VALUE(digest_text)"), Equals, mergeAnyValue)
+	c.Assert(mergeKindOf("COUNT(DISTINCT plan_digest)"), Equals, mergePlanCount)
+	c.Assert(mergeKindOf(""), Equals, mergeNone)
+}
+
+func (t *testAggregateSuite) Test_statementAccumulator(c *C) {
+	acc := newStatementAccumulator()
+	acc.add(&Model{
+		AggBeginTime:                 100,
+		AggEndTime:                   200,
+		AggExecCount:                 1,
+		AggSumLatency:                10,
+		AggMaxLatency:                10,
+		AggMinLatency:                10,
+		AggAvgLatency:                10,
+		AggDigestText:                "select ?",
+		AggTableNames:                "test.t",
+		AggAvgRocksdbKeySkippedCount: 4,
+	}, []string{"p1"})
+	acc.add(&Model{
+		AggBeginTime:                 200,
+		AggEndTime:                   300,
+		AggExecCount:                 3,
+		AggSumLatency:                60,
+		AggMaxLatency:                30,
+		AggMinLatency:                5,
+		AggAvgLatency:                20,
+		AggDigestText:                "select ? other",
+		AggAvgRocksdbKeySkippedCount: 8,
+	}, []string{"p1", "p2"})
+
+	m := acc.result()
+	c.Assert(m.AggBeginTime, Equals, 100)
+	c.Assert(m.AggEndTime, Equals, 300)
+	c.Assert(m.AggExecCount, Equals, 4)
+	c.Assert(m.AggSumLatency, Equals, 70)
+	c.Assert(m.AggMaxLatency, Equals, 30)
+	c.Assert(m.AggMinLatency, Equals, 5)
+	c.Assert(m.AggAvgLatency, Equals, 17)
+	c.Assert(m.AggAvgRocksdbKeySkippedCount, Equals, uint(7))
+	c.Assert(m.AggDigestText, Equals, "select ?")
+	c.Assert(m.AggPlanCount, Equals, 2)
+	c.Assert(m.RelatedSchemas, Equals, "test")
+}
+
+func (t *testAggregateSuite) Test_downsample(c *C) {
+	rows := []ArchivedStatementModel{
+		{BeginTime: 3600, EndTime: 5400, Digest: "d", PlanDigest: "p", Data: ArchivedStatementData{AggBeginTime: 3600, AggEndTime: 5400, AggExecCount: 1}},
+		{BeginTime: 5400, EndTime: 7200, Digest: "d", PlanDigest: "p", Data: ArchivedStatementData{AggBeginTime: 5400, AggEndTime: 7200, AggExecCount: 2}},
+		{BeginTime: 5400, EndTime: 7200, Digest: "d", PlanDigest: "q", Data: ArchivedStatementData{AggBeginTime: 5400, AggEndTime: 7200, AggExecCount: 4}},
+		{BeginTime: 7200, EndTime: 9000, Digest: "d", PlanDigest: "p", Data: ArchivedStatementData{AggBeginTime: 7200, AggEndTime: 9000, AggExecCount: 8}},
+	}
+	result := downsample(rows, 3600)
+	c.Assert(result, HasLen, 3)
+
+	c.Assert(result[0].BeginTime, Equals, 3600)
+	c.Assert(result[0].EndTime, Equals, 7200)
+	c.Assert(result[0].PlanDigest, Equals, "p")
+	c.Assert(result[0].Data.AggExecCount, Equals, 3)
+	c.Assert(result[0].Data.AggBeginTime, Equals, 3600)
+	c.Assert(result[0].Data.AggEndTime, Equals, 7200)
+
+	c.Assert(result[1].PlanDigest, Equals, "q")
+	c.Assert(result[1].Data.AggExecCount, Equals, 4)
+
+	c.Assert(result[2].BeginTime, Equals, 7200)
+	c.Assert(result[2].Data.AggExecCount, Equals, 8)
+}
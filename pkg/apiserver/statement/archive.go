@@ -0,0 +1,649 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+// The archiver copies closed statement summary windows from TiDB to the local storage, so that statements can be
+// queried after TiDB evicts them from CLUSTER_STATEMENTS_SUMMARY_HISTORY.
+
+const archiveInterval = 5 * time.Minute
+
+// ArchivedStatementData is a statement aggregated in a window, stored as JSON.
+type ArchivedStatementData Model
+
+func (d *ArchivedStatementData) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	}
+	return nil
+}
+
+func (d ArchivedStatementData) Value() (driver.Value, error) {
+	val, err := json.Marshal(d)
+	return string(val), err
+}
+
+// ArchivedStatementModel is a statement with a plan in a summary window. Windows are merged when downsampling.
+type ArchivedStatementModel struct {
+	ID         uint                  `gorm:"primary_key"`
+	BeginTime  int                   `gorm:"index"`
+	EndTime    int                   `gorm:"index"`
+	SchemaName string                `gorm:"size:64"`
+	Digest     string                `gorm:"size:64;index"`
+	PlanDigest string                `gorm:"size:64"`
+	StmtType   string                `gorm:"size:32"`
+	Data       ArchivedStatementData `gorm:"type:text"`
+}
+
+func (ArchivedStatementModel) TableName() string {
+	return "statement_archive"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&ArchivedStatementModel{})
+}
+
+// archivedWindow is a statement with a plan in a window queried from TiDB.
+type archivedWindow struct {
+	Model
+	StmtType string `gorm:"column:stmt_type"`
+}
+
+func (w *archivedWindow) toArchivedStatement() ArchivedStatementModel {
+	return ArchivedStatementModel{
+		BeginTime:  w.AggBeginTime,
+		EndTime:    w.AggEndTime,
+		SchemaName: w.AggSchemaName,
+		Digest:     w.AggDigest,
+		PlanDigest: w.AggPlanDigest,
+		StmtType:   w.StmtType,
+		Data:       ArchivedStatementData(w.Model),
+	}
+}
+
+type ArchiveStatus struct {
+	Enabled bool `json:"enabled"`
+	// LastArchivedTime is the end time of the latest archived window, 0 if nothing is archived.
+	LastArchivedTime int    `json:"last_archived_time"`
+	OldestTime       int    `json:"oldest_time"`
+	Rows             int64  `json:"rows"`
+	LastError        string `json:"last_error"`
+}
+
+type archiver struct {
+	mu        sync.Mutex
+	lastError string
+}
+
+func (a *archiver) setError(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil {
+		a.lastError = ""
+	} else {
+		a.lastError = err.Error()
+	}
+}
+
+func (a *archiver) getError() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastError
+}
+
+func (s *Service) archiveLoop(ctx context.Context) {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			dc, err := s.params.ConfigManager.Get()
+			if err != nil {
+				// Dynamic config is not ready yet, try in the next round.
+				continue
+			}
+			if dc.StatementArchive.Enable {
+				err := s.archive(&dc.StatementArchive)
+				if err != nil {
+					log.Warn("Failed to archive statements", zap.Error(err))
+				}
+				s.archiver.setError(err)
+			}
+			if err := s.compactArchive(&dc.StatementArchive, time.Now()); err != nil {
+				log.Warn("Failed to compact archived statements", zap.Error(err))
+			}
+		}
+	}
+}
+
+// checkArchiveCredential returns an error if the credential source of the archive is not available.
+func (s *Service) checkArchiveCredential(cfg *config.StatementArchiveConfig) error {
+	switch cfg.CredentialSource {
+	case config.StatementArchiveCredentialSSOImpersonation:
+		return s.params.SSOService.CheckImpersonation()
+	default:
+		return ErrArchiveNoCredential.New("no credential source is configured")
+	}
+}
+
+// openArchiveSQLConn opens a SQL connection with the credential source of the archive.
+func (s *Service) openArchiveSQLConn(cfg *config.StatementArchiveConfig) (*gorm.DB, error) {
+	switch cfg.CredentialSource {
+	case config.StatementArchiveCredentialSSOImpersonation:
+		return s.params.SSOService.OpenImpersonatedSQLConn()
+	default:
+		return nil, ErrArchiveNoCredential.New("no credential source is configured")
+	}
+}
+
+// archive copies windows closed after the latest archived window from TiDB.
+func (s *Service) archive(cfg *config.StatementArchiveConfig) error {
+	db, err := s.openArchiveSQLConn(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = utils.CloseTiDBConnection(db)
+	}()
+
+	var lastArchivedTime int
+	err = s.params.LocalStore.
+		Model(&ArchivedStatementModel{}).
+		Select("IFNULL(MAX(end_time), 0)").
+		Scan(&lastArchivedTime).Error
+	if err != nil {
+		return err
+	}
+
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	if err != nil {
+		return err
+	}
+	selectStmt, err := s.genSelectStmt(tableColumns, []string{"*"})
+	if err != nil {
+		return err
+	}
+	var windows []archivedWindow
+	err = db.
+		Select(selectStmt+", ANY_VALUE(stmt_type) AS stmt_type").
+		Table(statementsTable).
+		Where("summary_end_time > FROM_UNIXTIME(?) AND summary_end_time <= NOW()", lastArchivedTime).
+		Group("summary_begin_time, summary_end_time, schema_name, digest, plan_digest").
+		Find(&windows).Error
+	if err != nil {
+		return err
+	}
+	if len(windows) == 0 {
+		return nil
+	}
+
+	rows := make([]ArchivedStatementModel, 0, len(windows))
+	for i := range windows {
+		rows = append(rows, windows[i].toArchivedStatement())
+	}
+	if err := s.params.LocalStore.CreateInBatches(rows, 100).Error; err != nil {
+		return err
+	}
+	log.Debug("Statements are archived", zap.Int("rows", len(rows)))
+	return nil
+}
+
+type archiveGroupKey struct {
+	bucket     int
+	schemaName string
+	digest     string
+	planDigest string
+	stmtType   string
+}
+
+// downsample merges rows of the same statement and plan in each bucket of windowSecs. The time range of a merged row
+// is the bucket, so that it is not downsampled again.
+func downsample(rows []ArchivedStatementModel, windowSecs int) []ArchivedStatementModel {
+	groups := make(map[archiveGroupKey]*statementAccumulator)
+	keys := make([]archiveGroupKey, 0)
+	for i := range rows {
+		r := &rows[i]
+		key := archiveGroupKey{
+			bucket:     r.BeginTime - r.BeginTime%windowSecs,
+			schemaName: r.SchemaName,
+			digest:     r.Digest,
+			planDigest: r.PlanDigest,
+			stmtType:   r.StmtType,
+		}
+		acc, ok := groups[key]
+		if !ok {
+			acc = newStatementAccumulator()
+			groups[key] = acc
+			keys = append(keys, key)
+		}
+		m := Model(r.Data)
+		acc.add(&m, []string{r.PlanDigest})
+	}
+
+	result := make([]ArchivedStatementModel, 0, len(keys))
+	for _, key := range keys {
+		m := groups[key].result()
+		result = append(result, ArchivedStatementModel{
+			BeginTime:  key.bucket,
+			EndTime:    key.bucket + windowSecs,
+			SchemaName: key.schemaName,
+			Digest:     key.digest,
+			PlanDigest: key.planDigest,
+			StmtType:   key.stmtType,
+			Data:       ArchivedStatementData(m),
+		})
+	}
+	return result
+}
+
+// compactArchive deletes expired rows and downsamples old rows.
+func (s *Service) compactArchive(cfg *config.StatementArchiveConfig, now time.Time) error {
+	expireBefore := now.Add(-time.Duration(cfg.RetentionDays) * 24 * time.Hour).Unix()
+	if err := s.params.LocalStore.Where("end_time < ?", expireBefore).Delete(&ArchivedStatementModel{}).Error; err != nil {
+		return err
+	}
+
+	windowSecs := int(cfg.DownsampleWindowMins) * 60
+	downsampleBefore := int(now.Add(-time.Duration(cfg.DownsampleAfterHours) * time.Hour).Unix())
+	// Only rows in complete buckets are downsampled, and rows already downsampled are skipped.
+	downsampleBefore -= downsampleBefore % windowSecs
+	return s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		var rows []ArchivedStatementModel
+		err := tx.
+			Where("end_time <= ? AND end_time - begin_time < ?", downsampleBefore, windowSecs).
+			Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		merged := downsample(rows, windowSecs)
+		err = tx.
+			Where("end_time <= ? AND end_time - begin_time < ?", downsampleBefore, windowSecs).
+			Delete(&ArchivedStatementModel{}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.CreateInBatches(merged, 100).Error; err != nil {
+			return err
+		}
+		log.Debug("Archived statements are downsampled", zap.Int("from", len(rows)), zap.Int("to", len(merged)))
+		return nil
+	})
+}
+
+// archivedRowsFilter filters archived rows in the same way as applyStatementFilters.
+type archivedRowsFilter struct {
+	schemas   *regexp.Regexp
+	stmtTypes map[string]struct{}
+	texts     []*regexp.Regexp
+}
+
+func newArchivedRowsFilter(schemas, stmtTypes []string, text string) (*archivedRowsFilter, error) {
+	f := &archivedRowsFilter{}
+	if len(schemas) > 0 {
+		regex := make([]string, 0, len(schemas))
+		for _, schema := range schemas {
+			regex = append(regex, `\b`+regexp.QuoteMeta(schema)+`\.`)
+		}
+		re, err := regexp.Compile(strings.Join(regex, "|"))
+		if err != nil {
+			return nil, err
+		}
+		f.schemas = re
+	}
+	if len(stmtTypes) > 0 {
+		f.stmtTypes = make(map[string]struct{}, len(stmtTypes))
+		for _, t := range stmtTypes {
+			f.stmtTypes[strings.ToLower(t)] = struct{}{}
+		}
+	}
+	for _, v := range strings.Fields(strings.ToLower(text)) {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, rest.ErrBadRequest.Wrap(err, "invalid text pattern")
+		}
+		f.texts = append(f.texts, re)
+	}
+	return f, nil
+}
+
+func (f *archivedRowsFilter) match(r *ArchivedStatementModel) bool {
+	if f.schemas != nil && !f.schemas.MatchString(r.Data.AggTableNames) {
+		return false
+	}
+	if f.stmtTypes != nil {
+		if _, ok := f.stmtTypes[strings.ToLower(r.StmtType)]; !ok {
+			return false
+		}
+	}
+	for _, re := range f.texts {
+		if !re.MatchString(strings.ToLower(r.Data.AggDigestText)) &&
+			!re.MatchString(strings.ToLower(r.Digest)) &&
+			!re.MatchString(strings.ToLower(r.SchemaName)) &&
+			!re.MatchString(strings.ToLower(r.Data.AggTableNames)) {
+			return false
+		}
+	}
+	return true
+}
+
+// queryArchivedStatements returns archived rows overlapping the time range which are no longer held by TiDB.
+func (s *Service) queryArchivedStatements(db *gorm.DB, beginTime, endTime int, query func(*gorm.DB) *gorm.DB) ([]ArchivedStatementModel, error) {
+	var count int64
+	err := s.params.LocalStore.
+		Model(&ArchivedStatementModel{}).
+		Where("begin_time <= ? AND end_time >= ?", endTime, beginTime).
+		Count(&count).Error
+	if err != nil || count == 0 {
+		return nil, err
+	}
+
+	var liveBeginTime int
+	err = db.
+		Table(statementsTable).
+		Select("IFNULL(FLOOR(UNIX_TIMESTAMP(MIN(summary_begin_time))), 0)").
+		Scan(&liveBeginTime).Error
+	if err != nil {
+		return nil, err
+	}
+	if liveBeginTime != 0 && beginTime >= liveBeginTime {
+		return nil, nil
+	}
+
+	return queryArchivedRowsBefore(s.params.LocalStore, beginTime, endTime, liveBeginTime, query)
+}
+
+// queryArchivedRowsBefore returns archived rows in the time range which end before liveBeginTime, the begin time of
+// the oldest window still held by TiDB. A downsampled row that extends past liveBeginTime is skipped, otherwise its
+// windows would be counted again by the live data.
+func queryArchivedRowsBefore(
+	store *dbstore.DB,
+	beginTime, endTime, liveBeginTime int,
+	query func(*gorm.DB) *gorm.DB,
+) ([]ArchivedStatementModel, error) {
+	archiveQuery := store.Where("begin_time <= ? AND end_time >= ?", endTime, beginTime)
+	if liveBeginTime != 0 {
+		archiveQuery = archiveQuery.Where("end_time <= ?", liveBeginTime)
+	}
+	var rows []ArchivedStatementModel
+	if err := query(archiveQuery).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// queryMatchedArchivedStatements returns archived rows no longer held by TiDB that match the filters of the list.
+func (s *Service) queryMatchedArchivedStatements(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemas, stmtTypes []string,
+	text string,
+) ([]ArchivedStatementModel, error) {
+	rowsFilter, err := newArchivedRowsFilter(schemas, stmtTypes, text)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.queryArchivedStatements(db, beginTime, endTime, func(query *gorm.DB) *gorm.DB {
+		return query
+	})
+	if err != nil {
+		return nil, err
+	}
+	matched := make([]ArchivedStatementModel, 0, len(rows))
+	for i := range rows {
+		if rowsFilter.match(&rows[i]) {
+			matched = append(matched, rows[i])
+		}
+	}
+	return matched, nil
+}
+
+// mergeArchivedStatements merges archived rows into statements queried from TiDB.
+func (s *Service) mergeArchivedStatements(
+	db *gorm.DB,
+	live []Model,
+	rows []ArchivedStatementModel,
+	beginTime, endTime int,
+	schemas, stmtTypes []string,
+	filter *filterexpr.Filter,
+) ([]Model, error) {
+	if len(rows) == 0 {
+		return live, nil
+	}
+	livePlans, err := queryPlanDigests(db, beginTime, endTime, schemas, stmtTypes)
+	if err != nil {
+		return nil, err
+	}
+	return mergeStatements(live, livePlans, rows, filter), nil
+}
+
+// mergeStatements merges archived rows into live statements. The filter expression is evaluated against merged
+// statements, so live statements must not be filtered by it in advance, otherwise archived rows of a statement
+// filtered out would be merged into a partial statement.
+func mergeStatements(
+	live []Model,
+	livePlans map[statementKey][]string,
+	rows []ArchivedStatementModel,
+	filter *filterexpr.Filter,
+) []Model {
+	groups := make(map[statementKey]*statementAccumulator)
+	keys := make([]statementKey, 0)
+	accumulatorOf := func(key statementKey) *statementAccumulator {
+		acc, ok := groups[key]
+		if !ok {
+			acc = newStatementAccumulator()
+			groups[key] = acc
+			keys = append(keys, key)
+		}
+		return acc
+	}
+	for i := range live {
+		key := statementKey{schemaName: live[i].AggSchemaName, digest: live[i].AggDigest}
+		accumulatorOf(key).add(&live[i], livePlans[key])
+	}
+	for i := range rows {
+		m := Model(rows[i].Data)
+		accumulatorOf(statementKey{schemaName: rows[i].SchemaName, digest: rows[i].Digest}).add(&m, []string{rows[i].PlanDigest})
+	}
+
+	result := make([]Model, 0, len(keys))
+	for _, key := range keys {
+		m := groups[key].result()
+		if filter != nil && !filter.Match(&m) {
+			continue
+		}
+		result = append(result, m)
+	}
+	sortBySumLatency(result)
+	return result
+}
+
+func archivedDigestFilter(schemaName, digest string) func(*gorm.DB) *gorm.DB {
+	return func(query *gorm.DB) *gorm.DB {
+		// The digest of evicted statements is archived as an empty string.
+		query = query.Where("digest = ?", digest)
+		if digest != "" && schemaName != "" {
+			query = query.Where("schema_name = ?", schemaName)
+		}
+		return query
+	}
+}
+
+// mergeArchivedPlans merges archived plans of the statement no longer held by TiDB into plans queried from TiDB.
+func (s *Service) mergeArchivedPlans(
+	db *gorm.DB,
+	live []Model,
+	beginTime, endTime int,
+	schemaName, digest string,
+) ([]Model, error) {
+	rows, err := s.queryArchivedStatements(db, beginTime, endTime, archivedDigestFilter(schemaName, digest))
+	if err != nil || len(rows) == 0 {
+		return live, err
+	}
+
+	groups := make(map[string]*statementAccumulator)
+	planDigests := make([]string, 0)
+	accumulatorOf := func(planDigest string) *statementAccumulator {
+		acc, ok := groups[planDigest]
+		if !ok {
+			acc = newStatementAccumulator()
+			groups[planDigest] = acc
+			planDigests = append(planDigests, planDigest)
+		}
+		return acc
+	}
+	for i := range live {
+		accumulatorOf(live[i].AggPlanDigest).add(&live[i], nil)
+	}
+	for i := range rows {
+		m := Model(rows[i].Data)
+		accumulatorOf(rows[i].PlanDigest).add(&m, nil)
+	}
+
+	result := make([]Model, 0, len(planDigests))
+	for _, planDigest := range planDigests {
+		result = append(result, groups[planDigest].result())
+	}
+	return result, nil
+}
+
+// mergeArchivedPlanDetail merges archived details of the statement no longer held by TiDB into the detail queried
+// from TiDB.
+func (s *Service) mergeArchivedPlanDetail(
+	db *gorm.DB,
+	live Model,
+	beginTime, endTime int,
+	schemaName, digest string,
+	plans []string,
+) (Model, error) {
+	digestFilter := archivedDigestFilter(schemaName, digest)
+	rows, err := s.queryArchivedStatements(db, beginTime, endTime, func(query *gorm.DB) *gorm.DB {
+		query = digestFilter(query)
+		if digest != "" && len(plans) > 0 {
+			query = query.Where("plan_digest IN ?", plans)
+		}
+		return query
+	})
+	if err != nil || len(rows) == 0 {
+		return live, err
+	}
+
+	acc := newStatementAccumulator()
+	if live.AggExecCount > 0 {
+		acc.add(&live, nil)
+	}
+	for i := range rows {
+		m := Model(rows[i].Data)
+		acc.add(&m, []string{rows[i].PlanDigest})
+	}
+	return acc.result(), nil
+}
+
+// @Summary Get statement archive configurations
+// @Success 200 {object} config.StatementArchiveConfig
+// @Router /statements/archive/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) archiveConfigHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.StatementArchive)
+}
+
+// @Summary Update statement archive configurations
+// @Description The archiver connects to TiDB with the SQL credential of credential_source, which must be available when enabling the archive.
+// @Param request body config.StatementArchiveConfig true "Request body"
+// @Success 200 {object} config.StatementArchiveConfig
+// @Router /statements/archive/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) modifyArchiveConfigHandler(c *gin.Context) {
+	var req config.StatementArchiveConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if req.Enable {
+		if err := s.checkArchiveCredential(&req); err != nil {
+			rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+			return
+		}
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.StatementArchive)
+		dc.StatementArchive = req
+		audit.SetAfter(c, dc.StatementArchive)
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// @Summary Get statement archive status
+// @Success 200 {object} ArchiveStatus
+// @Router /statements/archive/status [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) archiveStatusHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	status := ArchiveStatus{
+		Enabled:   dc.StatementArchive.Enable,
+		LastError: s.archiver.getError(),
+	}
+	var stats struct {
+		RowCount int64
+		Oldest   int
+		Latest   int
+	}
+	err = s.params.LocalStore.
+		Model(&ArchivedStatementModel{}).
+		Select("COUNT(*) AS row_count, IFNULL(MIN(begin_time), 0) AS oldest, IFNULL(MAX(end_time), 0) AS latest").
+		Scan(&stats).Error
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	status.Rows = stats.RowCount
+	status.OldestTime = stats.Oldest
+	status.LastArchivedTime = stats.Latest
+	c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	"path"
+
+	. "github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var _ = Suite(&testArchiveSuite{})
+
+type testArchiveSuite struct{}
+
+func (t *testArchiveSuite) Test_queryArchivedRowsBefore(c *C) {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	db := &dbstore.DB{DB: gormDB}
+	c.Assert(autoMigrate(db), IsNil)
+
+	// TiDB holds windows since 3600. The second downsampled row straddles the boundary, so its windows after 3600
+	// are also in the live data.
+	rows := []*ArchivedStatementModel{
+		{BeginTime: 0, EndTime: 1800, Digest: "before"},
+		{BeginTime: 1800, EndTime: 3600, Digest: "until_boundary"},
+		{BeginTime: 3000, EndTime: 4200, Digest: "straddle"},
+		{BeginTime: 3600, EndTime: 4500, Digest: "after"},
+	}
+	c.Assert(db.Create(rows).Error, IsNil)
+
+	noop := func(query *gorm.DB) *gorm.DB { return query }
+	digestsOf := func(rows []ArchivedStatementModel) []string {
+		r := make([]string, 0, len(rows))
+		for _, row := range rows {
+			r = append(r, row.Digest)
+		}
+		return r
+	}
+
+	result, err := queryArchivedRowsBefore(db, 0, 5000, 3600, noop)
+	c.Assert(err, IsNil)
+	c.Assert(digestsOf(result), DeepEquals, []string{"before", "until_boundary"})
+
+	// Nothing is held by TiDB.
+	result, err = queryArchivedRowsBefore(db, 2000, 5000, 0, noop)
+	c.Assert(err, IsNil)
+	c.Assert(digestsOf(result), DeepEquals, []string{"until_boundary", "straddle", "after"})
+}
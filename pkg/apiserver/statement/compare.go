@@ -0,0 +1,288 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+const (
+	StatementChangeNew      = "new"
+	StatementChangeVanished = "vanished"
+	StatementChangeChanged  = "changed"
+
+	defaultLatencyThreshold       = 0.2
+	defaultExecCountThreshold     = 0.5
+	defaultProcessedKeysThreshold = 0.5
+	defaultMemThreshold           = 0.5
+)
+
+// compareFields are fields queried for each window of the comparison.
+var compareFields = []string{
+	"schema_name", "digest", "digest_text",
+	"exec_count", "avg_latency", "avg_processed_keys", "avg_mem", "plan_count",
+}
+
+type statementKey struct {
+	schemaName string
+	digest     string
+}
+
+type CompareStatementsRequest struct {
+	BaseBeginTime int      `json:"base_begin_time" form:"base_begin_time" binding:"required"`
+	BaseEndTime   int      `json:"base_end_time" form:"base_end_time" binding:"required"`
+	BeginTime     int      `json:"begin_time" form:"begin_time" binding:"required"`
+	EndTime       int      `json:"end_time" form:"end_time" binding:"required"`
+	Schemas       []string `json:"schemas" form:"schemas"`
+	StmtTypes     []string `json:"stmt_types" form:"stmt_types"`
+	// MinExecCount ignores statements executed less than the given times in both windows.
+	MinExecCount int `json:"min_exec_count" form:"min_exec_count"`
+	// Thresholds are relative changes, e.g. 0.2 reports changes over 20% in either direction. 0 uses the default value.
+	LatencyThreshold       float64 `json:"latency_threshold" form:"latency_threshold"`
+	ExecCountThreshold     float64 `json:"exec_count_threshold" form:"exec_count_threshold"`
+	ProcessedKeysThreshold float64 `json:"processed_keys_threshold" form:"processed_keys_threshold"`
+	MemThreshold           float64 `json:"mem_threshold" form:"mem_threshold"`
+}
+
+func (r *CompareStatementsRequest) adjust() {
+	if r.LatencyThreshold <= 0 {
+		r.LatencyThreshold = defaultLatencyThreshold
+	}
+	if r.ExecCountThreshold <= 0 {
+		r.ExecCountThreshold = defaultExecCountThreshold
+	}
+	if r.ProcessedKeysThreshold <= 0 {
+		r.ProcessedKeysThreshold = defaultProcessedKeysThreshold
+	}
+	if r.MemThreshold <= 0 {
+		r.MemThreshold = defaultMemThreshold
+	}
+}
+
+type StatementWindowStats struct {
+	ExecCount        int `json:"exec_count"`
+	AvgLatency       int `json:"avg_latency"`
+	AvgProcessedKeys int `json:"avg_processed_keys"`
+	AvgMem           int `json:"avg_mem"`
+	PlanCount        int `json:"plan_count"`
+}
+
+type MetricChange struct {
+	Metric string `json:"metric"`
+	Base   int    `json:"base"`
+	Value  int    `json:"value"`
+	// Ratio is the relative change to the base value, e.g. 1.5 means 150% higher.
+	Ratio float64 `json:"ratio"`
+}
+
+type StatementChange struct {
+	SchemaName string `json:"schema_name"`
+	Digest     string `json:"digest"`
+	DigestText string `json:"digest_text"`
+	// Kind is one of StatementChangeNew, StatementChangeVanished and StatementChangeChanged.
+	Kind    string                `json:"kind"`
+	Base    *StatementWindowStats `json:"base"`
+	Current *StatementWindowStats `json:"current"`
+	Changes []MetricChange        `json:"changes"`
+	// NewPlans and VanishedPlans are plan digests only appearing in the current and the base window respectively.
+	NewPlans      []string `json:"new_plans"`
+	VanishedPlans []string `json:"vanished_plans"`
+	// SumLatencyDelta is the change of the total latency, which is used to sort changes.
+	SumLatencyDelta int `json:"sum_latency_delta"`
+}
+
+func newStatementWindowStats(m *Model) *StatementWindowStats {
+	return &StatementWindowStats{
+		ExecCount:        m.AggExecCount,
+		AvgLatency:       m.AggAvgLatency,
+		AvgProcessedKeys: m.AggAvgProcessedKeys,
+		AvgMem:           m.AggAvgMem,
+		PlanCount:        m.AggPlanCount,
+	}
+}
+
+func (s *StatementWindowStats) sumLatency() int {
+	if s == nil {
+		return 0
+	}
+	return s.ExecCount * s.AvgLatency
+}
+
+// planDiff returns elements only in plans and elements only in basePlans.
+func planDiff(basePlans, plans []string) (added, removed []string) {
+	baseSet := make(map[string]struct{}, len(basePlans))
+	for _, p := range basePlans {
+		baseSet[p] = struct{}{}
+	}
+	set := make(map[string]struct{}, len(plans))
+	for _, p := range plans {
+		set[p] = struct{}{}
+		if _, ok := baseSet[p]; !ok {
+			added = append(added, p)
+		}
+	}
+	for _, p := range basePlans {
+		if _, ok := set[p]; !ok {
+			removed = append(removed, p)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
+}
+
+func metricChange(metric string, base, value int, threshold float64) (MetricChange, bool) {
+	if base <= 0 {
+		return MetricChange{}, false
+	}
+	ratio := float64(value-base) / float64(base)
+	if ratio < threshold && ratio > -threshold {
+		return MetricChange{}, false
+	}
+	return MetricChange{Metric: metric, Base: base, Value: value, Ratio: ratio}, true
+}
+
+// compareStatements returns statements which are new, vanished, or changed beyond thresholds in the current window,
+// sorted by the change of total latency, the most regressed one first.
+func compareStatements(
+	base, current []Model,
+	basePlans, currentPlans map[statementKey][]string,
+	req *CompareStatementsRequest,
+) []StatementChange {
+	baseMap := make(map[statementKey]*Model, len(base))
+	for i := range base {
+		baseMap[statementKey{schemaName: base[i].AggSchemaName, digest: base[i].AggDigest}] = &base[i]
+	}
+
+	result := make([]StatementChange, 0)
+	seen := make(map[statementKey]struct{}, len(current))
+	for i := range current {
+		m := &current[i]
+		key := statementKey{schemaName: m.AggSchemaName, digest: m.AggDigest}
+		seen[key] = struct{}{}
+		b, ok := baseMap[key]
+		if !ok {
+			if m.AggExecCount < req.MinExecCount {
+				continue
+			}
+			result = append(result, StatementChange{
+				SchemaName: m.AggSchemaName,
+				Digest:     m.AggDigest,
+				DigestText: m.AggDigestText,
+				Kind:       StatementChangeNew,
+				Current:    newStatementWindowStats(m),
+				NewPlans:   currentPlans[key],
+			})
+			continue
+		}
+		if m.AggExecCount < req.MinExecCount && b.AggExecCount < req.MinExecCount {
+			continue
+		}
+
+		change := StatementChange{
+			SchemaName: m.AggSchemaName,
+			Digest:     m.AggDigest,
+			DigestText: m.AggDigestText,
+			Kind:       StatementChangeChanged,
+			Base:       newStatementWindowStats(b),
+			Current:    newStatementWindowStats(m),
+		}
+		for _, c := range []struct {
+			metric      string
+			base, value int
+			threshold   float64
+		}{
+			{"avg_latency", b.AggAvgLatency, m.AggAvgLatency, req.LatencyThreshold},
+			{"exec_count", b.AggExecCount, m.AggExecCount, req.ExecCountThreshold},
+			{"avg_processed_keys", b.AggAvgProcessedKeys, m.AggAvgProcessedKeys, req.ProcessedKeysThreshold},
+			{"avg_mem", b.AggAvgMem, m.AggAvgMem, req.MemThreshold},
+		} {
+			if mc, ok := metricChange(c.metric, c.base, c.value, c.threshold); ok {
+				change.Changes = append(change.Changes, mc)
+			}
+		}
+		if b.AggPlanCount != m.AggPlanCount {
+			mc := MetricChange{Metric: "plan_count", Base: b.AggPlanCount, Value: m.AggPlanCount}
+			if b.AggPlanCount > 0 {
+				mc.Ratio = float64(m.AggPlanCount-b.AggPlanCount) / float64(b.AggPlanCount)
+			}
+			change.Changes = append(change.Changes, mc)
+		}
+		change.NewPlans, change.VanishedPlans = planDiff(basePlans[key], currentPlans[key])
+		if len(change.Changes) == 0 && len(change.NewPlans) == 0 && len(change.VanishedPlans) == 0 {
+			continue
+		}
+		result = append(result, change)
+	}
+
+	for i := range base {
+		b := &base[i]
+		key := statementKey{schemaName: b.AggSchemaName, digest: b.AggDigest}
+		if _, ok := seen[key]; ok || b.AggExecCount < req.MinExecCount {
+			continue
+		}
+		result = append(result, StatementChange{
+			SchemaName:    b.AggSchemaName,
+			Digest:        b.AggDigest,
+			DigestText:    b.AggDigestText,
+			Kind:          StatementChangeVanished,
+			Base:          newStatementWindowStats(b),
+			VanishedPlans: basePlans[key],
+		})
+	}
+
+	for i := range result {
+		result[i].SumLatencyDelta = result[i].Current.sumLatency() - result[i].Base.sumLatency()
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].SumLatencyDelta > result[j].SumLatencyDelta
+	})
+	return result
+}
+
+// @Summary Compare statements between two time windows
+// @Description Report statements which are new, vanished, or changed beyond thresholds compared to the base window
+// @Param q query CompareStatementsRequest true "Query"
+// @Success 200 {array} StatementChange
+// @Router /statements/compare [get]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+func (s *Service) compareHandler(c *gin.Context) {
+	var req CompareStatementsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+	req.adjust()
+	db := utils.GetTiDBConnection(c)
+
+	base, err := s.queryStatements(db, req.BaseBeginTime, req.BaseEndTime, req.Schemas, req.StmtTypes, "", compareFields, nil)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	current, err := s.queryStatements(db, req.BeginTime, req.EndTime, req.Schemas, req.StmtTypes, "", compareFields, nil)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	basePlans, err := queryPlanDigests(db, req.BaseBeginTime, req.BaseEndTime, req.Schemas, req.StmtTypes)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	currentPlans, err := queryPlanDigests(db, req.BeginTime, req.EndTime, req.Schemas, req.StmtTypes)
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, compareStatements(base, current, basePlans, currentPlans, &req))
+}
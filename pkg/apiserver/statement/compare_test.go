@@ -0,0 +1,70 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testCompareSuite{})
+
+type testCompareSuite struct{}
+
+func (t *testCompareSuite) Test_compareStatements(c *C) {
+	base := []Model{
+		{AggSchemaName: "test", AggDigest: "slower", AggExecCount: 100, AggAvgLatency: 10, AggAvgMem: 100, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "stable", AggExecCount: 100, AggAvgLatency: 10, AggAvgMem: 100, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "replan", AggExecCount: 100, AggAvgLatency: 10, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "vanished", AggExecCount: 10, AggAvgLatency: 10, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "rare", AggExecCount: 1, AggAvgLatency: 10, AggPlanCount: 1},
+	}
+	current := []Model{
+		{AggSchemaName: "test", AggDigest: "slower", AggExecCount: 100, AggAvgLatency: 30, AggAvgMem: 110, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "stable", AggExecCount: 110, AggAvgLatency: 11, AggAvgMem: 90, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "replan", AggExecCount: 100, AggAvgLatency: 10, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "new", AggExecCount: 5, AggAvgLatency: 10, AggPlanCount: 1},
+		{AggSchemaName: "test", AggDigest: "rare", AggExecCount: 2, AggAvgLatency: 100, AggPlanCount: 1},
+	}
+	basePlans := map[statementKey][]string{
+		{schemaName: "test", digest: "replan"}:   {"p1"},
+		{schemaName: "test", digest: "vanished"}: {"p2"},
+	}
+	currentPlans := map[statementKey][]string{
+		{schemaName: "test", digest: "replan"}: {"p3"},
+		{schemaName: "test", digest: "new"}:    {"p4"},
+	}
+	req := CompareStatementsRequest{MinExecCount: 5}
+	req.adjust()
+
+	result := compareStatements(base, current, basePlans, currentPlans, &req)
+	c.Assert(result, HasLen, 4)
+
+	c.Assert(result[0].Digest, Equals, "slower")
+	c.Assert(result[0].Kind, Equals, StatementChangeChanged)
+	c.Assert(result[0].SumLatencyDelta, Equals, 2000)
+	c.Assert(result[0].Changes, DeepEquals, []MetricChange{{Metric: "avg_latency", Base: 10, Value: 30, Ratio: 2}})
+
+	c.Assert(result[1].Digest, Equals, "new")
+	c.Assert(result[1].Kind, Equals, StatementChangeNew)
+	c.Assert(result[1].NewPlans, DeepEquals, []string{"p4"})
+
+	c.Assert(result[2].Digest, Equals, "replan")
+	c.Assert(result[2].Changes, HasLen, 0)
+	c.Assert(result[2].NewPlans, DeepEquals, []string{"p3"})
+	c.Assert(result[2].VanishedPlans, DeepEquals, []string{"p1"})
+
+	c.Assert(result[3].Digest, Equals, "vanished")
+	c.Assert(result[3].Kind, Equals, StatementChangeVanished)
+	c.Assert(result[3].Current, IsNil)
+	c.Assert(result[3].VanishedPlans, DeepEquals, []string{"p2"})
+}
+
+func (t *testCompareSuite) Test_planDiff(c *C) {
+	added, removed := planDiff([]string{"a", "b"}, []string{"c", "b"})
+	c.Assert(added, DeepEquals, []string{"c"})
+	c.Assert(removed, DeepEquals, []string{"a"})
+
+	added, removed = planDiff([]string{"a"}, []string{"a"})
+	c.Assert(added, HasLen, 0)
+	c.Assert(removed, HasLen, 0)
+}
@@ -0,0 +1,77 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
+)
+
+var modelFieldKinds = func() map[string]reflect.Kind {
+	t := reflect.TypeOf(Model{})
+	kinds := make(map[string]reflect.Kind, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		kinds[t.Field(i).Tag.Get("json")] = t.Field(i).Type.Kind()
+	}
+	return kinds
+}()
+
+// filterKindOf infers the kind of a statement field in filter expressions. Latencies and times are in nanoseconds,
+// except summary_begin_time and summary_end_time which are unix timestamps.
+func filterKindOf(jsonName string) filterexpr.Kind {
+	switch {
+	case modelFieldKinds[jsonName] == reflect.String:
+		return filterexpr.KindString
+	case strings.Contains(jsonName, "latency"),
+		strings.HasSuffix(jsonName, "_time") && !strings.HasPrefix(jsonName, "summary_"):
+		return filterexpr.KindDuration
+	case strings.HasSuffix(jsonName, "_mem"),
+		strings.HasSuffix(jsonName, "_disk"),
+		strings.HasSuffix(jsonName, "_size"),
+		strings.HasSuffix(jsonName, "_byte"):
+		return filterexpr.KindBytes
+	default:
+		return filterexpr.KindNumber
+	}
+}
+
+// filterFields returns aggregated fields available in filter expressions, so that filters apply to statements
+// aggregated in the time range rather than to each summary window.
+func filterFields(tableColumns []string) []filterexpr.Field {
+	result := make([]filterexpr.Field, 0)
+	for _, f := range getFieldsAndTags() {
+		if f.Aggregation == "" {
+			continue
+		}
+		representedColumns := f.Related
+		if len(representedColumns) == 0 {
+			representedColumns = []string{f.JSONName}
+		}
+		if !utils.IsSubsets(tableColumns, representedColumns) {
+			continue
+		}
+		result = append(result, filterexpr.Field{
+			Name: f.JSONName,
+			SQL:  f.Aggregation,
+			Kind: filterKindOf(f.JSONName),
+		})
+	}
+	return result
+}
+
+// compileFilter compiles the filter expression of statements. A nil filter is returned for an empty expression.
+func (s *Service) compileFilter(db *gorm.DB, expr string) (*filterexpr.Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	if err != nil {
+		return nil, err
+	}
+	return filterexpr.Compile(expr, filterFields(tableColumns))
+}
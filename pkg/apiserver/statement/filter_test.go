@@ -0,0 +1,62 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package statement
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
+)
+
+var _ = Suite(&testFilterSuite{})
+
+type testFilterSuite struct{}
+
+func (t *testFilterSuite) Test_filterFields(c *C) {
+	fields := filterFields([]string{"summary_begin_time", "exec_count", "avg_latency", "max_mem", "sample_user"})
+	kinds := make(map[string]filterexpr.Field)
+	for _, f := range fields {
+		kinds[f.Name] = f
+	}
+	c.Assert(kinds["summary_begin_time"].Kind, Equals, filterexpr.KindNumber)
+	c.Assert(kinds["exec_count"].Kind, Equals, filterexpr.KindNumber)
+	c.Assert(kinds["avg_latency"].Kind, Equals, filterexpr.KindDuration)
+	c.Assert(kinds["max_mem"].Kind, Equals, filterexpr.KindBytes)
+	c.Assert(kinds["sample_user"].Kind, Equals, filterexpr.KindString)
+	c.Assert(kinds["sample_user"].SQL, Equals, "ANY_VALUE(sample_user)")
+	_, ok := kinds["table_names"]
+	c.Assert(ok, IsFalse)
+
+	filter, err := filterexpr.Compile("exec_count >= 10 AND max_mem > 1MB", fields)
+	c.Assert(err, IsNil)
+	sql, args := filter.SQL()
+	c.Assert(sql, Equals, "((SUM(exec_count)) >= ? AND (MAX(max_mem)) > ?)")
+	c.Assert(args, DeepEquals, []interface{}{10.0, float64(1 << 20)})
+	c.Assert(filter.FieldNames(), DeepEquals, []string{"exec_count", "max_mem"})
+
+	c.Assert(filter.Match(&Model{AggExecCount: 10, AggMaxMem: 2 << 20}), IsTrue)
+	c.Assert(filter.Match(&Model{AggExecCount: 9, AggMaxMem: 2 << 20}), IsFalse)
+}
+
+func (t *testFilterSuite) Test_mergeStatementsFilter(c *C) {
+	live := []Model{
+		{AggSchemaName: "test", AggDigest: "a", AggExecCount: 15, AggSumLatency: 150},
+	}
+	rows := []ArchivedStatementModel{
+		{SchemaName: "test", Digest: "a", PlanDigest: "p1", Data: ArchivedStatementData{AggSchemaName: "test", AggDigest: "a", AggExecCount: 3, AggSumLatency: 30}},
+		{SchemaName: "test", Digest: "b", PlanDigest: "p2", Data: ArchivedStatementData{AggSchemaName: "test", AggDigest: "b", AggExecCount: 3, AggSumLatency: 30}},
+	}
+
+	result := mergeStatements(live, nil, rows, nil)
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].AggDigest, Equals, "a")
+	c.Assert(result[0].AggExecCount, Equals, 18)
+
+	// The live statement does not pass the filter, neither does it with archived rows merged.
+	filter, err := filterexpr.Compile("exec_count < 10", filterFields([]string{"exec_count"}))
+	c.Assert(err, IsNil)
+	result = mergeStatements(live, nil, rows, filter)
+	c.Assert(result, HasLen, 1)
+	c.Assert(result[0].AggDigest, Equals, "b")
+	c.Assert(result[0].AggExecCount, Equals, 3)
+}
@@ -8,6 +8,8 @@ import (
 	"strings"
 
 	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/util/filterexpr"
 )
 
 const (
@@ -37,15 +39,40 @@ func (s *Service) queryStatements(
 	schemas, stmtTypes []string,
 	text string,
 	reqFields []string,
+	filter *filterexpr.Filter,
 ) (result []Model, err error) {
-	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	query, archived, err := s.buildStatementsQuery(db, beginTime, endTime, schemas, stmtTypes, text, reqFields, filter)
 	if err != nil {
 		return nil, err
 	}
+	if err = query.Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return s.mergeArchivedStatements(db, result, archived, beginTime, endTime, schemas, stmtTypes, filter)
+}
 
+// buildStatementsQuery builds the query of statements held by TiDB, and returns archived rows that should be
+// merged into them.
+func (s *Service) buildStatementsQuery(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemas, stmtTypes []string,
+	text string,
+	reqFields []string,
+	filter *filterexpr.Filter,
+) (*gorm.DB, []ArchivedStatementModel, error) {
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if filter != nil && len(reqFields) > 0 && reqFields[0] != "*" {
+		// Fields in the filter are required to filter statements merged with archived rows.
+		reqFields = append(reqFields, filter.FieldNames()...)
+	}
 	selectStmt, err := s.genSelectStmt(tableColumns, reqFields)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	query := db.
@@ -56,6 +83,93 @@ func (s *Service) queryStatements(
 		Group("schema_name, digest").
 		Order("agg_sum_latency DESC")
 
+	query = applyStatementFilters(query, schemas, stmtTypes, text)
+
+	archived, err := s.queryMatchedArchivedStatements(db, beginTime, endTime, schemas, stmtTypes, text)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Statements merged with archived rows are filtered after merging, see mergeStatements.
+	if filter != nil && len(archived) == 0 {
+		sql, args := filter.SQL()
+		query = query.Having(sql, args...)
+	}
+	return query, archived, nil
+}
+
+// iterateStatements calls fn for each statement of queryStatements without holding all statements in memory.
+// Unlike queryStatements, statements having archived rows are not sorted, and the ones only archived are the last.
+func (s *Service) iterateStatements(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemas, stmtTypes []string,
+	text string,
+	reqFields []string,
+	filter *filterexpr.Filter,
+	fn func(m *Model) error,
+) error {
+	query, archived, err := s.buildStatementsQuery(db, beginTime, endTime, schemas, stmtTypes, text, reqFields, filter)
+	if err != nil {
+		return err
+	}
+	var livePlans map[statementKey][]string
+	archivedKeys := make([]statementKey, 0)
+	archivedByKey := make(map[statementKey][]ArchivedStatementModel)
+	if len(archived) > 0 {
+		if livePlans, err = queryPlanDigests(db, beginTime, endTime, schemas, stmtTypes); err != nil {
+			return err
+		}
+		for _, row := range archived {
+			key := statementKey{schemaName: row.SchemaName, digest: row.Digest}
+			if _, ok := archivedByKey[key]; !ok {
+				archivedKeys = append(archivedKeys, key)
+			}
+			archivedByKey[key] = append(archivedByKey[key], row)
+		}
+	}
+
+	rows, err := query.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close() // #nosec
+	for rows.Next() {
+		var m Model
+		if err := query.ScanRows(rows, &m); err != nil {
+			return err
+		}
+		if len(archived) == 0 {
+			_ = m.AfterFind(query)
+			if err := fn(&m); err != nil {
+				return err
+			}
+			continue
+		}
+		key := statementKey{schemaName: m.AggSchemaName, digest: m.AggDigest}
+		merged := mergeStatements([]Model{m}, livePlans, archivedByKey[key], filter)
+		delete(archivedByKey, key)
+		for i := range merged {
+			if err := fn(&merged[i]); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range archivedKeys {
+		merged := mergeStatements(nil, nil, archivedByKey[key], filter)
+		for i := range merged {
+			if err := fn(&merged[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func applyStatementFilters(query *gorm.DB, schemas, stmtTypes []string, text string) *gorm.DB {
 	if len(schemas) > 0 {
 		regex := make([]string, 0, len(schemas))
 		for _, schema := range schemas {
@@ -83,11 +197,11 @@ func (s *Service) queryStatements(
 		}
 	}
 
-	err = query.Find(&result).Error
-	return
+	return query
 }
 
-func (s *Service) queryPlans(
+// QueryPlans returns execution plans of the statement in the time range, including archived ones.
+func (s *Service) QueryPlans(
 	db *gorm.DB,
 	beginTime, endTime int,
 	schemaName, digest string,
@@ -130,12 +244,94 @@ func (s *Service) queryPlans(
 		query.Where("digest = ?", digest)
 	}
 
-	err = query.Find(&result).Error
+	if err = query.Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return s.mergeArchivedPlans(db, result, beginTime, endTime, schemaName, digest)
+}
 
-	return
+// queryPlanDigests returns plan digests of each statement in the time window.
+func queryPlanDigests(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemas, stmtTypes []string,
+) (map[statementKey][]string, error) {
+	var rows []struct {
+		SchemaName string
+		Digest     string
+		PlanDigest string
+	}
+	query := db.
+		Select("schema_name, digest, plan_digest").
+		Table(statementsTable).
+		Where("summary_begin_time <= FROM_UNIXTIME(?) AND summary_end_time >= FROM_UNIXTIME(?)", endTime, beginTime).
+		Group("schema_name, digest, plan_digest")
+	query = applyStatementFilters(query, schemas, stmtTypes, "")
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	result := make(map[statementKey][]string)
+	for _, row := range rows {
+		key := statementKey{schemaName: row.SchemaName, digest: row.Digest}
+		result[key] = append(result[key], row.PlanDigest)
+	}
+	return result, nil
 }
 
-func (s *Service) queryPlanDetail(
+// QueryStatementHistory returns the statement in each summary window of the time range, so that changes of the
+// statement over time can be seen. Only plans in the list are counted if it is not empty.
+func (s *Service) QueryStatementHistory(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemaName, digest string,
+	plans []string,
+) (result []Model, err error) {
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	selectStmt, err := s.genSelectStmt(tableColumns, []string{
+		"summary_begin_time",
+		"summary_end_time",
+		"schema_name",
+		"digest",
+		"exec_count",
+		"sum_latency",
+		"max_latency",
+		"avg_latency",
+		"avg_processed_keys",
+		"avg_mem",
+		"max_mem",
+		"plan_count",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.
+		Select(selectStmt).
+		Table(statementsTable).
+		Where("summary_begin_time <= FROM_UNIXTIME(?) AND summary_end_time >= FROM_UNIXTIME(?)", endTime, beginTime).
+		Where("digest = ?", digest).
+		Group("summary_begin_time").
+		Order("summary_begin_time")
+	if schemaName != "" {
+		query = query.Where("schema_name = ?", schemaName)
+	}
+	if len(plans) > 0 {
+		query = query.Where("plan_digest IN (?)", plans)
+	}
+
+	if err = query.Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// QueryPlanDetail returns the statement executed in given plans in the time range, including archived ones.
+func (s *Service) QueryPlanDetail(
 	db *gorm.DB,
 	beginTime, endTime int,
 	schemaName, digest string,
@@ -169,6 +365,8 @@ func (s *Service) queryPlanDetail(
 		query.Where("digest = ?", digest)
 	}
 
-	err = query.Scan(&result).Error
-	return
+	if err = query.Scan(&result).Error; err != nil {
+		return
+	}
+	return s.mergeArchivedPlanDetail(db, result, beginTime, endTime, schemaName, digest, plans)
 }
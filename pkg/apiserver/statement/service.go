@@ -3,39 +3,72 @@
 package statement
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joomcode/errorx"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 	commonUtils "github.com/pingcap/tidb-dashboard/pkg/utils"
 	"github.com/pingcap/tidb-dashboard/util/rest"
+	"github.com/pingcap/tidb-dashboard/util/rest/fileswap"
 )
 
 var (
 	ErrNS     = errorx.NewNamespace("error.api.statement")
 	ErrNoData = ErrNS.NewType("export_no_data")
+	// ErrArchiveNoCredential is returned when the statement archive has no SQL credential to read from TiDB.
+	ErrArchiveNoCredential = ErrNS.NewType("archive_no_credential")
 )
 
 type ServiceParams struct {
 	fx.In
-	TiDBClient *tidb.Client
-	SysSchema  *commonUtils.SysSchema
+	TiDBClient    *tidb.Client
+	SysSchema     *commonUtils.SysSchema
+	LocalStore    *dbstore.DB
+	ConfigManager *config.DynamicConfigManager
+	SSOService    *sso.Service
 }
 
 type Service struct {
-	params ServiceParams
+	params   ServiceParams
+	wg       sync.WaitGroup
+	archiver archiver
+	fSwap    *fileswap.Handler
 }
 
-func newService(p ServiceParams) *Service {
-	return &Service{params: p}
+func newService(lc fx.Lifecycle, p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	s := &Service{params: p, fSwap: fileswap.New()}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.archiveLoop(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			s.wg.Wait()
+			return nil
+		},
+	})
+	return s, nil
 }
 
 func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
@@ -47,11 +80,16 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 		{
 			endpoint.GET("/config", s.configHandler)
-			endpoint.POST("/config", auth.MWRequireWritePriv(), s.modifyConfigHandler)
+			endpoint.POST("/config", auth.MWRequirePermission(user.PermStatementConfig), s.modifyConfigHandler)
 			endpoint.GET("/stmt_types", s.stmtTypesHandler)
 			endpoint.GET("/list", s.listHandler)
 			endpoint.GET("/plans", s.plansHandler)
 			endpoint.GET("/plan/detail", s.planDetailHandler)
+			endpoint.GET("/compare", s.compareHandler)
+
+			endpoint.GET("/archive/config", s.archiveConfigHandler)
+			endpoint.PUT("/archive/config", auth.MWRequirePermission(user.PermStatementConfig), s.modifyArchiveConfigHandler)
+			endpoint.GET("/archive/status", s.archiveStatusHandler)
 
 			endpoint.POST("/download/token", s.downloadTokenHandler)
 
@@ -98,6 +136,12 @@ func (s *Service) modifyConfigHandler(c *gin.Context) {
 	}
 	db := utils.GetTiDBConnection(c)
 
+	oldConfig := &EditableConfig{}
+	if err := db.Raw(buildGlobalConfigProjectionSelectSQL(oldConfig)).Find(oldConfig).Error; err == nil {
+		audit.SetBefore(c, oldConfig)
+	}
+	audit.SetAfter(c, config)
+
 	var sqlWithNamedArgument string
 	if !config.Enable {
 		sqlWithNamedArgument = buildGlobalConfigNamedArgsUpdateSQL(&config, "Enable")
@@ -135,6 +179,14 @@ type GetStatementsRequest struct {
 	EndTime   int      `json:"end_time" form:"end_time"`
 	Text      string   `json:"text" form:"text"`
 	Fields    string   `json:"fields" form:"fields"`
+	// Filter is an expression of aggregated fields, e.g. "avg_latency > 100ms AND table_names CONTAINS 'orders'",
+	// see filterexpr.
+	Filter string `json:"filter" form:"filter"`
+}
+
+type DownloadRequest struct {
+	GetStatementsRequest
+	utils.ExportOptions
 }
 
 // @Summary Get a list of statements
@@ -155,13 +207,19 @@ func (s *Service) listHandler(c *gin.Context) {
 	if strings.TrimSpace(req.Fields) != "" {
 		fields = strings.Split(req.Fields, ",")
 	}
+	filter, err := s.compileFilter(db, req.Filter)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
 	overviews, err := s.queryStatements(
 		db,
 		req.BeginTime, req.EndTime,
 		req.Schemas,
 		req.StmtTypes,
 		req.Text,
-		fields)
+		fields,
+		filter)
 	if err != nil {
 		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
 		return
@@ -189,7 +247,7 @@ func (s *Service) plansHandler(c *gin.Context) {
 		return
 	}
 	db := utils.GetTiDBConnection(c)
-	plans, err := s.queryPlans(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest)
+	plans, err := s.QueryPlans(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest)
 	if err != nil {
 		rest.Error(c, err)
 		return
@@ -215,7 +273,7 @@ func (s *Service) planDetailHandler(c *gin.Context) {
 		return
 	}
 	db := utils.GetTiDBConnection(c)
-	result, err := s.queryPlanDetail(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest, req.Plans)
+	result, err := s.QueryPlanDetail(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest, req.Plans)
 	if err != nil {
 		rest.Error(c, err)
 		return
@@ -233,71 +291,73 @@ func (s *Service) planDetailHandler(c *gin.Context) {
 
 // @Router /statements/download/token [post]
 // @Summary Generate a download token for exported statements
+// @Description Statements are exported in CSV, JSON Lines or Parquet, optionally compressed by gzip
 // @Produce plain
-// @Param request body GetStatementsRequest true "Request body"
+// @Param request body DownloadRequest true "Request body"
 // @Success 200 {string} string "xxx"
 // @Security JwtAuth
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 func (s *Service) downloadTokenHandler(c *gin.Context) {
-	var req GetStatementsRequest
+	audit.Skip(c)
+	var req DownloadRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
 		return
 	}
+	if err := req.Validate(); err != nil {
+		rest.Error(c, err)
+		return
+	}
 	db := utils.GetTiDBConnection(c)
 	fields := []string{}
 	if strings.TrimSpace(req.Fields) != "" {
 		fields = strings.Split(req.Fields, ",")
 	}
-	overviews, err := s.queryStatements(
-		db,
-		req.BeginTime, req.EndTime,
-		req.Schemas,
-		req.StmtTypes,
-		req.Text,
-		fields)
+	filter, err := s.compileFilter(db, req.Filter)
 	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
-		return
-	}
-	if len(overviews) == 0 {
-		rest.Error(c, ErrNoData.NewWithNoMessage())
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
 		return
 	}
 
-	// interface{} tricky
-	rawData := make([]interface{}, len(overviews))
-	for i, v := range overviews {
-		rawData[i] = v
-	}
-
-	// convert data
-	csvData := utils.GenerateCSVFromRaw(rawData, fields, []string{"first_seen", "last_seen"})
-
-	// generate temp file that persist encrypted data
 	timeLayout := "01021504"
 	beginTime := time.Unix(int64(req.BeginTime), 0).Format(timeLayout)
 	endTime := time.Unix(int64(req.EndTime), 0).Format(timeLayout)
-	token, err := utils.ExportCSV(csvData,
-		fmt.Sprintf("statements_%s_%s_*.csv", beginTime, endTime),
-		"statements/download")
+	token, err := utils.ExportRows(s.fSwap, req.ExportOptions,
+		fmt.Sprintf("statements_%s_%s", beginTime, endTime),
+		Model{}, fields, []string{"first_seen", "last_seen"},
+		func(write func(row interface{}) error) error {
+			return s.iterateStatements(
+				db,
+				req.BeginTime, req.EndTime,
+				req.Schemas,
+				req.StmtTypes,
+				req.Text,
+				fields,
+				filter,
+				func(m *Model) error {
+					return write(m)
+				})
+		})
 	if err != nil {
 		rest.Error(c, err)
 		return
 	}
+	if token == "" {
+		rest.Error(c, ErrNoData.NewWithNoMessage())
+		return
+	}
 	c.String(http.StatusOK, token)
 }
 
 // @Router /statements/download [get]
 // @Summary Download statements
-// @Produce text/csv
+// @Produce application/octet-stream
 // @Param token query string true "download token"
 // @Failure 400 {object} rest.ErrorResponse
 // @Failure 401 {object} rest.ErrorResponse
 func (s *Service) downloadHandler(c *gin.Context) {
-	token := c.Query("token")
-	utils.DownloadByToken(token, "statements/download", c)
+	s.fSwap.HandleDownloadRequest(c)
 }
 
 // @Summary Get available field names
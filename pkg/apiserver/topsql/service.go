@@ -9,6 +9,7 @@ import (
 	"github.com/joomcode/errorx"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
@@ -43,7 +44,7 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	)
 	{
 		endpoint.GET("/config", s.GetConfig)
-		endpoint.POST("/config", auth.MWRequireWritePriv(), s.UpdateConfig)
+		endpoint.POST("/config", auth.MWRequirePermission(user.PermTopSQLConfig), s.UpdateConfig)
 		endpoint.GET("/instances", s.params.NgmProxy.Route("/topsql/v1/instances"))
 		endpoint.GET("/summary", s.params.NgmProxy.Route("/topsql/v1/summary"))
 	}
@@ -157,6 +158,12 @@ func (s *Service) UpdateConfig(c *gin.Context) {
 	}
 
 	db := utils.GetTiDBConnection(c)
+	oldCfg := &EditableConfig{}
+	if err := db.Raw("SELECT @@GLOBAL.tidb_enable_top_sql as tidb_enable_top_sql").Find(oldCfg).Error; err == nil {
+		audit.SetBefore(c, oldCfg)
+	}
+	audit.SetAfter(c, cfg)
+
 	err := db.Exec("SET @@GLOBAL.tidb_enable_top_sql = @Enable", &cfg).Error
 	if err != nil {
 		rest.Error(c, err)
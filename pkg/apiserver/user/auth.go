@@ -7,17 +7,20 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	jwt "github.com/breeswish/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	gojwt "github.com/golang-jwt/jwt"
 	"github.com/gtank/cryptopasta"
 	"github.com/joomcode/errorx"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/keyring"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/util/featureflag"
 	"github.com/pingcap/tidb-dashboard/util/rest"
@@ -30,11 +33,17 @@ var (
 	ErrSignInOther         = ErrNSSignIn.NewType("other")
 )
 
+// sessionKeyClaim is the JWT claim holding the ID of the session key that signs the token.
+const sessionKeyClaim = "k"
+
 type AuthService struct {
 	FeatureFlagNonRootLogin *featureflag.FeatureFlag
 
-	middleware     *jwt.GinJWTMiddleware
-	authenticators map[utils.AuthType]Authenticator
+	keyring            *keyring.Keyring
+	middlewaresMu      sync.Mutex
+	middlewares        map[string]*jwt.GinJWTMiddleware
+	authenticators     map[utils.AuthType]Authenticator
+	permissionResolver PermissionResolver
 }
 
 type AuthenticateForm struct {
@@ -74,28 +83,36 @@ func (a BaseAuthenticator) SignOutInfo(u *utils.SessionUser, redirectURL string)
 	return &SignOutInfo{}, nil
 }
 
-func NewAuthService(featureFlags *featureflag.Registry) *AuthService {
-	var secret *[32]byte
-
-	secretStr := os.Getenv("DASHBOARD_SESSION_SECRET")
-	switch len(secretStr) {
-	case 0:
-		secret = cryptopasta.NewEncryptionKey()
-	case 32:
-		log.Info("DASHBOARD_SESSION_SECRET is overridden from env var")
-		secret = &[32]byte{}
-		copy(secret[:], secretStr)
-	default:
-		log.Warn("DASHBOARD_SESSION_SECRET does not meet the 32 byte size requirement, ignored")
-		secret = cryptopasta.NewEncryptionKey()
-	}
-
-	service := &AuthService{
+func NewAuthService(featureFlags *featureflag.Registry, kr *keyring.Keyring) *AuthService {
+	return &AuthService{
 		FeatureFlagNonRootLogin: featureFlags.Register("nonRootLogin", ">= 5.3.0"),
-		middleware:              nil,
+		keyring:                 kr,
+		middlewares:             map[string]*jwt.GinJWTMiddleware{},
 		authenticators:          map[utils.AuthType]Authenticator{},
 	}
+}
+
+// getMiddleware returns the JWT middleware that signs and verifies tokens using the specified session key.
+// Middlewares are cached, since keys are rarely rotated.
+func (s *AuthService) getMiddleware(key *keyring.Key) *jwt.GinJWTMiddleware {
+	s.middlewaresMu.Lock()
+	defer s.middlewaresMu.Unlock()
+	if mw, ok := s.middlewares[key.ID]; ok {
+		return mw
+	}
+	mw := s.newMiddleware(key)
+	// Drop middlewares of keys that are no longer accepted.
+	for id := range s.middlewares {
+		if s.keyring.Get(id) == nil {
+			delete(s.middlewares, id)
+		}
+	}
+	s.middlewares[key.ID] = mw
+	return mw
+}
 
+func (s *AuthService) newMiddleware(key *keyring.Key) *jwt.GinJWTMiddleware {
+	secret := key.Secret
 	middleware, err := jwt.New(&jwt.GinJWTMiddleware{
 		IdentityKey: utils.SessionUserKey,
 		Realm:       "dashboard",
@@ -107,7 +124,7 @@ func NewAuthService(featureFlags *featureflag.Registry) *AuthService {
 			if err := c.ShouldBindJSON(&form); err != nil {
 				return nil, rest.ErrBadRequest.WrapWithNoMessage(err)
 			}
-			u, err := service.authForm(form)
+			u, err := s.authForm(form)
 			if err != nil {
 				return nil, errorx.Decorate(err, "authenticate failed")
 			}
@@ -129,7 +146,8 @@ func NewAuthService(featureFlags *featureflag.Registry) *AuthService {
 				return jwt.MapClaims{}
 			}
 			return jwt.MapClaims{
-				"p": base64.StdEncoding.EncodeToString(encrypted),
+				"p":             base64.StdEncoding.EncodeToString(encrypted),
+				sessionKeyClaim: key.ID,
 			}
 		},
 		IdentityHandler: func(c *gin.Context) interface{} {
@@ -157,7 +175,7 @@ func NewAuthService(featureFlags *featureflag.Registry) *AuthService {
 				return nil
 			}
 
-			a, ok := service.authenticators[user.AuthFrom]
+			a, ok := s.authenticators[user.AuthFrom]
 			if !ok {
 				return nil
 			}
@@ -204,10 +222,25 @@ func NewAuthService(featureFlags *featureflag.Registry) *AuthService {
 		// Error only comes from configuration errors. Fatal is fine.
 		log.Fatal("Failed to configure auth service", zap.Error(err))
 	}
+	return middleware
+}
 
-	service.middleware = middleware
-
-	return service
+// keyForRequest returns the session key that the token in the request claims to be signed with. The claim is
+// not trusted: the token will be verified using the returned key. When the claim is missing or refers to a
+// key that is no longer accepted, the current key is returned so that verification fails as usual.
+func (s *AuthService) keyForRequest(c *gin.Context) (*keyring.Key, error) {
+	parts := strings.SplitN(c.Request.Header.Get("Authorization"), " ", 2)
+	if len(parts) == 2 && parts[0] == "Bearer" {
+		claims := gojwt.MapClaims{}
+		if _, _, err := new(gojwt.Parser).ParseUnverified(parts[1], claims); err == nil {
+			if id, ok := claims[sessionKeyClaim].(string); ok {
+				if key := s.keyring.Get(id); key != nil {
+					return key, nil
+				}
+			}
+		}
+	}
+	return s.keyring.Current()
 }
 
 func (s *AuthService) authForm(f AuthenticateForm) (*utils.SessionUser, error) {
@@ -228,13 +261,22 @@ func registerRouter(r *gin.RouterGroup, s *AuthService) {
 	endpoint.GET("/login_info", s.GetLoginInfoHandler)
 	endpoint.POST("/login", s.LoginHandler)
 	endpoint.GET("/sign_out_info", s.MWAuthRequired(), s.getSignOutInfoHandler)
+	endpoint.POST("/session_key/rotate", s.MWAuthRequired(), s.MWRequirePermission(PermSessionKeyRotate), s.rotateSessionKeyHandler)
 }
 
 // MWAuthRequired creates a middleware that verifies the authentication token (JWT) in the request. If the token
 // is valid, identity information will be attached in the context. If there is no authentication token, or the
 // token is invalid, subsequent handlers will be skipped and errors will be generated.
 func (s *AuthService) MWAuthRequired() gin.HandlerFunc {
-	return s.middleware.MiddlewareFunc()
+	return func(c *gin.Context) {
+		key, err := s.keyForRequest(c)
+		if err != nil {
+			rest.Error(c, err)
+			c.Abort()
+			return
+		}
+		s.getMiddleware(key).MiddlewareFunc()(c)
+	}
 }
 
 // TODO: Make these MWRequireXxxPriv more general to use.
@@ -309,9 +351,15 @@ func (s *AuthService) GetLoginInfoHandler(c *gin.Context) {
 // @Param message body AuthenticateForm true "Credentials"
 // @Success 200 {object} TokenResponse
 // @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
 // @Router /user/login [post]
 func (s *AuthService) LoginHandler(c *gin.Context) {
-	s.middleware.LoginHandler(c)
+	key, err := s.keyring.Current()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	s.getMiddleware(key).LoginHandler(c)
 }
 
 type GetSignOutInfoRequest struct {
@@ -346,3 +394,20 @@ func (s *AuthService) getSignOutInfoHandler(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, si)
 }
+
+// @ID userRotateSessionKey
+// @Summary Rotate the session key shared by all instances
+// @Description Sessions signed by the previous key remain valid within a grace period. Sharing codes created before the previous rotation become invalid.
+// @Success 200 {object} rest.EmptyResponse
+// @Router /user/session_key/rotate [post]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *AuthService) rotateSessionKeyHandler(c *gin.Context) {
+	if err := s.keyring.Rotate(c.Request.Context()); err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rest.EmptyResponse{})
+}
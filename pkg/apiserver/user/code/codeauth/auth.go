@@ -45,5 +45,9 @@ func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUse
 }
 
 func (a *Authenticator) ProcessSession(user *utils.SessionUser) bool {
-	return !time.Now().After(user.SharedSessionExpireAt)
+	if time.Now().After(user.SharedSessionExpireAt) {
+		return false
+	}
+	// The sharing code may be revoked after the session is created.
+	return a.sharingCodeService.IsSharingCodeValid(user.SharingCodeID)
 }
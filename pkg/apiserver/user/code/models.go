@@ -0,0 +1,33 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package code
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type SharingCodeModel struct {
+	ID              string     `json:"id" gorm:"primary_key;size:32"`
+	Creator         string     `json:"creator" gorm:"size:256;index"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ExpireAt        time.Time  `json:"expire_at" gorm:"index"`
+	RevokeWritePriv bool       `json:"revoke_write_priv"`
+	UseCount        uint       `json:"use_count"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	RevokedAt       *time.Time `json:"revoked_at"`
+	// The shared session is encrypted by a key derived from the key in the sharing code and the session key
+	// identified by SessionKeyID, so that sessions cannot be recovered from the local storage alone, and rotating
+	// session keys also invalidates sharing codes.
+	SessionKeyID     string `json:"-" gorm:"size:16"`
+	EncryptedSession string `json:"-" gorm:"type:text"`
+}
+
+func (SharingCodeModel) TableName() string {
+	return "sharing_codes"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&SharingCodeModel{})
+}
@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/util/rest"
@@ -17,6 +19,8 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/user/share")
 	endpoint.Use(auth.MWAuthRequired())
 	endpoint.POST("/code", auth.MWRequireSharePriv(), s.ShareHandler)
+	endpoint.GET("/codes", s.listCodesHandler(auth))
+	endpoint.DELETE("/codes/:id", s.revokeCodeHandler(auth))
 }
 
 type ShareRequest struct {
@@ -26,6 +30,7 @@ type ShareRequest struct {
 
 type ShareResponse struct {
 	Code string `json:"code"`
+	ID   string `json:"id"`
 }
 
 // @ID userShareSession
@@ -49,11 +54,77 @@ func (s *Service) ShareHandler(c *gin.Context) {
 	}
 
 	sessionUser := utils.GetSession(c)
-	code := s.SharingCodeFromSession(sessionUser, expiry, req.RevokeWritePriv)
+	code, id := s.SharingCodeFromSession(sessionUser, expiry, req.RevokeWritePriv)
 	if code == nil {
 		rest.Error(c, ErrShareFailed.New("Share session failed"))
 		return
 	}
 
-	c.JSON(http.StatusOK, ShareResponse{Code: *code})
+	audit.SetTargets(c, *id)
+	c.JSON(http.StatusOK, ShareResponse{Code: *code, ID: *id})
+}
+
+// creatorFilter returns the creator that the current session is limited to. Sessions with the share management
+// permission are not limited, in which case an empty string is returned.
+func creatorFilter(c *gin.Context, auth *user.AuthService) (string, error) {
+	u := utils.GetSession(c)
+	perms, err := auth.GetPermissions(u)
+	if err != nil {
+		return "", err
+	}
+	if perms.Has(user.PermShareManage) {
+		return "", nil
+	}
+	return u.DisplayName, nil
+}
+
+// @ID userListSharingCodes
+// @Summary List sharing codes that are not expired. Only codes created by the current user are listed, unless the user has the share:manage permission.
+// @Security JwtAuth
+// @Success 200 {array} SharingCodeModel
+// @Router /user/share/codes [get]
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) listCodesHandler(auth *user.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		creator, err := creatorFilter(c, auth)
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		records, err := s.ListSharingCodes(creator)
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, records)
+	}
+}
+
+// @ID userRevokeSharingCode
+// @Summary Revoke a sharing code, sessions signed in with this code are signed out as well
+// @Param id path string true "Sharing code ID"
+// @Security JwtAuth
+// @Success 200 {object} rest.EmptyResponse
+// @Router /user/share/codes/{id} [delete]
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 404 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) revokeCodeHandler(auth *user.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		creator, err := creatorFilter(c, auth)
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		audit.SetTargets(c, c.Param("id"))
+		if err := s.RevokeSharingCode(c.Param("id"), creator); err != nil {
+			rest.Error(c, err)
+			if errorx.IsOfType(err, ErrSharingCodeMissing) {
+				c.Status(http.StatusNotFound)
+			}
+			return
+		}
+		c.JSON(http.StatusOK, rest.EmptyResponse{})
+	}
 }
@@ -3,42 +3,55 @@
 package code
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/gtank/cryptopasta"
 	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
 	"github.com/vmihailenco/msgpack/v5"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/keyring"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 )
 
 var (
-	ErrNS          = errorx.NewNamespace("error.api.user.code")
-	ErrShareFailed = ErrNS.NewType("share_failed")
+	ErrNS                 = errorx.NewNamespace("error.api.user.code")
+	ErrShareFailed        = ErrNS.NewType("share_failed")
+	ErrSharingCodeMissing = ErrNS.NewType("sharing_code_missing")
 )
 
 const (
 	// Max permitted lifetime of a shared session.
 	MaxSessionShareExpiry = time.Hour * 24 * 30
+
+	sharingCodeIDLen  = 16
+	sharingCodeKeyLen = 32
 )
 
-type Service struct {
-	sharingSecret *[32]byte
+type ServiceParams struct {
+	fx.In
+	LocalStore *dbstore.DB
+	Keyring    *keyring.Keyring
 }
 
-type sharedSession struct {
-	Session         *utils.SessionUser
-	ExpireAt        time.Time
-	RevokeWritePriv bool
+type Service struct {
+	params ServiceParams
 }
 
-func NewService() *Service {
-	return &Service{
-		sharingSecret: cryptopasta.NewEncryptionKey(),
+func NewService(p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
 	}
+	return &Service{params: p}, nil
 }
 
 var Module = fx.Options(
@@ -46,64 +59,191 @@ var Module = fx.Options(
 	fx.Invoke(registerRouter),
 )
 
-func (s *Service) NewSessionFromSharingCode(codeInHex string) *utils.SessionUser {
-	encrypted, err := hex.DecodeString(codeInHex)
+// The sharing code is hex(ID + Key). ID identifies the record in the local storage, while Key decrypts the shared
+// session in the record.
+func parseSharingCode(codeInHex string) (string, *[32]byte, error) {
+	b, err := hex.DecodeString(codeInHex)
 	if err != nil {
-		return nil
+		return "", nil, err
+	}
+	if len(b) != sharingCodeIDLen+sharingCodeKeyLen {
+		return "", nil, fmt.Errorf("invalid sharing code length")
 	}
+	var key [32]byte
+	copy(key[:], b[sharingCodeIDLen:])
+	return hex.EncodeToString(b[:sharingCodeIDLen]), &key, nil
+}
 
-	b, err := cryptopasta.Decrypt(encrypted, s.sharingSecret)
+// encryptionKey derives the key that encrypts the shared session from the key in the sharing code and the session
+// key shared by all instances.
+func encryptionKey(sessionKey *keyring.Key, codeKey *[32]byte) *[32]byte {
+	mac := hmac.New(sha256.New, sessionKey.Secret[:])
+	mac.Write(codeKey[:])
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return &key
+}
+
+func (s *Service) NewSessionFromSharingCode(codeInHex string) *utils.SessionUser {
+	id, key, err := parseSharingCode(codeInHex)
 	if err != nil {
 		return nil
 	}
 
-	var shared sharedSession
-	if err := msgpack.Unmarshal(b, &shared); err != nil {
+	var record SharingCodeModel
+	if err := s.params.LocalStore.Where("id = ?", id).Limit(1).Find(&record).Error; err != nil {
+		return nil
+	}
+	if record.ID == "" || record.RevokedAt != nil {
+		return nil
+	}
+	if time.Now().After(record.ExpireAt) {
 		return nil
 	}
 
-	if time.Now().After(shared.ExpireAt) {
+	sessionKey := s.params.Keyring.Lookup(record.SessionKeyID)
+	if sessionKey == nil {
 		return nil
 	}
+	encrypted, err := hex.DecodeString(record.EncryptedSession)
+	if err != nil {
+		return nil
+	}
+	b, err := cryptopasta.Decrypt(encrypted, encryptionKey(sessionKey, key))
+	if err != nil {
+		return nil
+	}
+	var session utils.SessionUser
+	if err := msgpack.Unmarshal(b, &session); err != nil {
+		return nil
+	}
+
+	err = s.params.LocalStore.
+		Model(&SharingCodeModel{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"use_count":    gorm.Expr("use_count + 1"),
+			"last_used_at": time.Now(),
+		}).
+		Error
+	if err != nil {
+		log.Warn("Failed to update sharing code usage", zap.String("id", id), zap.Error(err))
+	}
 
-	shared.Session.SharedSessionExpireAt = shared.ExpireAt
-	shared.Session.DisplayName = fmt.Sprintf("Shared from %s", shared.Session.DisplayName)
-	shared.Session.IsShareable = false
-	if shared.RevokeWritePriv {
-		shared.Session.IsWriteable = false
+	session.SharingCodeID = id
+	session.SharedSessionExpireAt = record.ExpireAt
+	session.DisplayName = fmt.Sprintf("Shared from %s", session.DisplayName)
+	session.IsShareable = false
+	if record.RevokeWritePriv {
+		session.IsWriteable = false
 	}
 
-	return shared.Session
+	return &session
 }
 
-func (s *Service) SharingCodeFromSession(session *utils.SessionUser, expireIn time.Duration, revokeWritePriv bool) *string {
+// SharingCodeFromSession persists the session and returns the sharing code and its ID.
+func (s *Service) SharingCodeFromSession(session *utils.SessionUser, expireIn time.Duration, revokeWritePriv bool) (*string, *string) {
 	if !session.IsShareable {
-		return nil
+		return nil, nil
 	}
 	if expireIn < 0 {
-		return nil
+		return nil, nil
 	}
 	if expireIn > MaxSessionShareExpiry {
-		return nil
+		return nil, nil
 	}
 
-	shared := sharedSession{
-		Session:         session,
-		ExpireAt:        time.Now().Add(expireIn),
-		RevokeWritePriv: revokeWritePriv,
+	b, err := msgpack.Marshal(session)
+	if err != nil {
+		// Do not output anything about how serialization is failed to avoid potential leaks.
+		return nil, nil
 	}
 
-	b, err := msgpack.Marshal(&shared)
+	sessionKey, err := s.params.Keyring.Current()
 	if err != nil {
-		// Do not output anything about how serialization is failed to avoid potential leaks.
-		return nil
+		log.Warn("Failed to get session key for sharing code", zap.Error(err))
+		return nil, nil
 	}
 
-	encrypted, err := cryptopasta.Encrypt(b, s.sharingSecret)
+	raw := make([]byte, sharingCodeIDLen+sharingCodeKeyLen)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil
+	}
+	var key [32]byte
+	copy(key[:], raw[sharingCodeIDLen:])
+
+	encrypted, err := cryptopasta.Encrypt(b, encryptionKey(sessionKey, &key))
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 
-	codeInHex := hex.EncodeToString(encrypted)
-	return &codeInHex
+	record := &SharingCodeModel{
+		ID:               hex.EncodeToString(raw[:sharingCodeIDLen]),
+		Creator:          session.DisplayName,
+		ExpireAt:         time.Now().Add(expireIn),
+		RevokeWritePriv:  revokeWritePriv,
+		SessionKeyID:     sessionKey.ID,
+		EncryptedSession: hex.EncodeToString(encrypted),
+	}
+	if err := s.params.LocalStore.Create(record).Error; err != nil {
+		log.Warn("Failed to save sharing code", zap.Error(err))
+		return nil, nil
+	}
+	s.removeExpiredSharingCodes()
+
+	codeInHex := hex.EncodeToString(raw)
+	return &codeInHex, &record.ID
+}
+
+// IsSharingCodeValid checks whether sessions created from the sharing code are still allowed.
+func (s *Service) IsSharingCodeValid(id string) bool {
+	if id == "" {
+		return false
+	}
+	var count int64
+	err := s.params.LocalStore.
+		Model(&SharingCodeModel{}).
+		Where("id = ? AND revoked_at IS NULL AND expire_at > ?", id, time.Now()).
+		Count(&count).
+		Error
+	return err == nil && count > 0
+}
+
+// ListSharingCodes lists sharing codes that are not expired. If creator is empty, codes of all creators are listed.
+func (s *Service) ListSharingCodes(creator string) ([]SharingCodeModel, error) {
+	s.removeExpiredSharingCodes()
+
+	db := s.params.LocalStore.Order("created_at DESC")
+	if creator != "" {
+		db = db.Where("creator = ?", creator)
+	}
+	var records []SharingCodeModel
+	if err := db.Find(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// RevokeSharingCode revokes a sharing code. If creator is not empty, only the code created by the creator can be
+// revoked. Sessions that are already signed in with this code will be signed out.
+func (s *Service) RevokeSharingCode(id string, creator string) error {
+	db := s.params.LocalStore.Model(&SharingCodeModel{}).Where("id = ? AND revoked_at IS NULL", id)
+	if creator != "" {
+		db = db.Where("creator = ?", creator)
+	}
+	result := db.Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSharingCodeMissing.New("sharing code %s does not exist or is already revoked", id)
+	}
+	return nil
+}
+
+func (s *Service) removeExpiredSharingCodes() {
+	err := s.params.LocalStore.Where("expire_at < ?", time.Now()).Delete(&SharingCodeModel{}).Error
+	if err != nil {
+		log.Warn("Failed to remove expired sharing codes", zap.Error(err))
+	}
 }
@@ -0,0 +1,420 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package keyring manages the session keys shared by all Dashboard instances in the cluster. Keys are stored in
+// etcd so that sessions survive restarts and PD leader changes. Keys can be rotated, in which case the previous key
+// is still accepted within a grace window.
+//
+// Keys stored in etcd are encrypted by DASHBOARD_SESSION_SECRET, a 32 byte secret which must be the same among all
+// instances. When it is not set, keys are encrypted by a key derived from the cluster ID, which only protects keys
+// against accidental disclosure: anyone able to read etcd can decrypt them. Sessions contain TiDB passwords, so
+// setting the secret is recommended.
+package keyring
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/gtank/cryptopasta"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	EtcdKeyPath = "/dashboard/session_keys"
+
+	secretEnvVar = "DASHBOARD_SESSION_SECRET"
+
+	// GracePeriod is how long the previous key is accepted after rotation. It should be longer than the lifetime
+	// of a session, including the refresh window.
+	GracePeriod = 48 * time.Hour
+	// RotationInterval is how long a key is used before it is automatically rotated.
+	RotationInterval = 30 * 24 * time.Hour
+
+	syncInterval    = 30 * time.Second
+	etcdTimeout     = 5 * time.Second
+	maxLoadInterval = 30 * time.Second
+	// readyTimeout is how long Current waits for keys to be loaded from etcd.
+	readyTimeout = 5 * time.Second
+)
+
+var (
+	ErrNS             = errorx.NewNamespace("error.keyring")
+	ErrNotReady       = ErrNS.NewType("not_ready")
+	ErrConflict       = ErrNS.NewType("conflict")
+	ErrDecryptFailed  = ErrNS.NewType("decrypt_failed")
+	ErrUnableToAccess = ErrNS.NewType("unable_to_access")
+)
+
+type Key struct {
+	ID        string
+	Secret    *[32]byte
+	CreatedAt time.Time
+}
+
+type storedKey struct {
+	ID              string     `json:"id"`
+	EncryptedSecret string     `json:"encrypted_secret"`
+	CreatedAt       time.Time  `json:"created_at"`
+	RetiredAt       *time.Time `json:"retired_at,omitempty"`
+}
+
+type storedKeyring struct {
+	Current  storedKey  `json:"current"`
+	Previous *storedKey `json:"previous,omitempty"`
+}
+
+type Keyring struct {
+	mu sync.RWMutex
+
+	etcdClient *clientv3.Client
+	// kek encrypts keys stored in etcd. When DASHBOARD_SESSION_SECRET is not set, it is derived from the cluster ID
+	// once etcd is accessed.
+	kek   *[32]byte
+	wg    sync.WaitGroup
+	ready chan struct{}
+
+	loaded            bool
+	current           *Key
+	previous          *Key
+	previousRetiredAt time.Time
+}
+
+func NewKeyring(lc fx.Lifecycle, etcdClient *clientv3.Client) *Keyring {
+	k := &Keyring{
+		etcdClient: etcdClient,
+		kek:        loadKEK(),
+		ready:      make(chan struct{}),
+	}
+	if k.kek == nil {
+		log.Warn(secretEnvVar + " is not set, session keys stored in etcd can be decrypted by anyone able to read etcd")
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			k.wg.Add(1)
+			go func() {
+				defer k.wg.Done()
+				k.syncLoop(ctx)
+			}()
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			k.wg.Wait()
+			return nil
+		},
+	})
+	return k
+}
+
+func newKey() (*Key, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:        hex.EncodeToString(id),
+		Secret:    cryptopasta.NewEncryptionKey(),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Current returns the key that should be used to sign or encrypt new data. It waits until keys are loaded from
+// etcd, and fails with ErrNotReady if they are not loaded in time.
+func (k *Keyring) Current() (*Key, error) {
+	select {
+	case <-k.ready:
+	case <-time.After(readyTimeout):
+		return nil, ErrNotReady.New("session keys are not loaded yet")
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, nil
+}
+
+// Get returns the key with the specified ID if it is still accepted, or nil otherwise.
+func (k *Keyring) Get(id string) *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current != nil && k.current.ID == id {
+		return k.current
+	}
+	if k.previous != nil && k.previous.ID == id && time.Since(k.previousRetiredAt) < GracePeriod {
+		return k.previous
+	}
+	return nil
+}
+
+// Lookup returns the key with the specified ID as long as it is kept, regardless of the grace period, or nil
+// otherwise. The previous key is kept until the next rotation, so data encrypted by a key can be decrypted for at
+// least RotationInterval unless keys are rotated manually.
+func (k *Keyring) Lookup(id string) *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.current != nil && k.current.ID == id {
+		return k.current
+	}
+	if k.previous != nil && k.previous.ID == id {
+		return k.previous
+	}
+	return nil
+}
+
+func (k *Keyring) syncLoop(ctx context.Context) {
+	ebo := backoff.NewExponentialBackOff()
+	ebo.MaxInterval = maxLoadInterval
+	ebo.MaxElapsedTime = 0
+	bo := backoff.WithContext(ebo, ctx)
+	if err := backoff.Retry(func() error { return k.sync(ctx) }, bo); err != nil {
+		log.Error("Failed to load session keys", zap.Error(err))
+		return
+	}
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.sync(ctx); err != nil {
+				log.Warn("Failed to sync session keys", zap.Error(err))
+			}
+		}
+	}
+}
+
+// sync loads keys from etcd, creating or rotating keys when necessary.
+func (k *Keyring) sync(ctx context.Context) error {
+	stored, rev, err := k.load(ctx)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		if err := k.create(ctx); err != nil && !errorx.IsOfType(err, ErrConflict) {
+			return err
+		}
+		// Either created by us or by another instance, load again.
+		if stored, rev, err = k.load(ctx); err != nil {
+			return err
+		}
+		if stored == nil {
+			return ErrUnableToAccess.New("session keys are missing after creation")
+		}
+	} else if time.Since(stored.Current.CreatedAt) > RotationInterval {
+		if err := k.rotate(ctx, stored, rev); err != nil && !errorx.IsOfType(err, ErrConflict) {
+			return err
+		}
+		if stored, _, err = k.load(ctx); err != nil {
+			return err
+		}
+	}
+	return k.apply(stored)
+}
+
+// Rotate generates a new key as the current key. The current key becomes the previous key and is still accepted
+// within the grace period.
+func (k *Keyring) Rotate(ctx context.Context) error {
+	stored, rev, err := k.load(ctx)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return ErrNotReady.New("session keys are not initialized")
+	}
+	if err := k.rotate(ctx, stored, rev); err != nil {
+		return err
+	}
+	stored, _, err = k.load(ctx)
+	if err != nil {
+		return err
+	}
+	return k.apply(stored)
+}
+
+func (k *Keyring) apply(stored *storedKeyring) error {
+	current, err := k.decryptStoredKey(&stored.Current)
+	if err != nil {
+		return err
+	}
+	var previous *Key
+	var previousRetiredAt time.Time
+	if stored.Previous != nil && stored.Previous.RetiredAt != nil {
+		previous, err = k.decryptStoredKey(stored.Previous)
+		if err != nil {
+			return err
+		}
+		previousRetiredAt = *stored.Previous.RetiredAt
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.loaded || k.current.ID != current.ID {
+		log.Info("Session key is loaded", zap.String("id", current.ID))
+	}
+	if !k.loaded {
+		close(k.ready)
+	}
+	k.loaded = true
+	k.current = current
+	k.previous = previous
+	k.previousRetiredAt = previousRetiredAt
+	return nil
+}
+
+// loadKEK returns DASHBOARD_SESSION_SECRET as the key to encrypt session keys stored in etcd. nil is returned when it
+// is not set or is invalid.
+func loadKEK() *[32]byte {
+	secretStr := os.Getenv(secretEnvVar)
+	switch len(secretStr) {
+	case 0:
+		return nil
+	case 32:
+		log.Info(secretEnvVar + " is overridden from env var")
+		kek := &[32]byte{}
+		copy(kek[:], secretStr)
+		return kek
+	default:
+		log.Warn(secretEnvVar + " does not meet the 32 byte size requirement, ignored")
+		return nil
+	}
+}
+
+// deriveKEK returns the key to encrypt session keys stored in etcd when DASHBOARD_SESSION_SECRET is not set.
+func deriveKEK(clusterID uint64) *[32]byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, clusterID)
+	kek := sha256.Sum256(append([]byte("tidb-dashboard-session-key/"), b...))
+	return &kek
+}
+
+func (k *Keyring) getKEK() *[32]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.kek
+}
+
+func (k *Keyring) decryptStoredKey(sk *storedKey) (*Key, error) {
+	encrypted, err := hex.DecodeString(sk.EncryptedSecret)
+	if err != nil {
+		return nil, ErrDecryptFailed.Wrap(err, "session key %s is broken", sk.ID)
+	}
+	plain, err := cryptopasta.Decrypt(encrypted, k.getKEK())
+	if err != nil || len(plain) != 32 {
+		return nil, ErrDecryptFailed.New("unable to decrypt session key %s, DASHBOARD_SESSION_SECRET may be different among instances", sk.ID)
+	}
+	secret := &[32]byte{}
+	copy(secret[:], plain)
+	return &Key{
+		ID:        sk.ID,
+		Secret:    secret,
+		CreatedAt: sk.CreatedAt,
+	}, nil
+}
+
+func (k *Keyring) encryptKey(key *Key) (*storedKey, error) {
+	encrypted, err := cryptopasta.Encrypt(key.Secret[:], k.getKEK())
+	if err != nil {
+		return nil, err
+	}
+	return &storedKey{
+		ID:              key.ID,
+		EncryptedSecret: hex.EncodeToString(encrypted),
+		CreatedAt:       key.CreatedAt,
+	}, nil
+}
+
+// load returns nil if keys do not exist in etcd. The mod revision is returned for compare-and-swap.
+func (k *Keyring) load(ctx context.Context) (*storedKeyring, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+	resp, err := k.etcdClient.Get(ctx, EtcdKeyPath)
+	if err != nil {
+		return nil, 0, ErrUnableToAccess.WrapWithNoMessage(err)
+	}
+	k.mu.Lock()
+	if k.kek == nil {
+		k.kek = deriveKEK(resp.Header.ClusterId)
+	}
+	k.mu.Unlock()
+	if len(resp.Kvs) == 0 {
+		return nil, 0, nil
+	}
+	var stored storedKeyring
+	if err := json.Unmarshal(resp.Kvs[0].Value, &stored); err != nil {
+		return nil, 0, ErrUnableToAccess.Wrap(err, "session keys are broken")
+	}
+	return &stored, resp.Kvs[0].ModRevision, nil
+}
+
+func (k *Keyring) create(ctx context.Context) error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	sk, err := k.encryptKey(key)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(storedKeyring{Current: *sk})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+	resp, err := k.etcdClient.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(EtcdKeyPath), "=", 0)).
+		Then(clientv3.OpPut(EtcdKeyPath, string(b))).
+		Commit()
+	if err != nil {
+		return ErrUnableToAccess.WrapWithNoMessage(err)
+	}
+	if !resp.Succeeded {
+		return ErrConflict.New("session keys are created by another instance")
+	}
+	log.Info("New session key is created", zap.String("id", key.ID))
+	return nil
+}
+
+func (k *Keyring) rotate(ctx context.Context, stored *storedKeyring, rev int64) error {
+	key, err := newKey()
+	if err != nil {
+		return err
+	}
+	sk, err := k.encryptKey(key)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	previous := stored.Current
+	previous.RetiredAt = &now
+	b, err := json.Marshal(storedKeyring{Current: *sk, Previous: &previous})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, etcdTimeout)
+	defer cancel()
+	resp, err := k.etcdClient.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(EtcdKeyPath), "=", rev)).
+		Then(clientv3.OpPut(EtcdKeyPath, string(b))).
+		Commit()
+	if err != nil {
+		return ErrUnableToAccess.WrapWithNoMessage(err)
+	}
+	if !resp.Succeeded {
+		return ErrConflict.New("session keys are rotated by another instance")
+	}
+	log.Info("Session key is rotated", zap.String("previous", previous.ID), zap.String("current", key.ID))
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package keyring
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gtank/cryptopasta"
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testKeyringSuite{})
+
+type testKeyringSuite struct{}
+
+func (t *testKeyringSuite) Test_StoredKeyRoundTrip(c *C) {
+	k := &Keyring{kek: cryptopasta.NewEncryptionKey()}
+	key, err := newKey()
+	c.Assert(err, IsNil)
+
+	sk, err := k.encryptKey(key)
+	c.Assert(err, IsNil)
+	c.Assert(sk.ID, Equals, key.ID)
+
+	decrypted, err := k.decryptStoredKey(sk)
+	c.Assert(err, IsNil)
+	c.Assert(*decrypted.Secret, Equals, *key.Secret)
+
+	// Keys encrypted with a different KEK cannot be loaded.
+	other := &Keyring{kek: cryptopasta.NewEncryptionKey()}
+	_, err = other.decryptStoredKey(sk)
+	c.Assert(err, NotNil)
+}
+
+func (t *testKeyringSuite) Test_GracePeriod(c *C) {
+	current, err := newKey()
+	c.Assert(err, IsNil)
+	previous, err := newKey()
+	c.Assert(err, IsNil)
+
+	k := &Keyring{
+		current:           current,
+		previous:          previous,
+		previousRetiredAt: time.Now().Add(-time.Hour),
+	}
+	c.Assert(k.Get(current.ID), Equals, current)
+	c.Assert(k.Get(previous.ID), Equals, previous)
+	c.Assert(k.Get("unknown"), IsNil)
+
+	// The previous key is no longer accepted after the grace period.
+	k.previousRetiredAt = time.Now().Add(-GracePeriod - time.Minute)
+	c.Assert(k.Get(previous.ID), IsNil)
+}
+
+func (t *testKeyringSuite) Test_loadKEK(c *C) {
+	defer os.Unsetenv(secretEnvVar)
+
+	os.Unsetenv(secretEnvVar)
+	c.Assert(loadKEK(), IsNil)
+
+	// Secrets of a wrong length are ignored.
+	os.Setenv(secretEnvVar, "too short")
+	c.Assert(loadKEK(), IsNil)
+
+	secret := "0123456789abcdef0123456789abcdef"
+	os.Setenv(secretEnvVar, secret)
+	kek := loadKEK()
+	c.Assert(kek, NotNil)
+	c.Assert(string(kek[:]), Equals, secret)
+}
+
+func (t *testKeyringSuite) Test_Lookup(c *C) {
+	current, err := newKey()
+	c.Assert(err, IsNil)
+	previous, err := newKey()
+	c.Assert(err, IsNil)
+
+	// Keys are not loaded yet.
+	k := &Keyring{}
+	c.Assert(k.Get(current.ID), IsNil)
+	c.Assert(k.Lookup(current.ID), IsNil)
+
+	k.current = current
+	k.previous = previous
+	k.previousRetiredAt = time.Now().Add(-GracePeriod - time.Minute)
+	c.Assert(k.Lookup(current.ID), Equals, current)
+	// The previous key is kept after the grace period.
+	c.Assert(k.Lookup(previous.ID), Equals, previous)
+	c.Assert(k.Lookup("unknown"), IsNil)
+}
+
+func (t *testKeyringSuite) Test_CurrentAfterApply(c *C) {
+	kek := cryptopasta.NewEncryptionKey()
+	k := &Keyring{kek: kek, ready: make(chan struct{})}
+	key, err := newKey()
+	c.Assert(err, IsNil)
+	sk, err := k.encryptKey(key)
+	c.Assert(err, IsNil)
+
+	c.Assert(k.apply(&storedKeyring{Current: *sk}), IsNil)
+	current, err := k.Current()
+	c.Assert(err, IsNil)
+	c.Assert(current.ID, Equals, key.ID)
+
+	// Applying again does not close the ready channel twice.
+	c.Assert(k.apply(&storedKeyring{Current: *sk}), IsNil)
+}
+
+func (t *testKeyringSuite) Test_deriveKEK(c *C) {
+	c.Assert(*deriveKEK(1), Equals, *deriveKEK(1))
+	c.Assert(*deriveKEK(1), Not(Equals), *deriveKEK(2))
+}
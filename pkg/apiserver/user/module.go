@@ -4,9 +4,11 @@ package user
 
 import (
 	"go.uber.org/fx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/keyring"
 )
 
 var Module = fx.Options(
-	fx.Provide(NewAuthService),
+	fx.Provide(keyring.NewKeyring, NewAuthService),
 	fx.Invoke(registerRouter),
 )
@@ -0,0 +1,178 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package user
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+// Permission is the name of an action that can be performed in a route group, in the form of `<group>:<action>`.
+type Permission string
+
+const (
+	PermProfilingStart    Permission = "profiling:start"
+	PermProfilingConfig   Permission = "profiling:config"
+	PermLogSearchConfig   Permission = "logsearch:config"
+	PermConprofConfig     Permission = "conprof:config"
+	PermConfigurationEdit Permission = "configuration:edit"
+	PermQueryEditorRun    Permission = "query_editor:run"
+	PermKeyVisualConfig   Permission = "keyvisual:config"
+	PermStatementConfig   Permission = "statement:config"
+	PermTopSQLConfig      Permission = "topsql:config"
+	PermMetricsConfig     Permission = "metrics:config"
+	PermSSOConfig         Permission = "sso:config"
+	PermRBACManage        Permission = "rbac:manage"
+	PermAuditView         Permission = "audit:view"
+	PermAuditConfig       Permission = "audit:config"
+	PermShareManage       Permission = "share:manage"
+	PermSessionKeyRotate  Permission = "session_key:rotate"
+	PermTopologyEdit      Permission = "topology:edit"
+	PermLogSearchRun      Permission = "logsearch:run"
+	PermDebugAPIRequest   Permission = "debug_api:request"
+)
+
+// AllPermissions lists all known permissions. Roles can only be granted permissions in this list.
+var AllPermissions = []Permission{
+	PermProfilingStart,
+	PermProfilingConfig,
+	PermLogSearchConfig,
+	PermConprofConfig,
+	PermConfigurationEdit,
+	PermQueryEditorRun,
+	PermKeyVisualConfig,
+	PermStatementConfig,
+	PermTopSQLConfig,
+	PermMetricsConfig,
+	PermSSOConfig,
+	PermRBACManage,
+	PermAuditView,
+	PermAuditConfig,
+	PermShareManage,
+	PermSessionKeyRotate,
+	PermTopologyEdit,
+	PermLogSearchRun,
+	PermDebugAPIRequest,
+}
+
+// ReadOnlyPermissions are the permissions that are still available when the session is not writeable,
+// e.g. a SQL user without write privileges or a sharing session with write privilege revoked.
+var ReadOnlyPermissions = []Permission{
+	PermProfilingStart,
+	PermLogSearchRun,
+	PermDebugAPIRequest,
+}
+
+func IsValidPermission(p Permission) bool {
+	for _, known := range AllPermissions {
+		if known == p {
+			return true
+		}
+	}
+	return false
+}
+
+// PermissionSet is a set of permissions granted to a session.
+type PermissionSet map[Permission]struct{}
+
+func NewPermissionSet(perms ...Permission) PermissionSet {
+	s := PermissionSet{}
+	s.Add(perms...)
+	return s
+}
+
+func (s PermissionSet) Add(perms ...Permission) {
+	for _, p := range perms {
+		s[p] = struct{}{}
+	}
+}
+
+func (s PermissionSet) Has(p Permission) bool {
+	_, ok := s[p]
+	return ok
+}
+
+// Intersect returns a new set containing permissions that exist in both sets.
+func (s PermissionSet) Intersect(other PermissionSet) PermissionSet {
+	r := PermissionSet{}
+	for p := range s {
+		if other.Has(p) {
+			r[p] = struct{}{}
+		}
+	}
+	return r
+}
+
+// List returns permissions in the set, ordered in the same way as AllPermissions.
+func (s PermissionSet) List() []Permission {
+	r := make([]Permission, 0, len(s))
+	for _, p := range AllPermissions {
+		if s.Has(p) {
+			r = append(r, p)
+		}
+	}
+	return r
+}
+
+// PermissionResolver resolves permissions granted to a session, usually according to the role bindings.
+// When ok is false, the resolver has no opinion about this session and legacy permissions will be used.
+type PermissionResolver interface {
+	ResolvePermissions(u *utils.SessionUser) (perms PermissionSet, ok bool, err error)
+}
+
+// legacyPermissions returns the permissions derived from the IsWriteable flag of the session only.
+func legacyPermissions(u *utils.SessionUser) PermissionSet {
+	if u.IsWriteable {
+		return NewPermissionSet(AllPermissions...)
+	}
+	return NewPermissionSet(ReadOnlyPermissions...)
+}
+
+// RegisterPermissionResolver sets the resolver used by MWRequirePermission. Only one resolver can be registered.
+func (s *AuthService) RegisterPermissionResolver(r PermissionResolver) {
+	s.permissionResolver = r
+}
+
+// GetPermissions returns the effective permissions of the session. Permissions granted by roles are always
+// capped by the IsWriteable flag of the session, so that a role never grants more than the SQL privileges.
+func (s *AuthService) GetPermissions(u *utils.SessionUser) (PermissionSet, error) {
+	legacy := legacyPermissions(u)
+	if s.permissionResolver == nil {
+		return legacy, nil
+	}
+	perms, ok, err := s.permissionResolver.ResolvePermissions(u)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return legacy, nil
+	}
+	return perms.Intersect(legacy), nil
+}
+
+// MWRequirePermission creates a middleware that aborts the request with a forbidden error if the current session
+// is not granted the specified permission. It must be used after MWAuthRequired.
+func (s *AuthService) MWRequirePermission(p Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := utils.GetSession(c)
+		if u == nil {
+			rest.Error(c, rest.ErrUnauthenticated.NewWithNoMessage())
+			c.Abort()
+			return
+		}
+		perms, err := s.GetPermissions(u)
+		if err != nil {
+			rest.Error(c, err)
+			c.Abort()
+			return
+		}
+		if !perms.Has(p) {
+			rest.Error(c, rest.ErrForbidden.New("Permission %s is required", p))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
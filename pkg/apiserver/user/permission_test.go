@@ -0,0 +1,88 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package user
+
+import (
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+var _ = Suite(&testPermissionSuite{})
+
+type testPermissionSuite struct{}
+
+type fakeResolver struct {
+	perms PermissionSet
+	ok    bool
+}
+
+func (r *fakeResolver) ResolvePermissions(u *utils.SessionUser) (PermissionSet, bool, error) {
+	return r.perms, r.ok, nil
+}
+
+func (t *testPermissionSuite) Test_GetPermissions(c *C) {
+	writeable := &utils.SessionUser{IsWriteable: true}
+	readOnly := &utils.SessionUser{IsWriteable: false}
+
+	s := &AuthService{}
+	perms, err := s.GetPermissions(writeable)
+	c.Assert(err, IsNil)
+	c.Assert(perms.List(), DeepEquals, AllPermissions)
+	perms, err = s.GetPermissions(readOnly)
+	c.Assert(err, IsNil)
+	c.Assert(perms.List(), DeepEquals, ReadOnlyPermissions)
+
+	// Resolver without opinion falls back to legacy permissions.
+	s.RegisterPermissionResolver(&fakeResolver{ok: false})
+	perms, err = s.GetPermissions(writeable)
+	c.Assert(err, IsNil)
+	c.Assert(perms.List(), DeepEquals, AllPermissions)
+
+	// Roles restrict permissions.
+	s.RegisterPermissionResolver(&fakeResolver{
+		perms: NewPermissionSet(PermProfilingStart, PermKeyVisualConfig),
+		ok:    true,
+	})
+	perms, err = s.GetPermissions(writeable)
+	c.Assert(err, IsNil)
+	c.Assert(perms.List(), DeepEquals, []Permission{PermProfilingStart, PermKeyVisualConfig})
+
+	// Roles never grant more than the session is allowed to write.
+	perms, err = s.GetPermissions(readOnly)
+	c.Assert(err, IsNil)
+	c.Assert(perms.List(), DeepEquals, []Permission{PermProfilingStart})
+}
+
+func (t *testPermissionSuite) Test_MWRequirePermission(c *C) {
+	gin.SetMode(gin.TestMode)
+	s := &AuthService{}
+	allowed := func(u *utils.SessionUser, p Permission) bool {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if u != nil {
+			ctx.Set(utils.SessionUserKey, u)
+		}
+		s.MWRequirePermission(p)(ctx)
+		return !ctx.IsAborted()
+	}
+	writeable := &utils.SessionUser{IsWriteable: true}
+	readOnly := &utils.SessionUser{IsWriteable: false}
+
+	c.Assert(allowed(nil, PermLogSearchRun), IsFalse)
+
+	// Editing the topology changes etcd, which needs write privileges.
+	c.Assert(allowed(writeable, PermTopologyEdit), IsTrue)
+	c.Assert(allowed(readOnly, PermTopologyEdit), IsFalse)
+
+	// Searching logs and requesting debug APIs only read diagnostic data.
+	c.Assert(allowed(readOnly, PermLogSearchRun), IsTrue)
+	c.Assert(allowed(readOnly, PermDebugAPIRequest), IsTrue)
+
+	s.RegisterPermissionResolver(&fakeResolver{perms: NewPermissionSet(PermLogSearchRun), ok: true})
+	c.Assert(allowed(writeable, PermLogSearchRun), IsTrue)
+	c.Assert(allowed(writeable, PermDebugAPIRequest), IsFalse)
+	c.Assert(allowed(writeable, PermTopologyEdit), IsFalse)
+}
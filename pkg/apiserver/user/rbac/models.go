@@ -0,0 +1,109 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package rbac
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type SubjectKind string
+
+const (
+	// SubjectKindSQLUser binds a role to the TiDB SQL user of the session.
+	SubjectKindSQLUser SubjectKind = "sql_user"
+	// SubjectKindSSOUser binds a role to the SSO user, identified by the email.
+	SubjectKindSSOUser SubjectKind = "sso_user"
+	// SubjectKindSSOGroup binds a role to a group in the `groups` claim returned by the SSO provider.
+	SubjectKindSSOGroup SubjectKind = "sso_group"
+	// SubjectKindDefault binds a role to all sessions that don't match any other bindings.
+	SubjectKindDefault SubjectKind = "default"
+)
+
+var SubjectKinds = []SubjectKind{SubjectKindSQLUser, SubjectKindSSOUser, SubjectKindSSOGroup, SubjectKindDefault}
+
+type PermissionList []user.Permission
+
+func (l *PermissionList) Scan(src interface{}) error {
+	return json.Unmarshal([]byte(src.(string)), l)
+}
+
+func (l PermissionList) Value() (driver.Value, error) {
+	val, err := json.Marshal(l)
+	return string(val), err
+}
+
+type RoleModel struct {
+	Name        string         `json:"name" gorm:"primary_key;size:64"`
+	Description string         `json:"description" gorm:"type:text"`
+	Permissions PermissionList `json:"permissions" gorm:"type:text"`
+	// BuiltIn roles are not persisted and cannot be modified.
+	BuiltIn bool `json:"built_in" gorm:"-"`
+}
+
+func (RoleModel) TableName() string {
+	return "rbac_roles"
+}
+
+type RoleBindingModel struct {
+	ID          uint        `json:"id" gorm:"primary_key"`
+	SubjectKind SubjectKind `json:"subject_kind" gorm:"size:32;uniqueIndex:subject_role"`
+	Subject     string      `json:"subject" gorm:"size:256;uniqueIndex:subject_role"`
+	Role        string      `json:"role" gorm:"size:64;index;uniqueIndex:subject_role"`
+}
+
+func (RoleBindingModel) TableName() string {
+	return "rbac_role_bindings"
+}
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// BuiltInRoles are always available and take precedence over custom roles with the same name.
+var BuiltInRoles = []RoleModel{
+	{
+		Name:        RoleViewer,
+		Description: "Can view all diagnostic data without changing anything in the cluster",
+		Permissions: user.ReadOnlyPermissions,
+		BuiltIn:     true,
+	},
+	{
+		Name:        RoleOperator,
+		Description: "Can additionally change dashboard feature settings, but not cluster configurations or data",
+		Permissions: append(PermissionList{
+			user.PermProfilingConfig,
+			user.PermLogSearchConfig,
+			user.PermConprofConfig,
+			user.PermKeyVisualConfig,
+			user.PermStatementConfig,
+			user.PermTopSQLConfig,
+			user.PermMetricsConfig,
+		}, user.ReadOnlyPermissions...),
+		BuiltIn: true,
+	},
+	{
+		Name:        RoleAdmin,
+		Description: "Can perform all actions",
+		Permissions: user.AllPermissions,
+		BuiltIn:     true,
+	},
+}
+
+func findBuiltInRole(name string) *RoleModel {
+	for i := range BuiltInRoles {
+		if BuiltInRoles[i].Name == name {
+			return &BuiltInRoles[i]
+		}
+	}
+	return nil
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&RoleModel{}, &RoleBindingModel{})
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package rbac
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
+)
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/user/rbac")
+	endpoint.Use(auth.MWAuthRequired())
+	endpoint.GET("/permissions", s.listPermissionsHandler)
+	endpoint.GET("/my_permissions", s.myPermissionsHandler(auth))
+	endpoint.GET("/roles", s.listRolesHandler)
+	endpoint.PUT("/roles", auth.MWRequirePermission(user.PermRBACManage), s.saveRoleHandler)
+	endpoint.DELETE("/roles/:name", auth.MWRequirePermission(user.PermRBACManage), s.deleteRoleHandler)
+	endpoint.GET("/bindings", s.listBindingsHandler)
+	endpoint.POST("/bindings", auth.MWRequirePermission(user.PermRBACManage), s.createBindingHandler)
+	endpoint.DELETE("/bindings/:id", auth.MWRequirePermission(user.PermRBACManage), s.deleteBindingHandler)
+}
+
+// errorStatus sets a proper HTTP status code for errors caused by the user input.
+func errorStatus(c *gin.Context, err error) {
+	rest.Error(c, err)
+	switch {
+	case errorx.IsOfType(err, ErrRoleNotFound), errorx.IsOfType(err, ErrBindingNotFound):
+		c.Status(http.StatusNotFound)
+	case errorx.IsOfType(err, ErrInvalidRole), errorx.IsOfType(err, ErrInvalidBinding),
+		errorx.IsOfType(err, ErrRoleBuiltIn), errorx.IsOfType(err, ErrRoleInUse):
+		c.Status(http.StatusBadRequest)
+	}
+}
+
+// @ID userRBACListPermissions
+// @Summary List all permissions that can be granted to roles
+// @Success 200 {array} string
+// @Router /user/rbac/permissions [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+func (s *Service) listPermissionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, user.AllPermissions)
+}
+
+// @ID userRBACGetMyPermissions
+// @Summary Get effective permissions of current session
+// @Success 200 {array} string
+// @Router /user/rbac/my_permissions [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) myPermissionsHandler(auth *user.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		perms, err := auth.GetPermissions(utils.GetSession(c))
+		if err != nil {
+			rest.Error(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, perms.List())
+	}
+}
+
+// @ID userRBACListRoles
+// @Summary List all roles, including built-in roles
+// @Success 200 {array} RoleModel
+// @Router /user/rbac/roles [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) listRolesHandler(c *gin.Context) {
+	roles, err := s.listRoles()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// @ID userRBACSaveRole
+// @Summary Create or replace a custom role
+// @Param request body RoleModel true "Request body"
+// @Success 200 {object} RoleModel
+// @Router /user/rbac/roles [put]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) saveRoleHandler(c *gin.Context) {
+	var req RoleModel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if err := s.saveRole(&req); err != nil {
+		errorStatus(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// @ID userRBACDeleteRole
+// @Summary Delete a custom role that is not bound to any subject
+// @Param name path string true "Role name"
+// @Success 200 {object} rest.EmptyResponse
+// @Router /user/rbac/roles/{name} [delete]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 404 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) deleteRoleHandler(c *gin.Context) {
+	if err := s.deleteRole(c.Param("name")); err != nil {
+		errorStatus(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rest.EmptyResponse{})
+}
+
+// @ID userRBACListBindings
+// @Summary List all role bindings
+// @Success 200 {array} RoleBindingModel
+// @Router /user/rbac/bindings [get]
+// @Security JwtAuth
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) listBindingsHandler(c *gin.Context) {
+	bindings, err := s.listBindings()
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, bindings)
+}
+
+// @ID userRBACCreateBinding
+// @Summary Bind a role to a SQL user, an SSO user, an SSO group or all other sessions
+// @Param request body RoleBindingModel true "Request body"
+// @Success 200 {object} RoleBindingModel
+// @Router /user/rbac/bindings [post]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 404 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) createBindingHandler(c *gin.Context) {
+	var req RoleBindingModel
+	if err := c.ShouldBindJSON(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	if err := s.createBinding(&req); err != nil {
+		errorStatus(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+// @ID userRBACDeleteBinding
+// @Summary Delete a role binding
+// @Param id path string true "Binding ID"
+// @Success 200 {object} rest.EmptyResponse
+// @Router /user/rbac/bindings/{id} [delete]
+// @Security JwtAuth
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Failure 403 {object} rest.ErrorResponse
+// @Failure 404 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+func (s *Service) deleteBindingHandler(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.New("Invalid binding id"))
+		return
+	}
+	if err := s.deleteBinding(uint(id)); err != nil {
+		errorStatus(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rest.EmptyResponse{})
+}
@@ -0,0 +1,216 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package rbac
+
+import (
+	"github.com/joomcode/errorx"
+	"go.uber.org/fx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var (
+	ErrNS              = errorx.NewNamespace("error.api.user.rbac")
+	ErrRoleNotFound    = ErrNS.NewType("role_not_found")
+	ErrRoleBuiltIn     = ErrNS.NewType("role_built_in")
+	ErrRoleInUse       = ErrNS.NewType("role_in_use")
+	ErrInvalidRole     = ErrNS.NewType("invalid_role")
+	ErrInvalidBinding  = ErrNS.NewType("invalid_binding")
+	ErrBindingNotFound = ErrNS.NewType("binding_not_found")
+)
+
+type ServiceParams struct {
+	fx.In
+	LocalStore *dbstore.DB
+}
+
+type Service struct {
+	params ServiceParams
+}
+
+func NewService(p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	return &Service{params: p}, nil
+}
+
+func registerPermissionResolver(s *Service, authService *user.AuthService) {
+	authService.RegisterPermissionResolver(s)
+}
+
+var Module = fx.Options(
+	fx.Provide(NewService),
+	fx.Invoke(registerPermissionResolver, registerRouter),
+)
+
+// ResolvePermissions implements user.PermissionResolver. Permissions of all roles bound to the session are merged.
+// If there is no binding matching the session, the `default` bindings are used. If there is still no binding,
+// the resolver has no opinion and legacy permissions will be used.
+func (s *Service) ResolvePermissions(u *utils.SessionUser) (user.PermissionSet, bool, error) {
+	roles, err := s.resolveRoles(u)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(roles) == 0 {
+		return nil, false, nil
+	}
+	perms := user.NewPermissionSet()
+	for _, name := range roles {
+		role, err := s.getRole(name)
+		if err != nil {
+			if errorx.IsOfType(err, ErrRoleNotFound) {
+				// The role may have been deleted, skip it.
+				continue
+			}
+			return nil, false, err
+		}
+		perms.Add(role.Permissions...)
+	}
+	return perms, true, nil
+}
+
+func (s *Service) resolveRoles(u *utils.SessionUser) ([]string, error) {
+	db := s.params.LocalStore.Model(&RoleBindingModel{})
+	cond := s.params.LocalStore.Where("1 = 0")
+	if u.HasTiDBAuth {
+		cond = cond.Or("subject_kind = ? AND subject = ?", SubjectKindSQLUser, u.TiDBUsername)
+	}
+	if len(u.OIDCIDToken) > 0 {
+		cond = cond.Or("subject_kind = ? AND subject = ?", SubjectKindSSOUser, u.DisplayName)
+		if len(u.SSOGroups) > 0 {
+			cond = cond.Or("subject_kind = ? AND subject IN ?", SubjectKindSSOGroup, u.SSOGroups)
+		}
+	}
+
+	var roles []string
+	if err := db.Where(cond).Pluck("role", &roles).Error; err != nil {
+		return nil, err
+	}
+	if len(roles) > 0 {
+		return roles, nil
+	}
+
+	err := s.params.LocalStore.
+		Model(&RoleBindingModel{}).
+		Where("subject_kind = ?", SubjectKindDefault).
+		Pluck("role", &roles).
+		Error
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (s *Service) getRole(name string) (*RoleModel, error) {
+	if r := findBuiltInRole(name); r != nil {
+		return r, nil
+	}
+	var role RoleModel
+	err := s.params.LocalStore.Where("name = ?", name).Limit(1).Find(&role).Error
+	if err != nil {
+		return nil, err
+	}
+	if role.Name == "" {
+		return nil, ErrRoleNotFound.New("role %s does not exist", name)
+	}
+	return &role, nil
+}
+
+func (s *Service) listRoles() ([]RoleModel, error) {
+	var custom []RoleModel
+	if err := s.params.LocalStore.Order("name").Find(&custom).Error; err != nil {
+		return nil, err
+	}
+	roles := make([]RoleModel, 0, len(BuiltInRoles)+len(custom))
+	roles = append(roles, BuiltInRoles...)
+	roles = append(roles, custom...)
+	return roles, nil
+}
+
+func validateRole(role *RoleModel) error {
+	if len(role.Name) == 0 || len(role.Name) > 64 {
+		return ErrInvalidRole.New("role name must be 1~64 characters")
+	}
+	for _, p := range role.Permissions {
+		if !user.IsValidPermission(p) {
+			return ErrInvalidRole.New("unknown permission %s", p)
+		}
+	}
+	return nil
+}
+
+// saveRole creates or replaces a custom role.
+func (s *Service) saveRole(role *RoleModel) error {
+	if findBuiltInRole(role.Name) != nil {
+		return ErrRoleBuiltIn.New("built-in role %s cannot be modified", role.Name)
+	}
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	role.BuiltIn = false
+	return s.params.LocalStore.Save(role).Error
+}
+
+func (s *Service) deleteRole(name string) error {
+	if findBuiltInRole(name) != nil {
+		return ErrRoleBuiltIn.New("built-in role %s cannot be deleted", name)
+	}
+	var count int64
+	err := s.params.LocalStore.Model(&RoleBindingModel{}).Where("role = ?", name).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrRoleInUse.New("role %s is still bound to %d subjects", name, count)
+	}
+	result := s.params.LocalStore.Where("name = ?", name).Delete(&RoleModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRoleNotFound.New("role %s does not exist", name)
+	}
+	return nil
+}
+
+func (s *Service) listBindings() ([]RoleBindingModel, error) {
+	var bindings []RoleBindingModel
+	err := s.params.LocalStore.Order("subject_kind, subject, role").Find(&bindings).Error
+	return bindings, err
+}
+
+func (s *Service) createBinding(b *RoleBindingModel) error {
+	validKind := false
+	for _, k := range SubjectKinds {
+		if k == b.SubjectKind {
+			validKind = true
+		}
+	}
+	if !validKind {
+		return ErrInvalidBinding.New("subject_kind must be in %v", SubjectKinds)
+	}
+	if b.SubjectKind == SubjectKindDefault {
+		b.Subject = ""
+	} else if len(b.Subject) == 0 {
+		return ErrInvalidBinding.New("subject cannot be empty")
+	}
+	if _, err := s.getRole(b.Role); err != nil {
+		return err
+	}
+	b.ID = 0
+	return s.params.LocalStore.Create(b).Error
+}
+
+func (s *Service) deleteBinding(id uint) error {
+	result := s.params.LocalStore.Where("id = ?", id).Delete(&RoleBindingModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBindingNotFound.New("role binding %d does not exist", id)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joomcode/errorx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/util/rest"
@@ -19,9 +20,9 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(auth.MWAuthRequired())
 	// TODO: Forbid modifying config when signed in as SSO.
 	endpoint.GET("/impersonations/list", s.listImpersonationHandler)
-	endpoint.POST("/impersonation", auth.MWRequireWritePriv(), s.createImpersonationHandler)
+	endpoint.POST("/impersonation", auth.MWRequirePermission(user.PermSSOConfig), s.createImpersonationHandler)
 	endpoint.GET("/config", s.getConfig)
-	endpoint.PUT("/config", auth.MWRequireWritePriv(), s.setConfig)
+	endpoint.PUT("/config", auth.MWRequirePermission(user.PermSSOConfig), s.setConfig)
 }
 
 type GetAuthURLRequest struct {
@@ -86,6 +87,7 @@ func (s *Service) createImpersonationHandler(c *gin.Context) {
 		return
 	}
 
+	audit.SetTargets(c, req.SQLUser)
 	rec, err := s.createImpersonation(req.SQLUser, req.Password)
 	if err != nil {
 		rest.Error(c, err)
@@ -154,7 +156,9 @@ func (s *Service) setConfig(c *gin.Context) {
 	}
 
 	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.SSO.CoreConfig)
 		dc.SSO = dConfig
+		audit.SetAfter(c, dc.SSO.CoreConfig)
 	}
 	if err := s.params.ConfigManager.Modify(opt); err != nil {
 		rest.Error(c, err)
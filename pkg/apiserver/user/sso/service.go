@@ -23,6 +23,7 @@ import (
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"golang.org/x/oauth2"
+	"gorm.io/gorm"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
@@ -198,9 +199,28 @@ func (s *Service) newSessionFromImpersonation(userInfo *oAuthUserInfo, idToken s
 		IsShareable:  true,
 		IsWriteable:  writeable && !dc.SSO.CoreConfig.IsReadOnly,
 		OIDCIDToken:  idToken,
+		SSOGroups:    userInfo.Groups,
 	}, nil
 }
 
+// CheckImpersonation returns an error if there is no valid SQL user impersonation for background jobs.
+func (s *Service) CheckImpersonation() error {
+	if _, _, err := s.getAndDecryptImpersonation(); err != nil {
+		return ErrInvalidImpersonateCredential.Wrap(err, "No valid SQL user impersonation")
+	}
+	return nil
+}
+
+// OpenImpersonatedSQLConn opens a SQL connection with the impersonated SQL user. It is used by background jobs which
+// are not bound to any session, e.g. the statement archiver.
+func (s *Service) OpenImpersonatedSQLConn() (*gorm.DB, error) {
+	userName, password, err := s.getAndDecryptImpersonation()
+	if err != nil {
+		return nil, ErrInvalidImpersonateCredential.Wrap(err, "No valid SQL user impersonation")
+	}
+	return s.params.TiDBClient.OpenSQLConn(userName, password)
+}
+
 func (s *Service) createImpersonation(userName string, password string) (*SSOImpersonationModel, error) {
 	{
 		// Check whether this user can access dashboard
@@ -384,8 +404,9 @@ func (s *Service) exchangeOAuthCode(redirectURL string, code string, codeVerifie
 }
 
 type oAuthUserInfo struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Name   string   `json:"name"`
+	Email  string   `json:"email"`
+	Groups []string `json:"groups"`
 }
 
 func (s *Service) oAuthGetUserInfo(accessToken string) (*oAuthUserInfo, error) {
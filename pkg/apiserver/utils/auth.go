@@ -24,12 +24,16 @@ type SessionUser struct {
 	TiDBUsername string
 	TiDBPassword string
 
-	// This field only exists for CodeAuth.
+	// These fields only exist for CodeAuth.
 	SharedSessionExpireAt time.Time `msgpack:"-" json:",omitempty"`
+	SharingCodeID         string    `msgpack:"-" json:",omitempty"`
 
 	// This field only exists for SSOAuth
 	OIDCIDToken string `json:",omitempty"`
 
+	// This field only exists for SSOAuth. It is used to resolve roles bound to SSO groups.
+	SSOGroups []string `json:",omitempty"`
+
 	// These fields should not be updated by individual authenticators.
 	AuthFrom AuthType `msgpack:"-" json:",omitempty"`
 
@@ -3,141 +3,92 @@
 package utils
 
 import (
-	"encoding/base64"
-	"encoding/csv"
-	"fmt"
+	"compress/gzip"
 	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"reflect"
-	"strings"
 	"time"
 
-	"github.com/Xeoncross/go-aesctr-with-hmac"
-	"github.com/gin-gonic/gin"
-	"github.com/gtank/cryptopasta"
-	"github.com/oleiade/reflections"
-	"github.com/pingcap/log"
-	"go.uber.org/zap"
-
+	"github.com/pingcap/tidb-dashboard/util/csvutil"
 	"github.com/pingcap/tidb-dashboard/util/rest"
+	"github.com/pingcap/tidb-dashboard/util/rest/fileswap"
 )
 
-// TODO: Better to be a streaming interface.
-func GenerateCSVFromRaw(rawData []interface{}, fields []string, timeFields []string) (data [][]string) {
-	timeFieldsMap := make(map[string]struct{})
-	for _, f := range timeFields {
-		timeFieldsMap[f] = struct{}{}
-	}
+const exportTokenExpire = 24 * time.Hour
 
-	fieldsMap := make(map[string]string)
-	t := reflect.TypeOf(rawData[0])
-	fieldsNum := t.NumField()
-	allFields := make([]string, fieldsNum)
-	for i := 0; i < fieldsNum; i++ {
-		field := t.Field(i)
-		allFields[i] = strings.ToLower(field.Tag.Get("json"))
-		fieldsMap[allFields[i]] = field.Name
-	}
-	if len(fields) == 1 && fields[0] == "*" {
-		fields = allFields
-	}
-
-	data = [][]string{fields}
-	timeLayout := "01-02 15:04:05"
-	for _, overview := range rawData {
-		row := []string{}
-		for _, field := range fields {
-			fieldName := fieldsMap[field]
-			s, _ := reflections.GetField(overview, fieldName)
-			var val string
-			switch t := s.(type) {
-			case int:
-				if _, ok := timeFieldsMap[field]; ok {
-					val = time.Unix(int64(t), 0).Format(timeLayout)
-				} else {
-					val = fmt.Sprintf("%d", t)
-				}
-			case uint:
-				val = fmt.Sprintf("%d", t)
-			case float64:
-				val = fmt.Sprintf("%f", t)
-			default:
-				val = fmt.Sprintf("%s", t)
-			}
-			row = append(row, val)
-		}
-		data = append(data, row)
-	}
-	return
+type ExportOptions struct {
+	// Format is one of csv, jsonl and parquet. csv is used when it is empty.
+	Format string `json:"format" form:"format"`
+	// Gzip compresses the exported file.
+	Gzip bool `json:"gzip" form:"gzip"`
 }
 
-// TODO: Better to be a streaming interface.
-func ExportCSV(data [][]string, filename, tokenNamespace string) (token string, err error) {
-	csvFile, err := ioutil.TempFile("", filename)
-	if err != nil {
-		return
-	}
-	defer csvFile.Close() // #nosec
-
-	// generate encryption key
-	secretKey := *cryptopasta.NewEncryptionKey()
-
-	pr, pw := io.Pipe()
-	go func() {
-		csvwriter := csv.NewWriter(pw)
-		_ = csvwriter.WriteAll(data)
-		_ = pw.Close()
-	}()
-	err = aesctr.Encrypt(pr, csvFile, secretKey[0:16], secretKey[16:])
-	if err != nil {
-		return
+func (o *ExportOptions) Validate() error {
+	switch o.Format {
+	case "":
+		o.Format = csvutil.FormatCSV
+	case csvutil.FormatCSV, csvutil.FormatJSONL, csvutil.FormatParquet:
+	default:
+		return rest.ErrBadRequest.New("unsupported export format %s", o.Format)
 	}
-
-	// generate token by filepath and secretKey
-	secretKeyStr := base64.StdEncoding.EncodeToString(secretKey[:])
-	token, err = NewJWTString(tokenNamespace, secretKeyStr+" "+csvFile.Name())
-	return
+	return nil
 }
 
-// FIXME: Remove or refine this function, as it is not general.
-func DownloadByToken(token, tokenNamespace string, c *gin.Context) {
-	tokenPlain, err := ParseJWTString(tokenNamespace, token)
-	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
-		return
-	}
-	arr := strings.Fields(tokenPlain)
-	if len(arr) != 2 {
-		rest.Error(c, rest.ErrBadRequest.New("invalid token"))
-		return
-	}
-	secretKey, err := base64.StdEncoding.DecodeString(arr[0])
-	if err != nil {
-		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
-		return
+// ExportRows streams rows into an encrypted temporary file without holding them in memory, and returns a token for
+// downloading the file by fSwap.HandleDownloadRequest. `iterate` calls `write` for each row, which must be a struct
+// of the same type as rowType. Fields are selected by json names, see csvutil.NewColumns. An empty token is returned
+// when there is no row.
+func ExportRows(
+	fSwap *fileswap.Handler,
+	opts ExportOptions,
+	fileName string,
+	rowType interface{},
+	fields []string,
+	timeFields []string,
+	iterate func(write func(row interface{}) error) error,
+) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
 	}
+	fileName += "." + opts.Format
 
-	filePath := arr[1]
-	fileInfo, err := os.Stat(filePath)
+	fw, err := fSwap.NewFileWriter("export_*")
 	if err != nil {
-		rest.Error(c, err)
-		return
+		return "", err
 	}
-	f, err := os.Open(filepath.Clean(filePath))
-	if err != nil {
-		rest.Error(c, err)
-		return
+	var w io.Writer = fw
+	var gw *gzip.Writer
+	if opts.Gzip {
+		gw = gzip.NewWriter(fw)
+		w = gw
+		fileName += ".gz"
 	}
 
-	c.Writer.Header().Set("Content-type", "text/csv")
-	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileInfo.Name()))
-	err = aesctr.Decrypt(f, c.Writer, secretKey[0:16], secretKey[16:])
-	if err != nil {
-		log.Error("decrypt csv failed", zap.Error(err))
+	rowCount := 0
+	err = func() error {
+		rw, err := csvutil.NewRowWriter(opts.Format, w, csvutil.NewColumns(reflect.TypeOf(rowType), fields, timeFields))
+		if err != nil {
+			return err
+		}
+		err = iterate(func(row interface{}) error {
+			rowCount++
+			return rw.WriteRow(row)
+		})
+		if err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		if gw != nil {
+			if err := gw.Close(); err != nil {
+				return err
+			}
+		}
+		return fw.Close()
+	}()
+	if err != nil || rowCount == 0 {
+		fw.Remove()
+		return "", err
 	}
-	// delete it anyway
-	_ = f.Close()
-	_ = os.Remove(filePath)
+	return fw.GetDownloadToken(fileName, exportTokenExpire)
 }
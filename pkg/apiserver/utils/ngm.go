@@ -17,6 +17,7 @@ import (
 	"golang.org/x/sync/singleflight"
 
 	"github.com/pingcap/tidb-dashboard/pkg/utils/topology"
+	"github.com/pingcap/tidb-dashboard/util/client/httpclient"
 	"github.com/pingcap/tidb-dashboard/util/rest"
 )
 
@@ -48,10 +49,14 @@ type NgmProxy struct {
 	etcdClient   *clientv3.Client
 	ngmReqGroup  singleflight.Group
 	ngmAddrCache atomic.Value
+	httpClient   *httpclient.Client
 }
 
 func NewNgmProxy(lc fx.Lifecycle, etcdClient *clientv3.Client) (*NgmProxy, error) {
-	s := &NgmProxy{etcdClient: etcdClient}
+	s := &NgmProxy{
+		etcdClient: etcdClient,
+		httpClient: httpclient.New(httpclient.Config{KindTag: "ngm"}),
+	}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			s.lifecycleCtx = ctx
@@ -78,6 +83,25 @@ func (n *NgmProxy) Route(targetPath string) gin.HandlerFunc {
 	}
 }
 
+// Available returns whether NgMonitoring is registered and alive.
+func (n *NgmProxy) Available() bool {
+	_, err := n.getNgmAddrFromCache()
+	return err == nil
+}
+
+// GetJSON sends a GET request to the NgMonitoring API and decodes the JSON response into the result.
+func (n *NgmProxy) GetJSON(ctx context.Context, targetPath string, query url.Values, result interface{}) error {
+	ngmAddr, err := n.getNgmAddrFromCache()
+	if err != nil {
+		return err
+	}
+	_, err = n.httpClient.LR().
+		SetContext(ctx).
+		Get(fmt.Sprintf("%s%s?%s", ngmAddr, targetPath, query.Encode())).
+		ReadBodyAsJSON(result)
+	return err
+}
+
 func (n *NgmProxy) getNgmAddrFromCache() (string, error) {
 	fn := func() (string, error) {
 		// Check whether cache is valid, and use the cache if possible.
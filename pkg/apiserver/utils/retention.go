@@ -0,0 +1,64 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+// RetentionItem is a group of stored artifacts which are deleted together, e.g. a profiling task group.
+type RetentionItem struct {
+	ID uint
+	// CreatedAt is zero if the creation time is unknown, in which case the item never expires by age.
+	CreatedAt time.Time
+	Bytes     int64
+}
+
+// StorageUsage summarizes stored artifacts of a feature.
+type StorageUsage struct {
+	Groups      int   `json:"groups"`
+	TotalBytes  int64 `json:"total_bytes"`
+	OldestGroup int64 `json:"oldest_group"` // Unix timestamp in seconds, 0 if there is no group
+}
+
+func NewStorageUsage(items []RetentionItem) StorageUsage {
+	usage := StorageUsage{Groups: len(items)}
+	for _, item := range items {
+		usage.TotalBytes += item.Bytes
+		if !item.CreatedAt.IsZero() && (usage.OldestGroup == 0 || item.CreatedAt.Unix() < usage.OldestGroup) {
+			usage.OldestGroup = item.CreatedAt.Unix()
+		}
+	}
+	return usage
+}
+
+// SelectExpiredItems returns IDs of items to be deleted. Items are kept from the newest one, until an item is older
+// than MaxAgeHours, or MaxGroups items are kept, or the total size of kept items exceeds MaxTotalBytes. The newest
+// item is kept unless it is older than MaxAgeHours, even if it alone exceeds MaxTotalBytes.
+func SelectExpiredItems(items []RetentionItem, cfg *config.RetentionConfig, now time.Time) []uint {
+	sorted := make([]RetentionItem, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	expireBefore := now.Add(-time.Duration(cfg.MaxAgeHours) * time.Hour)
+	var expired []uint
+	var totalBytes uint64
+	kept := uint(0)
+	for _, item := range sorted {
+		if item.Bytes > 0 {
+			totalBytes += uint64(item.Bytes)
+		}
+		if (!item.CreatedAt.IsZero() && item.CreatedAt.Before(expireBefore)) ||
+			(kept > 0 && (kept >= cfg.MaxGroups || totalBytes > cfg.MaxTotalBytes)) {
+			expired = append(expired, item.ID)
+			continue
+		}
+		kept++
+	}
+	return expired
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package utils
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+var _ = Suite(&testRetentionSuite{})
+
+type testRetentionSuite struct{}
+
+func (t *testRetentionSuite) Test_SelectExpiredItems(c *C) {
+	now := time.Now()
+	items := []RetentionItem{
+		{ID: 1, CreatedAt: now.Add(-50 * time.Hour), Bytes: 10},
+		{ID: 2, CreatedAt: now.Add(-3 * time.Hour), Bytes: 10},
+		{ID: 3, CreatedAt: now.Add(-1 * time.Hour), Bytes: 10},
+		{ID: 4, CreatedAt: now.Add(-2 * time.Hour), Bytes: 10},
+		{ID: 5, Bytes: 10},
+	}
+	unlimited := config.RetentionConfig{MaxAgeHours: 1000, MaxTotalBytes: 1000, MaxGroups: 1000}
+	c.Assert(SelectExpiredItems(items, &unlimited, now), HasLen, 0)
+
+	cfg := unlimited
+	cfg.MaxAgeHours = 24
+	c.Assert(SelectExpiredItems(items, &cfg, now), DeepEquals, []uint{1})
+
+	cfg = unlimited
+	cfg.MaxGroups = 2
+	c.Assert(SelectExpiredItems(items, &cfg, now), DeepEquals, []uint{2, 1, 5})
+
+	cfg = unlimited
+	cfg.MaxTotalBytes = 35
+	c.Assert(SelectExpiredItems(items, &cfg, now), DeepEquals, []uint{1, 5})
+
+	cfg = unlimited
+	cfg.MaxTotalBytes = 5
+	c.Assert(SelectExpiredItems(items, &cfg, now), DeepEquals, []uint{4, 2, 1, 5})
+
+	cfg = unlimited
+	cfg.MaxAgeHours = 24
+	cfg.MaxTotalBytes = 5
+	c.Assert(SelectExpiredItems(items[:1], &cfg, now), DeepEquals, []uint{1})
+
+	usage := NewStorageUsage(items)
+	c.Assert(usage.Groups, Equals, 5)
+	c.Assert(usage.TotalBytes, Equals, int64(50))
+	c.Assert(usage.OldestGroup, Equals, items[0].CreatedAt.Unix())
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/joomcode/errorx"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/util/featureflag"
@@ -53,6 +54,7 @@ type GenerateVisualPlanRequest struct {
 // // @Failure 401 {object} rest.ErrorResponse
 // // @Failure 500 {object} rest.ErrorResponse.
 func (s *Service) GenerateVisualPlan(c *gin.Context) {
+	audit.Skip(c)
 	var bp GenerateVisualPlanRequest
 	if err := c.ShouldBindJSON(&bp); err != nil {
 		rest.Error(c, err)
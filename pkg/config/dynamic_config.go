@@ -3,22 +3,69 @@
 package config
 
 import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
 )
 
 const (
-	KeyVisualDBPolicy = "db"
-	KeyVisualKVPolicy = "kv"
+	KeyVisualDBPolicy   = "db"
+	KeyVisualKVPolicy   = "kv"
+	KeyVisualRulePolicy = "rule"
+
+	KeyVisualRuleFixed   = "fixed"
+	KeyVisualRuleRegex   = "regex"
+	KeyVisualRuleSegment = "segment"
+
+	KeyVisualSegmentUintBE = "uint_be"
+	KeyVisualSegmentUintLE = "uint_le"
+	KeyVisualSegmentString = "string"
+	KeyVisualSegmentHex    = "hex"
+
+	// KeyVisualSegmentPlaceholder is replaced by the segment in the label of a segment rule.
+	KeyVisualSegmentPlaceholder = "%s"
 
 	DefaultKeyVisualPolicy = KeyVisualDBPolicy
 
 	DefaultProfilingAutoCollectionDurationSecs = 30
 	MaxProfilingAutoCollectionDurationSecs     = 120
 	DefaultProfilingAutoCollectionIntervalSecs = 3600
+
+	DefaultProfilingRetentionMaxAgeHours   = 7 * 24
+	DefaultProfilingRetentionMaxTotalBytes = 1 << 30
+	DefaultProfilingRetentionMaxGroups     = 100
+
+	DefaultLogSearchRetentionMaxAgeHours   = 7 * 24
+	DefaultLogSearchRetentionMaxTotalBytes = 4 << 30
+	DefaultLogSearchRetentionMaxGroups     = 100
+
+	DefaultConprofProfileSeconds       = 10
+	DefaultConprofIntervalSeconds      = 60
+	DefaultConprofTimeoutSeconds       = 120
+	DefaultConprofDataRetentionSeconds = 3 * 24 * 60 * 60
+	DefaultConprofDataRetentionBytes   = 1 << 30
+
+	DefaultStatementArchiveRetentionDays        = 35
+	DefaultStatementArchiveDownsampleAfterHours = 48
+	DefaultStatementArchiveDownsampleWindowMins = 60
+
+	// StatementArchiveCredentialSSOImpersonation makes the archiver connect to TiDB as the SQL user configured for
+	// SSO impersonation.
+	StatementArchiveCredentialSSOImpersonation = "sso_impersonation"
+
+	DefaultAuditRetentionDays = 90
+	MaxAuditRetentionDays     = 3650
 )
 
 var (
-	KeyVisualPolicies = []string{KeyVisualDBPolicy, KeyVisualKVPolicy}
+	KeyVisualPolicies = []string{KeyVisualDBPolicy, KeyVisualKVPolicy, KeyVisualRulePolicy}
+
+	StatementArchiveCredentialSources = []string{StatementArchiveCredentialSSOImpersonation}
 
 	ErrVerificationFailed = ErrorNS.NewType("verification failed")
 )
@@ -27,6 +74,71 @@ type KeyVisualConfig struct {
 	AutoCollectionDisabled bool   `json:"auto_collection_disabled"`
 	Policy                 string `json:"policy"`
 	PolicyKVSeparator      string `json:"policy_kv_separator"`
+	// PolicyRules are used by the rule policy. Labels generated by all matching rules are concatenated in order.
+	PolicyRules []KeyVisualLabelRule `json:"policy_rules"`
+	// PolicyRulesTxnKey indicates that keys are written by the transactional API, thus they are decoded from the
+	// memcomparable format before applying PolicyRules.
+	PolicyRulesTxnKey bool `json:"policy_rules_txn_key"`
+}
+
+// KeyVisualLabelRule generates labels for keys with the given prefix.
+type KeyVisualLabelRule struct {
+	// Prefix is the hex encoded prefix of keys that the rule applies to. Empty means all keys.
+	Prefix string `json:"prefix"`
+	// Type is one of KeyVisualRuleFixed, KeyVisualRuleRegex and KeyVisualRuleSegment.
+	Type string `json:"type"`
+	// Label is the label of a fixed rule, or an optional template of the segment rule, in which `%s` is replaced by
+	// the segment, e.g. `tenant_%s`.
+	Label string `json:"label"`
+	// Pattern is the regular expression of a regex rule. Each capture group generates a label, or the whole match
+	// generates a label if there is no capture group.
+	Pattern string `json:"pattern"`
+	// Offset and Width locate the bytes of a segment rule in the key.
+	Offset int `json:"offset"`
+	Width  int `json:"width"`
+	// Encoding is how the segment is decoded, one of KeyVisualSegmentUintBE, KeyVisualSegmentUintLE,
+	// KeyVisualSegmentString and KeyVisualSegmentHex.
+	Encoding string `json:"encoding"`
+	// Border indicates that keys with different labels generated by this rule belong to different logical ranges,
+	// which are never merged in the heatmap.
+	Border bool `json:"border"`
+}
+
+func (r *KeyVisualLabelRule) validate() error {
+	if _, err := hex.DecodeString(r.Prefix); err != nil {
+		return ErrVerificationFailed.New("prefix of label rule must be hex encoded")
+	}
+	switch r.Type {
+	case KeyVisualRuleFixed:
+		if r.Label == "" {
+			return ErrVerificationFailed.New("label of fixed label rule cannot be empty")
+		}
+	case KeyVisualRuleRegex:
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return ErrVerificationFailed.New("invalid pattern of regex label rule: %s", err.Error())
+		}
+	case KeyVisualRuleSegment:
+		if r.Offset < 0 || r.Width <= 0 {
+			return ErrVerificationFailed.New("offset of segment label rule cannot be negative and width must be positive")
+		}
+		if r.Label != "" && !strings.Contains(r.Label, KeyVisualSegmentPlaceholder) {
+			return ErrVerificationFailed.New("label of segment label rule must contain %s", KeyVisualSegmentPlaceholder)
+		}
+		switch r.Encoding {
+		case KeyVisualSegmentUintBE, KeyVisualSegmentUintLE:
+			if r.Width > 8 {
+				return ErrVerificationFailed.New("width of uint segment label rule cannot be greater than 8")
+			}
+		case KeyVisualSegmentString, KeyVisualSegmentHex:
+		default:
+			return ErrVerificationFailed.New("encoding of segment label rule must be in %v",
+				[]string{KeyVisualSegmentUintBE, KeyVisualSegmentUintLE, KeyVisualSegmentString, KeyVisualSegmentHex})
+		}
+	default:
+		return ErrVerificationFailed.New("type of label rule must be in %v",
+			[]string{KeyVisualRuleFixed, KeyVisualRuleRegex, KeyVisualRuleSegment})
+	}
+	return nil
 }
 
 func (c *KeyVisualConfig) validatePolicy() error {
@@ -38,10 +150,195 @@ func (c *KeyVisualConfig) validatePolicy() error {
 	return ErrVerificationFailed.New("policy must be in %v", KeyVisualPolicies)
 }
 
+// adjustRules drops invalid label rules. The default policy is used if no rule is left for the rule policy.
+func (c *KeyVisualConfig) adjustRules() {
+	rules := make([]KeyVisualLabelRule, 0, len(c.PolicyRules))
+	for _, r := range c.PolicyRules {
+		if err := r.validate(); err != nil {
+			log.Warn("Invalid key visual label rule is dropped", zap.String("prefix", r.Prefix), zap.Error(err))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	c.PolicyRules = rules
+	if c.Policy == KeyVisualRulePolicy && len(c.PolicyRules) == 0 {
+		log.Warn("No valid key visual label rule, use the default policy", zap.String("policy", DefaultKeyVisualPolicy))
+		c.Policy = DefaultKeyVisualPolicy
+	}
+}
+
+func (c *KeyVisualConfig) validateRules() error {
+	if c.Policy != KeyVisualRulePolicy {
+		return nil
+	}
+	if len(c.PolicyRules) == 0 {
+		return ErrVerificationFailed.New("policy_rules cannot be empty when policy is %s", KeyVisualRulePolicy)
+	}
+	for i := range c.PolicyRules {
+		if err := c.PolicyRules[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type ProfilingConfig struct {
 	AutoCollectionTargets      []model.RequestTargetNode `json:"auto_collection_targets"`
 	AutoCollectionDurationSecs uint                      `json:"auto_collection_duration_secs"`
 	AutoCollectionIntervalSecs uint                      `json:"auto_collection_interval_secs"`
+	Retention                  RetentionConfig           `json:"retention"`
+}
+
+// RetentionConfig limits stored artifacts, e.g. profiling results. Task groups exceeding any limit are deleted,
+// starting from the oldest one.
+type RetentionConfig struct {
+	MaxAgeHours   uint   `json:"max_age_hours"`
+	MaxTotalBytes uint64 `json:"max_total_bytes"`
+	MaxGroups     uint   `json:"max_groups"`
+}
+
+func (c *RetentionConfig) validate() error {
+	if c.MaxAgeHours == 0 {
+		return ErrVerificationFailed.New("max_age_hours cannot be 0")
+	}
+	if c.MaxTotalBytes == 0 {
+		return ErrVerificationFailed.New("max_total_bytes cannot be 0")
+	}
+	if c.MaxGroups == 0 {
+		return ErrVerificationFailed.New("max_groups cannot be 0")
+	}
+	return nil
+}
+
+func (c *RetentionConfig) adjust(defaults RetentionConfig) {
+	if c.MaxAgeHours == 0 {
+		c.MaxAgeHours = defaults.MaxAgeHours
+	}
+	if c.MaxTotalBytes == 0 {
+		c.MaxTotalBytes = defaults.MaxTotalBytes
+	}
+	if c.MaxGroups == 0 {
+		c.MaxGroups = defaults.MaxGroups
+	}
+}
+
+type LogSearchConfig struct {
+	Retention RetentionConfig `json:"retention"`
+}
+
+// ContinuousProfilingConfig is used by the built-in continuous profiler when NgMonitoring is not deployed. It has the
+// same shape as the continuous profiling config of NgMonitoring, plus DataRetentionBytes which limits the total size
+// of profiles stored locally.
+type ContinuousProfilingConfig struct {
+	Enable               bool   `json:"enable"`
+	ProfileSeconds       int    `json:"profile_seconds"`
+	IntervalSeconds      int    `json:"interval_seconds"`
+	TimeoutSeconds       int    `json:"timeout_seconds"`
+	DataRetentionSeconds int    `json:"data_retention_seconds"`
+	DataRetentionBytes   uint64 `json:"data_retention_bytes"`
+}
+
+func (c *ContinuousProfilingConfig) validate() error {
+	if c.ProfileSeconds <= 0 {
+		return ErrVerificationFailed.New("profile_seconds must be positive")
+	}
+	if c.IntervalSeconds < c.ProfileSeconds {
+		return ErrVerificationFailed.New("interval_seconds cannot be less than profile_seconds")
+	}
+	if c.TimeoutSeconds <= c.ProfileSeconds {
+		return ErrVerificationFailed.New("timeout_seconds must be greater than profile_seconds")
+	}
+	if c.DataRetentionSeconds <= 0 {
+		return ErrVerificationFailed.New("data_retention_seconds must be positive")
+	}
+	if c.DataRetentionBytes == 0 {
+		return ErrVerificationFailed.New("data_retention_bytes cannot be 0")
+	}
+	return nil
+}
+
+func (c *ContinuousProfilingConfig) adjust() {
+	if c.ProfileSeconds <= 0 {
+		c.ProfileSeconds = DefaultConprofProfileSeconds
+	}
+	// Defaults are raised for a long profile, so that profiles never overlap and each has the default margin to
+	// finish.
+	if c.IntervalSeconds < c.ProfileSeconds {
+		c.IntervalSeconds = DefaultConprofIntervalSeconds
+		if c.IntervalSeconds < c.ProfileSeconds {
+			c.IntervalSeconds = c.ProfileSeconds
+		}
+	}
+	if c.TimeoutSeconds <= c.ProfileSeconds {
+		c.TimeoutSeconds = DefaultConprofTimeoutSeconds
+		if c.TimeoutSeconds <= c.ProfileSeconds {
+			c.TimeoutSeconds = c.ProfileSeconds + DefaultConprofTimeoutSeconds - DefaultConprofProfileSeconds
+		}
+	}
+	if c.DataRetentionSeconds <= 0 {
+		c.DataRetentionSeconds = DefaultConprofDataRetentionSeconds
+	}
+	if c.DataRetentionBytes == 0 {
+		c.DataRetentionBytes = DefaultConprofDataRetentionBytes
+	}
+}
+
+// StatementArchiveConfig controls the local archive of statement summary history. Windows older than
+// DownsampleAfterHours are merged into DownsampleWindowMins windows, and windows older than RetentionDays are deleted.
+type StatementArchiveConfig struct {
+	Enable bool `json:"enable"`
+	// CredentialSource is where the archiver gets the SQL credential to read statements from TiDB. It is one of
+	// StatementArchiveCredentialSources and is required when the archive is enabled.
+	CredentialSource     string `json:"credential_source"`
+	RetentionDays        uint   `json:"retention_days"`
+	DownsampleAfterHours uint   `json:"downsample_after_hours"`
+	DownsampleWindowMins uint   `json:"downsample_window_mins"`
+}
+
+func (c *StatementArchiveConfig) validate() error {
+	if c.CredentialSource == "" {
+		if c.Enable {
+			return ErrVerificationFailed.New("credential_source is required when the archive is enabled")
+		}
+	} else if err := c.validateCredentialSource(); err != nil {
+		return err
+	}
+	if c.RetentionDays == 0 {
+		return ErrVerificationFailed.New("retention_days cannot be 0")
+	}
+	if c.DownsampleAfterHours == 0 {
+		return ErrVerificationFailed.New("downsample_after_hours cannot be 0")
+	}
+	if c.DownsampleWindowMins == 0 {
+		return ErrVerificationFailed.New("downsample_window_mins cannot be 0")
+	}
+	return nil
+}
+
+func (c *StatementArchiveConfig) validateCredentialSource() error {
+	for _, s := range StatementArchiveCredentialSources {
+		if s == c.CredentialSource {
+			return nil
+		}
+	}
+	return ErrVerificationFailed.New("credential_source must be in %v", StatementArchiveCredentialSources)
+}
+
+func (c *StatementArchiveConfig) adjust() {
+	if c.Enable && c.CredentialSource == "" {
+		// The archive of the old version borrowed the SSO impersonation implicitly. It must be enabled again with an
+		// explicit credential source.
+		c.Enable = false
+	}
+	if c.RetentionDays == 0 {
+		c.RetentionDays = DefaultStatementArchiveRetentionDays
+	}
+	if c.DownsampleAfterHours == 0 {
+		c.DownsampleAfterHours = DefaultStatementArchiveDownsampleAfterHours
+	}
+	if c.DownsampleWindowMins == 0 {
+		c.DownsampleWindowMins = DefaultStatementArchiveDownsampleWindowMins
+	}
 }
 
 type SSOCoreConfig struct {
@@ -59,16 +356,26 @@ type SSOConfig struct {
 	SignOutURL  string        `json:"sign_out_url"`
 }
 
+type AuditConfig struct {
+	RetentionDays uint `json:"retention_days"`
+}
+
 type DynamicConfig struct {
-	KeyVisual KeyVisualConfig `json:"keyvisual"`
-	Profiling ProfilingConfig `json:"profiling"`
-	SSO       SSOConfig       `json:"sso"`
+	KeyVisual        KeyVisualConfig           `json:"keyvisual"`
+	Profiling        ProfilingConfig           `json:"profiling"`
+	LogSearch        LogSearchConfig           `json:"log_search"`
+	Conprof          ContinuousProfilingConfig `json:"conprof"`
+	StatementArchive StatementArchiveConfig    `json:"statement_archive"`
+	SSO              SSOConfig                 `json:"sso"`
+	Audit            AuditConfig               `json:"audit"`
 }
 
 func (c *DynamicConfig) Clone() *DynamicConfig {
 	newCfg := *c
 	newCfg.Profiling.AutoCollectionTargets = make([]model.RequestTargetNode, len(c.Profiling.AutoCollectionTargets))
 	copy(newCfg.Profiling.AutoCollectionTargets, c.Profiling.AutoCollectionTargets)
+	newCfg.KeyVisual.PolicyRules = make([]KeyVisualLabelRule, len(c.KeyVisual.PolicyRules))
+	copy(newCfg.KeyVisual.PolicyRules, c.KeyVisual.PolicyRules)
 	return &newCfg
 }
 
@@ -77,6 +384,9 @@ func (c *DynamicConfig) Validate() error {
 		if err := c.KeyVisual.validatePolicy(); err != nil {
 			return err
 		}
+		if err := c.KeyVisual.validateRules(); err != nil {
+			return err
+		}
 	}
 
 	if len(c.Profiling.AutoCollectionTargets) > 0 {
@@ -98,15 +408,37 @@ func (c *DynamicConfig) Validate() error {
 		}
 	}
 
+	if err := c.Profiling.Retention.validate(); err != nil {
+		return err
+	}
+	if err := c.LogSearch.Retention.validate(); err != nil {
+		return err
+	}
+	if err := c.Conprof.validate(); err != nil {
+		return err
+	}
+	if err := c.StatementArchive.validate(); err != nil {
+		return err
+	}
+
+	if c.Audit.RetentionDays == 0 {
+		return ErrVerificationFailed.New("retention_days cannot be 0")
+	}
+	if c.Audit.RetentionDays > MaxAuditRetentionDays {
+		return ErrVerificationFailed.New("retention_days cannot be greater than %d", MaxAuditRetentionDays)
+	}
+
 	return nil
 }
 
-// Adjust is used to fill the default config for the existing config of the old version.
+// Adjust is used to fill the default config for the existing config of the old version. Invalid label rules are
+// dropped, so that they do not block other changes of the config.
 func (c *DynamicConfig) Adjust() {
 	if !c.KeyVisual.AutoCollectionDisabled {
-		if err := c.KeyVisual.validatePolicy(); err != nil {
+		if c.KeyVisual.validatePolicy() != nil {
 			c.KeyVisual.Policy = DefaultKeyVisualPolicy
 		}
+		c.KeyVisual.adjustRules()
 	}
 
 	if len(c.Profiling.AutoCollectionTargets) > 0 {
@@ -123,4 +455,24 @@ func (c *DynamicConfig) Adjust() {
 		c.Profiling.AutoCollectionDurationSecs = 0
 		c.Profiling.AutoCollectionIntervalSecs = 0
 	}
+
+	c.Profiling.Retention.adjust(RetentionConfig{
+		MaxAgeHours:   DefaultProfilingRetentionMaxAgeHours,
+		MaxTotalBytes: DefaultProfilingRetentionMaxTotalBytes,
+		MaxGroups:     DefaultProfilingRetentionMaxGroups,
+	})
+	c.LogSearch.Retention.adjust(RetentionConfig{
+		MaxAgeHours:   DefaultLogSearchRetentionMaxAgeHours,
+		MaxTotalBytes: DefaultLogSearchRetentionMaxTotalBytes,
+		MaxGroups:     DefaultLogSearchRetentionMaxGroups,
+	})
+	c.Conprof.adjust()
+	c.StatementArchive.adjust()
+
+	if c.Audit.RetentionDays == 0 {
+		c.Audit.RetentionDays = DefaultAuditRetentionDays
+	}
+	if c.Audit.RetentionDays > MaxAuditRetentionDays {
+		c.Audit.RetentionDays = MaxAuditRetentionDays
+	}
 }
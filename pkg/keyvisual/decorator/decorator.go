@@ -5,6 +5,7 @@ package decorator
 
 import (
 	"encoding/hex"
+	"time"
 
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 )
@@ -30,6 +31,20 @@ type Labeler interface {
 	Label(keys []string) []LabelKey
 }
 
+// HistoryLabelStrategy is a LabelStrategy which can label keys as of a past time.
+type HistoryLabelStrategy interface {
+	LabelStrategy
+	NewLabelerAt(t time.Time) Labeler
+}
+
+// NewLabelerAt generates a Labeler as of the given time if the strategy supports it, otherwise the current one.
+func NewLabelerAt(strategy LabelStrategy, t time.Time) Labeler {
+	if s, ok := strategy.(HistoryLabelStrategy); ok {
+		return s.NewLabelerAt(t)
+	}
+	return strategy.NewLabeler()
+}
+
 // NaiveLabelStrategy is one of the simplest LabelStrategy.
 func NaiveLabelStrategy() LabelStrategy {
 	return naiveLabelStrategy{}
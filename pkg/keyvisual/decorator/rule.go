@@ -0,0 +1,184 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package decorator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/region"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb/model"
+)
+
+// RuleLabelStrategy implements the LabelStrategy interface. It labels keys by configurable rules, which is useful
+// for clusters not used by TiDB, e.g. RawKV or TxnKV clusters.
+func RuleLabelStrategy(cfg *config.KeyVisualConfig) LabelStrategy {
+	s := &ruleLabelStrategy{}
+	s.ReloadConfig(cfg)
+	return s
+}
+
+type labelRule struct {
+	config.KeyVisualLabelRule
+	prefix []byte
+	regex  *regexp.Regexp
+}
+
+type ruleSet struct {
+	rules  []*labelRule
+	txnKey bool
+}
+
+type ruleLabelStrategy struct {
+	RuleSet atomic.Value
+}
+
+type ruleLabeler struct {
+	*ruleSet
+	Buffer model.KeyInfoBuffer
+}
+
+func compileRules(cfg *config.KeyVisualConfig) *ruleSet {
+	set := &ruleSet{txnKey: cfg.PolicyRulesTxnKey}
+	for _, r := range cfg.PolicyRules {
+		rule := &labelRule{KeyVisualLabelRule: r}
+		var err error
+		if rule.prefix, err = hex.DecodeString(r.Prefix); err != nil {
+			log.Warn("Ignore label rule with invalid prefix", zap.String("prefix", r.Prefix), zap.Error(err))
+			continue
+		}
+		if r.Type == config.KeyVisualRuleRegex {
+			if rule.regex, err = regexp.Compile(r.Pattern); err != nil {
+				log.Warn("Ignore label rule with invalid pattern", zap.String("pattern", r.Pattern), zap.Error(err))
+				continue
+			}
+		}
+		set.rules = append(set.rules, rule)
+	}
+	return set
+}
+
+// ReloadConfig recompiles the rules.
+func (s *ruleLabelStrategy) ReloadConfig(cfg *config.KeyVisualConfig) {
+	s.RuleSet.Store(compileRules(cfg))
+	log.Debug("Reload config", zap.Int("rules", len(cfg.PolicyRules)), zap.Bool("txn-key", cfg.PolicyRulesTxnKey))
+}
+
+func (s *ruleLabelStrategy) NewLabeler() Labeler {
+	return &ruleLabeler{
+		ruleSet: s.RuleSet.Load().(*ruleSet),
+	}
+}
+
+// decode returns the user key. Keys written by the transactional API are in the memcomparable format. Keys which
+// cannot be decoded, e.g. keys truncated by region splitting, are used as is.
+func (e *ruleLabeler) decode(key string) []byte {
+	keyBytes := region.Bytes(key)
+	if !e.txnKey || len(keyBytes) == 0 {
+		return keyBytes
+	}
+	decoded, err := e.Buffer.DecodeKey(keyBytes)
+	if err != nil {
+		return keyBytes
+	}
+	return decoded
+}
+
+// apply returns labels generated by the rule, or nil if the rule does not apply to the key.
+func (r *labelRule) apply(key []byte) []string {
+	if !bytes.HasPrefix(key, r.prefix) {
+		return nil
+	}
+	switch r.Type {
+	case config.KeyVisualRuleFixed:
+		return []string{r.Label}
+	case config.KeyVisualRuleRegex:
+		match := r.regex.FindSubmatch(key)
+		if match == nil {
+			return nil
+		}
+		if len(match) == 1 {
+			return []string{string(match[0])}
+		}
+		labels := make([]string, 0, len(match)-1)
+		for _, group := range match[1:] {
+			labels = append(labels, string(group))
+		}
+		return labels
+	case config.KeyVisualRuleSegment:
+		if len(key) < r.Offset+r.Width {
+			return nil
+		}
+		segment := key[r.Offset : r.Offset+r.Width]
+		var value string
+		switch r.Encoding {
+		case config.KeyVisualSegmentUintBE, config.KeyVisualSegmentUintLE:
+			buf := make([]byte, 8)
+			if r.Encoding == config.KeyVisualSegmentUintBE {
+				copy(buf[8-r.Width:], segment)
+				value = strconv.FormatUint(binary.BigEndian.Uint64(buf), 10)
+			} else {
+				copy(buf, segment)
+				value = strconv.FormatUint(binary.LittleEndian.Uint64(buf), 10)
+			}
+		case config.KeyVisualSegmentString:
+			value = string(segment)
+		default:
+			value = hex.EncodeToString(segment)
+		}
+		if r.Label != "" {
+			value = strings.Replace(r.Label, config.KeyVisualSegmentPlaceholder, value, 1)
+		}
+		return []string{value}
+	default:
+		return nil
+	}
+}
+
+// CrossBorder returns true if any border rule generates different labels for the two keys.
+func (e *ruleLabeler) CrossBorder(startKey, endKey string) bool {
+	start := append([]byte(nil), e.decode(startKey)...)
+	end := e.decode(endKey)
+	for _, rule := range e.rules {
+		if !rule.Border {
+			continue
+		}
+		startLabels := rule.apply(start)
+		endLabels := rule.apply(end)
+		if len(startLabels) != len(endLabels) {
+			return true
+		}
+		for i := range startLabels {
+			if startLabels[i] != endLabels[i] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Label concatenates labels generated by all rules. Keys not matching any rule are labeled by the hex encoded key.
+func (e *ruleLabeler) Label(keys []string) []LabelKey {
+	labelKeys := make([]LabelKey, len(keys))
+	for i, key := range keys {
+		str := hex.EncodeToString([]byte(key))
+		labelKeys[i].Key = str
+		userKey := e.decode(key)
+		for _, rule := range e.rules {
+			labelKeys[i].Labels = append(labelKeys[i].Labels, rule.apply(userKey)...)
+		}
+		if len(labelKeys[i].Labels) == 0 {
+			labelKeys[i].Labels = []string{str}
+		}
+	}
+	return labelKeys
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package decorator
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+var _ = Suite(&testRuleSuite{})
+
+type testRuleSuite struct{}
+
+func labelsOf(labeler Labeler, key string) []string {
+	return labeler.Label([]string{key})[0].Labels
+}
+
+func (t *testRuleSuite) TestLabel(c *C) {
+	strategy := RuleLabelStrategy(&config.KeyVisualConfig{
+		Policy: config.KeyVisualRulePolicy,
+		PolicyRules: []config.KeyVisualLabelRule{
+			// "t_"
+			{Prefix: "745f", Type: config.KeyVisualRuleFixed, Label: "tenant"},
+			{Prefix: "745f", Type: config.KeyVisualRuleSegment, Offset: 2, Width: 8, Encoding: config.KeyVisualSegmentUintBE, Label: "tenant_%s", Border: true},
+			{Prefix: "745f", Type: config.KeyVisualRuleRegex, Pattern: `^t_.{8}/(\w+)/`},
+			// "m"
+			{Prefix: "6d", Type: config.KeyVisualRuleRegex, Pattern: `^m[a-z]+`},
+		},
+	})
+	labeler := strategy.NewLabeler()
+
+	tenant1 := "t_\x00\x00\x00\x00\x00\x00\x00\x01"
+	tenant2 := "t_\x00\x00\x00\x00\x00\x00\x01\x00"
+	c.Assert(labelsOf(labeler, tenant1+"/users/1"), DeepEquals, []string{"tenant", "tenant_1", "users"})
+	c.Assert(labelsOf(labeler, tenant2), DeepEquals, []string{"tenant", "tenant_256"})
+	c.Assert(labelsOf(labeler, "meta1"), DeepEquals, []string{"meta"})
+	c.Assert(labelsOf(labeler, "x"), DeepEquals, []string{"78"})
+	c.Assert(labeler.Label([]string{"x"})[0].Key, Equals, "78")
+
+	c.Assert(labeler.CrossBorder(tenant1+"/a", tenant1+"/b"), Equals, false)
+	c.Assert(labeler.CrossBorder(tenant1+"/a", tenant2+"/a"), Equals, true)
+	c.Assert(labeler.CrossBorder(tenant1, ""), Equals, true)
+	c.Assert(labeler.CrossBorder("a", "b"), Equals, false)
+
+	strategy.ReloadConfig(&config.KeyVisualConfig{
+		Policy:      config.KeyVisualRulePolicy,
+		PolicyRules: []config.KeyVisualLabelRule{{Type: config.KeyVisualRuleSegment, Width: 1, Encoding: config.KeyVisualSegmentHex}},
+	})
+	c.Assert(labelsOf(strategy.NewLabeler(), "x"), DeepEquals, []string{"78"})
+	c.Assert(labelsOf(strategy.NewLabeler(), "ab"), DeepEquals, []string{"61"})
+}
+
+func (t *testRuleSuite) TestSegmentLabel(c *C) {
+	rule := config.KeyVisualLabelRule{Type: config.KeyVisualRuleSegment, Width: 1, Encoding: config.KeyVisualSegmentString, Label: "k_%s_%d"}
+	strategy := RuleLabelStrategy(&config.KeyVisualConfig{
+		Policy:      config.KeyVisualRulePolicy,
+		PolicyRules: []config.KeyVisualLabelRule{rule},
+	})
+	// The label is a template rather than a format string, so other verbs are kept as is.
+	c.Assert(labelsOf(strategy.NewLabeler(), "x"), DeepEquals, []string{"k_x_%d"})
+
+	cfg := &config.DynamicConfig{KeyVisual: config.KeyVisualConfig{Policy: config.KeyVisualRulePolicy}}
+	cfg.KeyVisual.PolicyRules = []config.KeyVisualLabelRule{rule}
+	cfg.Adjust()
+	c.Assert(cfg.KeyVisual.PolicyRules, HasLen, 1)
+	cfg.KeyVisual.PolicyRules = append(cfg.KeyVisual.PolicyRules, rule)
+	cfg.KeyVisual.PolicyRules[1].Label = "tenant"
+	c.Assert(cfg.Validate(), NotNil)
+	// The invalid rule is dropped, so that it does not block other changes.
+	cfg.Adjust()
+	c.Assert(cfg.KeyVisual.PolicyRules, DeepEquals, []config.KeyVisualLabelRule{rule})
+	c.Assert(cfg.KeyVisual.Policy, Equals, config.KeyVisualRulePolicy)
+	c.Assert(cfg.Validate(), IsNil)
+
+	cfg.KeyVisual.PolicyRules[0].Label = "tenant"
+	cfg.Adjust()
+	c.Assert(cfg.KeyVisual.PolicyRules, HasLen, 0)
+	c.Assert(cfg.KeyVisual.Policy, Equals, config.DefaultKeyVisualPolicy)
+}
+
+func (t *testRuleSuite) TestTxnKey(c *C) {
+	strategy := RuleLabelStrategy(&config.KeyVisualConfig{
+		Policy:            config.KeyVisualRulePolicy,
+		PolicyRulesTxnKey: true,
+		PolicyRules: []config.KeyVisualLabelRule{
+			{Type: config.KeyVisualRuleSegment, Width: 2, Encoding: config.KeyVisualSegmentString, Border: true},
+		},
+	})
+	labeler := strategy.NewLabeler()
+
+	// "ab" in the memcomparable format
+	encoded := "ab\x00\x00\x00\x00\x00\x00\xf9"
+	c.Assert(labelsOf(labeler, encoded), DeepEquals, []string{"ab"})
+	// Keys which cannot be decoded are used as is.
+	c.Assert(labelsOf(labeler, "cd"), DeepEquals, []string{"cd"})
+	c.Assert(labeler.CrossBorder(encoded, "ab"), Equals, false)
+	c.Assert(labeler.CrossBorder(encoded, "cd"), Equals, true)
+}
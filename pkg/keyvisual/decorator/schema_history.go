@@ -0,0 +1,191 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package decorator
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+// schemaHistoryRetention is how long a table version is kept after it is replaced or dropped. It is the same as the
+// time range kept by the key visualizer.
+const schemaHistoryRetention = 5 * 7 * 24 * time.Hour
+
+type IndexNames map[int64]string
+
+func (n *IndexNames) Scan(src interface{}) error {
+	return json.Unmarshal([]byte(src.(string)), n)
+}
+
+func (n IndexNames) Value() (driver.Value, error) {
+	val, err := json.Marshal(n)
+	return string(val), err
+}
+
+// SchemaHistoryModel is a version of a table (or a partition), which is valid in [ValidFrom, ValidTo). ValidTo is
+// nil for the current version.
+type SchemaHistoryModel struct {
+	ID            uint       `gorm:"primary_key"`
+	TableID       int64      `gorm:"index"`
+	DB            string     `gorm:"type:text"`
+	Name          string     `gorm:"type:text"`
+	Indices       IndexNames `gorm:"type:text"`
+	SchemaVersion int64
+	ValidFrom     time.Time
+	ValidTo       *time.Time `gorm:"index"`
+}
+
+func (SchemaHistoryModel) TableName() string {
+	return "keyviz_schema_history"
+}
+
+func (m *SchemaHistoryModel) detail() *tableDetail {
+	return &tableDetail{
+		Name:    m.Name,
+		DB:      m.DB,
+		ID:      m.TableID,
+		Indices: m.Indices,
+	}
+}
+
+// schemaHistory records how table IDs map to names over time, so that keys can be labeled as of the time they were
+// collected, even if the tables are renamed or dropped later, or TiDB is not available.
+type schemaHistory struct {
+	db *dbstore.DB
+
+	mu sync.RWMutex
+	// versions of each table, ordered by ValidFrom.
+	versions map[int64][]*SchemaHistoryModel
+}
+
+func newSchemaHistory(db *dbstore.DB) (*schemaHistory, error) {
+	if err := db.AutoMigrate(&SchemaHistoryModel{}); err != nil {
+		return nil, err
+	}
+	var models []*SchemaHistoryModel
+	if err := db.Order("valid_from, id").Find(&models).Error; err != nil {
+		return nil, err
+	}
+	h := &schemaHistory{
+		db:       db,
+		versions: make(map[int64][]*SchemaHistoryModel),
+	}
+	for _, m := range models {
+		h.versions[m.TableID] = append(h.versions[m.TableID], m)
+	}
+	return h, nil
+}
+
+func sameTable(m *SchemaHistoryModel, detail *tableDetail) bool {
+	if m.DB != detail.DB || m.Name != detail.Name || len(m.Indices) != len(detail.Indices) {
+		return false
+	}
+	for id, name := range detail.Indices {
+		if m.Indices[id] != name {
+			return false
+		}
+	}
+	return true
+}
+
+// record compares tables of the schema version with the current versions. Versions of dropped or changed tables are
+// closed at now, and new versions are created for new or changed tables.
+func (h *schemaHistory) record(schemaVersion int64, now time.Time, tables map[int64]*tableDetail) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var closed []*SchemaHistoryModel
+	var created []*SchemaHistoryModel
+	for tableID, versions := range h.versions {
+		current := versions[len(versions)-1]
+		if current.ValidTo != nil {
+			continue
+		}
+		if detail, ok := tables[tableID]; !ok || !sameTable(current, detail) {
+			closed = append(closed, current)
+		}
+	}
+	for tableID, detail := range tables {
+		versions := h.versions[tableID]
+		if len(versions) > 0 {
+			current := versions[len(versions)-1]
+			if current.ValidTo == nil && sameTable(current, detail) {
+				continue
+			}
+		}
+		created = append(created, &SchemaHistoryModel{
+			TableID:       tableID,
+			DB:            detail.DB,
+			Name:          detail.Name,
+			Indices:       detail.Indices,
+			SchemaVersion: schemaVersion,
+			ValidFrom:     now,
+		})
+	}
+	expired := now.Add(-schemaHistoryRetention)
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		for _, m := range closed {
+			if err := tx.Model(&SchemaHistoryModel{}).Where("id = ?", m.ID).Update("valid_to", now).Error; err != nil {
+				return err
+			}
+		}
+		if len(created) > 0 {
+			if err := tx.CreateInBatches(created, 100).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("valid_to < ?", expired).Delete(&SchemaHistoryModel{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, m := range closed {
+		validTo := now
+		m.ValidTo = &validTo
+	}
+	for _, m := range created {
+		h.versions[m.TableID] = append(h.versions[m.TableID], m)
+	}
+	for tableID, versions := range h.versions {
+		n := 0
+		for _, m := range versions {
+			if m.ValidTo == nil || !m.ValidTo.Before(expired) {
+				versions[n] = m
+				n++
+			}
+		}
+		if n == 0 {
+			delete(h.versions, tableID)
+		} else {
+			h.versions[tableID] = versions[:n]
+		}
+	}
+	return nil
+}
+
+// lookup returns the table as of the given time. If the table did not exist at that time, the closest version is
+// returned, i.e. the last version before the time, or the first version if the table was created after the time.
+func (h *schemaHistory) lookup(tableID int64, t time.Time) *tableDetail {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	versions := h.versions[tableID]
+	if len(versions) == 0 {
+		return nil
+	}
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].ValidFrom.After(t)
+	})
+	if i > 0 {
+		i--
+	}
+	return versions[i].detail()
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package decorator
+
+import (
+	"path"
+	"time"
+
+	. "github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var _ = Suite(&testSchemaHistorySuite{})
+
+type testSchemaHistorySuite struct {
+	db *dbstore.DB
+}
+
+func (t *testSchemaHistorySuite) SetUpTest(c *C) {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(c.MkDir(), "test.db")), &gorm.Config{})
+	c.Assert(err, IsNil)
+	t.db = &dbstore.DB{DB: gormDB}
+}
+
+func nameOf(detail *tableDetail) string {
+	if detail == nil {
+		return ""
+	}
+	return detail.DB + "." + detail.Name
+}
+
+func (t *testSchemaHistorySuite) TestRecordAndLookup(c *C) {
+	h, err := newSchemaHistory(t.db)
+	c.Assert(err, IsNil)
+
+	t0 := time.Now().Add(-time.Hour)
+	t1 := t0.Add(10 * time.Minute)
+	t2 := t0.Add(20 * time.Minute)
+
+	c.Assert(h.record(1, t0, map[int64]*tableDetail{
+		10: {DB: "test", Name: "t1", ID: 10, Indices: map[int64]string{1: "idx"}},
+		20: {DB: "test", Name: "t2", ID: 20, Indices: map[int64]string{}},
+	}), IsNil)
+	// t1 is renamed and t2 is dropped.
+	c.Assert(h.record(2, t1, map[int64]*tableDetail{
+		10: {DB: "test", Name: "t1_new", ID: 10, Indices: map[int64]string{1: "idx"}},
+	}), IsNil)
+	// Nothing changes.
+	c.Assert(h.record(3, t2, map[int64]*tableDetail{
+		10: {DB: "test", Name: "t1_new", ID: 10, Indices: map[int64]string{1: "idx"}},
+	}), IsNil)
+
+	c.Assert(nameOf(h.lookup(10, t0.Add(time.Minute))), Equals, "test.t1")
+	c.Assert(nameOf(h.lookup(10, t1.Add(time.Minute))), Equals, "test.t1_new")
+	c.Assert(nameOf(h.lookup(10, t0.Add(-time.Minute))), Equals, "test.t1")
+	c.Assert(nameOf(h.lookup(20, t2)), Equals, "test.t2")
+	c.Assert(h.lookup(10, t0).Indices, DeepEquals, map[int64]string{1: "idx"})
+	c.Assert(h.lookup(30, t2), IsNil)
+
+	// History is restored from the db.
+	h, err = newSchemaHistory(t.db)
+	c.Assert(err, IsNil)
+	c.Assert(h.versions[10], HasLen, 2)
+	c.Assert(h.versions[20], HasLen, 1)
+	c.Assert(h.versions[20][0].ValidTo, NotNil)
+	c.Assert(nameOf(h.lookup(10, t1)), Equals, "test.t1_new")
+}
+
+func (t *testSchemaHistorySuite) TestRetention(c *C) {
+	h, err := newSchemaHistory(t.db)
+	c.Assert(err, IsNil)
+
+	t0 := time.Now().Add(-2 * schemaHistoryRetention)
+	c.Assert(h.record(1, t0, map[int64]*tableDetail{
+		10: {DB: "test", Name: "t1", ID: 10},
+	}), IsNil)
+	c.Assert(h.record(2, t0.Add(time.Minute), map[int64]*tableDetail{}), IsNil)
+	c.Assert(h.versions[10], HasLen, 1)
+
+	c.Assert(h.record(3, time.Now(), map[int64]*tableDetail{}), IsNil)
+	c.Assert(h.versions[10], HasLen, 0)
+	var count int64
+	c.Assert(t.db.Model(&SchemaHistoryModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(0))
+}
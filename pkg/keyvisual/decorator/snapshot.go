@@ -0,0 +1,91 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package decorator
+
+import (
+	"encoding/hex"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+// LabelSnapshot records labels of a set of keys, so that they can be labeled again without the source of the labels,
+// e.g. the schema of a TiDB cluster.
+type LabelSnapshot struct {
+	Labels map[string]LabelKey
+	// The empty key is labeled differently when it is the start or the end of the key space.
+	GlobalStart LabelKey
+	GlobalEnd   LabelKey
+}
+
+// NewLabelSnapshot labels the keys with the labeler and records the result.
+func NewLabelSnapshot(labeler Labeler, keys []string) *LabelSnapshot {
+	allKeys := make([]string, 0, len(keys)+2)
+	allKeys = append(allKeys, "")
+	for _, key := range keys {
+		if key != "" {
+			allKeys = append(allKeys, key)
+		}
+	}
+	allKeys = append(allKeys, "")
+
+	labelKeys := labeler.Label(allKeys)
+	snapshot := &LabelSnapshot{
+		Labels:      make(map[string]LabelKey, len(allKeys)-2),
+		GlobalStart: labelKeys[0],
+		GlobalEnd:   labelKeys[len(labelKeys)-1],
+	}
+	for i := 1; i < len(allKeys)-1; i++ {
+		snapshot.Labels[allKeys[i]] = labelKeys[i]
+	}
+	return snapshot
+}
+
+// SnapshotLabelStrategy labels keys using a LabelSnapshot. Keys not in the snapshot are hex encoded.
+func SnapshotLabelStrategy(snapshot *LabelSnapshot) LabelStrategy {
+	return &snapshotLabelStrategy{snapshot: snapshot}
+}
+
+type snapshotLabelStrategy struct {
+	snapshot *LabelSnapshot
+}
+
+type snapshotLabeler struct {
+	snapshot *LabelSnapshot
+}
+
+// ReloadConfig does nothing, since labels in the snapshot never change.
+func (s *snapshotLabelStrategy) ReloadConfig(cfg *config.KeyVisualConfig) {}
+
+func (s *snapshotLabelStrategy) NewLabeler() Labeler {
+	return &snapshotLabeler{snapshot: s.snapshot}
+}
+
+// CrossBorder always returns false. Axes in the snapshot are already split by the original labeler.
+func (e *snapshotLabeler) CrossBorder(startKey, endKey string) bool {
+	return false
+}
+
+// Label looks up labels of the keys in the snapshot.
+func (e *snapshotLabeler) Label(keys []string) []LabelKey {
+	labelKeys := make([]LabelKey, len(keys))
+	for i, key := range keys {
+		if label, ok := e.snapshot.Labels[key]; ok {
+			labelKeys[i] = label
+			continue
+		}
+		str := hex.EncodeToString([]byte(key))
+		labelKeys[i] = LabelKey{
+			Key:    str,
+			Labels: []string{str},
+		}
+	}
+
+	if len(keys) > 0 && keys[0] == "" {
+		labelKeys[0] = e.snapshot.GlobalStart
+	}
+	endIndex := len(keys) - 1
+	if endIndex > 0 && keys[endIndex] == "" {
+		labelKeys[endIndex] = e.snapshot.GlobalEnd
+	}
+	return labelKeys
+}
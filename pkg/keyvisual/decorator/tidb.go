@@ -9,22 +9,38 @@ import (
 	"sync"
 	"time"
 
+	"github.com/pingcap/log"
 	"go.etcd.io/etcd/clientv3"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
 
 	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/region"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb/model"
 )
 
-// TiDBLabelStrategy implements the LabelStrategy interface. It obtains Label Information from TiDB.
-func TiDBLabelStrategy(lc fx.Lifecycle, wg *sync.WaitGroup, etcdClient *clientv3.Client, tidbClient *tidb.Client) LabelStrategy {
+// TiDBLabelStrategy implements the LabelStrategy interface. It obtains Label Information from TiDB, and records the
+// schema history into db, so that keys can still be labeled after tables are dropped or when TiDB is not available.
+func TiDBLabelStrategy(
+	lc fx.Lifecycle,
+	wg *sync.WaitGroup,
+	etcdClient *clientv3.Client,
+	tidbClient *tidb.Client,
+	db *dbstore.DB,
+) LabelStrategy {
 	s := &tidbLabelStrategy{
 		EtcdClient:    etcdClient,
 		tidbClient:    tidbClient,
 		SchemaVersion: -1,
 	}
+	history, err := newSchemaHistory(db)
+	if err != nil {
+		log.Warn("Failed to load schema history, keys of dropped tables will not be labeled", zap.Error(err))
+	} else {
+		s.History = history
+	}
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -52,6 +68,7 @@ type tidbLabelStrategy struct {
 	EtcdClient *clientv3.Client
 
 	TableMap      sync.Map
+	History       *schemaHistory
 	tidbClient    *tidb.Client
 	SchemaVersion int64
 	TidbAddress   []string
@@ -59,7 +76,10 @@ type tidbLabelStrategy struct {
 
 type tidbLabeler struct {
 	TableMap *sync.Map
-	Buffer   model.KeyInfoBuffer
+	History  *schemaHistory
+	// Time is the time to resolve tables in the schema history. Zero means the current schema.
+	Time   time.Time
+	Buffer model.KeyInfoBuffer
 }
 
 func (s *tidbLabelStrategy) ReloadConfig(cfg *config.KeyVisualConfig) {}
@@ -80,6 +100,16 @@ func (s *tidbLabelStrategy) Background(ctx context.Context) {
 func (s *tidbLabelStrategy) NewLabeler() Labeler {
 	return &tidbLabeler{
 		TableMap: &s.TableMap,
+		History:  s.History,
+	}
+}
+
+// NewLabelerAt returns a Labeler which labels keys with the schema as of the given time.
+func (s *tidbLabelStrategy) NewLabelerAt(t time.Time) Labeler {
+	return &tidbLabeler{
+		TableMap: &s.TableMap,
+		History:  s.History,
+		Time:     t,
 	}
 }
 
@@ -131,9 +161,8 @@ func (e *tidbLabeler) label(key string) (label LabelKey) {
 		return
 	}
 
-	var detail *tableDetail
-	if v, ok := e.TableMap.Load(tableID); ok {
-		detail = v.(*tableDetail)
+	detail := e.lookupTable(tableID)
+	if detail != nil {
 		label.Labels = append(label.Labels, detail.DB, detail.Name)
 	} else {
 		label.Labels = append(label.Labels, fmt.Sprintf("table_%d", tableID))
@@ -155,6 +184,21 @@ func (e *tidbLabeler) label(key string) (label LabelKey) {
 	return
 }
 
+func (e *tidbLabeler) lookupTable(tableID int64) *tableDetail {
+	if e.History != nil && !e.Time.IsZero() {
+		if detail := e.History.lookup(tableID, e.Time); detail != nil {
+			return detail
+		}
+	}
+	if v, ok := e.TableMap.Load(tableID); ok {
+		return v.(*tableDetail)
+	}
+	if e.History != nil {
+		return e.History.lookup(tableID, time.Now())
+	}
+	return nil
+}
+
 var globalStart = LabelKey{
 	Key:    "",
 	Labels: []string{"meta"},
@@ -66,6 +66,7 @@ func (s *tidbLabelStrategy) updateMap(ctx context.Context) {
 	}
 
 	// get all table info
+	tables := make(map[int64]*tableDetail)
 	updateSuccess := true
 	for _, db := range dbInfos {
 		if db.State == model.StateNone {
@@ -90,6 +91,7 @@ func (s *tidbLabelStrategy) updateMap(ctx context.Context) {
 				Indices: indices,
 			}
 			s.TableMap.Store(table.ID, detail)
+			tables[table.ID] = detail
 			if partition := table.GetPartitionInfo(); partition != nil {
 				for _, partitionDef := range partition.Definitions {
 					detail := &tableDetail{
@@ -99,6 +101,7 @@ func (s *tidbLabelStrategy) updateMap(ctx context.Context) {
 						Indices: indices,
 					}
 					s.TableMap.Store(partitionDef.ID, detail)
+					tables[partitionDef.ID] = detail
 				}
 			}
 		}
@@ -106,6 +109,12 @@ func (s *tidbLabelStrategy) updateMap(ctx context.Context) {
 
 	// update schema version
 	if updateSuccess {
+		if s.History != nil {
+			if err := s.History.record(schemaVersion, time.Now(), tables); err != nil {
+				log.Warn("failed to record schema history", zap.Error(err))
+				return
+			}
+		}
 		s.SchemaVersion = schemaVersion
 	}
 }
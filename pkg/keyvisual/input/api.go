@@ -22,15 +22,74 @@ var (
 
 // RegionInfo records detail region info for api usage.
 type RegionInfo struct {
-	ID              uint64 `json:"id"`
-	StartKey        string `json:"start_key"`
-	EndKey          string `json:"end_key"`
-	WrittenBytes    uint64 `json:"written_bytes"`
-	ReadBytes       uint64 `json:"read_bytes"`
-	WrittenKeys     uint64 `json:"written_keys"`
-	ReadKeys        uint64 `json:"read_keys"`
-	ApproximateSize int64  `json:"approximate_size"`
-	ApproximateKeys int64  `json:"approximate_keys"`
+	ID              uint64      `json:"id"`
+	StartKey        string      `json:"start_key"`
+	EndKey          string      `json:"end_key"`
+	Peers           []struct{}  `json:"peers"`
+	Leader          *Peer       `json:"leader"`
+	WrittenBytes    uint64      `json:"written_bytes"`
+	ReadBytes       uint64      `json:"read_bytes"`
+	WrittenKeys     uint64      `json:"written_keys"`
+	ReadKeys        uint64      `json:"read_keys"`
+	ApproximateSize int64       `json:"approximate_size"`
+	ApproximateKeys int64       `json:"approximate_keys"`
+	QueryStats      *QueryStats `json:"query_stats,omitempty"`
+}
+
+// Peer is a replica of a region on a store.
+type Peer struct {
+	StoreID uint64 `json:"store_id"`
+}
+
+// QueryStats is the number of queries of each type, which is reported by newer versions of PD only.
+type QueryStats struct {
+	GC                     uint64 `json:"gc"`
+	Get                    uint64 `json:"get"`
+	Scan                   uint64 `json:"scan"`
+	Coprocessor            uint64 `json:"coprocessor"`
+	Delete                 uint64 `json:"delete"`
+	DeleteRange            uint64 `json:"delete_range"`
+	Put                    uint64 `json:"put"`
+	Prewrite               uint64 `json:"prewrite"`
+	AcquirePessimisticLock uint64 `json:"acquire_pessimistic_lock"`
+	Commit                 uint64 `json:"commit"`
+	Rollback               uint64 `json:"rollback"`
+}
+
+func (q *QueryStats) readQueries() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.Get + q.Scan + q.Coprocessor
+}
+
+func (q *QueryStats) writeQueries() uint64 {
+	if q == nil {
+		return 0
+	}
+	return q.Delete + q.DeleteRange + q.Put + q.Prewrite + q.AcquirePessimisticLock + q.Commit + q.Rollback
+}
+
+func nonNegative(v int64) uint64 {
+	if v < 0 {
+		return 0
+	}
+	return uint64(v)
+}
+
+// regionValueGetters reads the value of each StatTag from a RegionInfo. LeaderCount depends on other regions, so it
+// is computed by RegionsInfo.leaderCounts instead.
+var regionValueGetters = map[regionpkg.StatTag]func(r *RegionInfo) uint64{
+	regionpkg.Integration:  func(r *RegionInfo) uint64 { return r.WrittenBytes + r.ReadBytes },
+	regionpkg.WrittenBytes: func(r *RegionInfo) uint64 { return r.WrittenBytes },
+	regionpkg.ReadBytes:    func(r *RegionInfo) uint64 { return r.ReadBytes },
+	regionpkg.WrittenKeys:  func(r *RegionInfo) uint64 { return r.WrittenKeys },
+	regionpkg.ReadKeys:     func(r *RegionInfo) uint64 { return r.ReadKeys },
+	regionpkg.RegionSize:   func(r *RegionInfo) uint64 { return nonNegative(r.ApproximateSize) },
+	regionpkg.RegionKeys:   func(r *RegionInfo) uint64 { return nonNegative(r.ApproximateKeys) },
+	regionpkg.PeerCount:    func(r *RegionInfo) uint64 { return uint64(len(r.Peers)) },
+	regionpkg.ReadQueries:  func(r *RegionInfo) uint64 { return r.QueryStats.readQueries() },
+	regionpkg.WriteQueries: func(r *RegionInfo) uint64 { return r.QueryStats.writeQueries() },
 }
 
 // RegionsInfo contains some regions with the detailed region info.
@@ -53,32 +112,36 @@ func (rs *RegionsInfo) GetKeys() []string {
 	return keys
 }
 
-func (rs *RegionsInfo) GetValues(tag regionpkg.StatTag) []uint64 {
-	values := make([]uint64, rs.Count)
-	switch tag {
-	case regionpkg.WrittenBytes:
-		for i, region := range rs.Regions {
-			values[i] = region.WrittenBytes
-		}
-	case regionpkg.ReadBytes:
-		for i, region := range rs.Regions {
-			values[i] = region.ReadBytes
-		}
-	case regionpkg.WrittenKeys:
-		for i, region := range rs.Regions {
-			values[i] = region.WrittenKeys
-		}
-	case regionpkg.ReadKeys:
-		for i, region := range rs.Regions {
-			values[i] = region.ReadKeys
+// leaderCounts returns the number of leaders of the store that holds the leader of each region, so that regions
+// led by a store with too many leaders stand out. Regions without a leader are 0.
+func (rs *RegionsInfo) leaderCounts() []uint64 {
+	storeLeaders := make(map[uint64]uint64)
+	for _, region := range rs.Regions {
+		if region.Leader != nil {
+			storeLeaders[region.Leader.StoreID]++
 		}
-	case regionpkg.Integration:
-		for i, region := range rs.Regions {
-			values[i] = region.WrittenBytes + region.ReadBytes
+	}
+	values := make([]uint64, rs.Count)
+	for i, region := range rs.Regions {
+		if region.Leader != nil {
+			values[i] = storeLeaders[region.Leader.StoreID]
 		}
-	default:
+	}
+	return values
+}
+
+func (rs *RegionsInfo) GetValues(tag regionpkg.StatTag) []uint64 {
+	if tag == regionpkg.LeaderCount {
+		return rs.leaderCounts()
+	}
+	getter, ok := regionValueGetters[tag]
+	if !ok {
 		panic("unreachable")
 	}
+	values := make([]uint64, rs.Count)
+	for i, region := range rs.Regions {
+		values[i] = getter(region)
+	}
 	return values
 }
 
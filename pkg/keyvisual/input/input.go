@@ -19,7 +19,10 @@ type StatInput interface {
 	Background(ctx context.Context, stat *storage.Stat)
 }
 
-func NewStatInput(provider *region.DataProvider) StatInput {
+func NewStatInput(provider *region.DataProvider, snapshot *storage.Snapshot) StatInput {
+	if snapshot != nil {
+		return SnapshotInput(snapshot)
+	}
 	if provider.FileStartTime == 0 && provider.FileEndTime == 0 {
 		if provider.PeriodicGetter == nil {
 			log.Fatal("Empty DataProvider is not allowed")
@@ -0,0 +1,39 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package input
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/storage"
+)
+
+type snapshotInput struct {
+	Snapshot *storage.Snapshot
+}
+
+// SnapshotInput loads an exported snapshot once. No further data is appended, so the heatmap is read-only.
+func SnapshotInput(snapshot *storage.Snapshot) StatInput {
+	return &snapshotInput{
+		Snapshot: snapshot,
+	}
+}
+
+func (input *snapshotInput) GetStartTime() time.Time {
+	return input.Snapshot.StartTime
+}
+
+func (input *snapshotInput) Background(ctx context.Context, stat *storage.Stat) {
+	if err := stat.LoadSnapshot(input.Snapshot); err != nil {
+		log.Error("keyvisual load snapshot failed", zap.Error(err))
+		return
+	}
+	log.Info("keyvisual load snapshot",
+		zap.Time("start-time", input.Snapshot.StartTime),
+		zap.Time("end-time", input.Snapshot.EndTime),
+		zap.Time("created-at", input.Snapshot.CreatedAt))
+}
@@ -12,6 +12,7 @@ import (
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/util/rest"
 )
@@ -116,7 +117,9 @@ func (s *Service) setDynamicConfig(c *gin.Context) {
 		return
 	}
 	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		audit.SetBefore(c, dc.KeyVisual)
 		dc.KeyVisual = req
+		audit.SetAfter(c, dc.KeyVisual)
 	}
 	if err := s.cfgManager.Modify(opt); err != nil {
 		rest.Error(c, err)
@@ -45,9 +45,12 @@ func CreateEmptyAxis(startKey, endKey string, valuesListLen int) Axis {
 	return CreateAxis(keys, valuesList)
 }
 
-// Shrink reduces all statistical values.
-func (axis *Axis) Shrink(ratio uint64) {
-	for _, values := range axis.ValuesList {
+// Shrink reduces statistical values of columns merged by MergeSum, which turns sums of compacted axes into averages.
+func (axis *Axis) Shrink(ratio uint64, merges []MergeStrategy) {
+	for j, values := range axis.ValuesList {
+		if mergeStrategyOf(merges, j) != MergeSum {
+			continue
+		}
 		for i := range values {
 			values[i] /= ratio
 		}
@@ -69,8 +72,8 @@ func (axis *Axis) Range(startKey string, endKey string) Axis {
 }
 
 // Focus uses the base column as the chunk for the Focus operation to obtain the partitioning scheme, and uses this to
-// reduce other columns.
-func (axis *Axis) Focus(labeler decorator.Labeler, threshold uint64, ratio int, target int) Axis {
+// reduce other columns by their merge strategies.
+func (axis *Axis) Focus(labeler decorator.Labeler, threshold uint64, ratio int, target int, merges []MergeStrategy) Axis {
 	if target >= len(axis.Keys)-1 {
 		return *axis
 	}
@@ -82,14 +85,14 @@ func (axis *Axis) Focus(labeler decorator.Labeler, threshold uint64, ratio int,
 	newValuesList[0] = newChunk.Values
 	for i := 1; i < valuesListLen; i++ {
 		baseChunk.SetValues(axis.ValuesList[i])
-		newValuesList[i] = baseChunk.Reduce(newChunk.Keys).Values
+		newValuesList[i] = baseChunk.Reduce(newChunk.Keys, mergeStrategyOf(merges, i)).Values
 	}
 	return CreateAxis(newChunk.Keys, newValuesList)
 }
 
 // Divide uses the base column as the chunk for the Divide operation to obtain the partitioning scheme, and uses this to
-// reduce other columns.
-func (axis *Axis) Divide(labeler decorator.Labeler, target int) Axis {
+// reduce other columns by their merge strategies.
+func (axis *Axis) Divide(labeler decorator.Labeler, target int, merges []MergeStrategy) Axis {
 	if target >= len(axis.Keys)-1 {
 		return *axis
 	}
@@ -101,7 +104,7 @@ func (axis *Axis) Divide(labeler decorator.Labeler, target int) Axis {
 	newValuesList[0] = newChunk.Values
 	for i := 1; i < valuesListLen; i++ {
 		baseChunk.SetValues(axis.ValuesList[i])
-		newValuesList[i] = baseChunk.Reduce(newChunk.Keys).Values
+		newValuesList[i] = baseChunk.Reduce(newChunk.Keys, mergeStrategyOf(merges, i)).Values
 	}
 	return CreateAxis(newChunk.Keys, newValuesList)
 }
@@ -160,8 +163,9 @@ func (c *chunk) Clear() {
 
 // Calculation
 
-// Reduce generates new chunks based on the more sparse newKeys.
-func (c *chunk) Reduce(newKeys []string) chunk {
+// Reduce generates new chunks based on the more sparse newKeys. Values of merged buckets are added up, or the max
+// value is kept if merge is MergeMax.
+func (c *chunk) Reduce(newKeys []string, merge MergeStrategy) chunk {
 	keys := c.Keys
 	CheckReduceOf(keys, newKeys)
 
@@ -178,7 +182,11 @@ func (c *chunk) Reduce(newKeys []string) chunk {
 		if i > 0 && equal(keys[i], endKeys[j]) {
 			j++
 		}
-		newValues[j] += value
+		if merge == MergeMax {
+			newValues[j] = MaxUint64(newValues[j], value)
+		} else {
+			newValues[j] += value
+		}
 	}
 	return createChunk(newKeys, newValues)
 }
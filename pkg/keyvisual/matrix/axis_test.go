@@ -33,7 +33,7 @@ func (s *testAxisSuite) TestChunkReduce(c *C) {
 
 	for _, testcase := range testcases {
 		originChunk := createChunk(testcase.keys, testcase.values)
-		reduceChunk := originChunk.Reduce(testcase.newKeys)
+		reduceChunk := originChunk.Reduce(testcase.newKeys, MergeSum)
 		c.Assert(reduceChunk.Values, DeepEquals, testcase.newValues)
 	}
 }
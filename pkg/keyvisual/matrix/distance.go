@@ -98,6 +98,10 @@ func (e *distanceSplitter) Split(dst, src chunk, tag splitTag, axesIndex int) {
 			for i, v := range src.Values {
 				dst.Values[i] += v
 			}
+		case splitMax:
+			for i, v := range src.Values {
+				dst.Values[i] = MaxUint64(dst.Values[i], v)
+			}
 		default:
 			panic("unreachable")
 		}
@@ -133,6 +137,18 @@ func (e *distanceSplitter) Split(dst, src chunk, tag splitTag, axesIndex int) {
 			}
 			end++
 		}
+	case splitMax:
+		// Gauges are not scaled, since each subrange belongs to the same bucket of the gauge.
+		for i, key := range src.Keys[1:] {
+			for !equal(dst.Keys[end], key) {
+				end++
+			}
+			value := src.Values[i]
+			for ; start < end; start++ {
+				dst.Values[start] = MaxUint64(dst.Values[start], value)
+			}
+			end++
+		}
 	default:
 		panic("unreachable")
 	}
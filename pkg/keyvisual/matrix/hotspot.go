@@ -0,0 +1,203 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package matrix
+
+import (
+	"sort"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
+)
+
+// HotspotOptions controls how hotspots are detected.
+type HotspotOptions struct {
+	// Limit is the maximum number of ranges of each kind. A range is hot in a column if its value is in the top
+	// Limit values of the column.
+	Limit int
+	// MinPersistentColumns is the minimum number of consecutive hot columns of a persistent hotspot.
+	MinPersistentColumns int
+	// RecentColumns is the number of latest columns compared with the baseline, i.e. all previous columns, when
+	// detecting surges.
+	RecentColumns int
+	// MinSurgeRatio is the minimum ratio of the recent average value to the baseline average value of a surge.
+	MinSurgeRatio float64
+}
+
+// HotRange is a range of keys in the matrix. Keys are labeled as of PeakTime.
+type HotRange struct {
+	StartKey decorator.LabelKey `json:"start_key"`
+	EndKey   decorator.LabelKey `json:"end_key"`
+	// Total is the sum of values in all columns.
+	Total uint64 `json:"total"`
+	// Peak is the maximum value in a column, which happened at PeakTime.
+	Peak     uint64 `json:"peak"`
+	PeakTime int64  `json:"peak_time"`
+}
+
+// PersistentHotspot is a range which is hot in consecutive columns from StartTime to EndTime.
+type PersistentHotspot struct {
+	HotRange
+	Columns   int   `json:"columns"`
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+}
+
+// Surge is a range whose recent values are much larger than its baseline.
+type Surge struct {
+	HotRange
+	RecentAverage   float64 `json:"recent_average"`
+	BaselineAverage float64 `json:"baseline_average"`
+	// Ratio is RecentAverage / BaselineAverage. It is 0 if BaselineAverage is 0, which means the range was cold.
+	Ratio float64 `json:"ratio"`
+}
+
+// Hotspots of a tag in the matrix.
+type Hotspots struct {
+	TopRanges  []HotRange          `json:"top_ranges"`
+	Persistent []PersistentHotspot `json:"persistent"`
+	Surges     []Surge             `json:"surges"`
+}
+
+func (mx *Matrix) hotRange(data [][]uint64, k int) HotRange {
+	var r HotRange
+	peakColumn := 0
+	for t, column := range data {
+		r.Total += column[k]
+		if column[k] > r.Peak || t == 0 {
+			r.Peak = column[k]
+			r.PeakTime = mx.TimeAxis[t+1]
+			peakColumn = t
+		}
+	}
+	keyAxis := mx.keyAxisAt(peakColumn)
+	r.StartKey = keyAxis[k]
+	r.EndKey = keyAxis[k+1]
+	return r
+}
+
+// hotColumns returns whether each range is hot in each column.
+func hotColumns(data [][]uint64, limit int) [][]bool {
+	hot := make([][]bool, len(data))
+	for t, column := range data {
+		hot[t] = make([]bool, len(column))
+		indices := make([]int, len(column))
+		for k := range indices {
+			indices[k] = k
+		}
+		sort.SliceStable(indices, func(i, j int) bool {
+			return column[indices[i]] > column[indices[j]]
+		})
+		for _, k := range indices[:Min(limit, len(indices))] {
+			if column[k] > 0 {
+				hot[t][k] = true
+			}
+		}
+	}
+	return hot
+}
+
+// Hotspots analyzes the data of the tag. It returns nil if there is no such tag.
+func (mx *Matrix) Hotspots(tag string, opts HotspotOptions) *Hotspots {
+	data, ok := mx.DataMap[tag]
+	if !ok {
+		return nil
+	}
+	result := &Hotspots{
+		TopRanges:  make([]HotRange, 0),
+		Persistent: make([]PersistentHotspot, 0),
+		Surges:     make([]Surge, 0),
+	}
+	if len(data) == 0 || opts.Limit <= 0 {
+		return result
+	}
+	rangesLen := len(data[0])
+	ranges := make([]HotRange, rangesLen)
+	for k := range ranges {
+		ranges[k] = mx.hotRange(data, k)
+	}
+
+	// top ranges
+	for _, r := range ranges {
+		if r.Total > 0 {
+			result.TopRanges = append(result.TopRanges, r)
+		}
+	}
+	sort.SliceStable(result.TopRanges, func(i, j int) bool {
+		return result.TopRanges[i].Total > result.TopRanges[j].Total
+	})
+	if len(result.TopRanges) > opts.Limit {
+		result.TopRanges = result.TopRanges[:opts.Limit]
+	}
+
+	// persistent hotspots
+	hot := hotColumns(data, opts.Limit)
+	for k := 0; k < rangesLen; k++ {
+		best := PersistentHotspot{}
+		run := 0
+		for t := range data {
+			if !hot[t][k] {
+				run = 0
+				continue
+			}
+			run++
+			if run > best.Columns {
+				best.Columns = run
+				best.StartTime = mx.TimeAxis[t+1-run]
+				best.EndTime = mx.TimeAxis[t+1]
+			}
+		}
+		if best.Columns > 0 && best.Columns >= opts.MinPersistentColumns {
+			best.HotRange = ranges[k]
+			result.Persistent = append(result.Persistent, best)
+		}
+	}
+	sort.SliceStable(result.Persistent, func(i, j int) bool {
+		a, b := result.Persistent[i], result.Persistent[j]
+		if a.Columns != b.Columns {
+			return a.Columns > b.Columns
+		}
+		return a.Total > b.Total
+	})
+	if len(result.Persistent) > opts.Limit {
+		result.Persistent = result.Persistent[:opts.Limit]
+	}
+
+	// surges
+	recent := opts.RecentColumns
+	if recent <= 0 || recent >= len(data) {
+		return result
+	}
+	baseline := len(data) - recent
+	for k := 0; k < rangesLen; k++ {
+		var recentSum, baselineSum uint64
+		for t, column := range data {
+			if t < baseline {
+				baselineSum += column[k]
+			} else {
+				recentSum += column[k]
+			}
+		}
+		s := Surge{
+			HotRange:        ranges[k],
+			RecentAverage:   float64(recentSum) / float64(recent),
+			BaselineAverage: float64(baselineSum) / float64(baseline),
+		}
+		if s.RecentAverage == 0 {
+			continue
+		}
+		if s.BaselineAverage > 0 {
+			s.Ratio = s.RecentAverage / s.BaselineAverage
+			if s.Ratio < opts.MinSurgeRatio {
+				continue
+			}
+		}
+		result.Surges = append(result.Surges, s)
+	}
+	sort.SliceStable(result.Surges, func(i, j int) bool {
+		a, b := result.Surges[i], result.Surges[j]
+		return a.RecentAverage-a.BaselineAverage > b.RecentAverage-b.BaselineAverage
+	})
+	if len(result.Surges) > opts.Limit {
+		result.Surges = result.Surges[:opts.Limit]
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package matrix
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
+)
+
+var _ = Suite(&testHotspotSuite{})
+
+type testHotspotSuite struct{}
+
+func newHotspotMatrix(data [][]uint64) Matrix {
+	keys := []string{"", "a", "b", "c", ""}
+	times := make([]int64, len(data)+1)
+	for i := range times {
+		times[i] = int64(i * 60)
+	}
+	return Matrix{
+		Keys:     keys,
+		DataMap:  map[string][][]uint64{"written_bytes": data},
+		KeyAxis:  decorator.NaiveLabelStrategy().NewLabeler().Label(keys),
+		TimeAxis: times,
+	}
+}
+
+func keysOf(ranges []HotRange) []string {
+	r := make([]string, 0, len(ranges))
+	for _, h := range ranges {
+		r = append(r, h.StartKey.Key)
+	}
+	return r
+}
+
+func (t *testHotspotSuite) TestHotspots(c *C) {
+	// Columns are times and rows are ranges ["", a), [a, b), [b, c), [c, "").
+	mx := newHotspotMatrix([][]uint64{
+		{10, 1, 0, 0},
+		{10, 2, 0, 1},
+		{10, 1, 0, 0},
+		{10, 1, 0, 0},
+		{1, 1, 0, 50},
+	})
+	c.Assert(mx.Hotspots("read_bytes", HotspotOptions{Limit: 1}), IsNil)
+
+	h := mx.Hotspots("written_bytes", HotspotOptions{
+		Limit:                2,
+		MinPersistentColumns: 3,
+		RecentColumns:        1,
+		MinSurgeRatio:        2,
+	})
+	c.Assert(keysOf(h.TopRanges), DeepEquals, []string{"63", ""})
+	c.Assert(h.TopRanges[0].Total, Equals, uint64(51))
+	c.Assert(h.TopRanges[0].Peak, Equals, uint64(50))
+	c.Assert(h.TopRanges[0].PeakTime, Equals, int64(300))
+
+	c.Assert(h.Persistent, HasLen, 2)
+	c.Assert(h.Persistent[0].StartKey.Key, Equals, "")
+	c.Assert(h.Persistent[0].Columns, Equals, 5)
+	c.Assert(h.Persistent[1].StartKey.Key, Equals, "61")
+	c.Assert(h.Persistent[1].Columns, Equals, 4)
+	c.Assert(h.Persistent[1].StartTime, Equals, int64(0))
+	c.Assert(h.Persistent[1].EndTime, Equals, int64(240))
+
+	c.Assert(h.Surges, HasLen, 1)
+	c.Assert(h.Surges[0].StartKey.Key, Equals, "63")
+	c.Assert(h.Surges[0].RecentAverage, Equals, 50.0)
+	c.Assert(h.Surges[0].BaselineAverage, Equals, 0.25)
+	c.Assert(h.Surges[0].Ratio, Equals, 200.0)
+}
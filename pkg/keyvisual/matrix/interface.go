@@ -11,6 +11,7 @@ type splitTag int
 const (
 	splitTo  splitTag = iota // Direct assignment after split
 	splitAdd                 // Add to original value after split
+	splitMax                 // Keep the larger one of original value and the undivided value, used by gauges
 )
 
 // SplitStrategy is an allocation scheme. It is used to generate a Splitter for a plane to split a chunk of columns.
@@ -4,6 +4,7 @@
 package matrix
 
 import (
+	"reflect"
 	"time"
 
 	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
@@ -11,10 +12,21 @@ import (
 
 // Matrix is the front end displays the required data.
 type Matrix struct {
-	Keys     []string              `json:"-"`
-	DataMap  map[string][][]uint64 `json:"data" binding:"required"`
-	KeyAxis  []decorator.LabelKey  `json:"keyAxis" binding:"required"`
-	TimeAxis []int64               `json:"timeAxis" binding:"required"`
+	Keys    []string              `json:"-"`
+	DataMap map[string][][]uint64 `json:"data" binding:"required"`
+	// KeyAxis is labeled as of the last time of TimeAxis, i.e. the end of the last column.
+	KeyAxis  []decorator.LabelKey `json:"keyAxis" binding:"required"`
+	TimeAxis []int64              `json:"timeAxis" binding:"required"`
+	// ColumnKeyAxes holds labels of earlier columns which differ from labels of their next columns, in the order of
+	// columns. Labels of a column are the ones of the first entry at or after it, or KeyAxis if there is none.
+	ColumnKeyAxes []ColumnKeyAxis `json:"columnKeyAxes,omitempty"`
+}
+
+// ColumnKeyAxis is the key axis labeled as of the end of a column.
+type ColumnKeyAxis struct {
+	// Column is the index of the column, which ends at TimeAxis[Column+1].
+	Column  int                  `json:"column"`
+	KeyAxis []decorator.LabelKey `json:"keyAxis"`
 }
 
 // CreateMatrix uses the specified times and keys to build an initial matrix with no data.
@@ -35,6 +47,39 @@ func CreateMatrix(labeler decorator.Labeler, times []time.Time, keys []string, v
 	}
 }
 
+// labelColumns labels keys as of the end of each column, so that a table renamed within the matrix is labeled with
+// its name at that time. Only strategies which can label keys as of a past time are supported.
+func (mx *Matrix) labelColumns(strategy decorator.LabelStrategy, times []time.Time) {
+	history, ok := strategy.(decorator.HistoryLabelStrategy)
+	if !ok {
+		return
+	}
+	next := mx.KeyAxis
+	var columnKeyAxes []ColumnKeyAxis
+	for column := len(times) - 3; column >= 0; column-- {
+		keyAxis := history.NewLabelerAt(times[column+1]).Label(mx.Keys)
+		if reflect.DeepEqual(keyAxis, next) {
+			continue
+		}
+		columnKeyAxes = append(columnKeyAxes, ColumnKeyAxis{Column: column, KeyAxis: keyAxis})
+		next = keyAxis
+	}
+	for i, j := 0, len(columnKeyAxes)-1; i < j; i, j = i+1, j-1 {
+		columnKeyAxes[i], columnKeyAxes[j] = columnKeyAxes[j], columnKeyAxes[i]
+	}
+	mx.ColumnKeyAxes = columnKeyAxes
+}
+
+// keyAxisAt returns the key axis labeled as of the end of the column.
+func (mx *Matrix) keyAxisAt(column int) []decorator.LabelKey {
+	for _, c := range mx.ColumnKeyAxes {
+		if c.Column >= column {
+			return c.KeyAxis
+		}
+	}
+	return mx.KeyAxis
+}
+
 // Range returns a sub Matrix with specified range.
 func (mx *Matrix) Range(startKey, endKey string) {
 	start, end, ok := KeysRange(mx.Keys, startKey, endKey)
@@ -43,6 +88,9 @@ func (mx *Matrix) Range(startKey, endKey string) {
 	}
 	mx.Keys = mx.Keys[start:end]
 	mx.KeyAxis = mx.KeyAxis[start:end]
+	for i := range mx.ColumnKeyAxes {
+		mx.ColumnKeyAxes[i].KeyAxis = mx.ColumnKeyAxes[i].KeyAxis[start:end]
+	}
 	for _, data := range mx.DataMap {
 		for i, axis := range data {
 			data[i] = axis[start : end-1]
@@ -5,6 +5,8 @@ package matrix
 import (
 	"sync"
 	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
 )
 
 // Plane stores consecutive axes. Each axis has StartTime, EndTime. The EndTime of each axis is the StartTime of its
@@ -31,29 +33,58 @@ func CreateEmptyPlane(startTime, endTime time.Time, startKey, endKey string, val
 	return CreatePlane([]time.Time{startTime, endTime}, []Axis{CreateEmptyAxis(startKey, endKey, valuesListLen)})
 }
 
-// Compact compacts Plane into an axis.
-func (plane *Plane) Compact(strategy SplitStrategy) Axis {
+// MergeStrategy is how values of a column are merged, either at different times when a plane is compacted, or in
+// adjacent buckets when an axis is reduced.
+type MergeStrategy int
+
+const (
+	// MergeSum adds values up. It is used by flows like written bytes.
+	MergeSum MergeStrategy = iota
+	// MergeMax keeps the max value. It is used by gauges like region size, which are meaningless when added up
+	// over time, or when a bucket is split and merged back.
+	MergeMax
+)
+
+func (m MergeStrategy) splitTag() splitTag {
+	if m == MergeMax {
+		return splitMax
+	}
+	return splitAdd
+}
+
+// mergeStrategyOf returns the merge strategy of the j-th column. Columns without a strategy are merged by MergeSum.
+func mergeStrategyOf(merges []MergeStrategy, j int) MergeStrategy {
+	if j < len(merges) {
+		return merges[j]
+	}
+	return MergeSum
+}
+
+// Compact compacts Plane into an axis. Each column is merged by the merge strategy of the same index.
+func (plane *Plane) Compact(strategy SplitStrategy, merges []MergeStrategy) Axis {
 	chunks := make([]chunk, len(plane.Axes))
 	for i, axis := range plane.Axes {
 		chunks[i] = createChunk(axis.Keys, axis.ValuesList[0])
 	}
-	compactChunk, splitter := compact(strategy, chunks)
+	compactChunk, splitter := compact(strategy, chunks, mergeStrategyOf(merges, 0))
 	valuesListLen := len(plane.Axes[0].ValuesList)
 	valuesList := make([][]uint64, valuesListLen)
 	valuesList[0] = compactChunk.Values
 	for j := 1; j < valuesListLen; j++ {
 		compactChunk.SetZeroValues()
+		tag := mergeStrategyOf(merges, j).splitTag()
 		for i, axis := range plane.Axes {
 			chunks[i].SetValues(axis.ValuesList[j])
-			splitter.Split(compactChunk, chunks[i], splitAdd, i)
+			splitter.Split(compactChunk, chunks[i], tag, i)
 		}
 		valuesList[j] = compactChunk.Values
 	}
 	return CreateAxis(compactChunk.Keys, valuesList)
 }
 
-// Pixel pixelates Plane into a matrix with a number of rows close to the target.
-func (plane *Plane) Pixel(strategy *Strategy, target int, displayTags []string) Matrix {
+// Pixel pixelates Plane into a matrix with a number of rows close to the target. merges are the merge strategies of
+// displayTags, the one of the base column decides how keys are divided.
+func (plane *Plane) Pixel(strategy *Strategy, target int, displayTags []string, merges []MergeStrategy) Matrix {
 	valuesListLen := len(plane.Axes[0].ValuesList)
 	if valuesListLen != len(displayTags) {
 		panic("the length of displayTags and valuesList should be equal")
@@ -63,10 +94,12 @@ func (plane *Plane) Pixel(strategy *Strategy, target int, displayTags []string)
 	for i, axis := range plane.Axes {
 		chunks[i] = createChunk(axis.Keys, axis.ValuesList[0])
 	}
-	compactChunk, splitter := compact(strategy, chunks)
-	labeler := strategy.NewLabeler()
+	compactChunk, splitter := compact(strategy, chunks, mergeStrategyOf(merges, 0))
+	// Keys are divided with the schema at the end of the plane, so that tables dropped within the plane are labeled.
+	labeler := decorator.NewLabelerAt(strategy.LabelStrategy, plane.Times[len(plane.Times)-1])
 	baseKeys := compactChunk.Divide(labeler, target, NotMergeLogicalRange).Keys
 	matrix := CreateMatrix(labeler, plane.Times, baseKeys, valuesListLen)
+	matrix.labelColumns(strategy.LabelStrategy, plane.Times)
 
 	var wg sync.WaitGroup
 	var mutex sync.Mutex
@@ -74,10 +107,16 @@ func (plane *Plane) Pixel(strategy *Strategy, target int, displayTags []string)
 		defer wg.Done()
 		data := make([][]uint64, axesLen)
 		goCompactChunk := createZeroChunk(compactChunk.Keys)
+		merge := mergeStrategyOf(merges, j)
+		// Gauges are not divided when split into the cleared chunk.
+		tag := splitTo
+		if merge == MergeMax {
+			tag = splitMax
+		}
 		for i, axis := range plane.Axes {
 			goCompactChunk.Clear()
-			splitter.Split(goCompactChunk, createChunk(chunks[i].Keys, axis.ValuesList[j]), splitTo, i)
-			data[i] = goCompactChunk.Reduce(baseKeys).Values
+			splitter.Split(goCompactChunk, createChunk(chunks[i].Keys, axis.ValuesList[j]), tag, i)
+			data[i] = goCompactChunk.Reduce(baseKeys, merge).Values
 		}
 		mutex.Lock()
 		defer mutex.Unlock()
@@ -93,7 +132,7 @@ func (plane *Plane) Pixel(strategy *Strategy, target int, displayTags []string)
 	return matrix
 }
 
-func compact(strategy SplitStrategy, chunks []chunk) (compactChunk chunk, splitter Splitter) {
+func compact(strategy SplitStrategy, chunks []chunk, merge MergeStrategy) (compactChunk chunk, splitter Splitter) {
 	// get compact chunk keys
 	keySet := make(map[string]struct{})
 	unlimitedEnd := false
@@ -119,8 +158,9 @@ func compact(strategy SplitStrategy, chunks []chunk) (compactChunk chunk, splitt
 	compactChunk = createZeroChunk(compactKeys)
 
 	splitter = strategy.NewSplitter(chunks, compactChunk.Keys)
+	tag := merge.splitTag()
 	for i, c := range chunks {
-		splitter.Split(compactChunk, c, splitAdd, i)
+		splitter.Split(compactChunk, c, tag, i)
 	}
 	return
 }
@@ -3,9 +3,114 @@
 package matrix
 
 import (
+	"time"
+
 	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
 )
 
 var _ = Suite(&testPlaneSuite{})
 
 type testPlaneSuite struct{}
+
+func (t *testPlaneSuite) TestCompactGauge(c *C) {
+	times := []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0)}
+	axes := []Axis{
+		// The first column is a flow, the second one is a gauge.
+		CreateAxis([]string{"a", "c"}, [][]uint64{{10}, {20}}),
+		CreateAxis([]string{"a", "b", "c"}, [][]uint64{{4, 6}, {6, 30}}),
+	}
+	plane := CreatePlane(times, axes)
+
+	axis := plane.Compact(AverageSplitStrategy(), []MergeStrategy{MergeSum, MergeMax})
+	c.Assert(axis.Keys, DeepEquals, []string{"a", "b", "c"})
+	c.Assert(axis.ValuesList[0], DeepEquals, []uint64{9, 11})
+	// Gauges are not divided when the bucket is split.
+	c.Assert(axis.ValuesList[1], DeepEquals, []uint64{20, 30})
+
+	// Averaging sums does not apply to gauges.
+	axis.Shrink(2, []MergeStrategy{MergeSum, MergeMax})
+	c.Assert(axis.ValuesList[0], DeepEquals, []uint64{4, 5})
+	c.Assert(axis.ValuesList[1], DeepEquals, []uint64{20, 30})
+}
+
+// renameLabelStrategy labels key "b" as "old" before renamedAt, and "new" since then.
+type renameLabelStrategy struct {
+	renamedAt time.Time
+}
+
+func (s renameLabelStrategy) ReloadConfig(cfg *config.KeyVisualConfig) {}
+
+func (s renameLabelStrategy) NewLabeler() decorator.Labeler {
+	return s.NewLabelerAt(time.Now())
+}
+
+func (s renameLabelStrategy) NewLabelerAt(t time.Time) decorator.Labeler {
+	return renameLabeler{renamed: !t.Before(s.renamedAt)}
+}
+
+type renameLabeler struct {
+	renamed bool
+}
+
+func (l renameLabeler) CrossBorder(startKey, endKey string) bool {
+	return false
+}
+
+func (l renameLabeler) Label(keys []string) []decorator.LabelKey {
+	labelKeys := make([]decorator.LabelKey, len(keys))
+	for i, key := range keys {
+		label := key
+		if key == "b" {
+			label = "old"
+			if l.renamed {
+				label = "new"
+			}
+		}
+		labelKeys[i] = decorator.LabelKey{Key: key, Labels: []string{label}}
+	}
+	return labelKeys
+}
+
+func (t *testPlaneSuite) TestPixelGauge(c *C) {
+	times := []time.Time{time.Unix(0, 0), time.Unix(60, 0)}
+	axes := []Axis{
+		CreateAxis([]string{"a", "b", "c", "d"}, [][]uint64{{4, 6, 10}, {30, 10, 20}}),
+	}
+	plane := CreatePlane(times, axes)
+	strategy := &Strategy{
+		LabelStrategy: decorator.NaiveLabelStrategy(),
+		SplitStrategy: AverageSplitStrategy(),
+	}
+
+	mx := plane.Pixel(strategy, 2, []string{"flow", "gauge"}, []MergeStrategy{MergeSum, MergeMax})
+	c.Assert(mx.Keys, DeepEquals, []string{"a", "d"})
+	c.Assert(mx.DataMap["flow"], DeepEquals, [][]uint64{{20}})
+	// Gauges of merged rows are not added up.
+	c.Assert(mx.DataMap["gauge"], DeepEquals, [][]uint64{{30}})
+}
+
+func (t *testPlaneSuite) TestPixelLabelColumns(c *C) {
+	times := []time.Time{time.Unix(0, 0), time.Unix(60, 0), time.Unix(120, 0), time.Unix(180, 0)}
+	axes := []Axis{
+		CreateAxis([]string{"a", "b", "c"}, [][]uint64{{1, 2}}),
+		CreateAxis([]string{"a", "b", "c"}, [][]uint64{{3, 4}}),
+		CreateAxis([]string{"a", "b", "c"}, [][]uint64{{5, 6}}),
+	}
+	plane := CreatePlane(times, axes)
+	strategy := &Strategy{
+		LabelStrategy: renameLabelStrategy{renamedAt: time.Unix(150, 0)},
+		SplitStrategy: AverageSplitStrategy(),
+	}
+
+	mx := plane.Pixel(strategy, 2, []string{"tag"}, []MergeStrategy{MergeSum})
+	c.Assert(mx.KeyAxis[1].Labels, DeepEquals, []string{"new"})
+	// The first two columns end before the rename and share the same labels.
+	c.Assert(mx.ColumnKeyAxes, HasLen, 1)
+	c.Assert(mx.ColumnKeyAxes[0].Column, Equals, 1)
+	c.Assert(mx.ColumnKeyAxes[0].KeyAxis[1].Labels, DeepEquals, []string{"old"})
+	c.Assert(mx.keyAxisAt(0)[1].Labels, DeepEquals, []string{"old"})
+	c.Assert(mx.keyAxisAt(2)[1].Labels, DeepEquals, []string{"new"})
+}
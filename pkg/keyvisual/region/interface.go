@@ -11,10 +11,12 @@ type RegionsInfo interface {
 type RegionsInfoGenerator func() (RegionsInfo, error)
 
 type DataProvider struct {
+	// Snapshot mode, which views a snapshot exported by the key visualizer.
+	SnapshotFile string
 	// File mode (debug)
 	FileStartTime int64
 	FileEndTime   int64
 	// API or Core mode
-	// This item takes effect only when both FileStartTime and FileEndTime are 0.
+	// This item takes effect only when SnapshotFile is empty and both FileStartTime and FileEndTime are 0.
 	PeriodicGetter RegionsInfoGenerator
 }
@@ -16,47 +16,81 @@ const (
 	WrittenKeys
 	// ReadKeys is the number of keys read to the data per minute.
 	ReadKeys
+	// RegionSize is the approximate size of the regions in MiB.
+	RegionSize
+	// RegionKeys is the approximate number of keys in the regions.
+	RegionKeys
+	// LeaderCount is the number of leaders of the store that holds the leader of the regions.
+	LeaderCount
+	// PeerCount is the number of peers of the regions.
+	PeerCount
+	// ReadQueries is the number of read queries per minute. It is only available when PD reports query stats.
+	ReadQueries
+	// WriteQueries is the number of write queries per minute. It is only available when PD reports query stats.
+	WriteQueries
 )
 
-// IntoTag converts a string into a StatTag.
+var tagNames = map[StatTag]string{
+	Integration:  "integration",
+	WrittenBytes: "written_bytes",
+	ReadBytes:    "read_bytes",
+	WrittenKeys:  "written_keys",
+	ReadKeys:     "read_keys",
+	RegionSize:   "region_size",
+	RegionKeys:   "region_keys",
+	LeaderCount:  "leader_count",
+	PeerCount:    "peer_count",
+	ReadQueries:  "read_queries",
+	WriteQueries: "write_queries",
+}
+
+// IntoTag converts a string into a StatTag. An empty string is Integration, and an unknown string is WrittenBytes.
 func IntoTag(typ string) StatTag {
-	switch typ {
-	case "":
+	if typ == "" {
 		return Integration
-	case "integration":
-		return Integration
-	case "written_bytes":
-		return WrittenBytes
-	case "read_bytes":
-		return ReadBytes
-	case "written_keys":
-		return WrittenKeys
-	case "read_keys":
-		return ReadKeys
-	default:
-		return WrittenBytes
 	}
+	if tag, ok := ParseTag(typ); ok {
+		return tag
+	}
+	return WrittenBytes
 }
 
-func (tag StatTag) String() string {
+// ParseTag converts the name of a tag into a StatTag. It returns false if there is no such tag.
+func ParseTag(name string) (StatTag, bool) {
+	for tag, tagName := range tagNames {
+		if tagName == name {
+			return tag, true
+		}
+	}
+	return 0, false
+}
+
+// IsGauge returns whether the tag is a gauge sampled at each time, rather than a flow within each minute.
+func (tag StatTag) IsGauge() bool {
 	switch tag {
-	case Integration:
-		return "integration"
-	case WrittenBytes:
-		return "written_bytes"
-	case ReadBytes:
-		return "read_bytes"
-	case WrittenKeys:
-		return "written_keys"
-	case ReadKeys:
-		return "read_keys"
+	case RegionSize, RegionKeys, LeaderCount, PeerCount:
+		return true
 	default:
-		panic("unreachable")
+		return false
+	}
+}
+
+func (tag StatTag) String() string {
+	if name, ok := tagNames[tag]; ok {
+		return name
 	}
+	panic("unreachable")
+}
+
+// StorageTags is the order of tags during storage. New tags must be appended to the end, so that axes stored
+// previously, which contain fewer tags, are still valid prefixes. See LegacyStorageTagsLen.
+var StorageTags = []StatTag{
+	WrittenBytes, ReadBytes, WrittenKeys, ReadKeys,
+	RegionSize, RegionKeys, LeaderCount, PeerCount, ReadQueries, WriteQueries,
 }
 
-// StorageTags is the order of tags during storage.
-var StorageTags = []StatTag{WrittenBytes, ReadBytes, WrittenKeys, ReadKeys}
+// LegacyStorageTagsLen is the number of tags stored by versions that only support read and write flows.
+const LegacyStorageTagsLen = 4
 
 // ResponseTags is the order of tags when responding.
 var ResponseTags = append([]StatTag{Integration}, StorageTags...)
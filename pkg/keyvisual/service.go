@@ -5,8 +5,11 @@ package keyvisual
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
 	"math"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"time"
@@ -19,6 +22,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	apiutils "github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
@@ -29,11 +33,18 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/pd"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 	"github.com/pingcap/tidb-dashboard/pkg/utils"
+	"github.com/pingcap/tidb-dashboard/util/rest"
 )
 
 const (
 	heatmapsMaxDisplayY = 1536
 
+	hotspotsDefaultLimit                = 10
+	hotspotsMaxLimit                    = 100
+	hotspotsDefaultMinPersistentColumns = 5
+	hotspotsDefaultRecentColumns        = 5
+	hotspotsDefaultMinSurgeRatio        = 3.0
+
 	distanceStrategyRatio = 1.0 / math.Phi
 	distanceStrategyLevel = 15
 	distanceStrategyCount = 50
@@ -73,6 +84,8 @@ type Service struct {
 	stat          *storage.Stat
 	strategy      *matrix.Strategy
 	labelStrategy decorator.LabelStrategy
+	// snapshot is not nil when viewing an exported snapshot.
+	snapshot *storage.Snapshot
 }
 
 // FIXME: Simplify these things.
@@ -104,13 +117,17 @@ func NewService(
 
 func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/keyvisual")
+	endpoint.GET("/snapshot/download", s.status.MWHandleStopped(stoppedHandler), s.downloadSnapshot)
+
 	endpoint.Use(auth.MWAuthRequired())
 
 	endpoint.GET("/config", s.getDynamicConfig)
-	endpoint.PUT("/config", auth.MWRequireWritePriv(), s.setDynamicConfig)
+	endpoint.PUT("/config", auth.MWRequirePermission(user.PermKeyVisualConfig), s.setDynamicConfig)
 
 	endpoint.Use(s.status.MWHandleStopped(stoppedHandler))
 	endpoint.GET("/heatmaps", s.heatmaps)
+	endpoint.GET("/hotspots", s.hotspots)
+	endpoint.GET("/snapshot/acquire_token", s.getSnapshotToken)
 }
 
 func (s *Service) IsRunning() bool {
@@ -132,10 +149,11 @@ func (s *Service) Start(ctx context.Context) error {
 			newStat,
 			s.provideLocals,
 			s.newProvider,
+			s.newSnapshot,
 			input.NewStatInput,
 			s.newLabelStrategy,
 		),
-		fx.Populate(&s.stat, &s.strategy, &s.labelStrategy),
+		fx.Populate(&s.stat, &s.strategy, &s.labelStrategy, &s.snapshot),
 		fx.Invoke(
 			// Must be at the end
 			s.status.Register,
@@ -155,15 +173,25 @@ func (s *Service) newLabelStrategy(
 	wg *sync.WaitGroup,
 	etcdClient *clientv3.Client,
 	tidbClient *tidb.Client,
+	db *dbstore.DB,
+	snapshot *storage.Snapshot,
 ) decorator.LabelStrategy {
+	if snapshot != nil {
+		log.Debug("New LabelStrategy", zap.String("policy", snapshot.Policy), zap.Bool("snapshot", true))
+		return decorator.SnapshotLabelStrategy(&snapshot.Labels)
+	}
 	switch s.keyVisualCfg.Policy {
 	case config.KeyVisualDBPolicy:
 		log.Debug("New LabelStrategy", zap.String("policy", s.keyVisualCfg.Policy))
-		return decorator.TiDBLabelStrategy(lc, wg, etcdClient, tidbClient)
+		return decorator.TiDBLabelStrategy(lc, wg, etcdClient, tidbClient, db)
 	case config.KeyVisualKVPolicy:
 		log.Debug("New LabelStrategy", zap.String("policy", s.keyVisualCfg.Policy),
 			zap.String("separator", s.keyVisualCfg.PolicyKVSeparator))
 		return decorator.SeparatorLabelStrategy(s.keyVisualCfg)
+	case config.KeyVisualRulePolicy:
+		log.Debug("New LabelStrategy", zap.String("policy", s.keyVisualCfg.Policy),
+			zap.Int("rules", len(s.keyVisualCfg.PolicyRules)))
+		return decorator.RuleLabelStrategy(s.keyVisualCfg)
 	default:
 		panic("unreachable")
 	}
@@ -178,6 +206,18 @@ func (s *Service) newProvider(pdClient *pd.Client) *region.DataProvider {
 	}
 }
 
+func (s *Service) newSnapshot(provider *region.DataProvider) (*storage.Snapshot, error) {
+	if provider.SnapshotFile == "" {
+		return nil, nil
+	}
+	file, err := os.Open(filepath.Clean(provider.SnapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() // #nosec
+	return storage.ReadSnapshot(file)
+}
+
 func (s *Service) reloadKeyVisualConfig(cfg *config.KeyVisualConfig) {
 	s.keyVisualCfg = cfg
 	if s.labelStrategy != nil {
@@ -193,6 +233,7 @@ func (s *Service) cleanAfterError() {
 	s.stat = nil
 	s.strategy = nil
 	s.labelStrategy = nil
+	s.snapshot = nil
 	s.ctx = nil
 	s.cancel = nil
 }
@@ -210,6 +251,7 @@ func (s *Service) Stop(ctx context.Context) error {
 	s.stat = nil
 	s.strategy = nil
 	s.labelStrategy = nil
+	s.snapshot = nil
 	s.ctx = nil
 	s.cancel = nil
 
@@ -217,12 +259,12 @@ func (s *Service) Stop(ctx context.Context) error {
 }
 
 // @Summary Key Visual Heatmaps
-// @Description Heatmaps in a given range to visualize TiKV usage
+// @Description Heatmaps in a given range to visualize TiKV usage. keyAxis is labeled with the schema as of the end of the time range, while columnKeyAxes holds labels of earlier columns that differ, e.g. before a table is renamed.
 // @Param startkey query string false "The start of the key range"
 // @Param endkey query string false "The end of the key range"
 // @Param starttime query int false "The start of the time range (Unix)"
 // @Param endtime query int false "The end of the time range (Unix)"
-// @Param type query string false "Main types of data" Enums(written_bytes, read_bytes, written_keys, read_keys, integration)
+// @Param type query string false "Main types of data" Enums(written_bytes, read_bytes, written_keys, read_keys, integration, region_size, region_keys, leader_count, peer_count, read_queries, write_queries)
 // @Success 200 {object} matrix.Matrix
 // @Router /keyvisual/heatmaps [get]
 // @Security JwtAuth
@@ -236,6 +278,10 @@ func (s *Service) heatmaps(c *gin.Context) {
 
 	endTime := time.Now()
 	startTime := endTime.Add(-360 * time.Minute)
+	if s.snapshot != nil {
+		endTime = s.snapshot.EndTime
+		startTime = s.snapshot.StartTime
+	}
 	if startTimeString != "" {
 		tsSec, err := strconv.ParseInt(startTimeString, 10, 64)
 		if err != nil {
@@ -281,7 +327,8 @@ func (s *Service) heatmaps(c *gin.Context) {
 	}
 	baseTag := region.IntoTag(typ)
 	plane := s.stat.Range(startTime, endTime, startKey, endKey, baseTag)
-	resp := plane.Pixel(s.strategy, heatmapsMaxDisplayY, region.GetDisplayTags(baseTag))
+	displayTags := region.GetDisplayTags(baseTag)
+	resp := plane.Pixel(s.strategy, heatmapsMaxDisplayY, displayTags, storage.DisplayMergeStrategies(displayTags))
 	resp.Range(startKey, endKey)
 	// TODO: An expedient to reduce data transmission, which needs to be deleted later.
 	resp.DataMap = map[string][][]uint64{
@@ -291,6 +338,190 @@ func (s *Service) heatmaps(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+type HotspotsRequest struct {
+	StartKey             string  `form:"startkey"`
+	EndKey               string  `form:"endkey"`
+	StartTime            int64   `form:"starttime"`
+	EndTime              int64   `form:"endtime"`
+	Type                 string  `form:"type"`
+	Limit                int     `form:"limit"`
+	MinPersistentColumns int     `form:"min_persistent_columns"`
+	RecentColumns        int     `form:"recent_columns"`
+	MinSurgeRatio        float64 `form:"min_surge_ratio"`
+}
+
+type HotspotsResponse struct {
+	StartTime int64 `json:"start_time"`
+	EndTime   int64 `json:"end_time"`
+	// Hotspots of each type of data.
+	Hotspots map[string]*matrix.Hotspots `json:"hotspots"`
+}
+
+// @Summary Key Visual Hotspots
+// @Description Find the top ranges, persistent hotspots and sudden surges in a given range for each type of flow data, e.g. written_bytes, or for the given type. Gauges such as region_size are not analyzed. Keys of a range are labeled with the schema as of its peak.
+// @Param q query HotspotsRequest true "Query"
+// @Success 200 {object} HotspotsResponse
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Router /keyvisual/hotspots [get]
+// @Security JwtAuth
+func (s *Service) hotspots(c *gin.Context) {
+	var req HotspotsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		rest.Error(c, rest.ErrBadRequest.WrapWithNoMessage(err))
+		return
+	}
+
+	endTime := time.Now()
+	startTime := endTime.Add(-time.Hour)
+	if s.snapshot != nil {
+		endTime = s.snapshot.EndTime
+		startTime = s.snapshot.StartTime
+	}
+	if req.StartTime != 0 {
+		startTime = time.Unix(req.StartTime, 0)
+	}
+	if req.EndTime != 0 {
+		endTime = time.Unix(req.EndTime, 0)
+	}
+	startKeyBytes, err := hex.DecodeString(req.StartKey)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.New("invalid startkey"))
+		return
+	}
+	endKeyBytes, err := hex.DecodeString(req.EndKey)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.New("invalid endkey"))
+		return
+	}
+	startKey, endKey := string(startKeyBytes), string(endKeyBytes)
+	if !(startTime.Before(endTime) && (endKey == "" || startKey < endKey)) {
+		rest.Error(c, rest.ErrBadRequest.New("invalid time range or key range"))
+		return
+	}
+	var tags []region.StatTag
+	if req.Type != "" {
+		tag, ok := region.ParseTag(req.Type)
+		if !ok || tag.IsGauge() {
+			rest.Error(c, rest.ErrBadRequest.New("invalid type %s", req.Type))
+			return
+		}
+		tags = []region.StatTag{tag}
+	} else {
+		// Hotspots and surges of gauges are not meaningful, e.g. a large region is not hot.
+		for _, tag := range region.ResponseTags {
+			if !tag.IsGauge() {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	opts := matrix.HotspotOptions{
+		Limit:                req.Limit,
+		MinPersistentColumns: req.MinPersistentColumns,
+		RecentColumns:        req.RecentColumns,
+		MinSurgeRatio:        req.MinSurgeRatio,
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = hotspotsDefaultLimit
+	}
+	if opts.Limit > hotspotsMaxLimit {
+		opts.Limit = hotspotsMaxLimit
+	}
+	if opts.MinPersistentColumns <= 0 {
+		opts.MinPersistentColumns = hotspotsDefaultMinPersistentColumns
+	}
+	if opts.RecentColumns <= 0 {
+		opts.RecentColumns = hotspotsDefaultRecentColumns
+	}
+	if opts.MinSurgeRatio <= 0 {
+		opts.MinSurgeRatio = hotspotsDefaultMinSurgeRatio
+	}
+
+	plane := s.stat.Range(startTime, endTime, startKey, endKey, region.Integration)
+	displayTags := region.GetDisplayTags(region.Integration)
+	mx := plane.Pixel(s.strategy, heatmapsMaxDisplayY, displayTags, storage.DisplayMergeStrategies(displayTags))
+	mx.Range(startKey, endKey)
+
+	resp := HotspotsResponse{
+		StartTime: startTime.Unix(),
+		EndTime:   endTime.Unix(),
+		Hotspots:  make(map[string]*matrix.Hotspots, len(tags)),
+	}
+	for _, tag := range tags {
+		resp.Hotspots[tag.String()] = mx.Hotspots(tag.String(), opts)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @Summary Generate a download token for exporting a heatmap snapshot
+// @Produce plain
+// @Param starttime query int true "The start of the time range (Unix)"
+// @Param endtime query int true "The end of the time range (Unix)"
+// @Security JwtAuth
+// @Success 200 {string} string "xxx"
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 401 {object} rest.ErrorResponse
+// @Router /keyvisual/snapshot/acquire_token [get]
+func (s *Service) getSnapshotToken(c *gin.Context) {
+	startTime, err := strconv.ParseInt(c.Query("starttime"), 10, 64)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.New("invalid starttime"))
+		return
+	}
+	endTime, err := strconv.ParseInt(c.Query("endtime"), 10, 64)
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.New("invalid endtime"))
+		return
+	}
+	if startTime >= endTime {
+		rest.Error(c, rest.ErrBadRequest.New("starttime must be smaller than endtime"))
+		return
+	}
+	token, err := apiutils.NewJWTString("keyvisual/snapshot", fmt.Sprintf("%d,%d", startTime, endTime))
+	if err != nil {
+		rest.Error(c, err)
+		return
+	}
+	c.String(http.StatusOK, token)
+}
+
+// @Summary Export a heatmap snapshot
+// @Description Export statistics in a time range with key labels into a gzip compressed snapshot, which can be viewed by starting the standalone Dashboard Server with `--keyviz-snapshot`. Keys are labeled with the schema as of the end of the time range.
+// @Produce application/x-gzip
+// @Param token query string true "download token"
+// @Failure 400 {object} rest.ErrorResponse
+// @Failure 500 {object} rest.ErrorResponse
+// @Router /keyvisual/snapshot/download [get]
+func (s *Service) downloadSnapshot(c *gin.Context) {
+	str, err := apiutils.ParseJWTString("keyvisual/snapshot", c.Query("token"))
+	if err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+	var startTime, endTime int64
+	if _, err := fmt.Sscanf(str, "%d,%d", &startTime, &endTime); err != nil {
+		rest.Error(c, rest.ErrBadRequest.NewWithNoMessage())
+		return
+	}
+
+	policy := s.keyVisualCfg.Policy
+	if s.snapshot != nil {
+		policy = s.snapshot.Policy
+	}
+	labeler := decorator.NewLabelerAt(s.labelStrategy, time.Unix(endTime, 0))
+	snap := s.stat.Snapshot(time.Unix(startTime, 0), time.Unix(endTime, 0), policy, labeler)
+
+	fileName := fmt.Sprintf("keyviz_%s_%s.snapshot.gz",
+		time.Unix(startTime, 0).Format("2006-01-02_15-04-05"),
+		time.Unix(endTime, 0).Format("2006-01-02_15-04-05"))
+	c.Writer.Header().Set("Content-type", "application/x-gzip")
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	if err := storage.WriteSnapshot(c.Writer, snap); err != nil {
+		log.Warn("Failed to write keyvisual snapshot", zap.Error(err))
+	}
+}
+
 func (s *Service) provideLocals() (*config.Config, *clientv3.Client, *pd.Client, *dbstore.DB, *tidb.Client) {
 	return s.config, s.etcdClient, s.pdClient, s.db, s.tidbClient
 }
@@ -41,10 +41,32 @@ func CreateStorageAxis(regions region.RegionsInfo, labeler decorator.Labeler) ma
 	return axis
 }
 
+// MergeStrategies returns how values of tags are merged over time, in the same order as tags.
+func MergeStrategies(tags []region.StatTag) []matrix.MergeStrategy {
+	merges := make([]matrix.MergeStrategy, len(tags))
+	for i, tag := range tags {
+		if tag.IsGauge() {
+			merges[i] = matrix.MergeMax
+		} else {
+			merges[i] = matrix.MergeSum
+		}
+	}
+	return merges
+}
+
+// DisplayMergeStrategies returns merge strategies of tags returned by region.GetDisplayTags.
+func DisplayMergeStrategies(displayTags []string) []matrix.MergeStrategy {
+	tags := make([]region.StatTag, len(displayTags))
+	for i, name := range displayTags {
+		tags[i] = region.IntoTag(name)
+	}
+	return MergeStrategies(tags)
+}
+
 // IntoStorageAxis converts ResponseAxis to StorageAxis.
 func IntoStorageAxis(responseAxis matrix.Axis, labeler decorator.Labeler) matrix.Axis {
 	// axis := preAxis.Focus(strategy, preThreshold, len(keys)/preRatioTarget, preTarget)
-	axis := responseAxis.Divide(labeler, preTarget)
+	axis := responseAxis.Divide(labeler, preTarget, MergeStrategies(region.ResponseTags))
 	var storageValuesList [][]uint64
 	storageValuesList = append(storageValuesList, axis.ValuesList[1:]...)
 	return matrix.CreateAxis(axis.Keys, storageValuesList)
@@ -72,6 +94,16 @@ func IntoResponseAxis(storageAxis matrix.Axis, baseTag region.StatTag) matrix.Ax
 	panic("unreachable")
 }
 
+// upgradeStorageAxis appends zero values for tags that did not exist when the StorageAxis was stored.
+func upgradeStorageAxis(axis *matrix.Axis) {
+	if len(axis.Keys) == 0 {
+		return
+	}
+	for len(axis.ValuesList) < len(region.StorageTags) {
+		axis.ValuesList = append(axis.ValuesList, make([]uint64, len(axis.Keys)-1))
+	}
+}
+
 // TODO: Temporary solution, need to trace the source of dirty data.
 func wash(axis *matrix.Axis) {
 	for i, value := range axis.ValuesList[1] {
@@ -0,0 +1,217 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/matrix"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/region"
+)
+
+// SnapshotVersion is the version of the snapshot format. It must be increased when the format of Snapshot or the
+// storage axis changes.
+const SnapshotVersion = 1
+
+var (
+	ErrNS              = errorx.NewNamespace("error.keyvisual")
+	ErrNSStorage       = ErrNS.NewSubNamespace("storage")
+	ErrInvalidSnapshot = ErrNSStorage.NewType("invalid_snapshot")
+)
+
+// SnapshotLayer contains axes of a layerStat. Axes[i] covers the time range (Times[i-1], Times[i]], where Times[-1]
+// is StartTime.
+type SnapshotLayer struct {
+	LayerNum  uint8
+	StartTime time.Time
+	Times     []time.Time
+	Axes      []matrix.Axis
+}
+
+// Snapshot is a portable copy of the statistics in a time range. Labels of all keys are included, so that the
+// snapshot can be viewed without accessing the cluster it comes from.
+type Snapshot struct {
+	Version   int
+	CreatedAt time.Time
+	StartTime time.Time
+	EndTime   time.Time
+	Policy    string
+	Layers    []SnapshotLayer
+	Labels    decorator.LabelSnapshot
+}
+
+func (snap *Snapshot) validate() error {
+	if snap.Version != SnapshotVersion {
+		return ErrInvalidSnapshot.New("unsupported snapshot version %d", snap.Version)
+	}
+	for i, layer := range snap.Layers {
+		if int(layer.LayerNum) != i {
+			return ErrInvalidSnapshot.New("layer %d is out of order", layer.LayerNum)
+		}
+		if len(layer.Times) != len(layer.Axes) {
+			return ErrInvalidSnapshot.New("layer %d has %d times but %d axes", layer.LayerNum, len(layer.Times), len(layer.Axes))
+		}
+		prev := layer.StartTime
+		for j, axis := range layer.Axes {
+			if !layer.Times[j].After(prev) {
+				return ErrInvalidSnapshot.New("times of layer %d are not in order", layer.LayerNum)
+			}
+			prev = layer.Times[j]
+			if len(axis.Keys) <= 1 || len(axis.ValuesList) < region.LegacyStorageTagsLen ||
+				len(axis.ValuesList) > len(region.StorageTags) {
+				return ErrInvalidSnapshot.New("malformed axis in layer %d", layer.LayerNum)
+			}
+			for _, values := range axis.ValuesList {
+				if len(values)+1 != len(axis.Keys) {
+					return ErrInvalidSnapshot.New("malformed axis in layer %d", layer.LayerNum)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteSnapshot writes the gzip compressed snapshot.
+func WriteSnapshot(w io.Writer, snap *Snapshot) error {
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(snap); err != nil {
+		_ = zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadSnapshot reads and validates a snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Snapshot, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, ErrInvalidSnapshot.Wrap(err, "snapshot is not gzip compressed")
+	}
+	defer zr.Close() // #nosec
+	var snap Snapshot
+	if err := gob.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, ErrInvalidSnapshot.Wrap(err, "failed to decode snapshot")
+	}
+	if err := snap.validate(); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+func (s *layerStat) size() int {
+	if s.Empty {
+		return 0
+	}
+	size := s.Tail - s.Head
+	if size <= 0 {
+		size += s.Len
+	}
+	return size
+}
+
+// snapshot copies axes overlapping with the time range.
+func (s *layerStat) snapshot(startTime, endTime time.Time) SnapshotLayer {
+	layer := SnapshotLayer{LayerNum: s.LayerNum}
+	prev := s.StartTime
+	for i := 0; i < s.size(); i++ {
+		idx := (s.Head + i) % s.Len
+		t := s.RingTimes[idx]
+		if t.After(startTime) && prev.Before(endTime) {
+			if len(layer.Times) == 0 {
+				layer.StartTime = prev
+			}
+			layer.Times = append(layer.Times, t)
+			layer.Axes = append(layer.Axes, s.RingAxes[idx])
+		}
+		prev = t
+	}
+	return layer
+}
+
+// load replaces all axes of the layer by the snapshot layer. Only the latest Len axes are kept.
+func (s *layerStat) load(layer SnapshotLayer, keyMap *matrix.KeyMap) {
+	times, axes := layer.Times, layer.Axes
+	startTime := layer.StartTime
+	if n := len(axes) - s.Len; n > 0 {
+		startTime = times[n-1]
+		times, axes = times[n:], axes[n:]
+	}
+
+	s.RingAxes = make([]matrix.Axis, s.Len)
+	s.RingTimes = make([]time.Time, s.Len)
+	copy(s.RingAxes, axes)
+	copy(s.RingTimes, times)
+	for i := range axes {
+		upgradeStorageAxis(&s.RingAxes[i])
+		keyMap.SaveKeys(s.RingAxes[i].Keys)
+	}
+	s.StartTime = startTime
+	s.EndTime = startTime
+	if len(times) > 0 {
+		s.EndTime = times[len(times)-1]
+	}
+	s.Head = 0
+	s.Tail = len(axes) % s.Len
+	s.Empty = len(axes) == 0
+}
+
+// Snapshot exports axes of all layers in the time range. The keys are labeled by the labeler, so that the labels are
+// still available when the snapshot is viewed elsewhere.
+func (s *Stat) Snapshot(startTime, endTime time.Time, policy string, labeler decorator.Labeler) *Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	snap := &Snapshot{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now(),
+		StartTime: startTime,
+		EndTime:   endTime,
+		Policy:    policy,
+	}
+	keySet := make(map[string]struct{})
+	for _, layerStat := range s.layers {
+		layer := layerStat.snapshot(startTime, endTime)
+		for _, axis := range layer.Axes {
+			for _, key := range axis.Keys {
+				keySet[key] = struct{}{}
+			}
+		}
+		snap.Layers = append(snap.Layers, layer)
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	snap.Labels = *decorator.NewLabelSnapshot(labeler, keys)
+	return snap
+}
+
+// LoadSnapshot replaces all axes in memory by the snapshot. Axes are not persisted, so the stored statistics are not
+// affected and will be restored the next time the Stat starts.
+func (s *Stat) LoadSnapshot(snap *Snapshot) error {
+	if len(snap.Layers) > len(s.layers) {
+		return ErrInvalidSnapshot.New("snapshot has %d layers, but at most %d layers are supported", len(snap.Layers), len(s.layers))
+	}
+
+	s.keyMap.Lock()
+	defer s.keyMap.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, layerStat := range s.layers {
+		layer := SnapshotLayer{LayerNum: uint8(i), StartTime: snap.StartTime}
+		if i < len(snap.Layers) {
+			layer = snap.Layers[i]
+		}
+		layerStat.load(layer, &s.keyMap)
+	}
+	return nil
+}
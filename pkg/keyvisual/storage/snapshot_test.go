@@ -0,0 +1,133 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/matrix"
+	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/region"
+)
+
+var _ = Suite(&testSnapshotSuite{})
+
+type testSnapshotSuite struct{}
+
+func newTestStat(cfg StatConfig, startTime time.Time) *Stat {
+	layers := make([]*layerStat, len(cfg.LayersConfig))
+	for i, c := range cfg.LayersConfig {
+		layers[i] = newLayerStat(uint8(i), c, nil, startTime, nil)
+		if i > 0 {
+			layers[i-1].Next = layers[i]
+		}
+	}
+	return &Stat{layers: layers}
+}
+
+func newTestAxis(keys ...string) matrix.Axis {
+	valuesList := make([][]uint64, region.LegacyStorageTagsLen)
+	for i := range valuesList {
+		valuesList[i] = make([]uint64, len(keys)-1)
+		for j := range valuesList[i] {
+			valuesList[i][j] = uint64(i + j)
+		}
+	}
+	return matrix.CreateAxis(keys, valuesList)
+}
+
+func (t *testSnapshotSuite) TestSnapshotRoundTrip(c *C) {
+	cfg := StatConfig{LayersConfig: []LayerConfig{{Len: 3, Ratio: 3}, {Len: 2, Ratio: 0}}}
+	start := time.Unix(1000, 0)
+	minute := func(n int) time.Time { return start.Add(time.Duration(n) * time.Minute) }
+
+	src := newTestStat(cfg, start)
+	err := src.LoadSnapshot(&Snapshot{
+		StartTime: start,
+		Layers: []SnapshotLayer{
+			{
+				LayerNum:  0,
+				StartTime: minute(3),
+				Times:     []time.Time{minute(4), minute(5), minute(6)},
+				Axes:      []matrix.Axis{newTestAxis("", "a\xff"), newTestAxis("", "b"), newTestAxis("", "c", "")},
+			},
+			{
+				LayerNum:  1,
+				StartTime: start,
+				Times:     []time.Time{minute(3)},
+				Axes:      []matrix.Axis{newTestAxis("", "z")},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	snap := src.Snapshot(minute(4), minute(6), "kv", decorator.NaiveLabelStrategy().NewLabeler())
+	c.Assert(snap.Layers, HasLen, 2)
+	c.Assert(snap.Layers[0].StartTime, Equals, minute(4))
+	c.Assert(snap.Layers[0].Times, DeepEquals, []time.Time{minute(5), minute(6)})
+	c.Assert(snap.Layers[1].Times, HasLen, 0)
+	c.Assert(snap.Labels.Labels["b"].Labels, DeepEquals, []string{"62"})
+
+	var buf bytes.Buffer
+	c.Assert(WriteSnapshot(&buf, snap), IsNil)
+	loaded, err := ReadSnapshot(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(loaded.Policy, Equals, "kv")
+
+	dst := newTestStat(cfg, time.Now())
+	c.Assert(dst.LoadSnapshot(loaded), IsNil)
+	times, axes := dst.rangeRoot(minute(0), minute(10))
+	c.Assert(times, HasLen, 3)
+	c.Assert(times[0].Equal(minute(4)), Equals, true)
+	c.Assert(times[2].Equal(minute(6)), Equals, true)
+	c.Assert(axes[0].Keys, DeepEquals, []string{"", "b"})
+	c.Assert(axes[1].Keys, DeepEquals, []string{"", "c", ""})
+	c.Assert(axes[1].ValuesList, HasLen, len(region.StorageTags))
+	c.Assert(axes[1].ValuesList[:region.LegacyStorageTagsLen], DeepEquals, newTestAxis("", "c", "").ValuesList)
+	c.Assert(axes[1].ValuesList[region.LegacyStorageTagsLen], DeepEquals, []uint64{0, 0})
+}
+
+func (t *testSnapshotSuite) TestLoadSnapshotKeepsLatestAxes(c *C) {
+	start := time.Unix(1000, 0)
+	minute := func(n int) time.Time { return start.Add(time.Duration(n) * time.Minute) }
+
+	stat := newTestStat(StatConfig{LayersConfig: []LayerConfig{{Len: 2, Ratio: 0}}}, start)
+	err := stat.LoadSnapshot(&Snapshot{
+		Layers: []SnapshotLayer{{
+			StartTime: start,
+			Times:     []time.Time{minute(1), minute(2), minute(3)},
+			Axes:      []matrix.Axis{newTestAxis("", "a"), newTestAxis("", "b"), newTestAxis("", "c")},
+		}},
+	})
+	c.Assert(err, IsNil)
+	times, axes := stat.rangeRoot(minute(0), minute(10))
+	c.Assert(times, DeepEquals, []time.Time{minute(1), minute(2), minute(3)})
+	c.Assert(axes, HasLen, 2)
+	c.Assert(axes[1].Keys, DeepEquals, []string{"", "c"})
+
+	err = stat.LoadSnapshot(&Snapshot{Layers: make([]SnapshotLayer, 2)})
+	c.Assert(err, NotNil)
+}
+
+func (t *testSnapshotSuite) TestReadInvalidSnapshot(c *C) {
+	_, err := ReadSnapshot(bytes.NewBufferString("foo"))
+	c.Assert(err, NotNil)
+
+	for _, snap := range []*Snapshot{
+		{Version: SnapshotVersion + 1},
+		{Version: SnapshotVersion, Layers: []SnapshotLayer{{LayerNum: 1}}},
+		{Version: SnapshotVersion, Layers: []SnapshotLayer{{Times: []time.Time{time.Unix(1, 0)}}}},
+		{Version: SnapshotVersion, Layers: []SnapshotLayer{{
+			Times: []time.Time{time.Unix(1, 0)},
+			Axes:  []matrix.Axis{{Keys: []string{"", "a"}, ValuesList: [][]uint64{{1}}}},
+		}}},
+	} {
+		var buf bytes.Buffer
+		c.Assert(WriteSnapshot(&buf, snap), IsNil)
+		_, err := ReadSnapshot(&buf)
+		c.Assert(err, NotNil)
+	}
+}
@@ -94,10 +94,11 @@ func (s *layerStat) Reduce(labeler decorator.Labeler) {
 	}
 
 	plane := matrix.CreatePlane(times, axes)
-	newAxis := plane.Compact(s.SplitStrategy)
+	merges := MergeStrategies(region.StorageTags)
+	newAxis := plane.Compact(s.SplitStrategy, merges)
 	newAxis = IntoResponseAxis(newAxis, region.Integration)
 	newAxis = IntoStorageAxis(newAxis, labeler)
-	newAxis.Shrink(uint64(s.Ratio))
+	newAxis.Shrink(uint64(s.Ratio), merges)
 	s.Next.Append(newAxis, s.StartTime, labeler)
 }
 
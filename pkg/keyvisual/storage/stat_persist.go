@@ -106,6 +106,7 @@ func (s *Stat) Restore() error {
 			if err != nil {
 				return err
 			}
+			upgradeStorageAxis(&axis)
 			s.keyMap.SaveKeys(axis.Keys)
 			s.layers[layerNum].RingAxes[i] = axis
 		}
@@ -92,6 +92,24 @@ func (s *testInfoSuite) TestWithShareCodeAndWritePrivLoginUser() {
 	s.Require().Equal(res.IsShareable, false)
 }
 
+func (s *testInfoSuite) TestWithRevokedShareCode() {
+	rootUserToken := s.getTokenBySQLRoot()
+	shareCode := s.shareCode(rootUserToken, false)
+	shareCodeUserToken := s.getTokenByShareCode(shareCode)
+
+	codes, err := s.codeService.ListSharingCodes("root")
+	s.Require().Nil(err)
+	s.Require().NotEmpty(codes)
+	s.Require().Nil(s.codeService.RevokeSharingCode(codes[0].ID, "root"))
+
+	req, _ := http.NewRequest(http.MethodGet, "/info/whoami", nil)
+	req.Header.Add("Authorization", "Bearer "+shareCodeUserToken)
+	_, w := util.TestReqWithHandlers(req, s.authService.MWAuthRequired(), s.infoService.WhoamiHandler)
+	s.Require().Equal(401, w.Code)
+
+	s.Require().Nil(s.codeService.NewSessionFromSharingCode(shareCode))
+}
+
 func (s *testInfoSuite) getTokenBySQLRoot() string {
 	param := make(map[string]interface{})
 	param["type"] = 0
@@ -0,0 +1,376 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package csvutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/henrylee2cn/ameda"
+)
+
+// A minimal Parquet writer. Columns are flat and required, values are PLAIN encoded without compression, and each
+// column chunk of a row group is written as a single data page. See https://github.com/apache/parquet-format.
+
+const (
+	parquetMagic = "PAR1"
+	// parquetMaxRowGroupBytes is the max size of values buffered before a row group is written.
+	parquetMaxRowGroupBytes = 16 << 20
+	parquetCreatedBy        = "tidb-dashboard"
+
+	parquetTypeBoolean   int32 = 0
+	parquetTypeInt64     int32 = 2
+	parquetTypeDouble    int32 = 5
+	parquetTypeByteArray int32 = 6
+
+	parquetConvertedNone            int32 = -1
+	parquetConvertedUTF8            int32 = 0
+	parquetConvertedTimestampMillis int32 = 9
+	parquetConvertedUint64          int32 = 14
+
+	parquetRepetitionRequired int32 = 0
+	parquetEncodingPlain      int32 = 0
+	parquetEncodingRLE        int32 = 3
+	parquetCodecUncompressed  int32 = 0
+	parquetPageTypeData       int32 = 0
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32
+	isTime    bool
+	values    bytes.Buffer
+	// bools are bit-packed when the row group is written.
+	bools []bool
+}
+
+func newParquetColumn(name string, t reflect.Type, isTime bool) *parquetColumn {
+	c := &parquetColumn{name: name, converted: parquetConvertedNone, isTime: isTime}
+	switch {
+	case isTime:
+		c.typ, c.converted = parquetTypeInt64, parquetConvertedTimestampMillis
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		c.typ = parquetTypeInt64
+	case t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uint64:
+		c.typ, c.converted = parquetTypeInt64, parquetConvertedUint64
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		c.typ = parquetTypeDouble
+	case t.Kind() == reflect.Bool:
+		c.typ = parquetTypeBoolean
+	default:
+		// Strings, and other values encoded as JSON.
+		c.typ, c.converted = parquetTypeByteArray, parquetConvertedUTF8
+	}
+	return c
+}
+
+func (c *parquetColumn) append(v reflect.Value) error {
+	var b [8]byte
+	switch c.typ {
+	case parquetTypeInt64:
+		var n int64
+		switch {
+		case c.isTime:
+			ts, _ := ameda.InterfaceToInt64(v.Interface())
+			n = ts * 1000
+		case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+			n = int64(v.Uint())
+		default:
+			n = v.Int()
+		}
+		binary.LittleEndian.PutUint64(b[:], uint64(n))
+		c.values.Write(b[:])
+	case parquetTypeDouble:
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		c.values.Write(b[:])
+	case parquetTypeBoolean:
+		c.bools = append(c.bools, v.Bool())
+	default:
+		var data []byte
+		if v.Kind() == reflect.String {
+			data = []byte(v.String())
+		} else {
+			var err error
+			if data, err = json.Marshal(v.Interface()); err != nil {
+				return err
+			}
+		}
+		binary.LittleEndian.PutUint32(b[:4], uint32(len(data)))
+		c.values.Write(b[:4])
+		c.values.Write(data)
+	}
+	return nil
+}
+
+// take returns PLAIN encoded values and resets the column.
+func (c *parquetColumn) take() []byte {
+	if c.typ == parquetTypeBoolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, v := range c.bools {
+			if v {
+				packed[i/8] |= 1 << (i % 8)
+			}
+		}
+		c.bools = c.bools[:0]
+		return packed
+	}
+	data := append([]byte(nil), c.values.Bytes()...)
+	c.values.Reset()
+	return data
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	size   int64
+	rows   int64
+}
+
+// countingWriter counts written bytes, which are offsets in the Parquet file.
+type countingWriter struct {
+	w       io.Writer
+	written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+type parquetRowWriter struct {
+	w         *countingWriter
+	columns   *Columns
+	cols      []*parquetColumn
+	rowGroups []parquetRowGroup
+	rows      int64
+	totalRows int64
+}
+
+func newParquetRowWriter(w io.Writer, columns *Columns) (*parquetRowWriter, error) {
+	pw := &parquetRowWriter{w: &countingWriter{w: w}, columns: columns}
+	for i, fieldIndex := range columns.index {
+		pw.cols = append(pw.cols, newParquetColumn(columns.names[i], columns.objType.Field(fieldIndex).Type, columns.isTime[i]))
+	}
+	if _, err := io.WriteString(pw.w, parquetMagic); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (w *parquetRowWriter) WriteRow(s interface{}) error {
+	objValue := reflect.Indirect(reflect.ValueOf(s))
+	bufferedBytes := 0
+	for i, fieldIndex := range w.columns.index {
+		if err := w.cols[i].append(objValue.Field(fieldIndex)); err != nil {
+			return err
+		}
+		bufferedBytes += w.cols[i].values.Len()
+	}
+	w.rows++
+	if bufferedBytes >= parquetMaxRowGroupBytes {
+		return w.writeRowGroup()
+	}
+	return nil
+}
+
+func (w *parquetRowWriter) writeRowGroup() error {
+	if w.rows == 0 {
+		return nil
+	}
+	rg := parquetRowGroup{rows: w.rows}
+	for _, c := range w.cols {
+		data := c.take()
+		header := parquetPageHeader(w.rows, len(data))
+		chunk := parquetChunk{offset: w.w.written, size: int64(len(header) + len(data))}
+		if _, err := w.w.Write(header); err != nil {
+			return err
+		}
+		if _, err := w.w.Write(data); err != nil {
+			return err
+		}
+		rg.chunks = append(rg.chunks, chunk)
+		rg.size += chunk.size
+	}
+	w.rowGroups = append(w.rowGroups, rg)
+	w.totalRows += w.rows
+	w.rows = 0
+	return nil
+}
+
+// Flush writes buffered rows and the footer. No more rows can be written after that.
+func (w *parquetRowWriter) Flush() error {
+	if err := w.writeRowGroup(); err != nil {
+		return err
+	}
+	footer := w.fileMetaData()
+	var size [4]byte
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, size[:], []byte(parquetMagic)} {
+		if _, err := w.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parquetPageHeader(numValues int64, size int) []byte {
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32(1, parquetPageTypeData)
+	t.i32(2, int32(size))
+	t.i32(3, int32(size))
+	t.structField(5)
+	t.i32(1, int32(numValues))
+	t.i32(2, parquetEncodingPlain)
+	t.i32(3, parquetEncodingRLE)
+	t.i32(4, parquetEncodingRLE)
+	t.structEnd()
+	t.structEnd()
+	return t.buf
+}
+
+func (w *parquetRowWriter) fileMetaData() []byte {
+	t := &thriftWriter{}
+	t.structBegin()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(w.cols)+1)
+	t.structBegin()
+	t.string(4, "schema")
+	t.i32(5, int32(len(w.cols)))
+	t.structEnd()
+	for _, c := range w.cols {
+		t.structBegin()
+		t.i32(1, c.typ)
+		t.i32(3, parquetRepetitionRequired)
+		t.string(4, c.name)
+		if c.converted != parquetConvertedNone {
+			t.i32(6, c.converted)
+		}
+		t.structEnd()
+	}
+	t.i64(3, w.totalRows)
+	t.list(4, thriftStruct, len(w.rowGroups))
+	for _, rg := range w.rowGroups {
+		t.structBegin()
+		t.list(1, thriftStruct, len(rg.chunks))
+		for i, chunk := range rg.chunks {
+			t.structBegin()
+			t.i64(2, chunk.offset)
+			t.structField(3)
+			t.i32(1, w.cols[i].typ)
+			t.list(2, thriftI32, 1)
+			t.varint(int64(parquetEncodingPlain))
+			t.list(3, thriftBinary, 1)
+			t.binary(w.cols[i].name)
+			t.i32(4, parquetCodecUncompressed)
+			t.i64(5, rg.rows)
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+		t.i64(2, rg.size)
+		t.i64(3, rg.rows)
+		t.structEnd()
+	}
+	t.string(6, parquetCreatedBy)
+	t.structEnd()
+	return t.buf
+}
+
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// thriftWriter encodes the thrift compact protocol, in which Parquet metadata is written.
+type thriftWriter struct {
+	buf     []byte
+	lastID  int16
+	idStack []int16
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	for v >= 0x80 {
+		t.buf = append(t.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	t.buf = append(t.buf, byte(v))
+}
+
+// varint writes a zigzag encoded integer.
+func (t *thriftWriter) varint(v int64) {
+	t.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.lastID; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.varint(int64(id))
+	}
+	t.lastID = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(v string) {
+	t.uvarint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
+
+func (t *thriftWriter) string(id int16, v string) {
+	t.field(id, thriftBinary)
+	t.binary(v)
+}
+
+// list writes the header of a list field, which is followed by elements.
+func (t *thriftWriter) list(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) structBegin() {
+	t.idStack = append(t.idStack, t.lastID)
+	t.lastID = 0
+}
+
+func (t *thriftWriter) structEnd() {
+	t.buf = append(t.buf, 0)
+	t.lastID = t.idStack[len(t.idStack)-1]
+	t.idStack = t.idStack[:len(t.idStack)-1]
+}
+
+// structField writes the header of a struct field, which must be ended by structEnd.
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.structBegin()
+}
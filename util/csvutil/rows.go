@@ -0,0 +1,182 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package csvutil
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/henrylee2cn/ameda"
+)
+
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+
+	// csvTimeLayout is the layout of time fields in CSV, which is kept the same as the previous CSV export.
+	csvTimeLayout = "01-02 15:04:05"
+)
+
+// Columns are fields of a struct type selected by their json names, which are written by a RowWriter.
+type Columns struct {
+	objType reflect.Type
+	names   []string
+	index   []int
+	isTime  []bool
+}
+
+func jsonNameOf(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return strings.ToLower(name)
+}
+
+// NewColumns selects fields of the struct type by json names in the given order. All fields having a json name are
+// selected when jsonNames is empty or `*`. Unknown names are ignored. Fields in timeFields are unix timestamps in
+// seconds, which are written as time in CSV and Parquet.
+func NewColumns(objType reflect.Type, jsonNames []string, timeFields []string) *Columns {
+	for objType.Kind() == reflect.Ptr {
+		objType = objType.Elem()
+	}
+	timeFieldsMap := make(map[string]struct{}, len(timeFields))
+	for _, f := range timeFields {
+		timeFieldsMap[f] = struct{}{}
+	}
+
+	fieldIndex := make(map[string]int)
+	allNames := make([]string, 0, objType.NumField())
+	for i := 0; i < objType.NumField(); i++ {
+		if name := jsonNameOf(objType.Field(i)); name != "" {
+			fieldIndex[name] = i
+			allNames = append(allNames, name)
+		}
+	}
+	if len(jsonNames) == 0 || (len(jsonNames) == 1 && jsonNames[0] == "*") {
+		jsonNames = allNames
+	}
+
+	c := &Columns{objType: objType}
+	for _, name := range jsonNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		i, ok := fieldIndex[name]
+		if !ok {
+			continue
+		}
+		_, isTime := timeFieldsMap[name]
+		c.names = append(c.names, name)
+		c.index = append(c.index, i)
+		c.isTime = append(c.isTime, isTime)
+	}
+	return c
+}
+
+func (c *Columns) Names() []string {
+	return c.names
+}
+
+// RowWriter writes structs as rows of selected columns.
+type RowWriter interface {
+	WriteRow(s interface{}) error
+	// Flush writes buffered rows. For formats having a footer like Parquet, the footer is written as well, so it
+	// must only be called once after all rows are written.
+	Flush() error
+}
+
+// NewRowWriter creates a RowWriter in the format. The header is written immediately if the format has one.
+func NewRowWriter(format string, w io.Writer, columns *Columns) (RowWriter, error) {
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns.names); err != nil {
+			return nil, err
+		}
+		return &csvRowWriter{cw: cw, columns: columns, rowBuf: make([]string, 0, len(columns.names))}, nil
+	case FormatJSONL:
+		return &jsonlRowWriter{bw: bufio.NewWriter(w), columns: columns}, nil
+	case FormatParquet:
+		return newParquetRowWriter(w, columns)
+	default:
+		return nil, fmt.Errorf("unsupported format %s", format)
+	}
+}
+
+type csvRowWriter struct {
+	cw      *csv.Writer
+	columns *Columns
+	rowBuf  []string
+}
+
+func (w *csvRowWriter) WriteRow(s interface{}) error {
+	objValue := reflect.Indirect(reflect.ValueOf(s))
+	w.rowBuf = w.rowBuf[:0]
+	for i, fieldIndex := range w.columns.index {
+		fv := objValue.Field(fieldIndex).Interface()
+		if w.columns.isTime[i] {
+			ts, _ := ameda.InterfaceToInt64(fv)
+			w.rowBuf = append(w.rowBuf, time.Unix(ts, 0).Format(csvTimeLayout))
+			continue
+		}
+		w.rowBuf = append(w.rowBuf, formatCSVValue(fv))
+	}
+	return w.cw.Write(w.rowBuf)
+}
+
+// formatCSVValue formats values in the same way as the previous CSV export, e.g. floats are never in exponent
+// notation.
+func formatCSVValue(v interface{}) string {
+	switch t := v.(type) {
+	case float64:
+		return fmt.Sprintf("%f", t)
+	case float32:
+		return fmt.Sprintf("%f", t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func (w *csvRowWriter) Flush() error {
+	w.cw.Flush()
+	return w.cw.Error()
+}
+
+// jsonlRowWriter writes each row as a JSON object in a line, keeping the order of columns.
+type jsonlRowWriter struct {
+	bw      *bufio.Writer
+	columns *Columns
+}
+
+func (w *jsonlRowWriter) WriteRow(s interface{}) error {
+	objValue := reflect.Indirect(reflect.ValueOf(s))
+	_ = w.bw.WriteByte('{')
+	for i, fieldIndex := range w.columns.index {
+		if i > 0 {
+			_ = w.bw.WriteByte(',')
+		}
+		name, err := json.Marshal(w.columns.names[i])
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(objValue.Field(fieldIndex).Interface())
+		if err != nil {
+			return err
+		}
+		_, _ = w.bw.Write(name)
+		_ = w.bw.WriteByte(':')
+		_, _ = w.bw.Write(value)
+	}
+	_, err := w.bw.WriteString("}\n")
+	return err
+}
+
+func (w *jsonlRowWriter) Flush() error {
+	return w.bw.Flush()
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package csvutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bar struct {
+	Digest    string  `json:"digest"`
+	Query     string  `json:"query"`
+	ExecCount int     `json:"exec_count"`
+	LastSeen  int     `json:"last_seen"`
+	Latency   float64 `json:"latency"`
+	Ignored   string  `json:"-"`
+	NoTag     string
+}
+
+func TestNewColumns(t *testing.T) {
+	c := NewColumns(reflect.TypeOf(&bar{}), nil, nil)
+	require.Equal(t, []string{"digest", "query", "exec_count", "last_seen", "latency"}, c.Names())
+
+	c = NewColumns(reflect.TypeOf(bar{}), []string{"*"}, nil)
+	require.Equal(t, []string{"digest", "query", "exec_count", "last_seen", "latency"}, c.Names())
+
+	c = NewColumns(reflect.TypeOf(bar{}), []string{"latency", "unknown", "Digest"}, nil)
+	require.Equal(t, []string{"latency", "digest"}, c.Names())
+}
+
+func TestRowWriter(t *testing.T) {
+	rows := []bar{
+		{Digest: "d1", Query: "select \"a\", 1", ExecCount: 3, LastSeen: 1633106800, Latency: 1.5},
+		{Digest: "d2", Query: "select 2", ExecCount: 1, LastSeen: 0, Latency: 0},
+	}
+	columns := NewColumns(reflect.TypeOf(bar{}), []string{"digest", "query", "last_seen", "latency"}, []string{"last_seen"})
+
+	buf := bytes.Buffer{}
+	w, err := NewRowWriter(FormatCSV, &buf, columns)
+	require.NoError(t, err)
+	for i := range rows {
+		require.NoError(t, w.WriteRow(&rows[i]))
+	}
+	require.NoError(t, w.Flush())
+	// Times are in the local time zone.
+	require.Equal(t, `digest,query,last_seen,latency
+d1,"select ""a"", 1",`+time.Unix(1633106800, 0).Format("01-02 15:04:05")+`,1.500000
+d2,select 2,`+time.Unix(0, 0).Format("01-02 15:04:05")+`,0.000000
+`, buf.String())
+
+	buf.Reset()
+	w, err = NewRowWriter(FormatJSONL, &buf, columns)
+	require.NoError(t, err)
+	for i := range rows {
+		require.NoError(t, w.WriteRow(rows[i]))
+	}
+	require.NoError(t, w.Flush())
+	require.Equal(t, `{"digest":"d1","query":"select \"a\", 1","last_seen":1633106800,"latency":1.5}
+{"digest":"d2","query":"select 2","last_seen":0,"latency":0}
+`, buf.String())
+
+	_, err = NewRowWriter("xlsx", &buf, columns)
+	require.Error(t, err)
+}
+
+func TestParquetRowWriter(t *testing.T) {
+	rows := []bar{
+		{Digest: "d1", ExecCount: 3, LastSeen: 1633106800, Latency: 1.5},
+		{Digest: "d2", ExecCount: 1, LastSeen: 0, Latency: 0},
+	}
+	columns := NewColumns(reflect.TypeOf(bar{}), []string{"digest", "exec_count", "last_seen", "latency"}, []string{"last_seen"})
+
+	buf := bytes.Buffer{}
+	w, err := NewRowWriter(FormatParquet, &buf, columns)
+	require.NoError(t, err)
+	for i := range rows {
+		require.NoError(t, w.WriteRow(&rows[i]))
+	}
+	require.NoError(t, w.Flush())
+
+	data := buf.Bytes()
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	for _, name := range columns.Names() {
+		require.Contains(t, string(footer), name)
+	}
+
+	// The first column chunk starts right after the magic, whose values follow the page header.
+	chunk := data[4 : len(data)-8-footerLen]
+	require.Contains(t, string(chunk), "\x02\x00\x00\x00d1\x02\x00\x00\x00d2")
+	lastSeen := make([]byte, 8)
+	binary.LittleEndian.PutUint64(lastSeen, 1633106800*1000)
+	require.Contains(t, string(chunk), string(lastSeen))
+}
@@ -0,0 +1,355 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package filterexpr
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindNumber
+	// KindDuration fields only accept durations with a unit, e.g. 100ms.
+	KindDuration
+	// KindBytes fields accept sizes with a unit, e.g. 10MB, or numbers of bytes.
+	KindBytes
+)
+
+// Field is a field that can be used in expressions.
+type Field struct {
+	// Name is the name used in expressions, which is case-insensitive. It is also the json name of the struct field
+	// when the filter is evaluated against structs.
+	Name string
+	// SQL is the SQL expression of the field, e.g. a column name or an aggregation.
+	SQL  string
+	Kind Kind
+	// Unit is the duration represented by 1 in the value of KindDuration fields. 0 means nanosecond.
+	Unit time.Duration
+}
+
+var byteUnits = map[string]float64{
+	"b":   1,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// compiledPredicate is a predicate whose values are converted into the value space of the field, i.e. float64 for
+// numeric fields and string for string fields.
+type compiledPredicate struct {
+	field  *Field
+	op     string
+	values []interface{}
+}
+
+// Filter is a compiled filter expression.
+type Filter struct {
+	root node
+}
+
+// Compile parses the expression and validates it against fields. A nil filter is returned for an empty expression.
+func Compile(expr string, fields []Field) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	root, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	fieldsMap := make(map[string]*Field, len(fields))
+	for i := range fields {
+		fieldsMap[strings.ToLower(fields[i].Name)] = &fields[i]
+	}
+	root, err = compileNode(root, fieldsMap)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{root: root}, nil
+}
+
+func compileNode(n node, fields map[string]*Field) (node, error) {
+	switch n := n.(type) {
+	case *logicalNode:
+		left, err := compileNode(n.left, fields)
+		if err != nil {
+			return nil, err
+		}
+		right, err := compileNode(n.right, fields)
+		if err != nil {
+			return nil, err
+		}
+		return &logicalNode{and: n.and, left: left, right: right}, nil
+	case *notNode:
+		inner, err := compileNode(n.inner, fields)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner: inner}, nil
+	case *predicateNode:
+		return compilePredicate(n, fields)
+	default:
+		panic(fmt.Sprintf("unknown node %T", n))
+	}
+}
+
+func compilePredicate(n *predicateNode, fields map[string]*Field) (*compiledPredicate, error) {
+	field, ok := fields[n.field]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, ErrInvalidExpr.New("unknown field '%s' at position %d, available fields: %s",
+			n.field, n.pos, strings.Join(names, ", "))
+	}
+
+	switch n.op {
+	case "<", "<=", ">", ">=":
+		if field.Kind == KindString {
+			return nil, ErrInvalidExpr.New("operator %s is not supported by string field '%s'", n.op, n.field)
+		}
+	case "CONTAINS", "NOT CONTAINS":
+		if field.Kind != KindString {
+			return nil, ErrInvalidExpr.New("operator %s is only supported by string fields, '%s' is not", n.op, n.field)
+		}
+	}
+
+	p := &compiledPredicate{field: field, op: n.op, values: make([]interface{}, 0, len(n.values))}
+	for _, v := range n.values {
+		value, err := convertValue(field, v)
+		if err != nil {
+			return nil, err
+		}
+		p.values = append(p.values, value)
+	}
+	return p, nil
+}
+
+func convertValue(field *Field, v literal) (interface{}, error) {
+	if field.Kind == KindString {
+		return v.text, nil
+	}
+	if v.isString {
+		return nil, ErrInvalidExpr.New("expect a number for field '%s' at position %d, got a string", field.Name, v.pos)
+	}
+
+	numEnd := strings.IndexFunc(v.text, func(r rune) bool {
+		return !(r >= '0' && r <= '9') && r != '.' && r != '-'
+	})
+	if numEnd < 0 {
+		numEnd = len(v.text)
+	}
+	unit := strings.ToLower(v.text[numEnd:])
+
+	switch field.Kind {
+	case KindDuration:
+		if unit == "" {
+			return nil, ErrInvalidExpr.New("expect a duration with a unit like 100ms for field '%s' at position %d", field.Name, v.pos)
+		}
+		d, err := time.ParseDuration(strings.ToLower(v.text))
+		if err != nil {
+			return nil, ErrInvalidExpr.New("invalid duration '%s' at position %d", v.text, v.pos)
+		}
+		fieldUnit := field.Unit
+		if fieldUnit <= 0 {
+			fieldUnit = time.Nanosecond
+		}
+		return float64(d) / float64(fieldUnit), nil
+	case KindBytes:
+		n, err := strconv.ParseFloat(v.text[:numEnd], 64)
+		if err != nil {
+			return nil, ErrInvalidExpr.New("invalid size '%s' at position %d", v.text, v.pos)
+		}
+		if unit == "" {
+			return n, nil
+		}
+		multiplier, ok := byteUnits[unit]
+		if !ok {
+			return nil, ErrInvalidExpr.New("unknown size unit '%s' at position %d", v.text[numEnd:], v.pos)
+		}
+		return n * multiplier, nil
+	default:
+		if unit != "" {
+			return nil, ErrInvalidExpr.New("unexpected unit '%s' for field '%s' at position %d", v.text[numEnd:], field.Name, v.pos)
+		}
+		n, err := strconv.ParseFloat(v.text, 64)
+		if err != nil {
+			return nil, ErrInvalidExpr.New("invalid number '%s' at position %d", v.text, v.pos)
+		}
+		return n, nil
+	}
+}
+
+// escapeLike escapes wildcards of the LIKE pattern, using the default escape character `\`.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SQL returns the condition and its parameters, which can be passed to gorm Where or Having.
+func (f *Filter) SQL() (string, []interface{}) {
+	args := make([]interface{}, 0)
+	sql := nodeSQL(f.root, &args)
+	return sql, args
+}
+
+func nodeSQL(n node, args *[]interface{}) string {
+	switch n := n.(type) {
+	case *logicalNode:
+		op := "OR"
+		if n.and {
+			op = "AND"
+		}
+		return fmt.Sprintf("(%s %s %s)", nodeSQL(n.left, args), op, nodeSQL(n.right, args))
+	case *notNode:
+		return fmt.Sprintf("(NOT %s)", nodeSQL(n.inner, args))
+	case *compiledPredicate:
+		switch n.op {
+		case "IN", "NOT IN":
+			*args = append(*args, n.values)
+			return fmt.Sprintf("(%s) %s (?)", n.field.SQL, n.op)
+		case "CONTAINS", "NOT CONTAINS":
+			*args = append(*args, "%"+escapeLike(strings.ToLower(n.values[0].(string)))+"%")
+			op := "LIKE"
+			if n.op == "NOT CONTAINS" {
+				op = "NOT LIKE"
+			}
+			return fmt.Sprintf("LOWER(%s) %s ?", n.field.SQL, op)
+		case "!=":
+			*args = append(*args, n.values[0])
+			return fmt.Sprintf("(%s) <> ?", n.field.SQL)
+		default:
+			*args = append(*args, n.values[0])
+			return fmt.Sprintf("(%s) %s ?", n.field.SQL, n.op)
+		}
+	default:
+		panic(fmt.Sprintf("unknown node %T", n))
+	}
+}
+
+// FieldNames returns names of fields used in the expression.
+func (f *Filter) FieldNames() []string {
+	names := make([]string, 0)
+	seen := make(map[string]struct{})
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *logicalNode:
+			walk(n.left)
+			walk(n.right)
+		case *notNode:
+			walk(n.inner)
+		case *compiledPredicate:
+			if _, ok := seen[n.field.Name]; !ok {
+				seen[n.field.Name] = struct{}{}
+				names = append(names, n.field.Name)
+			}
+		}
+	}
+	walk(f.root)
+	return names
+}
+
+// Match evaluates the filter against a struct, whose fields are found by their json names.
+func (f *Filter) Match(s interface{}) bool {
+	return nodeMatch(f.root, reflect.Indirect(reflect.ValueOf(s)))
+}
+
+func nodeMatch(n node, obj reflect.Value) bool {
+	switch n := n.(type) {
+	case *logicalNode:
+		if n.and {
+			return nodeMatch(n.left, obj) && nodeMatch(n.right, obj)
+		}
+		return nodeMatch(n.left, obj) || nodeMatch(n.right, obj)
+	case *notNode:
+		return !nodeMatch(n.inner, obj)
+	case *compiledPredicate:
+		v, ok := fieldValue(obj, n.field)
+		if !ok {
+			return false
+		}
+		return n.match(v)
+	default:
+		panic(fmt.Sprintf("unknown node %T", n))
+	}
+}
+
+// fieldValue returns the value of the field as float64 or string.
+func fieldValue(obj reflect.Value, field *Field) (interface{}, bool) {
+	t := obj.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !strings.EqualFold(strings.Split(t.Field(i).Tag.Get("json"), ",")[0], field.Name) {
+			continue
+		}
+		v := obj.Field(i)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(v.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return float64(v.Uint()), true
+		case reflect.Float32, reflect.Float64:
+			return v.Float(), true
+		case reflect.String:
+			return v.String(), true
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func (p *compiledPredicate) match(v interface{}) bool {
+	switch p.op {
+	case "IN", "NOT IN":
+		in := false
+		for _, value := range p.values {
+			if v == value {
+				in = true
+				break
+			}
+		}
+		return in == (p.op == "IN")
+	case "CONTAINS", "NOT CONTAINS":
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		contains := strings.Contains(strings.ToLower(s), strings.ToLower(p.values[0].(string)))
+		return contains == (p.op == "CONTAINS")
+	case "=":
+		return v == p.values[0]
+	case "!=":
+		return v != p.values[0]
+	}
+
+	n, ok1 := v.(float64)
+	value, ok2 := p.values[0].(float64)
+	if !ok1 || !ok2 {
+		return false
+	}
+	switch p.op {
+	case "<":
+		return n < value
+	case "<=":
+		return n <= value
+	case ">":
+		return n > value
+	case ">=":
+		return n >= value
+	default:
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package filterexpr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"github.com/stretchr/testify/require"
+)
+
+var testFields = []Field{
+	{Name: "avg_latency", SQL: "AVG(latency)", Kind: KindDuration},
+	{Name: "query_time", SQL: "Query_time", Kind: KindDuration, Unit: time.Second},
+	{Name: "max_mem", SQL: "MAX(mem)", Kind: KindBytes},
+	{Name: "exec_count", SQL: "SUM(exec_count)", Kind: KindNumber},
+	{Name: "table_names", SQL: "table_names", Kind: KindString},
+	{Name: "sample_user", SQL: "sample_user", Kind: KindString},
+}
+
+type testRow struct {
+	AvgLatency int     `json:"avg_latency"`
+	QueryTime  float64 `json:"query_time"`
+	MaxMem     uint    `json:"max_mem"`
+	ExecCount  int     `json:"exec_count"`
+	TableNames string  `json:"table_names"`
+	SampleUser string  `json:"sample_user,omitempty"`
+}
+
+func TestCompileSQL(t *testing.T) {
+	f, err := Compile("", testFields)
+	require.NoError(t, err)
+	require.Nil(t, f)
+
+	f, err = Compile(`avg_latency > 100ms AND table_names CONTAINS 'orders' AND sample_user = 'app'`, testFields)
+	require.NoError(t, err)
+	sql, args := f.SQL()
+	require.Equal(t, "(((AVG(latency)) > ? AND LOWER(table_names) LIKE ?) AND (sample_user) = ?)", sql)
+	require.Equal(t, []interface{}{float64(100 * time.Millisecond), "%orders%", "app"}, args)
+
+	f, err = Compile(`NOT (query_time >= 1.5s or max_mem < 1MB) and Exec_Count not in (1, 2) AND table_names not contains "50%_off"`, testFields)
+	require.NoError(t, err)
+	sql, args = f.SQL()
+	require.Equal(t, `(((NOT ((Query_time) >= ? OR (MAX(mem)) < ?)) AND (SUM(exec_count)) NOT IN (?)) AND LOWER(table_names) NOT LIKE ?)`, sql)
+	require.Equal(t, []interface{}{1.5, float64(1 << 20), []interface{}{1.0, 2.0}, `%50\%\_off%`}, args)
+
+	f, err = Compile(`sample_user <> 'root' OR sample_user != 'app'`, testFields)
+	require.NoError(t, err)
+	require.Equal(t, []string{"sample_user"}, f.FieldNames())
+	sql, args = f.SQL()
+	require.Equal(t, "((sample_user) <> ? OR (sample_user) <> ?)", sql)
+	require.Equal(t, []interface{}{"root", "app"}, args)
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		`unknown = 1`,
+		`avg_latency > 100`,
+		`avg_latency > '100ms'`,
+		`avg_latency > 100xs`,
+		`exec_count > 1ms`,
+		`max_mem > 1XB`,
+		`table_names > 'a'`,
+		`exec_count CONTAINS '1'`,
+		`exec_count >`,
+		`exec_count = 1 AND`,
+		`(exec_count = 1`,
+		`exec_count = 1)`,
+		`exec_count IN ()`,
+		`table_names = 'a`,
+		`exec_count ! 1`,
+		`exec_count NOT = 1`,
+		`exec_count = 1 exec_count = 2`,
+		`exec_count # 1`,
+	} {
+		_, err := Compile(expr, testFields)
+		require.Error(t, err, expr)
+		require.True(t, errorx.IsOfType(err, ErrInvalidExpr), expr)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	row := testRow{
+		AvgLatency: int(200 * time.Millisecond),
+		QueryTime:  2,
+		MaxMem:     2 << 20,
+		ExecCount:  3,
+		TableNames: "test.Orders,test.items",
+		SampleUser: "app",
+	}
+	cases := []struct {
+		expr  string
+		match bool
+	}{
+		{`avg_latency > 100ms AND table_names CONTAINS 'orders' AND sample_user = 'app'`, true},
+		{`avg_latency > 1s`, false},
+		{`query_time >= 2s AND query_time <= 2000ms`, true},
+		{`max_mem > 1MB AND max_mem < 3MiB`, true},
+		{`max_mem = 2097152`, true},
+		{`exec_count IN (1, 3)`, true},
+		{`exec_count NOT IN (1, 3)`, false},
+		{`table_names NOT CONTAINS 'users'`, true},
+		{`sample_user != 'app' OR exec_count < 3`, false},
+		{`NOT sample_user = 'root'`, true},
+	}
+	for _, c := range cases {
+		f, err := Compile(c.expr, testFields)
+		require.NoError(t, err, c.expr)
+		require.Equal(t, c.match, f.Match(&row), c.expr)
+	}
+}
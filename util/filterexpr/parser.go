@@ -0,0 +1,322 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package filterexpr parses filter expressions like
+//
+//	avg_latency > 100ms AND table_names CONTAINS 'orders' AND sample_user = 'app'
+//
+// and compiles them into parameterized SQL conditions, or evaluates them against structs.
+//
+// An expression is made of predicates combined by AND, OR, NOT and parentheses. Supported predicates are
+// `field op value` where op is one of =, !=, <>, <, <=, >, >=, `field [NOT] IN (value, ...)` and
+// `field [NOT] CONTAINS 'text'`. Values are numbers, numbers with a unit (durations like 100ms and sizes like
+// 10MB), or quoted strings. Keywords are case-insensitive.
+package filterexpr
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/joomcode/errorx"
+)
+
+var (
+	ErrNS          = errorx.NewNamespace("filter_expr")
+	ErrInvalidExpr = ErrNS.NewType("invalid_expr")
+)
+
+const maxExprLength = 4096
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) isKeyword(keyword string) bool {
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+func describe(t token) string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return "'" + t.text + "'"
+}
+
+func tokenize(s string) ([]token, error) {
+	runes := []rune(s)
+	tokens := make([]token, 0)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{kind: tokenOp, text: "=", pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				op += string(runes[i+1])
+			}
+			if op == "!" {
+				return nil, ErrInvalidExpr.New("unexpected '!' at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		case r == '\'' || r == '"':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, ErrInvalidExpr.New("unterminated string at position %d", start)
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: sb.String(), pos: start})
+		case unicode.IsDigit(r) || r == '.' || (r == '-' && i+1 < len(runes) && (unicode.IsDigit(runes[i+1]) || runes[i+1] == '.')):
+			// A number may be followed by a unit, e.g. 1.5s or 10MB.
+			start := i
+			i++
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || runes[i] == 'µ') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		default:
+			return nil, ErrInvalidExpr.New("unexpected '%c' at position %d", r, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+type node interface{}
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+type notNode struct {
+	inner node
+}
+
+type literal struct {
+	text     string
+	isString bool
+	pos      int
+}
+
+type predicateNode struct {
+	field string
+	pos   int
+	// op is one of the comparison operators, IN, NOT IN, CONTAINS and NOT CONTAINS.
+	op     string
+	values []literal
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, ErrInvalidExpr.New("expect %s at position %d, got %s", what, t.pos, describe(t))
+	}
+	return t, nil
+}
+
+// parseOr parses `and (OR and)*`.
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses `not (AND not)*`.
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses `NOT not | ( or ) | predicate`.
+func (p *parser) parseNot() (node, error) {
+	t := p.peek()
+	switch {
+	case t.isKeyword("NOT"):
+		p.next()
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{inner: inner}, nil
+	case t.kind == tokenLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	default:
+		return p.parsePredicate()
+	}
+}
+
+func (p *parser) parseValue() (literal, error) {
+	t := p.next()
+	if t.kind != tokenString && t.kind != tokenNumber {
+		return literal{}, ErrInvalidExpr.New("expect a value at position %d, got %s", t.pos, describe(t))
+	}
+	return literal{text: t.text, isString: t.kind == tokenString, pos: t.pos}, nil
+}
+
+func (p *parser) parsePredicate() (node, error) {
+	field, err := p.expect(tokenIdent, "a field name")
+	if err != nil {
+		return nil, err
+	}
+	pred := &predicateNode{field: strings.ToLower(field.text), pos: field.pos}
+
+	t := p.next()
+	negative := false
+	if t.isKeyword("NOT") {
+		negative = true
+		t = p.next()
+	}
+	switch {
+	case t.kind == tokenOp && !negative:
+		pred.op = t.text
+		if pred.op == "<>" {
+			pred.op = "!="
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		pred.values = []literal{v}
+	case t.isKeyword("CONTAINS"):
+		pred.op = "CONTAINS"
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		pred.values = []literal{v}
+	case t.isKeyword("IN"):
+		pred.op = "IN"
+		if _, err := p.expect(tokenLParen, "'('"); err != nil {
+			return nil, err
+		}
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			pred.values = append(pred.values, v)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+		if _, err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidExpr.New("expect an operator after '%s' at position %d, got %s", field.text, t.pos, describe(t))
+	}
+	if negative {
+		pred.op = "NOT " + pred.op
+	}
+	return pred, nil
+}
+
+func parse(s string) (node, error) {
+	if len(s) > maxExprLength {
+		return nil, ErrInvalidExpr.New("expression is longer than %d characters", maxExprLength)
+	}
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, ErrInvalidExpr.New("unexpected %s at position %d", describe(t), t.pos)
+	}
+	return root, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+// Package logpattern groups log messages into templates using the Drain algorithm, see
+// https://jiemingzhu.github.io/pub/pjhe_icws2017.pdf.
+//
+// Messages are split into tokens by whitespace. Tokens containing digits are replaced by Wildcard before
+// clustering, and tokens that differ among messages of the same cluster become Wildcard as well.
+package logpattern
+
+import (
+	"strconv"
+	"strings"
+)
+
+const Wildcard = "<*>"
+
+type Options struct {
+	// Depth is the depth of the prefix tree. Messages are routed by their first Depth-2 tokens.
+	Depth int
+	// SimilarityThreshold is the minimum ratio of equal tokens for a message to join a cluster.
+	SimilarityThreshold float64
+	// MaxChildren is the maximum number of children of a tree node. Further tokens are routed to Wildcard.
+	MaxChildren int
+	// MaxClusters is the maximum number of clusters. Messages not matching existing clusters are dropped when
+	// the limit is reached.
+	MaxClusters int
+}
+
+var DefaultOptions = Options{
+	Depth:               4,
+	SimilarityThreshold: 0.5,
+	MaxChildren:         100,
+	MaxClusters:         5000,
+}
+
+type Cluster struct {
+	ID     int
+	Tokens []string
+	// Size is the number of messages in the cluster.
+	Size int64
+}
+
+func (c *Cluster) Template() string {
+	return strings.Join(c.Tokens, " ")
+}
+
+type node struct {
+	children map[string]*node
+	clusters []*Cluster
+}
+
+func newNode() *node {
+	return &node{children: map[string]*node{}}
+}
+
+// Miner is not thread-safe.
+type Miner struct {
+	opts     Options
+	root     *node
+	clusters []*Cluster
+}
+
+func NewMiner(opts Options) *Miner {
+	if opts.Depth < 3 {
+		opts.Depth = 3
+	}
+	return &Miner{
+		opts: opts,
+		root: newNode(),
+	}
+}
+
+// Clusters returns all clusters, ordered by creation.
+func (m *Miner) Clusters() []*Cluster {
+	return m.clusters
+}
+
+// Add adds a message and returns the cluster it belongs to. Returns nil if the message matches no cluster and
+// no more clusters can be created.
+func (m *Miner) Add(message string) *Cluster {
+	fields := strings.Fields(message)
+	tokens := make([]string, len(fields))
+	for i, f := range fields {
+		tokens[i] = maskToken(f)
+	}
+	return m.add(tokens, 1)
+}
+
+// AddTemplate adds count messages of a template previously produced by a Miner, which is useful to merge
+// clusters of different miners.
+func (m *Miner) AddTemplate(template string, count int64) *Cluster {
+	return m.add(strings.Fields(template), count)
+}
+
+func (m *Miner) add(tokens []string, count int64) *Cluster {
+	leaf := m.route(tokens)
+	c := m.match(leaf, tokens)
+	if c != nil {
+		for i, t := range tokens {
+			if c.Tokens[i] != t {
+				c.Tokens[i] = Wildcard
+			}
+		}
+		c.Size += count
+		return c
+	}
+	if m.opts.MaxClusters > 0 && len(m.clusters) >= m.opts.MaxClusters {
+		return nil
+	}
+	c = &Cluster{
+		ID:     len(m.clusters),
+		Tokens: append([]string(nil), tokens...),
+		Size:   count,
+	}
+	leaf.clusters = append(leaf.clusters, c)
+	m.clusters = append(m.clusters, c)
+	return c
+}
+
+// route returns the leaf node for the tokens, creating nodes along the path when necessary.
+func (m *Miner) route(tokens []string) *node {
+	cur := m.child(m.root, strconv.Itoa(len(tokens)))
+	for i := 0; i < m.opts.Depth-2 && i < len(tokens); i++ {
+		key := tokens[i]
+		if strings.Contains(key, Wildcard) {
+			key = Wildcard
+		}
+		if _, ok := cur.children[key]; !ok && m.opts.MaxChildren > 0 && len(cur.children) >= m.opts.MaxChildren {
+			key = Wildcard
+		}
+		cur = m.child(cur, key)
+	}
+	return cur
+}
+
+func (m *Miner) child(n *node, key string) *node {
+	c, ok := n.children[key]
+	if !ok {
+		c = newNode()
+		n.children[key] = c
+	}
+	return c
+}
+
+// match returns the most similar cluster in the leaf if the similarity reaches the threshold.
+func (m *Miner) match(leaf *node, tokens []string) *Cluster {
+	var best *Cluster
+	bestSim := -1.0
+	bestWildcards := -1
+	for _, c := range leaf.clusters {
+		sim, wildcards := similarity(c.Tokens, tokens)
+		if sim > bestSim || (sim == bestSim && wildcards > bestWildcards) {
+			best, bestSim, bestWildcards = c, sim, wildcards
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	if len(tokens) > 0 && bestSim < m.opts.SimilarityThreshold {
+		return nil
+	}
+	return best
+}
+
+func similarity(template, tokens []string) (float64, int) {
+	if len(tokens) == 0 {
+		return 1, 0
+	}
+	equal, wildcards := 0, 0
+	for i, t := range template {
+		if t == Wildcard {
+			wildcards++
+			continue
+		}
+		if t == tokens[i] {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(tokens)), wildcards
+}
+
+func hasDigit(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= '0' && s[i] <= '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// maskToken replaces variable tokens by Wildcard. For `key=value` tokens, only the value is replaced so that
+// the key is kept in the template.
+func maskToken(token string) string {
+	if !hasDigit(token) {
+		return token
+	}
+	if i := strings.IndexByte(token, '='); i > 0 && !hasDigit(token[:i]) {
+		value := token[i+1:]
+		trimmed := strings.TrimRight(value, "]\"),;")
+		return token[:i+1] + Wildcard + value[len(trimmed):]
+	}
+	return Wildcard
+}
@@ -0,0 +1,60 @@
+// Copyright 2022 PingCAP, Inc. Licensed under Apache-2.0.
+
+package logpattern
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskToken(t *testing.T) {
+	require.Equal(t, "region", maskToken("region"))
+	require.Equal(t, Wildcard, maskToken("12345"))
+	require.Equal(t, Wildcard, maskToken("127.0.0.1:20160"))
+	require.Equal(t, "[region_id=<*>]", maskToken("[region_id=123]"))
+	require.Equal(t, "[\"cost=<*>\"]", maskToken("[\"cost=1.2ms\"]"))
+	require.Equal(t, Wildcard, maskToken("k1=v"))
+}
+
+func TestMiner(t *testing.T) {
+	m := NewMiner(DefaultOptions)
+	c1 := m.Add("[peer.rs:100] [\"split region\"] [region_id=1] [peer_id=10]")
+	c2 := m.Add("[peer.rs:100] [\"split region\"] [region_id=2] [peer_id=20]")
+	require.Same(t, c1, c2)
+	require.Equal(t, int64(2), c1.Size)
+	require.Equal(t, "<*> [\"split region\"] [region_id=<*>] [peer_id=<*>]", c1.Template())
+
+	c3 := m.Add("connection closed by user root")
+	c4 := m.Add("connection closed by user admin")
+	require.Same(t, c3, c4)
+	require.Equal(t, "connection closed by user <*>", c4.Template())
+
+	c5 := m.Add("schema version changed")
+	require.NotSame(t, c3, c5)
+	require.Len(t, m.Clusters(), 3)
+}
+
+func TestMinerAddTemplate(t *testing.T) {
+	m1 := NewMiner(DefaultOptions)
+	m1.Add("load data from file a.csv")
+	m1.Add("load data from file b.csv")
+
+	m2 := NewMiner(DefaultOptions)
+	m2.Add("load data from file c.csv")
+	for _, c := range m1.Clusters() {
+		m2.AddTemplate(c.Template(), c.Size)
+	}
+	require.Len(t, m2.Clusters(), 1)
+	require.Equal(t, int64(3), m2.Clusters()[0].Size)
+	require.Equal(t, "load data from file <*>", m2.Clusters()[0].Template())
+}
+
+func TestMinerMaxClusters(t *testing.T) {
+	opts := DefaultOptions
+	opts.MaxClusters = 1
+	m := NewMiner(opts)
+	require.NotNil(t, m.Add("foo bar baz qux"))
+	require.Nil(t, m.Add("completely different message here"))
+	require.NotNil(t, m.Add("foo bar baz quux"))
+}